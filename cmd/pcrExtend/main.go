@@ -2,13 +2,13 @@ package main
 
 import (
 	"fmt"
-	"github.com/xcaliburne/RemoteAttestations/pkg/tpm"
+	tpmPkg "github.com/xcaliburne/RemoteAttestations/pkg/tpm"
 	"os"
 	"strconv"
 )
 
 func main() {
-	tpm, err := tpm.Open()
+	tpm, err := tpmPkg.Open()
 	if err != nil {
 		fmt.Println(err)
 	}
@@ -19,7 +19,11 @@ func main() {
 	}
 	hash := os.Args[2]
 	tpm.ExtendPCR(pcrId, []byte(hash), 0, "")
-	pcrs := tpm.ListPCRs()
+	pcrs, err := tpm.ListPCRs(tpmPkg.SHA1)
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
 	fmt.Printf("Extending PCR %v with hash: %v\nNew PCR value: %v\n", pcrId, hash, pcrs[pcrId])
 	//pcrs := tpm.ListPCRs()
 	//for i, pcr := range pcrs {
@@ -0,0 +1,83 @@
+package main
+
+import (
+	"fmt"
+	flag "github.com/spf13/pflag"
+	p "github.com/xcaliburne/RemoteAttestations/internal/prover"
+	"github.com/xcaliburne/RemoteAttestations/pkg/tpm"
+	"io/ioutil"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// runCommand runs a seal, unseal or nv command, which only need the TPM.
+func runCommand(conf *p.Config, args []string) error {
+	t, err := p.OpenTPM(conf)
+	if err != nil {
+		return err
+	}
+	defer t.Close()
+	switch {
+	case args[0] == "seal" && len(args) == 3:
+		return p.SealFile(t, conf.SealSelection(), args[1], args[2])
+	case args[0] == "unseal" && (len(args) == 2 || len(args) == 3):
+		data, err := p.UnsealFile(t, args[1])
+		if err != nil {
+			return err
+		}
+		return output(data, args[2:])
+	case args[0] == "nv" && len(args) >= 3:
+		return runNVCommand(t, conf, args[1:])
+	default:
+		flag.Usage()
+		return fmt.Errorf("invalid command: %v", strings.Join(args, " "))
+	}
+}
+
+func runNVCommand(t tpm.TPM, conf *p.Config, args []string) error {
+	index, err := strconv.ParseUint(args[1], 0, 32)
+	if err != nil {
+		return fmt.Errorf("invalid NV index %v: %v", args[1], err)
+	}
+	policy := tpm.NVPolicy{OwnerAuth: *nvOwnerAuth, PCRs: tpm.PCRSelection{Bank: conf.PCRBank, PCRs: *nvPCRs}}
+	if policy.OwnerAuth || args[0] == "define" || args[0] == "undefine" {
+		if err = p.ProveOwnership(t, conf); err != nil {
+			return err
+		}
+	}
+	switch {
+	case args[0] == "define" && len(args) == 3:
+		size, err := strconv.Atoi(args[2])
+		if err != nil {
+			return fmt.Errorf("invalid NV index size %v: %v", args[2], err)
+		}
+		return t.NVDefine(uint32(index), size, policy)
+	case args[0] == "read" && (len(args) == 2 || len(args) == 3):
+		data, err := t.NVRead(uint32(index), policy)
+		if err != nil {
+			return err
+		}
+		return output(data, args[2:])
+	case args[0] == "write" && len(args) == 3:
+		data, err := ioutil.ReadFile(args[2])
+		if err != nil {
+			return fmt.Errorf("error reading %v: %v", args[2], err)
+		}
+		return t.NVWrite(uint32(index), data, policy)
+	case args[0] == "undefine" && len(args) == 2:
+		return t.NVUndefine(uint32(index))
+	default:
+		flag.Usage()
+		return fmt.Errorf("invalid nv command: %v", strings.Join(args, " "))
+	}
+}
+
+// output writes data to the file of args, to the standard output when args is empty.
+func output(data []byte, args []string) error {
+	if len(args) == 0 {
+		_, err := os.Stdout.Write(data)
+		return err
+	}
+	return ioutil.WriteFile(args[0], data, 0600)
+}
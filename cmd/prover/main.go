@@ -6,6 +6,11 @@ import (
 	flag "github.com/spf13/pflag"
 	p "github.com/xcaliburne/RemoteAttestations/internal/prover"
 	"github.com/xcaliburne/RemoteAttestations/internal/prover/RestServer"
+	"github.com/xcaliburne/RemoteAttestations/pkg/eventlog"
+	"github.com/xcaliburne/RemoteAttestations/pkg/httpClient"
+	"github.com/xcaliburne/RemoteAttestations/pkg/ima"
+	"github.com/xcaliburne/RemoteAttestations/pkg/mtls"
+	"github.com/xcaliburne/RemoteAttestations/pkg/tpm"
 	"gopkg.in/yaml.v3"
 	"io/ioutil"
 	"net"
@@ -17,6 +22,7 @@ import (
 type Config struct {
 	Rest   RestServer.Config `yaml:"rest"`
 	Prover p.Config          `yaml:"prover"`
+	TLS    mtls.Config       `yaml:"tls"`
 }
 
 var (
@@ -25,11 +31,38 @@ var (
 	port          = flag.StringP("port", "p", "8080", "Listening port")
 	name          = flag.StringP("name", "n", "prover", "Name of the prover")
 	ak            = flag.String("ak", "ak.json", "Path to the AK file")
+	akCert        = flag.String("ak_cert", "ak.crt", "Path to the AK certificate issued by the privacy CA")
 	ownerPassword = flag.String("owner_password", "tpmOwnerPassword", "tpm owner password")
 	userPassword  = flag.String("user_password", "tpmUserPassword", "tpm user password")
+	enrollToken   = flag.String("enrollment_token", "", "Token exchanged with the verifier for the tpm passwords when the TPM is not owned")
+	credentials   = flag.String("tpm_credentials", "tpm_credentials.json", "Path to the tpm passwords obtained at enrollment")
 	verifierUrl   = flag.String("verifier_url", "", "Verifier Listening URL")
+	tpmBackend    = flag.String("tpm_backend", "auto", "TPM backend (auto, tspi, tpm2 or software)")
+	tpmStateDir   = flag.String("tpm_state_dir", "swtpm", "Path to the software TPM state directory")
+	pcrBank       = flag.String("pcr_bank", "sha1", "PCR bank to quote (sha1, sha256 or sha384)")
+	sealPCRs      = flag.IntSlice("seal_pcrs", nil, "PCRs of the PCR bank secrets are sealed to")
+	nvOwnerAuth   = flag.Bool("nv_owner_auth", false, "NV index authorized by the TPM owner")
+	nvPCRs        = flag.IntSlice("nv_pcrs", nil, "PCRs of the PCR bank an NV index is bound to")
+	eventLog      = flag.String("event_log", eventlog.DefaultPath, "Path to the binary firmware event log served to the verifier")
+	imaLog        = flag.String("ima_log", ima.DefaultASCIIPath, "Path to the ascii or binary IMA measurement list served to the verifier")
+	insecure      = flag.Bool("insecure", false, "Serve and register over plain HTTP when TLS is not configured")
 )
 
+const usage = `Usage: prover [flags] [command]
+
+Without command, the prover registers to the verifier and serves attestation requests.
+
+Commands:
+  seal <in> <out>   seal the content of in to the seal_pcrs of pcr_bank
+  unseal <in> [out] unseal in, to the standard output when out is omitted
+  nv define <index> <size>  define an NV index with the nv_owner_auth or nv_pcrs policy
+  nv read <index> [out]     read an NV index, to the standard output when out is omitted
+  nv write <index> <in>     write the content of in to an NV index
+  nv undefine <index>       undefine an NV index
+
+Flags:
+`
+
 func parseConfig(configPath string) (*Config, error) {
 	//Set default values
 	conf := Config{}
@@ -42,9 +75,21 @@ func parseConfig(configPath string) (*Config, error) {
 	conf.Rest.Port = *port
 	conf.Prover.Name = *name
 	conf.Prover.AKFile = *ak
+	conf.Prover.AKCertFile = *akCert
 	conf.Prover.OwnerPassword = *ownerPassword
 	conf.Prover.UserPassword = *userPassword
+	conf.Prover.CredentialsFile = *credentials
 	conf.Prover.VerifierAddress = addr
+	conf.Prover.TPM.Backend = *tpmBackend
+	conf.Prover.TPM.StateDir = *tpmStateDir
+	conf.Prover.PCRBank = tpm.Bank(*pcrBank)
+	conf.Prover.Seal.PCRs = *sealPCRs
+	conf.Prover.EventLog = *eventLog
+	conf.Prover.IMALog = *imaLog
+	flag.Usage = func() {
+		fmt.Fprint(os.Stderr, usage)
+		flag.PrintDefaults()
+	}
 	flag.Parse()
 	yamlFile, err := ioutil.ReadFile(configPath)
 	if err != nil {
@@ -77,12 +122,21 @@ func parseConfig(configPath string) (*Config, error) {
 	if wasSet("ak") {
 		conf.Prover.AKFile = *ak
 	}
+	if wasSet("ak_cert") {
+		conf.Prover.AKCertFile = *akCert
+	}
 	if wasSet("owner_password") {
 		conf.Prover.OwnerPassword = *ownerPassword
 	}
 	if wasSet("user_password") {
 		conf.Prover.UserPassword = *userPassword
 	}
+	if wasSet("enrollment_token") {
+		conf.Prover.EnrollmentToken = *enrollToken
+	}
+	if wasSet("tpm_credentials") {
+		conf.Prover.CredentialsFile = *credentials
+	}
 	if wasSet("verifier_url") {
 		addr, err = p.HttpUrlParser(*verifierUrl)
 		if err != nil {
@@ -90,6 +144,27 @@ func parseConfig(configPath string) (*Config, error) {
 		}
 		conf.Prover.VerifierAddress = addr
 	}
+	if wasSet("tpm_backend") {
+		conf.Prover.TPM.Backend = *tpmBackend
+	}
+	if wasSet("tpm_state_dir") {
+		conf.Prover.TPM.StateDir = *tpmStateDir
+	}
+	if wasSet("pcr_bank") {
+		conf.Prover.PCRBank, err = tpm.ParseBank(*pcrBank)
+		if err != nil {
+			log.Fatal(err)
+		}
+	}
+	if wasSet("seal_pcrs") {
+		conf.Prover.Seal.PCRs = *sealPCRs
+	}
+	if wasSet("event_log") {
+		conf.Prover.EventLog = *eventLog
+	}
+	if wasSet("ima_log") {
+		conf.Prover.IMALog = *imaLog
+	}
 	//fmt.Printf("%+v\n", conf)
 	return &conf, nil
 }
@@ -99,7 +174,29 @@ func main() {
 	if err != nil {
 		log.Errorf("error creating verifier: %v\n", err)
 	}
-	fmt.Printf("%+v\n", conf)
+	if flag.NArg() > 0 {
+		if err = runCommand(&conf.Prover, flag.Args()); err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+	if err = conf.TLS.Check(*insecure); err != nil {
+		log.Fatalf("Error configuring TLS: %v, start with --insecure to serve and register over plain HTTP", err)
+	}
+	if conf.TLS.Enabled() {
+		conf.Rest.TLS, err = conf.TLS.Server()
+		if err != nil {
+			log.Fatalf("Error configuring TLS: %v", err)
+		}
+		clientTLS, err := conf.TLS.Client()
+		if err != nil {
+			log.Fatalf("Error configuring TLS: %v", err)
+		}
+		httpClient.Client = httpClient.New(clientTLS)
+		conf.Prover.VerifierAddress.Scheme = "https"
+	} else {
+		log.Warn("TLS not configured, serving and registering over plain HTTP")
+	}
 	prover, err := p.NewProver(&conf.Prover)
 	if err != nil {
 		log.Fatal(err)
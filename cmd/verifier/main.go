@@ -1,11 +1,14 @@
 package main
 
 import (
+	"context"
 	"fmt"
 	log "github.com/sirupsen/logrus"
 	flag "github.com/spf13/pflag"
 	"github.com/xcaliburne/RemoteAttestations/internal/verifier"
 	"github.com/xcaliburne/RemoteAttestations/internal/verifier/RestServer"
+	"github.com/xcaliburne/RemoteAttestations/pkg/httpClient"
+	"github.com/xcaliburne/RemoteAttestations/pkg/mtls"
 	"gopkg.in/yaml.v3"
 	"io/ioutil"
 	"net"
@@ -18,15 +21,15 @@ import (
 type Config struct {
 	Rest     RestServer.Config `yaml:"rest"`
 	Verifier verifier.Config   `yaml:"verifier"`
+	TLS      mtls.Config       `yaml:"tls"`
 }
 
 var (
-	configFile    = flag.StringP("config", "c", "configs/verifier.yaml", "Path to the configFile file")
-	address       = flag.IPP("address", "a", net.IP{0, 0, 0, 0}, "Listening address")
-	port          = flag.StringP("port", "p", "8080", "Listening port")
-	ownerPassword = flag.String("owner_password", "tpmOwnerPassword", "tpm owner password")
-	userPassword  = flag.String("user_password", "tpmUserPassword", "tpm user password")
-	interval      = flag.DurationP("attestation_interval", "i", time.Duration(15)*time.Minute, "Interval between two attestations")
+	configFile = flag.StringP("config", "c", "configs/verifier.yaml", "Path to the configFile file")
+	address    = flag.IPP("address", "a", net.IP{0, 0, 0, 0}, "Listening address")
+	port       = flag.StringP("port", "p", "8080", "Listening port")
+	interval   = flag.DurationP("attestation_interval", "i", time.Duration(15)*time.Minute, "Interval between two attestations")
+	insecure   = flag.Bool("insecure", false, "Serve and attest over plain HTTP when TLS is not configured")
 )
 
 func parseConfig() (*Config, error) {
@@ -34,8 +37,6 @@ func parseConfig() (*Config, error) {
 	conf := Config{}
 	conf.Rest.Address = *address
 	conf.Rest.Port = *port
-	conf.Verifier.Init.OwnerPassword = *ownerPassword
-	conf.Verifier.Init.UserPassword = *userPassword
 	conf.Verifier.AttestationInterval = *interval
 	flag.Parse()
 	yamlFile, err := ioutil.ReadFile(*configFile)
@@ -63,16 +64,9 @@ func parseConfig() (*Config, error) {
 	if wasSet("address") {
 		conf.Rest.Address = *address
 	}
-	if wasSet("owner_password") {
-		conf.Verifier.Init.OwnerPassword = *ownerPassword
-	}
-	if wasSet("user_password") {
-		conf.Verifier.Init.OwnerPassword = *userPassword
-	}
 	if wasSet("attestation_interval") {
 		conf.Verifier.AttestationInterval = *interval
 	}
-	fmt.Printf("%+v\n", conf)
 	return &conf, nil
 }
 
@@ -81,7 +75,62 @@ func main() {
 	if err != nil {
 		log.Fatalf("Error creating verifier: %v", err)
 	}
+	if err = conf.TLS.Check(*insecure); err != nil {
+		log.Fatalf("Error configuring TLS: %v, start with --insecure to serve and attest over plain HTTP", err)
+	}
 	v := verifier.NewVerifier(&conf.Verifier)
+	if conf.TLS.Enabled() {
+		conf.Rest.TLS, err = conf.TLS.Server()
+		if err != nil {
+			log.Fatalf("Error configuring TLS: %v", err)
+		}
+		clientTLS, err := conf.TLS.Client()
+		if err != nil {
+			log.Fatalf("Error configuring TLS: %v", err)
+		}
+		httpClient.Client = httpClient.New(clientTLS)
+		v.Scheme = "https"
+		operators, err := conf.TLS.Operators()
+		if err != nil {
+			log.Fatalf("Error configuring TLS: %v", err)
+		}
+		if operators != nil {
+			conf.Rest.Operators = operators
+		} else {
+			log.Warn("no admin CA configured, the admin routes are refused")
+		}
+	} else {
+		log.Warn("TLS not configured, serving and attesting over plain HTTP, the admin routes are refused")
+	}
+	store, err := conf.Verifier.OpenProverStore()
+	if err != nil {
+		log.Fatalf("Error opening prover store: %v", err)
+	}
+	defer store.Close()
+	v.SetStore(store)
+	v.CA, err = conf.Verifier.LoadPrivacyCA()
+	if err != nil {
+		log.Fatalf("Error loading privacy CA: %v", err)
+	}
+	v.EKTrust, err = conf.Verifier.LoadEKTrustStore()
+	if err != nil {
+		log.Fatalf("Error loading EK trust store: %v", err)
+	}
+	v.EventPolicy, err = conf.Verifier.LoadEventPolicy()
+	if err != nil {
+		log.Fatalf("Error loading event log policy: %v", err)
+	}
+	v.IMAPolicy, err = conf.Verifier.LoadIMAPolicy()
+	if err != nil {
+		log.Fatalf("Error loading IMA policy: %v", err)
+	}
+	v.PCRPolicies, err = conf.Verifier.LoadPCRPolicies()
+	if err != nil {
+		log.Fatalf("Error loading PCR policies: %v", err)
+	}
+	if len(conf.Verifier.Webhooks.Endpoints) > 0 {
+		v.Notifier = verifier.NewNotifier(conf.Verifier.Webhooks, v.Letters)
+	}
 	server, err := RestServer.NewServer(&conf.Rest, v)
 	if err != nil {
 		log.Fatalf("Error creating server: %v", err)
@@ -92,25 +141,42 @@ func main() {
 
 	var wg sync.WaitGroup
 
-	quit := make(chan struct{})
+	ctx, cancel := context.WithCancel(context.Background())
+	if v.Notifier != nil {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			v.Notifier.Run(ctx)
+		}()
+	}
+	scheduler := verifier.NewScheduler(v)
 	wg.Add(1)
 	go func() {
 		defer wg.Done()
-		for {
-			select {
-			case <-quit:
-				log.Info("stopping attestations")
-				return
-			default:
-				v.StartAttestations()
-			}
-			time.Sleep(conf.Verifier.AttestationInterval)
-		}
+		scheduler.Run(ctx)
 	}()
 
+	if v.EKTrust != nil && conf.Verifier.EKTrust.CRLRefreshInterval > 0 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			ticker := time.NewTicker(conf.Verifier.EKTrust.CRLRefreshInterval)
+			defer ticker.Stop()
+			for {
+				v.RefreshRevocations()
+				select {
+				case <-ctx.Done():
+					log.Info("stopping CRL refresh")
+					return
+				case <-ticker.C:
+				}
+			}
+		}()
+	}
+
 	<-c
 	//Stop attestations
-	close(quit)
+	cancel()
 	err = server.Stop()
 	if err != nil {
 		log.Error(err)
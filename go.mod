@@ -3,15 +3,16 @@ module github.com/xcaliburne/RemoteAttestations
 go 1.15
 
 require (
-	github.com/golang/groupcache v0.0.0-20200121045136-8c9f03a8e57e // indirect
-	github.com/google/certificate-transparency-go v1.1.1
-	github.com/google/go-cmp v0.5.2
-	github.com/google/go-tpm v0.3.1
-	github.com/google/go-tspi v0.2.0
+	github.com/google/certificate-transparency-go v1.1.2
+	github.com/google/go-cmp v0.5.7
+	github.com/google/go-tpm v0.3.3
+	github.com/google/go-tpm-tools v0.3.9
+	github.com/google/go-tspi v0.2.1-0.20190423175329-115dea689aad
 	github.com/gorilla/mux v1.8.0
 	github.com/loov/goda v0.2.1 // indirect
 	github.com/modern-go/concurrent v0.0.0-20180306012644-bacd9c7ef1dd // indirect
 	github.com/sirupsen/logrus v1.7.0
 	github.com/spf13/pflag v1.0.5
-	gopkg.in/yaml.v3 v3.0.0-20200313102051-9f266ea9e77c
+	go.etcd.io/bbolt v1.3.6
+	gopkg.in/yaml.v3 v3.0.0-20210107192922-496545a6307b
 )
@@ -2,19 +2,26 @@ package RestServer
 
 import (
 	"context"
+	"crypto/tls"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"github.com/gorilla/mux"
 	log "github.com/sirupsen/logrus"
 	"github.com/xcaliburne/RemoteAttestations/internal/prover"
+	"github.com/xcaliburne/RemoteAttestations/pkg/tpm"
 	"net"
 	"net/http"
+	"os"
+	"strconv"
 	"time"
 )
 
 type Config struct {
 	Address net.IP `yaml:"address"`
 	Port    string `yaml:"port"`
+	// TLS serves HTTPS instead of plain HTTP when set.
+	TLS *tls.Config `yaml:"-"`
 }
 
 type RestServer struct {
@@ -26,6 +33,39 @@ type RestServer struct {
 func (rest *RestServer) handleRequests(router *mux.Router) {
 	router.HandleFunc("/", rest.test).Methods("POST", "GET")
 	router.HandleFunc("/attest", rest.attest).Methods("POST")
+	router.HandleFunc("/eventlog", rest.eventLog).Methods("GET")
+	router.HandleFunc("/ima", rest.imaLog).Methods("GET")
+	router.Handle("/metrics", prover.Metrics).Methods("GET")
+	router.Use(countRequests)
+}
+
+var requestsTotal = prover.Metrics.NewCounter("prover_http_requests_total",
+	"HTTP requests served by route, method and status code.", "route", "method", "code")
+
+// countRequests counts the requests served by next.
+func countRequests(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		recorder := &statusRecorder{ResponseWriter: w, status: http.StatusOK}
+		next.ServeHTTP(recorder, r)
+		route := r.URL.Path
+		if current := mux.CurrentRoute(r); current != nil {
+			if template, err := current.GetPathTemplate(); err == nil {
+				route = template
+			}
+		}
+		requestsTotal.Inc(route, r.Method, strconv.Itoa(recorder.status))
+	})
+}
+
+// statusRecorder keeps the status code written to the ResponseWriter.
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+}
+
+func (r *statusRecorder) WriteHeader(status int) {
+	r.status = status
+	r.ResponseWriter.WriteHeader(status)
 }
 
 func NewServer(config *Config, prover prover.Prover) (*RestServer, error) {
@@ -40,6 +80,7 @@ func NewServer(config *Config, prover prover.Prover) (*RestServer, error) {
 		ReadTimeout:  time.Second * 15,
 		IdleTimeout:  time.Second * 60,
 		Handler:      router, // Pass our instance of gorilla/mux in.
+		TLSConfig:    config.TLS,
 	}
 	restServer := &RestServer{
 		config: config,
@@ -54,7 +95,14 @@ func (rest *RestServer) Run() {
 	log.Info(fmt.Sprintf("starting up REST API on %s\n", rest.server.Addr))
 	go func() {
 		defer log.Info("server goroutine terminated")
-		if err := rest.server.ListenAndServe(); err != nil {
+		var err error
+		if rest.server.TLSConfig != nil {
+			// The certificate and key are already in TLSConfig.
+			err = rest.server.ListenAndServeTLS("", "")
+		} else {
+			err = rest.server.ListenAndServe()
+		}
+		if err != nil {
 			log.Info(err)
 		}
 	}()
@@ -76,8 +124,10 @@ func (rest *RestServer) test(w http.ResponseWriter, r *http.Request) {
 func (rest *RestServer) attest(w http.ResponseWriter, r *http.Request) {
 	log.Info(r.URL)
 	decoder := json.NewDecoder(r.Body)
+	// PCRSelection is optional, the prover quotes every PCR of its configured bank by default.
 	var queryBody = struct {
-		Nonce []byte
+		Nonce        []byte
+		PCRSelection tpm.PCRSelection
 	}{}
 	err := decoder.Decode(&queryBody)
 	if err != nil {
@@ -90,7 +140,14 @@ func (rest *RestServer) attest(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, "empty nonce", http.StatusBadRequest)
 		return
 	}
-	attestation, err := rest.p.Attest(queryBody.Nonce[:])
+	if queryBody.PCRSelection.Bank != "" || len(queryBody.PCRSelection.PCRs) > 0 {
+		if err = queryBody.PCRSelection.Validate(); err != nil {
+			log.Error("invalid PCR selection: ", err)
+			http.Error(w, "invalid PCR selection", http.StatusBadRequest)
+			return
+		}
+	}
+	attestation, err := rest.p.Attest(queryBody.Nonce[:], queryBody.PCRSelection)
 	if err != nil {
 		log.Error("error computing attestation: ", err)
 		http.Error(w, "error computing attestation", http.StatusInternalServerError)
@@ -109,3 +166,61 @@ func (rest *RestServer) attest(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 }
+
+func (rest *RestServer) eventLog(w http.ResponseWriter, r *http.Request) {
+	log.Info(r.URL)
+	eventLog, err := rest.p.EventLog()
+	if os.IsNotExist(err) {
+		http.Error(w, "no event log", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		log.Error("error reading event log: ", err)
+		http.Error(w, "error reading event log", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/octet-stream")
+	_, err = w.Write(eventLog)
+	if err != nil {
+		log.Error("error writing response: ", err)
+	}
+}
+
+// imaLog serves the IMA measurement list entries from the offset query parameter, 0 by default.
+func (rest *RestServer) imaLog(w http.ResponseWriter, r *http.Request) {
+	log.Info(r.URL)
+	offset := 0
+	if s := r.URL.Query().Get("offset"); s != "" {
+		var err error
+		if offset, err = strconv.Atoi(s); err != nil || offset < 0 {
+			http.Error(w, "invalid offset", http.StatusBadRequest)
+			return
+		}
+	}
+	entries, err := rest.p.IMALog(offset)
+	if os.IsNotExist(err) {
+		http.Error(w, "no IMA measurement list", http.StatusNotFound)
+		return
+	}
+	if errors.Is(err, prover.ErrIMAOffset) {
+		// The list restarted with a reboot, the verifier fetches it again from the start
+		http.Error(w, "offset past the end of the IMA measurement list", http.StatusRequestedRangeNotSatisfiable)
+		return
+	}
+	if err != nil {
+		log.Error("error reading IMA measurement list: ", err)
+		http.Error(w, "error reading IMA measurement list", http.StatusInternalServerError)
+		return
+	}
+	respBody, err := json.Marshal(entries)
+	if err != nil {
+		log.Error("error marshaling response: ", err)
+		http.Error(w, "error marshaling response", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	_, err = w.Write(respBody)
+	if err != nil {
+		log.Error("error writing response: ", err)
+	}
+}
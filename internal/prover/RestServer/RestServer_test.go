@@ -10,6 +10,7 @@ import (
 	"github.com/xcaliburne/RemoteAttestations/internal/prover/tests/mocks"
 	"github.com/xcaliburne/RemoteAttestations/internal/verifier/tests/fakes"
 	"github.com/xcaliburne/RemoteAttestations/pkg/httpClient"
+	"github.com/xcaliburne/RemoteAttestations/pkg/ima"
 	"github.com/xcaliburne/RemoteAttestations/pkg/tests"
 	"github.com/xcaliburne/RemoteAttestations/pkg/tpm"
 	tpmFakes "github.com/xcaliburne/RemoteAttestations/pkg/tpm/tests/fakes"
@@ -19,6 +20,8 @@ import (
 	"net/http"
 	"net/http/httptest"
 	"net/url"
+	"os"
+	"strings"
 	"testing"
 	"time"
 )
@@ -95,15 +98,36 @@ func TestRestServer_attest(t *testing.T) {
 		{
 			name:       "valid query",
 			input:      fmt.Sprintf("{\"Nonce\": \"%v\"}", string(fakes.GetFakeNonce())),
-			mock:       mocks.MockProver{CatchAttest: func(nonce []byte) (tpm.Quote, error) { return tpmFakes.GetFakeQuote(), nil }},
+			mock:       mocks.MockProver{CatchAttest: func(nonce []byte, sel tpm.PCRSelection) (tpm.Quote, error) { return tpmFakes.GetFakeQuote(), nil }},
 			want:       jsonQuote,
 			wantErr:    nil,
 			wantStatus: http.StatusOK,
 		},
+		{
+			name:  "query with PCR selection",
+			input: fmt.Sprintf("{\"Nonce\": \"%v\", \"PCRSelection\": {\"Bank\": \"sha1\", \"PCRs\": [0, 7]}}", string(fakes.GetFakeNonce())),
+			mock: mocks.MockProver{CatchAttest: func(nonce []byte, sel tpm.PCRSelection) (tpm.Quote, error) {
+				if !cmp.Equal(sel, tpm.PCRSelection{Bank: tpm.SHA1, PCRs: []int{0, 7}}) {
+					return nil, fmt.Errorf("unexpected selection %v", sel)
+				}
+				return tpmFakes.GetFakeQuote(), nil
+			}},
+			want:       jsonQuote,
+			wantErr:    nil,
+			wantStatus: http.StatusOK,
+		},
+		{
+			name:       "query with invalid PCR selection",
+			input:      fmt.Sprintf("{\"Nonce\": \"%v\", \"PCRSelection\": {\"Bank\": \"md5\", \"PCRs\": [0]}}", string(fakes.GetFakeNonce())),
+			mock:       mocks.MockProver{CatchAttest: func(nonce []byte, sel tpm.PCRSelection) (tpm.Quote, error) { return tpmFakes.GetFakeQuote(), nil }},
+			want:       []byte{},
+			wantErr:    nil,
+			wantStatus: http.StatusBadRequest,
+		},
 		{
 			name:       "query without nonce",
 			input:      fmt.Sprintf("{\"Nonce\": \"%v\"}", ""),
-			mock:       mocks.MockProver{CatchAttest: func(nonce []byte) (tpm.Quote, error) { return tpmFakes.GetFakeQuote(), nil }},
+			mock:       mocks.MockProver{CatchAttest: func(nonce []byte, sel tpm.PCRSelection) (tpm.Quote, error) { return tpmFakes.GetFakeQuote(), nil }},
 			want:       []byte{},
 			wantErr:    nil,
 			wantStatus: http.StatusBadRequest,
@@ -111,7 +135,7 @@ func TestRestServer_attest(t *testing.T) {
 		{
 			name:       "query with invalid json",
 			input:      fmt.Sprintf("{\"Nonce\": \"%v\"}", "{{"),
-			mock:       mocks.MockProver{CatchAttest: func(nonce []byte) (tpm.Quote, error) { return tpmFakes.GetFakeQuote(), nil }},
+			mock:       mocks.MockProver{CatchAttest: func(nonce []byte, sel tpm.PCRSelection) (tpm.Quote, error) { return tpmFakes.GetFakeQuote(), nil }},
 			want:       []byte{},
 			wantErr:    nil,
 			wantStatus: http.StatusBadRequest,
@@ -119,7 +143,7 @@ func TestRestServer_attest(t *testing.T) {
 		{
 			name:       "query with internal error",
 			input:      fmt.Sprintf("{\"Nonce\": \"%v\"}", string(fakes.GetFakeNonce())),
-			mock:       mocks.MockProver{CatchAttest: func(nonce []byte) (tpm.Quote, error) { return nil, fmt.Errorf("some error") }},
+			mock:       mocks.MockProver{CatchAttest: func(nonce []byte, sel tpm.PCRSelection) (tpm.Quote, error) { return nil, fmt.Errorf("some error") }},
 			want:       []byte{},
 			wantErr:    nil,
 			wantStatus: http.StatusInternalServerError,
@@ -127,7 +151,7 @@ func TestRestServer_attest(t *testing.T) {
 		{
 			name:  "query with marshaling error",
 			input: fmt.Sprintf("{\"Nonce\": \"%v\"}", string(fakes.GetFakeNonce())),
-			mock: mocks.MockProver{CatchAttest: func(nonce []byte) (tpm.Quote, error) {
+			mock: mocks.MockProver{CatchAttest: func(nonce []byte, sel tpm.PCRSelection) (tpm.Quote, error) {
 				return &mocksTPM.MockQuote{
 					CatchUnmarshal: func(data []byte) error {
 						return fmt.Errorf("some error")
@@ -164,3 +188,164 @@ func TestRestServer_attest(t *testing.T) {
 		})
 	}
 }
+
+func TestRestServer_eventLog(t *testing.T) {
+	var testSuite = []struct {
+		name       string
+		mock       mocks.MockProver
+		want       []byte
+		wantStatus int
+	}{
+		{
+			name:       "event log",
+			mock:       mocks.MockProver{CatchEventLog: func() ([]byte, error) { return []byte("event log"), nil }},
+			want:       []byte("event log"),
+			wantStatus: http.StatusOK,
+		},
+		{
+			name:       "no event log",
+			mock:       mocks.MockProver{CatchEventLog: func() ([]byte, error) { return nil, os.ErrNotExist }},
+			wantStatus: http.StatusNotFound,
+		},
+		{
+			name:       "error reading event log",
+			mock:       mocks.MockProver{CatchEventLog: func() ([]byte, error) { return nil, fmt.Errorf("some error") }},
+			wantStatus: http.StatusInternalServerError,
+		},
+	}
+
+	testServer := httptest.NewServer(http.HandlerFunc(r.eventLog))
+	defer testServer.Close()
+
+	for _, test := range testSuite {
+		t.Run(test.name, func(t *testing.T) {
+			r.p = &test.mock
+			resp, err := http.Get(testServer.URL)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer resp.Body.Close()
+			got, _ := ioutil.ReadAll(resp.Body)
+			if resp.StatusCode != test.wantStatus {
+				t.Error(tests.Failure(t, resp.StatusCode, test.wantStatus, ""))
+			}
+			if len(test.want) != 0 && !cmp.Equal(got, test.want) {
+				t.Error(tests.Failure(t, got, test.want, ""))
+			}
+		})
+	}
+}
+
+func TestRestServer_imaLog(t *testing.T) {
+	entries := []ima.Entry{{PCR: ima.PCR, TemplateName: "ima-ng", Path: "/usr/bin/bash"}}
+	var testSuite = []struct {
+		name       string
+		query      string
+		mock       mocks.MockProver
+		want       []ima.Entry
+		wantStatus int
+	}{
+		{
+			name: "whole list",
+			mock: mocks.MockProver{CatchIMALog: func(offset int) ([]ima.Entry, error) {
+				return entries, nil
+			}},
+			want:       entries,
+			wantStatus: http.StatusOK,
+		},
+		{
+			name:  "from an offset",
+			query: "?offset=3",
+			mock: mocks.MockProver{CatchIMALog: func(offset int) ([]ima.Entry, error) {
+				if offset != 3 {
+					return nil, fmt.Errorf("unexpected offset %d", offset)
+				}
+				return entries, nil
+			}},
+			want:       entries,
+			wantStatus: http.StatusOK,
+		},
+		{
+			name:       "invalid offset",
+			query:      "?offset=-1",
+			mock:       mocks.MockProver{},
+			wantStatus: http.StatusBadRequest,
+		},
+		{
+			name:       "IMA disabled",
+			mock:       mocks.MockProver{CatchIMALog: func(int) ([]ima.Entry, error) { return nil, os.ErrNotExist }},
+			wantStatus: http.StatusNotFound,
+		},
+		{
+			name:  "offset past the end of the list",
+			query: "?offset=3",
+			mock: mocks.MockProver{CatchIMALog: func(int) ([]ima.Entry, error) {
+				return nil, fmt.Errorf("%w: offset 3 for 1 entries", prover.ErrIMAOffset)
+			}},
+			wantStatus: http.StatusRequestedRangeNotSatisfiable,
+		},
+		{
+			name:       "error reading the list",
+			mock:       mocks.MockProver{CatchIMALog: func(int) ([]ima.Entry, error) { return nil, fmt.Errorf("some error") }},
+			wantStatus: http.StatusInternalServerError,
+		},
+	}
+
+	testServer := httptest.NewServer(http.HandlerFunc(r.imaLog))
+	defer testServer.Close()
+
+	for _, test := range testSuite {
+		t.Run(test.name, func(t *testing.T) {
+			r.p = &test.mock
+			resp, err := http.Get(testServer.URL + test.query)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer resp.Body.Close()
+			if resp.StatusCode != test.wantStatus {
+				t.Fatal(tests.Failure(t, resp.StatusCode, test.wantStatus, ""))
+			}
+			if test.want == nil {
+				return
+			}
+			var got []ima.Entry
+			if err = json.NewDecoder(resp.Body).Decode(&got); err != nil {
+				t.Fatal(err)
+			}
+			if !cmp.Equal(got, test.want) {
+				t.Error(tests.Failure(t, got, test.want, ""))
+			}
+		})
+	}
+}
+
+func TestRestServer_metrics(t *testing.T) {
+	router := mux.NewRouter()
+	r.handleRequests(router)
+	testServer := httptest.NewServer(router)
+	defer testServer.Close()
+	r.p = &mocks.MockProver{CatchIMALog: func(offset int) ([]ima.Entry, error) { return nil, nil }}
+
+	for _, path := range []string{"/ima", "/ima?offset=1", "/ima?offset=first"} {
+		resp, err := http.Get(testServer.URL + path)
+		if err != nil {
+			t.Fatalf("Get() returned an error: %v", err)
+		}
+		resp.Body.Close()
+	}
+	resp, err := http.Get(testServer.URL + "/metrics")
+	if err != nil {
+		t.Fatalf("Get() returned an error: %v", err)
+	}
+	defer resp.Body.Close()
+	body, _ := ioutil.ReadAll(resp.Body)
+	for _, want := range []string{
+		`prover_http_requests_total{route="/ima",method="GET",code="200"} 2`,
+		`prover_http_requests_total{route="/ima",method="GET",code="400"} 1`,
+		"# TYPE prover_quote_seconds histogram",
+	} {
+		if !strings.Contains(string(body), want) {
+			t.Error(tests.Failure(t, string(body), want, "metrics"))
+		}
+	}
+}
@@ -1,30 +1,80 @@
 package prover
 
 import (
+	"github.com/xcaliburne/RemoteAttestations/pkg/tpm"
 	"net/url"
 	"strings"
 )
 
+type TPMConfig struct {
+	Backend  string `yaml:"backend"`
+	StateDir string `yaml:"state_dir"`
+}
+
+// SealConfig binds prover secrets to the platform state, sealed files are created with the seal command.
+// The user password authorizes the SRK the secrets are sealed with, so it cannot be sealed itself.
+type SealConfig struct {
+	// PCRs are the PCRs of the quoted bank the secrets are sealed to.
+	PCRs []int `yaml:"pcrs"`
+	// OwnerPasswordFile is a sealed file holding the owner password, it replaces owner_password when set.
+	OwnerPasswordFile string `yaml:"owner_password_file"`
+	// AK keeps the attestation key file sealed.
+	AK bool `yaml:"attestation_key"`
+}
+
 type Config struct {
-	Name            string   `yaml:"name"`
-	AKFile          string   `yaml:"attestation_key"`
-	OwnerPassword   string   `yaml:"owner_password"`
-	UserPassword    string   `yaml:"user_password"`
-	VerifierAddress *url.URL `yaml:"verifier_url"`
+	Name            string     `yaml:"name"`
+	AKFile          string     `yaml:"attestation_key"`
+	AKCertFile      string     `yaml:"attestation_key_certificate"`
+	OwnerPassword   string     `yaml:"owner_password"`
+	UserPassword    string     `yaml:"user_password"`
+	VerifierAddress *url.URL   `yaml:"verifier_url"`
+	TPM             TPMConfig  `yaml:"tpm"`
+	PCRBank         tpm.Bank   `yaml:"pcr_bank"`
+	Seal            SealConfig `yaml:"seal"`
+	EventLog        string     `yaml:"event_log"`
+	IMALog          string     `yaml:"ima_log"`
+	// Labels are sent to the verifier at registration, they select the policies the prover is appraised with once an
+	// operator approves them.
+	Labels map[string]string `yaml:"labels"`
+	// EnrollmentToken is exchanged with the verifier for the TPM passwords when the TPM is not owned yet.
+	EnrollmentToken string `yaml:"enrollment_token"`
+	// CredentialsFile keeps the enrolled TPM passwords, they replace owner_password and user_password once it exists.
+	// It is created with mode 0600 and refused when group or other can access it.
+	CredentialsFile string `yaml:"tpm_credentials"`
 }
 
 func (c *Config) UnmarshalYAML(unmarshal func(interface{}) error) error {
 	var s struct {
-		Name            string `yaml:"name"`
-		AKFile          string `yaml:"attestation_key"`
-		OwnerPassword   string `yaml:"owner_password"`
-		UserPassword    string `yaml:"user_password"`
-		VerifierAddress string `yaml:"verifier_url"`
+		Name            string            `yaml:"name"`
+		AKFile          string            `yaml:"attestation_key"`
+		AKCertFile      string            `yaml:"attestation_key_certificate"`
+		OwnerPassword   string            `yaml:"owner_password"`
+		UserPassword    string            `yaml:"user_password"`
+		EnrollmentToken string            `yaml:"enrollment_token"`
+		CredentialsFile string            `yaml:"tpm_credentials"`
+		VerifierAddress string            `yaml:"verifier_url"`
+		TPM             TPMConfig         `yaml:"tpm"`
+		PCRBank         tpm.Bank          `yaml:"pcr_bank"`
+		Seal            SealConfig        `yaml:"seal"`
+		EventLog        string            `yaml:"event_log"`
+		IMALog          string            `yaml:"ima_log"`
+		Labels          map[string]string `yaml:"labels"`
+	}
+	//Keep already set values for keys missing from the file
+	s.Name, s.AKFile, s.AKCertFile, s.OwnerPassword, s.UserPassword, s.EnrollmentToken, s.CredentialsFile, s.TPM, s.PCRBank, s.Seal, s.EventLog, s.IMALog, s.Labels = c.Name, c.AKFile, c.AKCertFile, c.OwnerPassword, c.UserPassword, c.EnrollmentToken, c.CredentialsFile, c.TPM, c.PCRBank, c.Seal, c.EventLog, c.IMALog, c.Labels
+	if c.VerifierAddress != nil {
+		s.VerifierAddress = c.VerifierAddress.String()
 	}
 	err := unmarshal(&s)
 	if err != nil {
 		return err
 	}
+	c.Name, c.AKFile, c.AKCertFile, c.OwnerPassword, c.UserPassword, c.EnrollmentToken, c.CredentialsFile, c.TPM, c.Seal, c.EventLog, c.IMALog, c.Labels = s.Name, s.AKFile, s.AKCertFile, s.OwnerPassword, s.UserPassword, s.EnrollmentToken, s.CredentialsFile, s.TPM, s.Seal, s.EventLog, s.IMALog, s.Labels
+	c.PCRBank, err = tpm.ParseBank(string(s.PCRBank))
+	if err != nil {
+		return err
+	}
 	//TODO: Fix c.VerifierAddress parsing
 	c.VerifierAddress, err = HttpUrlParser(s.VerifierAddress)
 	if err != nil {
@@ -33,6 +83,11 @@ func (c *Config) UnmarshalYAML(unmarshal func(interface{}) error) error {
 	return nil
 }
 
+// SealSelection returns the PCRs secrets are sealed to.
+func (c *Config) SealSelection() tpm.PCRSelection {
+	return tpm.PCRSelection{Bank: c.PCRBank, PCRs: c.Seal.PCRs}
+}
+
 func HttpUrlParser(s string) (*url.URL, error) {
 	if !strings.HasPrefix(s, "http") {
 		s = "http://" + s
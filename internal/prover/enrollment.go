@@ -0,0 +1,105 @@
+package prover
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	log "github.com/sirupsen/logrus"
+	"github.com/xcaliburne/RemoteAttestations/pkg/httpClient"
+	"io/ioutil"
+	"net/http"
+	"os"
+)
+
+// Credentials are the TPM passwords the verifier generates for the prover when it enrolls.
+type Credentials struct {
+	OwnerPassword string
+	UserPassword  string
+}
+
+// LoadCredentials replaces the TPM passwords of c with the ones of its credentials file, when the prover is enrolled.
+// A credentials file readable by group or other is refused.
+func (c *Config) LoadCredentials() error {
+	if c.CredentialsFile == "" {
+		return nil
+	}
+	info, err := os.Stat(c.CredentialsFile)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("error reading TPM credentials: %v", err)
+	}
+	if info.Mode().Perm()&0077 != 0 {
+		return fmt.Errorf("TPM credentials file %v has mode %v, it must not be accessible by group or other", c.CredentialsFile, info.Mode().Perm())
+	}
+	data, err := ioutil.ReadFile(c.CredentialsFile)
+	if err != nil {
+		return fmt.Errorf("error reading TPM credentials: %v", err)
+	}
+	var credentials Credentials
+	err = json.Unmarshal(data, &credentials)
+	if err != nil {
+		return fmt.Errorf("error decoding TPM credentials: %v", err)
+	}
+	c.OwnerPassword, c.UserPassword = credentials.OwnerPassword, credentials.UserPassword
+	return nil
+}
+
+// enroll exchanges the enrollment token for the TPM credentials of the prover. They are saved before the TPM
+// is taken with them, a prover stopped in between keeps its credentials as the token cannot be exchanged again.
+func (p *DataProver) enroll() error {
+	if p.Config.CredentialsFile == "" {
+		return fmt.Errorf("no TPM credentials file to save the enrolled credentials in")
+	}
+	if _, err := os.Stat(p.Config.CredentialsFile); err == nil {
+		log.Info("already enrolled, TPM credentials read from ", p.Config.CredentialsFile)
+		return nil
+	}
+	queryURL := *p.Config.VerifierAddress
+	queryURL.Path = "enroll"
+	body := struct {
+		Token string
+		Name  string
+	}{p.Config.EnrollmentToken, p.Config.Name}
+	jsonBody, err := json.Marshal(body)
+	if err != nil {
+		return fmt.Errorf("error while marshaling body: %v", err)
+	}
+	r, err := httpClient.Client.Post(queryURL.String(), "application/json", jsonBody)
+	if err != nil {
+		return fmt.Errorf("error post query: %v", err)
+	}
+	defer r.Body.Close()
+	if r.StatusCode != http.StatusOK {
+		reason, _ := ioutil.ReadAll(r.Body)
+		return fmt.Errorf("enrollment refused: %v: %s", r.Status, bytes.TrimSpace(reason))
+	}
+	var credentials Credentials
+	err = json.NewDecoder(r.Body).Decode(&credentials)
+	if err != nil {
+		return fmt.Errorf("error decoding credentials: %v", err)
+	}
+	if credentials.OwnerPassword == "" || credentials.UserPassword == "" {
+		return fmt.Errorf("verifier returned empty credentials")
+	}
+	data, err := json.Marshal(credentials)
+	if err != nil {
+		return fmt.Errorf("error marshaling credentials: %v", err)
+	}
+	// The file is created with its final mode, a file created meanwhile is not reused
+	file, err := os.OpenFile(p.Config.CredentialsFile, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+	if err != nil {
+		return fmt.Errorf("error saving TPM credentials: %v", err)
+	}
+	_, err = file.Write(data)
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return fmt.Errorf("error saving TPM credentials: %v", err)
+	}
+	log.Info("enrolled, TPM credentials saved in ", p.Config.CredentialsFile)
+	p.Config.OwnerPassword, p.Config.UserPassword = credentials.OwnerPassword, credentials.UserPassword
+	return nil
+}
@@ -0,0 +1,15 @@
+package prover
+
+import (
+	"github.com/xcaliburne/RemoteAttestations/pkg/metrics"
+)
+
+// Metrics are the metrics of the prover, served on /metrics.
+var Metrics = metrics.NewRegistry()
+
+var (
+	quoteSeconds = Metrics.NewHistogram("prover_quote_seconds",
+		"Time the TPM takes to quote the PCRs.", nil)
+	tpmErrorsTotal = Metrics.NewCounter("prover_tpm_errors_total",
+		"TPM operations that failed, by operation.", "operation")
+)
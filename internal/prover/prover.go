@@ -1,19 +1,30 @@
 package prover
 
 import (
+	"bytes"
 	"encoding/json"
+	"encoding/pem"
+	"errors"
 	"fmt"
 	log "github.com/sirupsen/logrus"
 	"github.com/xcaliburne/RemoteAttestations/pkg/httpClient"
+	"github.com/xcaliburne/RemoteAttestations/pkg/ima"
 	"github.com/xcaliburne/RemoteAttestations/pkg/tpm"
+	"io/ioutil"
 	"net/http"
+	"time"
 )
 
 type Prover interface {
 	Register(restIP, restPort string) error
-	Attest(nonce []byte) (tpm.Quote, error)
+	Attest(nonce []byte, sel tpm.PCRSelection) (tpm.Quote, error)
+	EventLog() ([]byte, error)
+	IMALog(offset int) ([]ima.Entry, error)
 }
 
+// ErrIMAOffset is returned by IMALog for an offset past the end of the IMA measurement list, it restarted with a reboot.
+var ErrIMAOffset = errors.New("offset past the end of the IMA measurement list")
+
 type DataProver struct {
 	Config *Config
 	TPM    tpm.TPM
@@ -26,7 +37,11 @@ var _ Prover = (*DataProver)(nil)
 func NewProver(config *Config) (*DataProver, error) {
 	var p DataProver
 	var err error
-	t, err := tpm.Open()
+	err = config.LoadCredentials()
+	if err != nil {
+		return nil, err
+	}
+	t, err := openTPM(config.TPM)
 	if err != nil {
 		return nil, fmt.Errorf("error opening TPM: %v", err)
 	}
@@ -35,6 +50,12 @@ func NewProver(config *Config) (*DataProver, error) {
 	if err != nil {
 		return nil, err
 	}
+	if !isInit && config.EnrollmentToken != "" {
+		err = p.enroll()
+		if err != nil {
+			return nil, fmt.Errorf("error enrolling prover: %v", err)
+		}
+	}
 	if !isInit {
 		err = p.init()
 		if err != nil {
@@ -48,6 +69,21 @@ func NewProver(config *Config) (*DataProver, error) {
 	return &p, nil
 }
 
+func openTPM(config TPMConfig) (tpm.TPM, error) {
+	switch config.Backend {
+	case "", "auto":
+		return tpm.Open()
+	case "tspi":
+		return tpm.OpenVersion(1)
+	case "tpm2":
+		return tpm.OpenVersion(2)
+	case "software":
+		return tpm.OpenSoftware(config.StateDir)
+	default:
+		return nil, fmt.Errorf("unknown TPM backend: %v", config.Backend)
+	}
+}
+
 func (p *DataProver) isInit() (bool, error) {
 	owned, err := p.TPM.IsOwned()
 	if err != nil {
@@ -62,11 +98,20 @@ func (p *DataProver) init() error {
 	if err != nil {
 		return fmt.Errorf("error taking ownership: %v", err)
 	}
+	// The AK is wrapped under the SRK, authorized by the user password.
+	err = p.TPM.ProveUsership(p.Config.UserPassword)
+	if err != nil {
+		return fmt.Errorf("error proving usership: %v", err)
+	}
 	ak, err := p.TPM.CreateAK()
 	if err != nil {
 		return fmt.Errorf("error while creating ak: %v", err)
 	}
-	err = ak.Save(p.Config.AKFile)
+	if p.Config.Seal.AK {
+		err = p.saveSealedAK(ak)
+	} else {
+		err = ak.Save(p.Config.AKFile)
+	}
 	log.Info("saved ak in ", p.Config.AKFile)
 	if err != nil {
 		return fmt.Errorf("error saving ak: %v", err)
@@ -74,20 +119,51 @@ func (p *DataProver) init() error {
 	return nil
 }
 
-func (p *DataProver) load() error {
-	err := p.TPM.ProveOwnership(p.Config.OwnerPassword)
+func (p *DataProver) saveSealedAK(ak tpm.AttestationKey) error {
+	akJson, err := json.Marshal(ak)
 	if err != nil {
-		return fmt.Errorf("error proving ownership: %v", err)
+		return fmt.Errorf("error marshaling attestation key: %v", err)
 	}
-	err = p.TPM.ProveUsership(p.Config.UserPassword)
+	sealed, err := p.TPM.Seal(akJson, p.Config.SealSelection())
+	if err != nil {
+		return fmt.Errorf("error sealing attestation key: %v", err)
+	}
+	return ioutil.WriteFile(p.Config.AKFile, sealed, 0400)
+}
+
+func (p *DataProver) loadSealedAK() (tpm.AttestationKey, error) {
+	akJson, err := UnsealFile(p.TPM, p.Config.AKFile)
+	if err != nil {
+		return nil, err
+	}
+	var ak tpm.AttestationKeyData
+	err = json.Unmarshal(akJson, &ak)
+	if err != nil {
+		return nil, err
+	}
+	return &ak, nil
+}
+
+func (p *DataProver) load() error {
+	// Usership is proven first, sealed secrets are unsealed with the SRK.
+	err := p.TPM.ProveUsership(p.Config.UserPassword)
 	if err != nil {
 		return fmt.Errorf("error proving usership: %v", err)
 	}
+	err = ProveOwnership(p.TPM, p.Config)
+	if err != nil {
+		return err
+	}
 	ek, err := p.TPM.GetEK()
 	if err != nil {
 		return fmt.Errorf("error getting EK: %v", err)
 	}
-	ak, err := tpm.LoadAK(p.Config.AKFile)
+	var ak tpm.AttestationKey
+	if p.Config.Seal.AK {
+		ak, err = p.loadSealedAK()
+	} else {
+		ak, err = tpm.LoadAK(p.Config.AKFile)
+	}
 	if err != nil {
 		return fmt.Errorf("error loading AK: %v", err)
 	}
@@ -115,11 +191,13 @@ func (p *DataProver) registerEK(restIP, restPort string) error {
 		Endpoint string
 		Port     string
 		EK       tpm.EndorsementKey
+		Labels   map[string]string
 	}{
 		Name:     p.Config.Name,
 		Endpoint: restIP,
 		Port:     restPort,
 		EK:       p.EK,
+		Labels:   p.Config.Labels,
 	}
 	jsonBody, err := json.Marshal(body)
 	if err != nil {
@@ -131,11 +209,15 @@ func (p *DataProver) registerEK(restIP, restPort string) error {
 	}
 	defer r.Body.Close()
 	if r.StatusCode != http.StatusOK {
-		return fmt.Errorf("an error occured during query: %v", r.Status)
+		// A rejected EK certificate comes with a JSON reason
+		reason, _ := ioutil.ReadAll(r.Body)
+		return fmt.Errorf("an error occured during query: %v: %s", r.Status, bytes.TrimSpace(reason))
 	}
 	return nil
 }
 
+// registerAK submits the AK to the verifier and proves that it lives in the same TPM as the EK
+// by activating the returned credential.
 func (p *DataProver) registerAK() error {
 	queryURL := p.Config.VerifierAddress
 	queryURL.Path = "registerNewAK"
@@ -155,13 +237,91 @@ func (p *DataProver) registerAK() error {
 	if r.StatusCode != http.StatusOK {
 		return fmt.Errorf("an error occured during query: %v", r.Status)
 	}
+	var credential tpm.Credential
+	err = json.NewDecoder(r.Body).Decode(&credential)
+	if err != nil {
+		return fmt.Errorf("error decoding credential: %v", err)
+	}
+	secret, err := p.TPM.ActivateCredential(p.AK, &credential)
+	if err != nil {
+		tpmErrorsTotal.Inc("activate_credential")
+		return fmt.Errorf("error activating credential: %v", err)
+	}
+	return p.activateAK(secret)
+}
+
+func (p *DataProver) activateAK(secret []byte) error {
+	queryURL := p.Config.VerifierAddress
+	queryURL.Path = "activateAK"
+	body := struct {
+		EK     tpm.EndorsementKey
+		Secret []byte
+	}{p.EK, secret}
+	jsonBody, err := json.Marshal(body)
+	if err != nil {
+		return fmt.Errorf("error while marshaling body: %v", err)
+	}
+	r, err := httpClient.Client.Post(queryURL.String(), "application/json", jsonBody)
+	if err != nil {
+		return fmt.Errorf("error post query: %v", err)
+	}
+	defer r.Body.Close()
+	if r.StatusCode != http.StatusOK {
+		return fmt.Errorf("an error occured during query: %v", r.Status)
+	}
+	var resp struct{ AKCertificate []byte }
+	err = json.NewDecoder(r.Body).Decode(&resp)
+	if err != nil {
+		return fmt.Errorf("error decoding response: %v", err)
+	}
+	if len(resp.AKCertificate) == 0 || p.Config.AKCertFile == "" {
+		return nil
+	}
+	certPEM := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: resp.AKCertificate})
+	err = ioutil.WriteFile(p.Config.AKCertFile, certPEM, 0644)
+	if err != nil {
+		return fmt.Errorf("error saving AK certificate: %v", err)
+	}
+	log.Info("saved AK certificate in ", p.Config.AKCertFile)
 	return nil
 }
 
-func (p *DataProver) Attest(nonce []byte) (tpm.Quote, error) {
-	quote, err := p.TPM.Quote(p.AK, nonce, tpm.All_pcrs[:])
+// Attest quotes the PCRs of sel, every PCR when sel has none. The configured bank is used when sel has no bank.
+func (p *DataProver) Attest(nonce []byte, sel tpm.PCRSelection) (tpm.Quote, error) {
+	if sel.Bank == "" {
+		sel.Bank = p.Config.PCRBank
+	}
+	if len(sel.PCRs) == 0 {
+		sel = tpm.AllPCRs(sel.Bank)
+	}
+	start := time.Now()
+	quote, err := p.TPM.Quote(p.AK, nonce, sel)
 	if err != nil {
+		tpmErrorsTotal.Inc("quote")
 		return nil, fmt.Errorf("error while quoting: %v", err)
 	}
+	quoteSeconds.Observe(time.Since(start).Seconds())
 	return quote, nil
 }
+
+// EventLog returns the binary firmware event log the verifier replays against the quote.
+// The error satisfies os.IsNotExist when the platform has no event log.
+func (p *DataProver) EventLog() ([]byte, error) {
+	return ioutil.ReadFile(p.Config.EventLog)
+}
+
+// IMALog returns the entries of the IMA measurement list from offset, the verifier keeps the entries it already checked.
+// The error satisfies os.IsNotExist when IMA is disabled, it wraps ErrIMAOffset when offset is past the end of the list.
+func (p *DataProver) IMALog(offset int) ([]ima.Entry, error) {
+	entries, err := ima.Read(p.Config.IMALog)
+	if err != nil {
+		return nil, err
+	}
+	if offset < 0 {
+		return nil, fmt.Errorf("invalid offset %d for %d IMA entries", offset, len(entries))
+	}
+	if offset > len(entries) {
+		return nil, fmt.Errorf("%w: offset %d for %d entries", ErrIMAOffset, offset, len(entries))
+	}
+	return entries[offset:], nil
+}
@@ -14,6 +14,8 @@ import (
 	"io/ioutil"
 	"net/http"
 	"net/url"
+	"os"
+	"path/filepath"
 	"testing"
 )
 
@@ -69,42 +71,78 @@ func TestDataProver_IsInit(t *testing.T) {
 }
 
 func TestDataProver_Attest(t *testing.T) {
-	p := DataProver{}
+	p := DataProver{Config: &Config{PCRBank: tpm.SHA256}}
+	var gotSel tpm.PCRSelection
 	var testSuite = []struct {
 		name    string
 		mock    mocks.MockTPM
 		input   []byte
+		sel     tpm.PCRSelection
 		want    tpm.Quote
+		wantSel tpm.PCRSelection
 		wantErr error
 	}{
 		{
 			name: "Correct use",
 			mock: mocks.MockTPM{
-				CatchQuote: func(ak tpm.AttestationKey, nonce []byte, pcrIds []int) (tpm.Quote, error) {
+				CatchQuote: func(ak tpm.AttestationKey, nonce []byte, sel tpm.PCRSelection) (tpm.Quote, error) {
+					gotSel = sel
+					return tpmFakes.GetFakeQuote(), nil
+				},
+			},
+			input:   verifierFakes.GetFakeNonce(),
+			want:    tpmFakes.GetFakeQuote(),
+			wantSel: tpm.AllPCRs(tpm.SHA256),
+			wantErr: nil,
+		},
+		{
+			name: "PCR selection",
+			mock: mocks.MockTPM{
+				CatchQuote: func(ak tpm.AttestationKey, nonce []byte, sel tpm.PCRSelection) (tpm.Quote, error) {
+					gotSel = sel
+					return tpmFakes.GetFakeQuote(), nil
+				},
+			},
+			input:   verifierFakes.GetFakeNonce(),
+			sel:     tpm.PCRSelection{Bank: tpm.SHA1, PCRs: []int{0, 7}},
+			want:    tpmFakes.GetFakeQuote(),
+			wantSel: tpm.PCRSelection{Bank: tpm.SHA1, PCRs: []int{0, 7}},
+			wantErr: nil,
+		},
+		{
+			name: "PCR selection without bank",
+			mock: mocks.MockTPM{
+				CatchQuote: func(ak tpm.AttestationKey, nonce []byte, sel tpm.PCRSelection) (tpm.Quote, error) {
+					gotSel = sel
 					return tpmFakes.GetFakeQuote(), nil
 				},
 			},
 			input:   verifierFakes.GetFakeNonce(),
+			sel:     tpm.PCRSelection{PCRs: []int{10}},
 			want:    tpmFakes.GetFakeQuote(),
+			wantSel: tpm.PCRSelection{Bank: tpm.SHA256, PCRs: []int{10}},
 			wantErr: nil,
 		},
 		{
 			name: "tpm returns an error",
 			mock: mocks.MockTPM{
-				CatchQuote: func(ak tpm.AttestationKey, nonce []byte, pcrIds []int) (tpm.Quote, error) {
+				CatchQuote: func(ak tpm.AttestationKey, nonce []byte, sel tpm.PCRSelection) (tpm.Quote, error) {
+					gotSel = sel
 					return nil, fmt.Errorf("some error")
 				},
 			},
 			input:   verifierFakes.GetFakeNonce(),
 			want:    nil,
+			wantSel: tpm.AllPCRs(tpm.SHA256),
 			wantErr: fmt.Errorf("some error"),
 		},
 	}
 
+	quotes, tpmErrors := quoteSeconds.Count(), tpmErrorsTotal.Value("quote")
 	for _, test := range testSuite {
 		t.Run(test.name, func(t *testing.T) {
 			p.TPM = &test.mock
-			got, gotErr := p.Attest(test.input)
+			got, gotErr := p.Attest(test.input, test.sel)
 			if test.wantErr == nil && gotErr != nil {
 				t.Error(tests.Failure(t, gotErr, test.wantErr, ""))
 			} else if test.wantErr != nil && gotErr == nil {
@@ -113,8 +151,17 @@ func TestDataProver_Attest(t *testing.T) {
 			if !cmp.Equal(got, test.want) {
 				t.Errorf(tests.Failure(t, got, test.want, ""))
 			}
+			if !cmp.Equal(gotSel, test.wantSel) {
+				t.Error(tests.Failure(t, gotSel, test.wantSel, "quoted selection"))
+			}
 		})
 	}
+	if got := quoteSeconds.Count() - quotes; got != 3 {
+		t.Error(tests.Failure(t, got, 3, "quote latencies observed"))
+	}
+	if got := tpmErrorsTotal.Value("quote") - tpmErrors; got != 1 {
+		t.Error(tests.Failure(t, got, 1, "TPM quote errors counted"))
+	}
 }
 
 //func TestProver_load(t *testing.T) {
@@ -168,17 +215,60 @@ func TestDataProver_Register(t *testing.T) {
 					return &http.Response{
 						Status:     "",
 						StatusCode: 200,
-						Body:       ioutil.NopCloser(bytes.NewReader([]byte{})), //empty body != nil
+						Body:       ioutil.NopCloser(bytes.NewReader([]byte("{}"))), //empty credential
 					}, nil
 				},
 			},
 			input: DataProver{
 				Config: conf,
+				TPM:    &mocks.MockTPM{},
 				AK:     tpmFakes.GetFakeAttestationKeyValid(),
 				EK:     tpmFakes.GetFakeEndorsementKeyValid(),
 			},
 			wantErr: nil,
 		},
+		{
+			name: "Server does not return a credential",
+			mock: httpMocks.MockHttpClient{
+				CatchPost: func(url string, contentType string, body []byte) (*http.Response, error) {
+					return &http.Response{
+						Status:     "",
+						StatusCode: 200,
+						Body:       ioutil.NopCloser(bytes.NewReader([]byte{})), //empty body != nil
+					}, nil
+				},
+			},
+			input: DataProver{
+				Config: conf,
+				TPM:    &mocks.MockTPM{},
+				AK:     tpmFakes.GetFakeAttestationKeyValid(),
+				EK:     tpmFakes.GetFakeEndorsementKeyValid(),
+			},
+			wantErr: fmt.Errorf("some error"),
+		},
+		{
+			name: "Credential activation fails",
+			mock: httpMocks.MockHttpClient{
+				CatchPost: func(url string, contentType string, body []byte) (*http.Response, error) {
+					return &http.Response{
+						Status:     "",
+						StatusCode: 200,
+						Body:       ioutil.NopCloser(bytes.NewReader([]byte("{}"))), //empty credential
+					}, nil
+				},
+			},
+			input: DataProver{
+				Config: conf,
+				TPM: &mocks.MockTPM{
+					CatchActivateCredential: func(ak tpm.AttestationKey, credential *tpm.Credential) ([]byte, error) {
+						return nil, fmt.Errorf("some error")
+					},
+				},
+				AK: tpmFakes.GetFakeAttestationKeyValid(),
+				EK: tpmFakes.GetFakeEndorsementKeyValid(),
+			},
+			wantErr: fmt.Errorf("some error"),
+		},
 		{
 			name: "Server returns error",
 			mock: httpMocks.MockHttpClient{
@@ -279,3 +369,151 @@ func TestDataProver_Register(t *testing.T) {
 		})
 	}
 }
+
+func TestDataProver_loadSealed(t *testing.T) {
+	dir := t.TempDir()
+	config := &Config{
+		AKFile:       filepath.Join(dir, "ak.sealed"),
+		UserPassword: "user",
+		PCRBank:      tpm.SHA256,
+		Seal:         SealConfig{PCRs: []int{16}, OwnerPasswordFile: filepath.Join(dir, "owner.sealed"), AK: true},
+	}
+	swTPM, err := tpm.OpenSoftware(filepath.Join(dir, "swtpm"))
+	if err != nil {
+		t.Fatalf("OpenSoftware() returned an error: %v", err)
+	}
+	if err = swTPM.TakeOwnership("owner", "user"); err != nil {
+		t.Fatalf("TakeOwnership() returned an error: %v", err)
+	}
+	if err = swTPM.ProveUsership("user"); err != nil {
+		t.Fatalf("ProveUsership() returned an error: %v", err)
+	}
+	p := DataProver{Config: config, TPM: swTPM}
+	ak, err := swTPM.CreateAK()
+	if err != nil {
+		t.Fatalf("CreateAK() returned an error: %v", err)
+	}
+	if err = p.saveSealedAK(ak); err != nil {
+		t.Fatalf("saveSealedAK() returned an error: %v", err)
+	}
+	if err = ioutil.WriteFile(filepath.Join(dir, "owner"), []byte("owner\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err = SealFile(swTPM, config.SealSelection(), filepath.Join(dir, "owner"), config.Seal.OwnerPasswordFile); err != nil {
+		t.Fatalf("SealFile() returned an error: %v", err)
+	}
+
+	if err = p.load(); err != nil {
+		t.Fatalf("load() returned an error: %v", err)
+	}
+	if !cmp.Equal(p.AK.PublicKey(), ak.PublicKey()) {
+		t.Error(tests.Failure(t, p.AK.PublicKey(), ak.PublicKey(), "unsealed AK"))
+	}
+	if err = swTPM.ExtendPCR(16, []byte("untrusted"), 0, ""); err != nil {
+		t.Fatalf("ExtendPCR() returned an error: %v", err)
+	}
+	if err = p.load(); err == nil {
+		t.Error(tests.Failure(t, err, "error", "unsealed secrets after a PCR change"))
+	}
+}
+
+func TestNewProver_enroll(t *testing.T) {
+	verifierURL, _ := url.Parse("http://127.0.0.1")
+	var testSuite = []struct {
+		name       string
+		status     int
+		body       string
+		wantErr    bool
+		wantOwner  string
+		wantStored bool
+	}{
+		{name: "Correct use", status: http.StatusOK, body: `{"OwnerPassword":"owner","UserPassword":"user"}`, wantOwner: "owner", wantStored: true},
+		{name: "Token refused", status: http.StatusForbidden, body: "invalid enrollment token", wantErr: true},
+		{name: "Empty credentials", status: http.StatusOK, body: `{}`, wantErr: true},
+	}
+	for _, test := range testSuite {
+		t.Run(test.name, func(t *testing.T) {
+			dir := t.TempDir()
+			config := &Config{
+				Name:            "edge",
+				AKFile:          filepath.Join(dir, "ak.json"),
+				OwnerPassword:   "default",
+				UserPassword:    "default",
+				VerifierAddress: verifierURL,
+				TPM:             TPMConfig{Backend: "software", StateDir: filepath.Join(dir, "swtpm")},
+				EnrollmentToken: "secret",
+				CredentialsFile: filepath.Join(dir, "tpm_credentials.json"),
+			}
+			var gotBody []byte
+			httpClient.Client = &httpMocks.MockHttpClient{
+				CatchPost: func(url string, contentType string, body []byte) (*http.Response, error) {
+					gotBody = body
+					return &http.Response{
+						StatusCode: test.status,
+						Body:       ioutil.NopCloser(bytes.NewReader([]byte(test.body))),
+					}, nil
+				},
+			}
+			p, err := NewProver(config)
+			if (err != nil) != test.wantErr {
+				t.Fatal(tests.Failure(t, err, test.wantErr, ""))
+			}
+			if string(gotBody) != `{"Token":"secret","Name":"edge"}` {
+				t.Error(tests.Failure(t, string(gotBody), `{"Token":"secret","Name":"edge"}`, "enrollment request"))
+			}
+			info, statErr := os.Stat(config.CredentialsFile)
+			if (statErr == nil) != test.wantStored {
+				t.Fatal(tests.Failure(t, statErr, test.wantStored, "credentials file saved"))
+			}
+			if err != nil {
+				return
+			}
+			defer p.TPM.Close()
+			if info.Mode().Perm() != 0600 {
+				t.Error(tests.Failure(t, info.Mode().Perm(), os.FileMode(0600), "credentials file mode"))
+			}
+			if config.OwnerPassword != test.wantOwner {
+				t.Error(tests.Failure(t, config.OwnerPassword, test.wantOwner, "owner password"))
+			}
+			reloaded := &Config{OwnerPassword: "default", CredentialsFile: config.CredentialsFile}
+			if err = reloaded.LoadCredentials(); err != nil || reloaded.OwnerPassword != "owner" || reloaded.UserPassword != "user" {
+				t.Error(tests.Failure(t, reloaded, "enrolled credentials", "credentials reloaded from the file"))
+			}
+		})
+	}
+}
+
+func TestConfig_LoadCredentials(t *testing.T) {
+	var testSuite = []struct {
+		name      string
+		mode      os.FileMode
+		missing   bool
+		wantErr   bool
+		wantOwner string
+	}{
+		{name: "Owner only", mode: 0600, wantOwner: "owner"},
+		{name: "Not enrolled", missing: true, wantOwner: "default"},
+		{name: "Readable by group", mode: 0640, wantErr: true, wantOwner: "default"},
+		{name: "Readable by other", mode: 0604, wantErr: true, wantOwner: "default"},
+	}
+	for _, test := range testSuite {
+		t.Run(test.name, func(t *testing.T) {
+			config := &Config{OwnerPassword: "default", CredentialsFile: filepath.Join(t.TempDir(), "tpm_credentials.json")}
+			if !test.missing {
+				if err := ioutil.WriteFile(config.CredentialsFile, []byte(`{"OwnerPassword":"owner","UserPassword":"user"}`), test.mode); err != nil {
+					t.Fatalf("WriteFile() returned an error: %v", err)
+				}
+				// The umask may have cleared bits of the mode
+				if err := os.Chmod(config.CredentialsFile, test.mode); err != nil {
+					t.Fatalf("Chmod() returned an error: %v", err)
+				}
+			}
+			if err := config.LoadCredentials(); (err != nil) != test.wantErr {
+				t.Error(tests.Failure(t, err, test.wantErr, ""))
+			}
+			if config.OwnerPassword != test.wantOwner {
+				t.Error(tests.Failure(t, config.OwnerPassword, test.wantOwner, "owner password"))
+			}
+		})
+	}
+}
@@ -0,0 +1,73 @@
+package prover
+
+import (
+	"bytes"
+	"fmt"
+	"github.com/xcaliburne/RemoteAttestations/pkg/tpm"
+	"io/ioutil"
+)
+
+// OpenTPM opens the TPM of config and proves usership, which is all sealing and unsealing require.
+func OpenTPM(config *Config) (tpm.TPM, error) {
+	err := config.LoadCredentials()
+	if err != nil {
+		return nil, err
+	}
+	t, err := openTPM(config.TPM)
+	if err != nil {
+		return nil, fmt.Errorf("error opening TPM: %v", err)
+	}
+	err = t.ProveUsership(config.UserPassword)
+	if err != nil {
+		t.Close()
+		return nil, fmt.Errorf("error proving usership: %v", err)
+	}
+	return t, nil
+}
+
+// ProveOwnership proves the ownership of t with the owner password of config, unsealing it first when it is sealed.
+func ProveOwnership(t tpm.TPM, config *Config) error {
+	ownerPassword := config.OwnerPassword
+	if config.Seal.OwnerPasswordFile != "" {
+		unsealed, err := UnsealFile(t, config.Seal.OwnerPasswordFile)
+		if err != nil {
+			return fmt.Errorf("error unsealing owner password: %v", err)
+		}
+		ownerPassword = string(bytes.TrimRight(unsealed, "\r\n"))
+	}
+	err := t.ProveOwnership(ownerPassword)
+	if err != nil {
+		return fmt.Errorf("error proving ownership: %v", err)
+	}
+	return nil
+}
+
+// SealFile seals the content of in to sel and writes the sealed blob to out.
+func SealFile(t tpm.TPM, sel tpm.PCRSelection, in, out string) error {
+	data, err := ioutil.ReadFile(in)
+	if err != nil {
+		return fmt.Errorf("error reading %v: %v", in, err)
+	}
+	sealed, err := t.Seal(data, sel)
+	if err != nil {
+		return fmt.Errorf("error sealing %v: %v", in, err)
+	}
+	err = ioutil.WriteFile(out, sealed, 0600)
+	if err != nil {
+		return fmt.Errorf("error writing %v: %v", out, err)
+	}
+	return nil
+}
+
+// UnsealFile returns the unsealed content of a file written by SealFile.
+func UnsealFile(t tpm.TPM, in string) ([]byte, error) {
+	sealed, err := ioutil.ReadFile(in)
+	if err != nil {
+		return nil, fmt.Errorf("error reading %v: %v", in, err)
+	}
+	data, err := t.Unseal(sealed)
+	if err != nil {
+		return nil, fmt.Errorf("error unsealing %v: %v", in, err)
+	}
+	return data, nil
+}
@@ -1,18 +1,29 @@
 package mocks
 
 import (
+	"github.com/xcaliburne/RemoteAttestations/pkg/ima"
 	"github.com/xcaliburne/RemoteAttestations/pkg/tpm"
 )
 
 type MockProver struct {
 	CatchRegister func(restIP, restPort string) error
-	CatchAttest   func(nonce []byte) (tpm.Quote, error)
+	CatchAttest   func(nonce []byte, sel tpm.PCRSelection) (tpm.Quote, error)
+	CatchEventLog func() ([]byte, error)
+	CatchIMALog   func(offset int) ([]ima.Entry, error)
 }
 
 func (m *MockProver) Register(restIP, restPort string) error {
 	return m.CatchRegister(restIP, restPort)
 }
 
-func (m *MockProver) Attest(nonce []byte) (tpm.Quote, error) {
-	return m.CatchAttest(nonce)
+func (m *MockProver) Attest(nonce []byte, sel tpm.PCRSelection) (tpm.Quote, error) {
+	return m.CatchAttest(nonce, sel)
+}
+
+func (m *MockProver) EventLog() ([]byte, error) {
+	return m.CatchEventLog()
+}
+
+func (m *MockProver) IMALog(offset int) ([]ima.Entry, error) {
+	return m.CatchIMALog(offset)
 }
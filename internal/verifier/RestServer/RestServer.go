@@ -2,20 +2,34 @@ package RestServer
 
 import (
 	"context"
+	"crypto/tls"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"github.com/gorilla/mux"
 	log "github.com/sirupsen/logrus"
 	"github.com/xcaliburne/RemoteAttestations/internal/verifier"
 	"github.com/xcaliburne/RemoteAttestations/pkg/tpm"
+	verifierDB "github.com/xcaliburne/RemoteAttestations/pkg/verifier"
 	"net"
 	"net/http"
+	"strconv"
+	"strings"
 	"time"
 )
 
 type Config struct {
 	Address net.IP `yaml:"address"`
 	Port    string `yaml:"port"`
+	// TLS serves HTTPS instead of plain HTTP when set.
+	TLS *tls.Config `yaml:"-"`
+	// Operators authenticates the operators on the admin routes, these are refused when it is nil.
+	Operators Authenticator `yaml:"-"`
+}
+
+// Authenticator returns the identity of the operator sending r, an error when r is not sent by an operator.
+type Authenticator interface {
+	Operator(r *http.Request) (string, error)
 }
 
 type RestServer struct {
@@ -26,9 +40,31 @@ type RestServer struct {
 
 func (s *RestServer) handleRequests(router *mux.Router) {
 	router.HandleFunc("/", s.helloWorld).Methods("GET")
-	router.HandleFunc("/getNewEdgeInitParameters", s.getNewEdgeInitParameters).Methods("GET")
+	router.HandleFunc("/enroll", s.enroll).Methods("POST")
 	router.HandleFunc("/registerNewEK", s.registerNewEK).Methods("POST")
 	router.HandleFunc("/registerNewAK", s.registerNewAK).Methods("POST")
+	router.HandleFunc("/activateAK", s.activateAK).Methods("POST")
+	router.HandleFunc("/provers", s.listProvers).Methods("GET")
+	router.HandleFunc("/provers/{id}", s.getProver).Methods("GET")
+	router.HandleFunc("/provers/{id}/history", s.proverHistory).Methods("GET")
+	router.HandleFunc("/provers/{id}/attestations/{attestation}", s.proverAttestation).Methods("GET")
+	router.HandleFunc("/provers/attest", s.operator(s.attestProvers)).Methods("POST")
+	router.HandleFunc("/provers/{id}/attest", s.operator(s.attestProver)).Methods("POST")
+	router.HandleFunc("/provers/{id}", s.operator(s.deregister)).Methods("DELETE")
+	router.HandleFunc("/provers/{id}/ak", s.operator(s.dropAK)).Methods("DELETE")
+	router.HandleFunc("/provers/{id}/labels", s.operator(s.setLabels)).Methods("PUT")
+	router.HandleFunc("/registrations", s.operator(s.pendingRegistrations)).Methods("GET")
+	router.HandleFunc("/registrations/{id}/approve", s.operator(s.approveRegistration)).Methods("POST")
+	router.HandleFunc("/registrations/{id}/reject", s.operator(s.rejectRegistration)).Methods("POST")
+	router.HandleFunc("/blocklist", s.operator(s.blocklist)).Methods("GET")
+	router.HandleFunc("/blocklist/{id}", s.operator(s.unblockEK)).Methods("DELETE")
+	router.HandleFunc("/audit", s.operator(s.auditLog)).Methods("GET")
+	router.HandleFunc("/enrollment-tokens", s.operator(s.createEnrollmentToken)).Methods("POST")
+	router.HandleFunc("/enrollment-tokens", s.operator(s.enrollmentTokens)).Methods("GET")
+	router.HandleFunc("/enrollment-tokens/{id}", s.operator(s.revokeEnrollmentToken)).Methods("DELETE")
+	router.HandleFunc("/webhooks/dead-letters", s.operator(s.deadLetters)).Methods("GET")
+	router.HandleFunc("/webhooks/dead-letters/{id}/redeliver", s.operator(s.redeliver)).Methods("POST")
+	router.Handle("/metrics", verifier.Metrics).Methods("GET")
 }
 
 func NewServer(config *Config, verifier verifier.Verifier) (*RestServer, error) {
@@ -43,6 +79,7 @@ func NewServer(config *Config, verifier verifier.Verifier) (*RestServer, error)
 		ReadTimeout:  time.Second * 15,
 		IdleTimeout:  time.Second * 60,
 		Handler:      router, // Pass our instance of gorilla/mux in.
+		TLSConfig:    config.TLS,
 	}
 	restServer := &RestServer{
 		config: config,
@@ -57,7 +94,14 @@ func (s *RestServer) Run() {
 	log.Info(fmt.Sprintf("starting up REST API on %s\n", s.server.Addr))
 	go func() {
 		defer log.Info("server goroutine terminated")
-		if err := s.server.ListenAndServe(); err != nil {
+		var err error
+		if s.server.TLSConfig != nil {
+			// The certificate and key are already in TLSConfig.
+			err = s.server.ListenAndServeTLS("", "")
+		} else {
+			err = s.server.ListenAndServe()
+		}
+		if err != nil {
 			log.Info(err)
 		}
 	}()
@@ -69,6 +113,25 @@ func (s *RestServer) Stop() error {
 	return s.server.Shutdown(ctx)
 }
 
+// operator serves an admin route with handler, passing it the identity of the operator sending the request.
+// Requests that are not sent by an operator are refused.
+func (s *RestServer) operator(handler func(w http.ResponseWriter, r *http.Request, operator string)) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if s.config.Operators == nil {
+			log.Errorf("%v %v refused, no operator authentication configured", r.Method, r.URL)
+			http.Error(w, http.StatusText(http.StatusForbidden), http.StatusForbidden)
+			return
+		}
+		operator, err := s.config.Operators.Operator(r)
+		if err != nil {
+			log.Errorf("%v %v refused: %v", r.Method, r.URL, err)
+			http.Error(w, http.StatusText(http.StatusForbidden), http.StatusForbidden)
+			return
+		}
+		handler(w, r, operator)
+	}
+}
+
 func (s *RestServer) helloWorld(w http.ResponseWriter, r *http.Request) {
 	log.Info(r.URL)
 	_, err := w.Write([]byte("Hello World!\n"))
@@ -77,17 +140,25 @@ func (s *RestServer) helloWorld(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
-func (s *RestServer) getNewEdgeInitParameters(w http.ResponseWriter, r *http.Request) {
+// enroll exchanges the enrollment token of a device for its TPM credentials. Every invalid token gets the same answer.
+func (s *RestServer) enroll(w http.ResponseWriter, r *http.Request) {
 	log.Info(r.URL)
-	jsonResp, err := json.Marshal(s.v.InitParams())
-	if err != nil {
-		http.Error(w, "error marshaling json", 500)
+	var request struct{ Token, Name string }
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil || request.Token == "" || request.Name == "" {
+		http.Error(w, "token and name are required", http.StatusBadRequest)
+		return
+	}
+	credentials, err := s.v.Enroll(request.Token, request.Name)
+	if errors.Is(err, verifier.ErrInvalidEnrollmentToken) {
+		http.Error(w, verifier.ErrInvalidEnrollmentToken.Error(), http.StatusForbidden)
+		return
 	}
-	fmt.Println(string(jsonResp))
-	_, err = w.Write(jsonResp)
 	if err != nil {
-		log.Error(err)
+		log.Error("error enrolling device: ", err)
+		http.Error(w, "error enrolling device", http.StatusInternalServerError)
+		return
 	}
+	writeJSON(w, credentials)
 }
 
 func (s *RestServer) registerNewEK(w http.ResponseWriter, r *http.Request) {
@@ -98,6 +169,7 @@ func (s *RestServer) registerNewEK(w http.ResponseWriter, r *http.Request) {
 		Endpoint string
 		Port     string
 		EK       *tpm.EndorsementKeyData
+		Labels   map[string]string
 	}{}
 	err := decoder.Decode(&queryBody)
 	if err != nil {
@@ -110,15 +182,27 @@ func (s *RestServer) registerNewEK(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, http.StatusText(http.StatusBadRequest), http.StatusBadRequest)
 		return
 	}
-	p := verifier.Prover{EK: queryBody.EK, Name: queryBody.Name, Endpoint: queryBody.Endpoint, Port: queryBody.Port}
+	p := verifier.Prover{EK: queryBody.EK, Name: queryBody.Name, Endpoint: queryBody.Endpoint, Port: queryBody.Port, ClaimedLabels: queryBody.Labels}
 	err = s.v.RegisterNewEK(&p)
-	if err != nil {
-		if err.Error() != "error storing new EK: endorsement key already set\n"{
-			log.Error("error registering EK: ", err)
-			http.Error(w, "error registering EK", 500)
-		}else {
-			err = nil
+	var ekErr *verifierDB.EKCertError
+	if errors.As(err, &ekErr) {
+		log.Error("EK certificate rejected: ", err)
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusForbidden)
+		err = json.NewEncoder(w).Encode(struct {
+			Reason verifierDB.EKRejectionReason `json:"reason"`
+			Error  string                       `json:"error"`
+		}{ekErr.Reason, ekErr.Err.Error()})
+		if err != nil {
+			log.Error(err)
 		}
+		return
+	}
+	// A prover registering its EK again gets the same answer as the first time
+	if err != nil && !errors.Is(err, verifier.ErrProverExists) {
+		log.Error("error registering EK: ", err)
+		http.Error(w, "error registering EK", 500)
+		return
 	}
 	_, err = w.Write([]byte("success"))
 	if err != nil {
@@ -145,17 +229,478 @@ func (s *RestServer) registerNewAK(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 	p := verifier.Prover{EK: queryBody.EK, AK: queryBody.AK}
-	err = s.v.RegisterNewAK(&p)
+	credential, err := s.v.RegisterNewAK(&p)
+	if errors.Is(err, verifier.ErrAKChallengePending) {
+		http.Error(w, err.Error(), http.StatusConflict)
+		return
+	}
 	if err != nil {
-		if err.Error() != "error storing new AK: attestation key already set\n"{
-			log.Error("error registering AK: ", err)
-			http.Error(w, "error registering AK", 500)
-		}else {
-			err = nil
-		}
+		log.Error("error registering AK: ", err)
+		http.Error(w, "error registering AK", 500)
+		return
 	}
-	_, err = w.Write([]byte("success\n"))
+	jsonResp, err := json.Marshal(credential)
+	if err != nil {
+		http.Error(w, "error marshaling json", 500)
+		return
+	}
+	_, err = w.Write(jsonResp)
 	if err != nil {
 		log.Error(err)
 	}
 }
+
+func (s *RestServer) activateAK(w http.ResponseWriter, r *http.Request) {
+	log.Info(r.URL)
+	decoder := json.NewDecoder(r.Body)
+	var queryBody = struct {
+		EK     *tpm.EndorsementKeyData
+		Secret []byte
+	}{}
+	err := decoder.Decode(&queryBody)
+	if err != nil {
+		log.Error("error decoding query: ", err)
+		http.Error(w, http.StatusText(http.StatusBadRequest), http.StatusBadRequest)
+		return
+	}
+	if queryBody.EK == nil || len(queryBody.Secret) == 0 {
+		log.Errorf("%v %v", http.StatusText(http.StatusBadRequest), http.StatusBadRequest)
+		http.Error(w, http.StatusText(http.StatusBadRequest), http.StatusBadRequest)
+		return
+	}
+	cert, err := s.v.ActivateAK(queryBody.EK, queryBody.Secret)
+	if err != nil {
+		log.Error("error activating AK: ", err)
+		http.Error(w, "error activating AK", http.StatusForbidden)
+		return
+	}
+	jsonResp, err := json.Marshal(struct{ AKCertificate []byte }{cert})
+	if err != nil {
+		http.Error(w, "error marshaling json", 500)
+		return
+	}
+	_, err = w.Write(jsonResp)
+	if err != nil {
+		log.Error(err)
+	}
+}
+
+// listProvers serves the provers selected by the query parameters, see proverFilter.
+func (s *RestServer) listProvers(w http.ResponseWriter, r *http.Request) {
+	log.Info(r.URL)
+	filter, err := proverFilter(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	provers, err := s.v.ListProvers(filter)
+	if err != nil {
+		log.Error("error listing provers: ", err)
+		http.Error(w, "error listing provers", http.StatusInternalServerError)
+		return
+	}
+	writeJSON(w, provers)
+}
+
+// getProver serves the prover whose EK fingerprint is the id path parameter.
+func (s *RestServer) getProver(w http.ResponseWriter, r *http.Request) {
+	log.Info(r.URL)
+	prover, err := s.v.GetProver(mux.Vars(r)["id"])
+	if err != nil {
+		queryError(w, "error retrieving prover", err)
+		return
+	}
+	writeJSON(w, prover)
+}
+
+// proverHistory serves a page of the attestation history of a prover, from the offset query parameter
+// with at most limit attestations.
+func (s *RestServer) proverHistory(w http.ResponseWriter, r *http.Request) {
+	log.Info(r.URL)
+	query := r.URL.Query()
+	var offset, limit int
+	if q := query.Get("offset"); q != "" {
+		var err error
+		if offset, err = strconv.Atoi(q); err != nil || offset < 0 {
+			http.Error(w, "invalid offset", http.StatusBadRequest)
+			return
+		}
+	}
+	if q := query.Get("limit"); q != "" {
+		var err error
+		if limit, err = strconv.Atoi(q); err != nil || limit <= 0 {
+			http.Error(w, "invalid limit", http.StatusBadRequest)
+			return
+		}
+	}
+	page, err := s.v.ProverHistory(mux.Vars(r)["id"], offset, limit)
+	if err != nil {
+		queryError(w, "error retrieving attestation history", err)
+		return
+	}
+	writeJSON(w, page)
+}
+
+// proverAttestation serves the evidence of an attestation of a prover, with its nonce and quote.
+func (s *RestServer) proverAttestation(w http.ResponseWriter, r *http.Request) {
+	log.Info(r.URL)
+	vars := mux.Vars(r)
+	id, err := strconv.ParseUint(vars["attestation"], 10, 64)
+	if err != nil {
+		http.Error(w, "invalid attestation", http.StatusBadRequest)
+		return
+	}
+	attestation, err := s.v.ProverAttestation(vars["id"], id)
+	if err != nil {
+		queryError(w, "error retrieving attestation", err)
+		return
+	}
+	writeJSON(w, attestation)
+}
+
+// attestProver attests the prover whose EK fingerprint is the id path parameter and serves the result.
+func (s *RestServer) attestProver(w http.ResponseWriter, r *http.Request, _ string) {
+	log.Info(r.URL)
+	attestation, err := s.v.AttestProver(r.Context(), mux.Vars(r)["id"])
+	switch {
+	case errors.Is(err, verifier.ErrProverRevoked) || errors.Is(err, verifier.ErrAKNotActivated) || errors.Is(err, verifier.ErrRegistrationPending):
+		http.Error(w, err.Error(), http.StatusConflict)
+		return
+	case errors.Is(err, context.DeadlineExceeded):
+		http.Error(w, err.Error(), http.StatusGatewayTimeout)
+		return
+	case err != nil:
+		queryError(w, "error attesting prover", err)
+		return
+	}
+	writeJSON(w, attestation)
+}
+
+// attestProvers attests the provers selected by the query parameters, see proverFilter, and serves the results.
+func (s *RestServer) attestProvers(w http.ResponseWriter, r *http.Request, _ string) {
+	log.Info(r.URL)
+	filter, err := proverFilter(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	results, err := s.v.AttestProvers(r.Context(), filter)
+	if err != nil {
+		log.Error("error attesting provers: ", err)
+		http.Error(w, "error attesting provers", http.StatusInternalServerError)
+		return
+	}
+	writeJSON(w, results)
+}
+
+// deregister removes the prover whose EK fingerprint is the id path parameter. The JSON body tells why operator
+// deregisters it and whether its EK is blocked.
+func (s *RestServer) deregister(w http.ResponseWriter, r *http.Request, operator string) {
+	log.Info(r.URL)
+	request, ok := operatorDeregistration(w, r, operator)
+	if !ok {
+		return
+	}
+	if err := s.v.Deregister(mux.Vars(r)["id"], request); err != nil {
+		queryError(w, "error deregistering prover", err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// dropAK removes the AK of the prover whose EK fingerprint is the id path parameter, the JSON body tells why operator drops it.
+func (s *RestServer) dropAK(w http.ResponseWriter, r *http.Request, operator string) {
+	log.Info(r.URL)
+	reason, ok := operatorReason(w, r)
+	if !ok {
+		return
+	}
+	err := s.v.DropAK(mux.Vars(r)["id"], operator, reason)
+	if errors.Is(err, verifier.ErrAKNotActivated) {
+		http.Error(w, err.Error(), http.StatusConflict)
+		return
+	}
+	if err != nil {
+		queryError(w, "error dropping AK", err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// setLabels replaces the labels of the prover whose EK fingerprint is the id path parameter, the JSON body holds the
+// labels and tells why operator sets them.
+func (s *RestServer) setLabels(w http.ResponseWriter, r *http.Request, operator string) {
+	log.Info(r.URL)
+	var request struct {
+		Labels map[string]string
+		Reason string
+	}
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil || request.Reason == "" {
+		http.Error(w, "reason is required", http.StatusBadRequest)
+		return
+	}
+	if err := s.v.SetLabels(mux.Vars(r)["id"], operator, request.Reason, request.Labels); err != nil {
+		queryError(w, "error setting labels", err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// pendingRegistrations serves the provers whose registration waits for approval, with their EK fingerprint,
+// name and claimed endpoint.
+func (s *RestServer) pendingRegistrations(w http.ResponseWriter, r *http.Request, _ string) {
+	log.Info(r.URL)
+	provers, err := s.v.PendingRegistrations()
+	if err != nil {
+		log.Error("error listing pending registrations: ", err)
+		http.Error(w, "error listing pending registrations", http.StatusInternalServerError)
+		return
+	}
+	writeJSON(w, provers)
+}
+
+// approveRegistration approves the pending registration of the prover whose EK fingerprint is the id path parameter,
+// the JSON body tells why operator approves it.
+func (s *RestServer) approveRegistration(w http.ResponseWriter, r *http.Request, operator string) {
+	log.Info(r.URL)
+	reason, ok := operatorReason(w, r)
+	if !ok {
+		return
+	}
+	err := s.v.ApproveRegistration(mux.Vars(r)["id"], operator, reason)
+	if errors.Is(err, verifier.ErrNotPending) || errors.Is(err, verifier.ErrInvalidTransition) {
+		http.Error(w, err.Error(), http.StatusConflict)
+		return
+	}
+	if err != nil {
+		queryError(w, "error approving registration", err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// rejectRegistration removes the prover whose EK fingerprint is the id path parameter and whose registration is
+// pending, the JSON body is a verifier.Deregistration whose actor is operator.
+func (s *RestServer) rejectRegistration(w http.ResponseWriter, r *http.Request, operator string) {
+	log.Info(r.URL)
+	request, ok := operatorDeregistration(w, r, operator)
+	if !ok {
+		return
+	}
+	err := s.v.RejectRegistration(mux.Vars(r)["id"], request)
+	if errors.Is(err, verifier.ErrNotPending) {
+		http.Error(w, err.Error(), http.StatusConflict)
+		return
+	}
+	if err != nil {
+		queryError(w, "error rejecting registration", err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// blocklist serves the blocked EKs.
+func (s *RestServer) blocklist(w http.ResponseWriter, r *http.Request, _ string) {
+	log.Info(r.URL)
+	blocklist, err := s.v.Blocklist()
+	if err != nil {
+		log.Error("error reading blocklist: ", err)
+		http.Error(w, "error reading blocklist", http.StatusInternalServerError)
+		return
+	}
+	writeJSON(w, blocklist)
+}
+
+// unblockEK removes the EK whose fingerprint is the id path parameter from the blocklist, the JSON body tells why
+// operator unblocks it.
+func (s *RestServer) unblockEK(w http.ResponseWriter, r *http.Request, operator string) {
+	log.Info(r.URL)
+	reason, ok := operatorReason(w, r)
+	if !ok {
+		return
+	}
+	if err := s.v.UnblockEK(mux.Vars(r)["id"], operator, reason); err != nil {
+		queryError(w, "error unblocking EK", err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// auditLog serves the audit log of the operator actions.
+func (s *RestServer) auditLog(w http.ResponseWriter, r *http.Request, _ string) {
+	log.Info(r.URL)
+	entries, err := s.v.AuditLog()
+	if err != nil {
+		log.Error("error reading audit log: ", err)
+		http.Error(w, "error reading audit log", http.StatusInternalServerError)
+		return
+	}
+	writeJSON(w, entries)
+}
+
+// createEnrollmentToken creates the enrollment token of a device for operator, the token is only in this response.
+// TTL is a duration such as 1h, the configured default when empty.
+func (s *RestServer) createEnrollmentToken(w http.ResponseWriter, r *http.Request, operator string) {
+	log.Info(r.URL)
+	var request struct{ Device, TTL string }
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil || request.Device == "" {
+		http.Error(w, "device is required", http.StatusBadRequest)
+		return
+	}
+	var ttl time.Duration
+	if request.TTL != "" {
+		var err error
+		if ttl, err = time.ParseDuration(request.TTL); err != nil || ttl <= 0 {
+			http.Error(w, "invalid TTL", http.StatusBadRequest)
+			return
+		}
+	}
+	token, entry, err := s.v.CreateEnrollmentToken(verifier.EnrollmentRequest{Device: request.Device, Actor: operator, TTL: ttl})
+	if err != nil {
+		log.Error("error creating enrollment token: ", err)
+		http.Error(w, "error creating enrollment token", http.StatusInternalServerError)
+		return
+	}
+	// The content type is set before the status, writeJSON sets it too late.
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	writeJSON(w, struct {
+		Token string
+		*verifier.EnrollmentToken
+	}{token, entry})
+}
+
+// enrollmentTokens serves the enrollment tokens, without the tokens themselves.
+func (s *RestServer) enrollmentTokens(w http.ResponseWriter, r *http.Request, _ string) {
+	log.Info(r.URL)
+	tokens, err := s.v.EnrollmentTokens()
+	if err != nil {
+		log.Error("error reading enrollment tokens: ", err)
+		http.Error(w, "error reading enrollment tokens", http.StatusInternalServerError)
+		return
+	}
+	writeJSON(w, tokens)
+}
+
+// revokeEnrollmentToken revokes the enrollment token whose ID is the id path parameter, the JSON body tells why operator revokes it.
+func (s *RestServer) revokeEnrollmentToken(w http.ResponseWriter, r *http.Request, operator string) {
+	log.Info(r.URL)
+	reason, ok := operatorReason(w, r)
+	if !ok {
+		return
+	}
+	err := s.v.RevokeEnrollmentToken(mux.Vars(r)["id"], operator, reason)
+	if errors.Is(err, verifier.ErrEnrollmentTokenNotFound) {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		log.Error("error revoking enrollment token: ", err)
+		http.Error(w, "error revoking enrollment token", http.StatusInternalServerError)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// deadLetters serves the webhook deliveries that failed.
+func (s *RestServer) deadLetters(w http.ResponseWriter, r *http.Request, _ string) {
+	log.Info(r.URL)
+	letters, err := s.v.DeadLetters()
+	if err != nil {
+		log.Error("error reading dead letters: ", err)
+		http.Error(w, "error reading dead letters", http.StatusInternalServerError)
+		return
+	}
+	writeJSON(w, letters)
+}
+
+// redeliver sends the dead letter whose ID is the id path parameter to its webhook again.
+func (s *RestServer) redeliver(w http.ResponseWriter, r *http.Request, _ string) {
+	log.Info(r.URL)
+	id, err := strconv.ParseUint(mux.Vars(r)["id"], 10, 64)
+	if err != nil {
+		http.Error(w, "invalid dead letter", http.StatusBadRequest)
+		return
+	}
+	err = s.v.Redeliver(r.Context(), id)
+	switch {
+	case errors.Is(err, verifier.ErrDeadLetterNotFound):
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	case errors.Is(err, verifier.ErrWebhooksDisabled):
+		http.Error(w, err.Error(), http.StatusConflict)
+		return
+	case err != nil:
+		log.Error("error redelivering dead letter: ", err)
+		http.Error(w, err.Error(), http.StatusBadGateway)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// operatorReason decodes why the operator acts from the JSON body of r, it answers 400 and returns false when it is missing.
+func operatorReason(w http.ResponseWriter, r *http.Request) (string, bool) {
+	var request struct{ Reason string }
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil || request.Reason == "" {
+		http.Error(w, "reason is required", http.StatusBadRequest)
+		return "", false
+	}
+	return request.Reason, true
+}
+
+// operatorDeregistration decodes the verifier.Deregistration of the JSON body of r, operator is its actor whatever
+// the body says. It answers 400 and returns false when the reason is missing.
+func operatorDeregistration(w http.ResponseWriter, r *http.Request, operator string) (verifier.Deregistration, bool) {
+	var request verifier.Deregistration
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil || request.Reason == "" {
+		http.Error(w, "reason is required", http.StatusBadRequest)
+		return request, false
+	}
+	request.Actor = operator
+	return request, true
+}
+
+// proverFilter selects the provers in the trust state of the state query parameter carrying the labels
+// of the label query parameters, written key=value.
+func proverFilter(r *http.Request) (verifier.ProverFilter, error) {
+	query := r.URL.Query()
+	filter := verifier.ProverFilter{State: verifier.TrustState(query.Get("state"))}
+	if filter.State != "" && !filter.State.Valid() {
+		return filter, fmt.Errorf("invalid state")
+	}
+	for _, label := range query["label"] {
+		kv := strings.SplitN(label, "=", 2)
+		if len(kv) != 2 || kv[0] == "" {
+			return filter, fmt.Errorf("invalid label")
+		}
+		if filter.Labels == nil {
+			filter.Labels = map[string]string{}
+		}
+		filter.Labels[kv[0]] = kv[1]
+	}
+	return filter, nil
+}
+
+// queryError answers 404 when err is a missing prover, attestation or blocked EK, 500 otherwise.
+func queryError(w http.ResponseWriter, message string, err error) {
+	if errors.Is(err, verifier.ErrProverNotFound) || errors.Is(err, verifier.ErrAttestationNotFound) || errors.Is(err, verifier.ErrEKNotBlocked) {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	log.Errorf("%v: %v", message, err)
+	http.Error(w, message, http.StatusInternalServerError)
+}
+
+func writeJSON(w http.ResponseWriter, v interface{}) {
+	respBody, err := json.Marshal(v)
+	if err != nil {
+		log.Error("error marshaling response: ", err)
+		http.Error(w, "error marshaling response", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	_, err = w.Write(respBody)
+	if err != nil {
+		log.Error("error writing response: ", err)
+	}
+}
@@ -1,6 +1,7 @@
 package RestServer
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
 	"github.com/google/go-cmp/cmp"
@@ -9,12 +10,17 @@ import (
 	"github.com/xcaliburne/RemoteAttestations/internal/verifier"
 	"github.com/xcaliburne/RemoteAttestations/internal/verifier/tests/mocks"
 	"github.com/xcaliburne/RemoteAttestations/pkg/httpClient"
+	"github.com/xcaliburne/RemoteAttestations/pkg/mtls"
 	"github.com/xcaliburne/RemoteAttestations/pkg/tests"
+	"github.com/xcaliburne/RemoteAttestations/pkg/tpm"
 	"github.com/xcaliburne/RemoteAttestations/pkg/tpm/tests/fakes"
+	verifierDB "github.com/xcaliburne/RemoteAttestations/pkg/verifier"
 	"io/ioutil"
 	"net"
 	"net/http"
 	"net/http/httptest"
+	"path/filepath"
+	"strings"
 	"testing"
 	"time"
 )
@@ -22,8 +28,19 @@ import (
 var r *RestServer
 var v = &mocks.MockVerifier{}
 var config = &Config{
-	Address: net.IP{127, 0, 0, 1},
-	Port:    "8080",
+	Address:   net.IP{127, 0, 0, 1},
+	Port:      "8080",
+	Operators: operatorHeader{},
+}
+
+// operatorHeader authenticates the operator named by the Operator header of the requests.
+type operatorHeader struct{}
+
+func (operatorHeader) Operator(r *http.Request) (string, error) {
+	if operator := r.Header.Get("Operator"); operator != "" {
+		return operator, nil
+	}
+	return "", mtls.ErrNotOperator
 }
 
 func init() {
@@ -70,11 +87,12 @@ func TestRestServer_registerNewEK(t *testing.T) {
 	}
 
 	var testSuite = []struct {
-		name    string
-		input   string
-		mock    mocks.MockVerifier
-		want    int
-		wantErr error
+		name       string
+		input      string
+		mock       mocks.MockVerifier
+		want       int
+		wantReason verifierDB.EKRejectionReason
+		wantErr    error
 	}{
 		{
 			name:    "correct query",
@@ -125,6 +143,16 @@ func TestRestServer_registerNewEK(t *testing.T) {
 			want:    http.StatusInternalServerError,
 			wantErr: nil,
 		},
+		{
+			name:  "query with rejected EK certificate",
+			input: fmt.Sprintf(jsonFormat, "test", "127.0.0.1", "8080", string(jsonEK)),
+			mock: mocks.MockVerifier{CatchRegisterNewEK: func(p *verifier.Prover) error {
+				return fmt.Errorf("error verifying EK Certificate: %w", &verifierDB.EKCertError{Reason: verifierDB.EKUnknownIssuer, Err: fmt.Errorf("some error")})
+			}},
+			want:       http.StatusForbidden,
+			wantReason: verifierDB.EKUnknownIssuer,
+			wantErr:    nil,
+		},
 	}
 	testServer := httptest.NewServer(http.HandlerFunc(r.registerNewEK))
 	defer testServer.Close()
@@ -141,10 +169,62 @@ func TestRestServer_registerNewEK(t *testing.T) {
 			if !cmp.Equal(got, test.want) {
 				t.Error(tests.Failure(t, got, test.want, ""))
 			}
+			if test.wantReason == "" {
+				return
+			}
+			var body struct {
+				Reason verifierDB.EKRejectionReason `json:"reason"`
+			}
+			if err := json.NewDecoder(req.Body).Decode(&body); err != nil || body.Reason != test.wantReason {
+				t.Error(tests.Failure(t, body.Reason, test.wantReason, "rejection reason"))
+			}
 		})
 	}
 }
 
+func TestRestServer_registerNewEK_twice(t *testing.T) {
+	dir := t.TempDir()
+	swTPM, err := tpm.OpenSoftware(dir)
+	if err != nil {
+		t.Fatalf("OpenSoftware() returned an error: %v", err)
+	}
+	ek, err := swTPM.GetEK()
+	if err != nil {
+		t.Fatalf("GetEK() returned an error: %v", err)
+	}
+	jsonEK, err := json.Marshal(ek)
+	if err != nil {
+		t.Fatalf("unable to marshal json: %v", err)
+	}
+	trust, err := verifierDB.LoadEKTrustStore([]string{filepath.Join(dir, tpm.SoftwareEKCAFile)}, verifierDB.EKPolicy{})
+	if err != nil {
+		t.Fatalf("LoadEKTrustStore() returned an error: %v", err)
+	}
+	dv := verifier.NewVerifier(&verifier.Config{})
+	dv.EKTrust = trust
+	r.v = dv
+	testServer := httptest.NewServer(http.HandlerFunc(r.registerNewEK))
+	defer testServer.Close()
+
+	input := fmt.Sprintf("{\"Name\":\"test\",\"Endpoint\":\"127.0.0.1\",\"Port\":\"8080\",\"EK\":%s}", string(jsonEK))
+	for i := 1; i <= 2; i++ {
+		req, err := httpClient.Client.Post(testServer.URL, "application/json", []byte(input))
+		if err != nil {
+			t.Fatalf("Post() returned an error: %v", err)
+		}
+		if req.StatusCode != http.StatusOK {
+			t.Error(tests.Failure(t, req.StatusCode, http.StatusOK, fmt.Sprintf("registration %d", i)))
+		}
+	}
+	provers, err := dv.Provers.List()
+	if err != nil {
+		t.Fatalf("List() returned an error: %v", err)
+	}
+	if len(provers) != 1 {
+		t.Error(tests.Failure(t, len(provers), 1, "registered provers"))
+	}
+}
+
 func TestRestServer_registerNewAK(t *testing.T) {
 	jsonFormat := "{\"EK\": %s,\"AK\": %s}"
 	jsonEK, err := json.Marshal(fakes.GetFakeEndorsementKeyValid())
@@ -166,28 +246,35 @@ func TestRestServer_registerNewAK(t *testing.T) {
 		{
 			name:    "correct query",
 			input:   fmt.Sprintf(jsonFormat, string(jsonEK), string(jsonAK)),
-			mock:    mocks.MockVerifier{CatchRegisterNewAK: func(p *verifier.Prover) error { return nil }},
+			mock:    mocks.MockVerifier{CatchRegisterNewAK: func(p *verifier.Prover) (*tpm.Credential, error) { return &tpm.Credential{}, nil }},
 			want:    http.StatusOK,
 			wantErr: nil,
 		},
 		{
 			name:    "query without EK",
 			input:   fmt.Sprintf(jsonFormat, "{}", string(jsonAK)),
-			mock:    mocks.MockVerifier{CatchRegisterNewAK: func(p *verifier.Prover) error { return nil }},
+			mock:    mocks.MockVerifier{CatchRegisterNewAK: func(p *verifier.Prover) (*tpm.Credential, error) { return &tpm.Credential{}, nil }},
 			want:    http.StatusBadRequest,
 			wantErr: nil,
 		},
 		{
 			name:    "query without AK",
 			input:   fmt.Sprintf(jsonFormat, string(jsonEK), "{}"),
-			mock:    mocks.MockVerifier{CatchRegisterNewAK: func(p *verifier.Prover) error { return nil }},
+			mock:    mocks.MockVerifier{CatchRegisterNewAK: func(p *verifier.Prover) (*tpm.Credential, error) { return &tpm.Credential{}, nil }},
 			want:    http.StatusBadRequest,
 			wantErr: nil,
 		},
+		{
+			name:    "query while another AK is pending",
+			input:   fmt.Sprintf(jsonFormat, string(jsonEK), string(jsonAK)),
+			mock:    mocks.MockVerifier{CatchRegisterNewAK: func(p *verifier.Prover) (*tpm.Credential, error) { return nil, verifier.ErrAKChallengePending }},
+			want:    http.StatusConflict,
+			wantErr: nil,
+		},
 		{
 			name:    "query with internal error",
 			input:   fmt.Sprintf(jsonFormat, string(jsonEK), string(jsonAK)),
-			mock:    mocks.MockVerifier{CatchRegisterNewAK: func(p *verifier.Prover) error { return fmt.Errorf("some error") }},
+			mock:    mocks.MockVerifier{CatchRegisterNewAK: func(p *verifier.Prover) (*tpm.Credential, error) { return nil, fmt.Errorf("some error") }},
 			want:    http.StatusInternalServerError,
 			wantErr: nil,
 		},
@@ -217,3 +304,604 @@ func TestRestServer_registerNewAK(t *testing.T) {
 		})
 	}
 }
+
+func TestRestServer_activateAK(t *testing.T) {
+	jsonFormat := "{\"EK\": %s,\"Secret\": %s}"
+	jsonEK, err := json.Marshal(fakes.GetFakeEndorsementKeyValid())
+	if err != nil {
+		t.Fatalf("unable to marshal json: %v", err)
+	}
+	jsonSecret, err := json.Marshal([]byte("secret"))
+	if err != nil {
+		t.Fatalf("unable to marshal json: %v", err)
+	}
+
+	var testSuite = []struct {
+		name    string
+		input   string
+		mock    mocks.MockVerifier
+		want    int
+		wantErr error
+	}{
+		{
+			name:    "correct query",
+			input:   fmt.Sprintf(jsonFormat, string(jsonEK), string(jsonSecret)),
+			mock:    mocks.MockVerifier{CatchActivateAK: func(ek tpm.EndorsementKey, secret []byte) ([]byte, error) { return nil, nil }},
+			want:    http.StatusOK,
+			wantErr: nil,
+		},
+		{
+			name:    "query without EK",
+			input:   fmt.Sprintf(jsonFormat, "{}", string(jsonSecret)),
+			mock:    mocks.MockVerifier{CatchActivateAK: func(ek tpm.EndorsementKey, secret []byte) ([]byte, error) { return nil, nil }},
+			want:    http.StatusBadRequest,
+			wantErr: nil,
+		},
+		{
+			name:    "query without secret",
+			input:   fmt.Sprintf(jsonFormat, string(jsonEK), "null"),
+			mock:    mocks.MockVerifier{CatchActivateAK: func(ek tpm.EndorsementKey, secret []byte) ([]byte, error) { return nil, nil }},
+			want:    http.StatusBadRequest,
+			wantErr: nil,
+		},
+		{
+			name:  "AK already registered",
+			input: fmt.Sprintf(jsonFormat, string(jsonEK), string(jsonSecret)),
+			mock: mocks.MockVerifier{CatchActivateAK: func(ek tpm.EndorsementKey, secret []byte) ([]byte, error) {
+				return nil, fmt.Errorf("error storing new AK: attestation key already set\n")
+			}},
+			want:    http.StatusForbidden,
+			wantErr: nil,
+		},
+		{
+			name:    "wrong secret",
+			input:   fmt.Sprintf(jsonFormat, string(jsonEK), string(jsonSecret)),
+			mock:    mocks.MockVerifier{CatchActivateAK: func(ek tpm.EndorsementKey, secret []byte) ([]byte, error) { return nil, fmt.Errorf("some error") }},
+			want:    http.StatusForbidden,
+			wantErr: nil,
+		},
+	}
+
+	testServer := httptest.NewServer(http.HandlerFunc(r.activateAK))
+	defer testServer.Close()
+	for _, test := range testSuite {
+		t.Run(test.name, func(t *testing.T) {
+			r.v = &test.mock
+			req, gotErr := httpClient.Client.Post(testServer.URL, "application/json", []byte(test.input))
+			if gotErr != test.wantErr {
+				t.Error(tests.Failure(t, gotErr, test.wantErr, ""))
+				t.Skip()
+			}
+			got := req.StatusCode
+			if !cmp.Equal(got, test.want) {
+				t.Error(tests.Failure(t, got, test.want, ""))
+			}
+		})
+	}
+}
+
+func TestRestServer_queries(t *testing.T) {
+	const id = "0123abcd"
+	mock := mocks.MockVerifier{
+		CatchListProvers: func(filter verifier.ProverFilter) ([]verifier.ProverStatus, error) {
+			if filter.State == verifier.StateUntrusted && filter.Labels["role"] == "gateway" {
+				return []verifier.ProverStatus{{ID: id, Name: "gateway", State: verifier.StateUntrusted}}, nil
+			}
+			return []verifier.ProverStatus{}, nil
+		},
+		CatchGetProver: func(prover string) (*verifier.ProverStatus, error) {
+			if prover != id {
+				return nil, verifier.ErrProverNotFound
+			}
+			return &verifier.ProverStatus{ID: id, Name: "gateway"}, nil
+		},
+		CatchProverHistory: func(prover string, offset, limit int) (*verifier.HistoryPage, error) {
+			if prover != id {
+				return nil, fmt.Errorf("some error")
+			}
+			return &verifier.HistoryPage{Total: 30, Offset: offset, Attestations: make([]verifier.Attestation, limit)}, nil
+		},
+		CatchProverAttestation: func(prover string, attestation uint64) (*verifier.Attestation, error) {
+			if attestation != 7 {
+				return nil, fmt.Errorf("error reading history: %w", verifier.ErrAttestationNotFound)
+			}
+			return &verifier.Attestation{ID: 7, Nonce: []byte("nonce")}, nil
+		},
+	}
+	router := mux.NewRouter()
+	r.handleRequests(router)
+	testServer := httptest.NewServer(router)
+	defer testServer.Close()
+	r.v = &mock
+
+	var testSuite = []struct {
+		name     string
+		path     string
+		want     int
+		wantBody string
+	}{
+		{name: "list provers", path: "/provers", want: http.StatusOK, wantBody: "[]"},
+		{name: "list filtered provers", path: "/provers?state=untrusted&label=role=gateway", want: http.StatusOK, wantBody: `"Name":"gateway"`},
+		{name: "list with unknown state", path: "/provers?state=compromised", want: http.StatusBadRequest},
+		{name: "list with invalid label", path: "/provers?label=gateway", want: http.StatusBadRequest},
+		{name: "get prover", path: "/provers/" + id, want: http.StatusOK, wantBody: `"ID":"` + id + `"`},
+		{name: "get unknown prover", path: "/provers/ffff", want: http.StatusNotFound},
+		{name: "history page", path: "/provers/" + id + "/history?offset=10&limit=2", want: http.StatusOK, wantBody: `"Offset":10`},
+		{name: "history with invalid offset", path: "/provers/" + id + "/history?offset=-1", want: http.StatusBadRequest},
+		{name: "history with invalid limit", path: "/provers/" + id + "/history?limit=none", want: http.StatusBadRequest},
+		{name: "history with internal error", path: "/provers/ffff/history", want: http.StatusInternalServerError},
+		{name: "attestation evidence", path: "/provers/" + id + "/attestations/7", want: http.StatusOK, wantBody: `"Nonce":"bm9uY2U="`},
+		{name: "attestation no longer kept", path: "/provers/" + id + "/attestations/3", want: http.StatusNotFound},
+		{name: "invalid attestation", path: "/provers/" + id + "/attestations/last", want: http.StatusBadRequest},
+	}
+	for _, test := range testSuite {
+		t.Run(test.name, func(t *testing.T) {
+			resp, err := httpClient.Client.Get(testServer.URL + test.path)
+			if err != nil {
+				t.Fatalf("Get() returned an error: %v", err)
+			}
+			defer resp.Body.Close()
+			if resp.StatusCode != test.want {
+				t.Error(tests.Failure(t, resp.StatusCode, test.want, ""))
+			}
+			body, err := ioutil.ReadAll(resp.Body)
+			if err != nil {
+				t.Fatalf("ReadAll() returned an error: %v", err)
+			}
+			if !strings.Contains(string(body), test.wantBody) {
+				t.Error(tests.Failure(t, string(body), test.wantBody, "body"))
+			}
+		})
+	}
+}
+
+func TestRestServer_attestProver(t *testing.T) {
+	mock := mocks.MockVerifier{
+		CatchAttestProver: func(ctx context.Context, id string) (*verifier.Attestation, error) {
+			switch id {
+			case "trusted":
+				return &verifier.Attestation{ID: 1, State: verifier.StateTrusted}, nil
+			case "revoked":
+				return nil, verifier.ErrProverRevoked
+			case "slow":
+				return nil, fmt.Errorf("attestation of slow still running: %w", context.DeadlineExceeded)
+			}
+			return nil, verifier.ErrProverNotFound
+		},
+		CatchAttestProvers: func(ctx context.Context, filter verifier.ProverFilter) ([]verifier.AttestationResult, error) {
+			if filter.Labels["role"] != "gateway" {
+				return []verifier.AttestationResult{}, nil
+			}
+			return []verifier.AttestationResult{{ID: "trusted", Name: "gateway", Attestation: &verifier.Attestation{State: verifier.StateTrusted}}}, nil
+		},
+	}
+	router := mux.NewRouter()
+	r.handleRequests(router)
+	testServer := httptest.NewServer(router)
+	defer testServer.Close()
+	r.v = &mock
+
+	var testSuite = []struct {
+		name     string
+		path     string
+		operator string
+		want     int
+		wantBody string
+	}{
+		{name: "attest prover", path: "/provers/trusted/attest", operator: "alice", want: http.StatusOK, wantBody: `"State":"trusted"`},
+		{name: "attest prover without operator", path: "/provers/trusted/attest", want: http.StatusForbidden},
+		{name: "attest revoked prover", path: "/provers/revoked/attest", operator: "alice", want: http.StatusConflict},
+		{name: "attestation timeout", path: "/provers/slow/attest", operator: "alice", want: http.StatusGatewayTimeout},
+		{name: "attest unknown prover", path: "/provers/ffff/attest", operator: "alice", want: http.StatusNotFound},
+		{name: "attest selected provers", path: "/provers/attest?label=role=gateway", operator: "alice", want: http.StatusOK, wantBody: `"Name":"gateway"`},
+		{name: "attest selected provers without operator", path: "/provers/attest?label=role=gateway", want: http.StatusForbidden},
+		{name: "attest with invalid selector", path: "/provers/attest?state=compromised", operator: "alice", want: http.StatusBadRequest},
+	}
+	for _, test := range testSuite {
+		t.Run(test.name, func(t *testing.T) {
+			req, err := http.NewRequest("POST", testServer.URL+test.path, nil)
+			if err != nil {
+				t.Fatalf("NewRequest() returned an error: %v", err)
+			}
+			if test.operator != "" {
+				req.Header.Set("Operator", test.operator)
+			}
+			resp, err := http.DefaultClient.Do(req)
+			if err != nil {
+				t.Fatalf("Do() returned an error: %v", err)
+			}
+			defer resp.Body.Close()
+			if resp.StatusCode != test.want {
+				t.Error(tests.Failure(t, resp.StatusCode, test.want, ""))
+			}
+			body, err := ioutil.ReadAll(resp.Body)
+			if err != nil {
+				t.Fatalf("ReadAll() returned an error: %v", err)
+			}
+			if !strings.Contains(string(body), test.wantBody) {
+				t.Error(tests.Failure(t, string(body), test.wantBody, "body"))
+			}
+		})
+	}
+}
+
+func TestRestServer_deregistration(t *testing.T) {
+	mock := mocks.MockVerifier{
+		CatchDeregister: func(id string, request verifier.Deregistration) error {
+			if id != "stolen" {
+				return verifier.ErrProverNotFound
+			}
+			if request.Actor != "alice" {
+				return fmt.Errorf("some error")
+			}
+			if !request.Block {
+				return fmt.Errorf("some error")
+			}
+			return nil
+		},
+		CatchDropAK: func(id, actor, reason string) error {
+			if id != "edge" {
+				return verifier.ErrAKNotActivated
+			}
+			if actor != "alice" {
+				return fmt.Errorf("some error")
+			}
+			return nil
+		},
+		CatchSetLabels: func(id, actor, reason string, labels map[string]string) error {
+			if id != "edge" {
+				return verifier.ErrProverNotFound
+			}
+			if actor != "alice" || labels["site"] != "paris" {
+				return fmt.Errorf("some error")
+			}
+			return nil
+		},
+		CatchUnblockEK: func(id, actor, reason string) error {
+			if id != "stolen" {
+				return verifier.ErrEKNotBlocked
+			}
+			if actor != "alice" {
+				return fmt.Errorf("some error")
+			}
+			return nil
+		},
+		CatchBlocklist: func() ([]verifier.BlockedEK, error) {
+			return []verifier.BlockedEK{{ID: "stolen", Actor: "alice", Reason: "stolen"}}, nil
+		},
+		CatchAuditLog: func() ([]verifier.AuditEntry, error) {
+			return []verifier.AuditEntry{{Actor: "alice", Action: verifier.AuditBlock, Prover: "stolen"}}, nil
+		},
+	}
+	router := mux.NewRouter()
+	r.handleRequests(router)
+	testServer := httptest.NewServer(router)
+	defer testServer.Close()
+	r.v = &mock
+
+	var testSuite = []struct {
+		name     string
+		method   string
+		path     string
+		operator string
+		body     string
+		want     int
+		wantBody string
+	}{
+		{name: "deregister and block", method: "DELETE", path: "/provers/stolen", operator: "alice", body: `{"Actor":"mallory","Reason":"stolen","Block":true}`, want: http.StatusNoContent},
+		{name: "deregister without reason", method: "DELETE", path: "/provers/stolen", operator: "alice", body: `{}`, want: http.StatusBadRequest},
+		{name: "deregister without operator", method: "DELETE", path: "/provers/stolen", body: `{"Actor":"alice","Reason":"stolen"}`, want: http.StatusForbidden},
+		{name: "deregister unknown prover", method: "DELETE", path: "/provers/ffff", operator: "alice", body: `{"Reason":"retired"}`, want: http.StatusNotFound},
+		{name: "deregister with internal error", method: "DELETE", path: "/provers/stolen", operator: "bob", body: `{"Reason":"retired"}`, want: http.StatusInternalServerError},
+		{name: "drop AK", method: "DELETE", path: "/provers/edge/ak", operator: "alice", body: `{"Reason":"AK leaked"}`, want: http.StatusNoContent},
+		{name: "drop AK not activated", method: "DELETE", path: "/provers/gateway/ak", operator: "alice", body: `{"Reason":"AK leaked"}`, want: http.StatusConflict},
+		{name: "drop AK without reason", method: "DELETE", path: "/provers/edge/ak", operator: "alice", body: `{}`, want: http.StatusBadRequest},
+		{name: "drop AK without operator", method: "DELETE", path: "/provers/edge/ak", body: `{"Actor":"alice","Reason":"AK leaked"}`, want: http.StatusForbidden},
+		{name: "set labels", method: "PUT", path: "/provers/edge/labels", operator: "alice", body: `{"Labels":{"site":"paris"},"Reason":"moved"}`, want: http.StatusNoContent},
+		{name: "set labels of unknown prover", method: "PUT", path: "/provers/ffff/labels", operator: "alice", body: `{"Labels":{"site":"paris"},"Reason":"moved"}`, want: http.StatusNotFound},
+		{name: "set labels without reason", method: "PUT", path: "/provers/edge/labels", operator: "alice", body: `{"Labels":{"site":"paris"}}`, want: http.StatusBadRequest},
+		{name: "set labels without operator", method: "PUT", path: "/provers/edge/labels", body: `{"Labels":{"site":"paris"},"Reason":"moved"}`, want: http.StatusForbidden},
+		{name: "unblock EK", method: "DELETE", path: "/blocklist/stolen", operator: "alice", body: `{"Actor":"mallory","Reason":"found again"}`, want: http.StatusNoContent},
+		{name: "unblock EK not blocked", method: "DELETE", path: "/blocklist/ffff", operator: "alice", body: `{"Reason":"found again"}`, want: http.StatusNotFound},
+		{name: "unblock EK without operator", method: "DELETE", path: "/blocklist/stolen", body: `{"Actor":"alice","Reason":"found again"}`, want: http.StatusForbidden},
+		{name: "blocklist", method: "GET", path: "/blocklist", operator: "alice", want: http.StatusOK, wantBody: `"Actor":"alice"`},
+		{name: "blocklist without operator", method: "GET", path: "/blocklist", want: http.StatusForbidden},
+		{name: "audit log", method: "GET", path: "/audit", operator: "alice", want: http.StatusOK, wantBody: `"Action":"block"`},
+		{name: "audit log without operator", method: "GET", path: "/audit", want: http.StatusForbidden},
+	}
+	for _, test := range testSuite {
+		t.Run(test.name, func(t *testing.T) {
+			req, err := http.NewRequest(test.method, testServer.URL+test.path, strings.NewReader(test.body))
+			if err != nil {
+				t.Fatalf("NewRequest() returned an error: %v", err)
+			}
+			if test.operator != "" {
+				req.Header.Set("Operator", test.operator)
+			}
+			resp, err := http.DefaultClient.Do(req)
+			if err != nil {
+				t.Fatalf("Do() returned an error: %v", err)
+			}
+			defer resp.Body.Close()
+			if resp.StatusCode != test.want {
+				t.Error(tests.Failure(t, resp.StatusCode, test.want, ""))
+			}
+			body, err := ioutil.ReadAll(resp.Body)
+			if err != nil {
+				t.Fatalf("ReadAll() returned an error: %v", err)
+			}
+			if !strings.Contains(string(body), test.wantBody) {
+				t.Error(tests.Failure(t, string(body), test.wantBody, "body"))
+			}
+		})
+	}
+}
+
+func TestRestServer_enrollment(t *testing.T) {
+	mock := mocks.MockVerifier{
+		CatchEnroll: func(token, device string) (*verifier.DeviceCredentials, error) {
+			switch {
+			case token == "used":
+				return nil, fmt.Errorf("%w: used", verifier.ErrInvalidEnrollmentToken)
+			case device != "edge":
+				return nil, fmt.Errorf("some error")
+			}
+			return &verifier.DeviceCredentials{OwnerPassword: "owner", UserPassword: "user"}, nil
+		},
+		CatchCreateEnrollmentToken: func(request verifier.EnrollmentRequest) (string, *verifier.EnrollmentToken, error) {
+			if request.TTL != 0 && request.TTL != time.Hour {
+				return "", nil, fmt.Errorf("some error")
+			}
+			return "secret", &verifier.EnrollmentToken{ID: "2bb80d", Device: request.Device, CreatedBy: request.Actor}, nil
+		},
+		CatchEnrollmentTokens: func() ([]verifier.EnrollmentToken, error) {
+			return []verifier.EnrollmentToken{{ID: "2bb80d", Device: "edge", CreatedBy: "alice"}}, nil
+		},
+		CatchRevokeEnrollmentToken: func(id, actor, reason string) error {
+			if id != "2bb80d" {
+				return verifier.ErrEnrollmentTokenNotFound
+			}
+			if actor != "alice" {
+				return fmt.Errorf("some error")
+			}
+			return nil
+		},
+	}
+	router := mux.NewRouter()
+	r.handleRequests(router)
+	testServer := httptest.NewServer(router)
+	defer testServer.Close()
+	r.v = &mock
+
+	var testSuite = []struct {
+		name     string
+		method   string
+		path     string
+		operator string
+		body     string
+		want     int
+		wantBody string
+	}{
+		{name: "enroll", method: "POST", path: "/enroll", body: `{"Token":"secret","Name":"edge"}`, want: http.StatusOK, wantBody: `"OwnerPassword":"owner"`},
+		{name: "enroll with used token", method: "POST", path: "/enroll", body: `{"Token":"used","Name":"edge"}`, want: http.StatusForbidden, wantBody: verifier.ErrInvalidEnrollmentToken.Error()},
+		{name: "enroll without name", method: "POST", path: "/enroll", body: `{"Token":"secret"}`, want: http.StatusBadRequest},
+		{name: "enroll with internal error", method: "POST", path: "/enroll", body: `{"Token":"secret","Name":"gateway"}`, want: http.StatusInternalServerError},
+		{name: "create token", method: "POST", path: "/enrollment-tokens", operator: "alice", body: `{"Device":"edge","Actor":"mallory","TTL":"1h"}`, want: http.StatusCreated, wantBody: `"Token":"secret"`},
+		{name: "create token records the operator", method: "POST", path: "/enrollment-tokens", operator: "alice", body: `{"Device":"edge","Actor":"mallory"}`, want: http.StatusCreated, wantBody: `"CreatedBy":"alice"`},
+		{name: "create token with invalid TTL", method: "POST", path: "/enrollment-tokens", operator: "alice", body: `{"Device":"edge","TTL":"soon"}`, want: http.StatusBadRequest},
+		{name: "create token without device", method: "POST", path: "/enrollment-tokens", operator: "alice", body: `{}`, want: http.StatusBadRequest},
+		{name: "create token without operator", method: "POST", path: "/enrollment-tokens", body: `{"Device":"edge","Actor":"alice"}`, want: http.StatusForbidden},
+		{name: "tokens", method: "GET", path: "/enrollment-tokens", operator: "alice", want: http.StatusOK, wantBody: `"Device":"edge"`},
+		{name: "tokens without operator", method: "GET", path: "/enrollment-tokens", want: http.StatusForbidden},
+		{name: "revoke token", method: "DELETE", path: "/enrollment-tokens/2bb80d", operator: "alice", body: `{"Reason":"device returned"}`, want: http.StatusNoContent},
+		{name: "revoke unknown token", method: "DELETE", path: "/enrollment-tokens/ffff", operator: "alice", body: `{"Reason":"device returned"}`, want: http.StatusNotFound},
+		{name: "revoke token without reason", method: "DELETE", path: "/enrollment-tokens/2bb80d", operator: "alice", body: `{}`, want: http.StatusBadRequest},
+		{name: "revoke token without operator", method: "DELETE", path: "/enrollment-tokens/2bb80d", body: `{"Actor":"alice","Reason":"device returned"}`, want: http.StatusForbidden},
+	}
+	for _, test := range testSuite {
+		t.Run(test.name, func(t *testing.T) {
+			req, err := http.NewRequest(test.method, testServer.URL+test.path, strings.NewReader(test.body))
+			if err != nil {
+				t.Fatalf("NewRequest() returned an error: %v", err)
+			}
+			if test.operator != "" {
+				req.Header.Set("Operator", test.operator)
+			}
+			resp, err := http.DefaultClient.Do(req)
+			if err != nil {
+				t.Fatalf("Do() returned an error: %v", err)
+			}
+			defer resp.Body.Close()
+			if resp.StatusCode != test.want {
+				t.Error(tests.Failure(t, resp.StatusCode, test.want, ""))
+			}
+			body, err := ioutil.ReadAll(resp.Body)
+			if err != nil {
+				t.Fatalf("ReadAll() returned an error: %v", err)
+			}
+			if !strings.Contains(string(body), test.wantBody) {
+				t.Error(tests.Failure(t, string(body), test.wantBody, "body"))
+			}
+		})
+	}
+}
+
+func TestRestServer_registrations(t *testing.T) {
+	mock := mocks.MockVerifier{
+		CatchPendingRegistrations: func() ([]verifier.ProverStatus, error) {
+			return []verifier.ProverStatus{{ID: "2bb80d", Name: "edge", Endpoint: "10.42.0.7", Port: "8080", State: verifier.StatePending, Pending: true}}, nil
+		},
+		CatchApproveRegistration: func(id, actor, reason string) error {
+			if actor != "alice" {
+				return fmt.Errorf("some error")
+			}
+			switch id {
+			case "2bb80d":
+				return nil
+			case "approved":
+				return verifier.ErrNotPending
+			}
+			return verifier.ErrProverNotFound
+		},
+		CatchRejectRegistration: func(id string, request verifier.Deregistration) error {
+			if request.Actor != "alice" {
+				return fmt.Errorf("some error")
+			}
+			if id != "2bb80d" {
+				return verifier.ErrNotPending
+			}
+			return nil
+		},
+		CatchAttestProver: func(ctx context.Context, id string) (*verifier.Attestation, error) {
+			return nil, verifier.ErrRegistrationPending
+		},
+	}
+	router := mux.NewRouter()
+	r.handleRequests(router)
+	testServer := httptest.NewServer(router)
+	defer testServer.Close()
+	r.v = &mock
+
+	var testSuite = []struct {
+		name     string
+		method   string
+		path     string
+		operator string
+		body     string
+		want     int
+		wantBody string
+	}{
+		{name: "pending registrations", method: "GET", path: "/registrations", operator: "alice", want: http.StatusOK, wantBody: `"Endpoint":"10.42.0.7"`},
+		{name: "pending registrations without operator", method: "GET", path: "/registrations", want: http.StatusForbidden},
+		{name: "approve", method: "POST", path: "/registrations/2bb80d/approve", operator: "alice", body: `{"Reason":"asset 4242"}`, want: http.StatusNoContent},
+		{name: "approve as another actor", method: "POST", path: "/registrations/2bb80d/approve", operator: "bob", body: `{"Actor":"alice","Reason":"asset 4242"}`, want: http.StatusInternalServerError},
+		{name: "approve without reason", method: "POST", path: "/registrations/2bb80d/approve", operator: "alice", body: `{}`, want: http.StatusBadRequest},
+		{name: "approve without operator", method: "POST", path: "/registrations/2bb80d/approve", body: `{"Actor":"alice","Reason":"asset 4242"}`, want: http.StatusForbidden},
+		{name: "approve approved prover", method: "POST", path: "/registrations/approved/approve", operator: "alice", body: `{"Reason":"asset 4242"}`, want: http.StatusConflict},
+		{name: "approve unknown prover", method: "POST", path: "/registrations/ffff/approve", operator: "alice", body: `{"Reason":"asset 4242"}`, want: http.StatusNotFound},
+		{name: "reject and block", method: "POST", path: "/registrations/2bb80d/reject", operator: "alice", body: `{"Actor":"mallory","Reason":"unknown device","Block":true}`, want: http.StatusNoContent},
+		{name: "reject approved prover", method: "POST", path: "/registrations/approved/reject", operator: "alice", body: `{"Reason":"unknown device"}`, want: http.StatusConflict},
+		{name: "reject without reason", method: "POST", path: "/registrations/2bb80d/reject", operator: "alice", body: `{}`, want: http.StatusBadRequest},
+		{name: "reject without operator", method: "POST", path: "/registrations/2bb80d/reject", body: `{"Actor":"alice","Reason":"unknown device"}`, want: http.StatusForbidden},
+		{name: "attest pending prover", method: "POST", path: "/provers/2bb80d/attest", operator: "alice", want: http.StatusConflict},
+	}
+	for _, test := range testSuite {
+		t.Run(test.name, func(t *testing.T) {
+			req, err := http.NewRequest(test.method, testServer.URL+test.path, strings.NewReader(test.body))
+			if err != nil {
+				t.Fatalf("NewRequest() returned an error: %v", err)
+			}
+			if test.operator != "" {
+				req.Header.Set("Operator", test.operator)
+			}
+			resp, err := http.DefaultClient.Do(req)
+			if err != nil {
+				t.Fatalf("Do() returned an error: %v", err)
+			}
+			defer resp.Body.Close()
+			if resp.StatusCode != test.want {
+				t.Error(tests.Failure(t, resp.StatusCode, test.want, ""))
+			}
+			body, err := ioutil.ReadAll(resp.Body)
+			if err != nil {
+				t.Fatalf("ReadAll() returned an error: %v", err)
+			}
+			if !strings.Contains(string(body), test.wantBody) {
+				t.Error(tests.Failure(t, string(body), test.wantBody, "body"))
+			}
+		})
+	}
+}
+
+func TestRestServer_deadLetters(t *testing.T) {
+	mock := mocks.MockVerifier{
+		CatchDeadLetters: func() ([]verifier.DeadLetter, error) {
+			return []verifier.DeadLetter{{ID: 1, URL: "https://hooks.example.com", Attempts: 5}}, nil
+		},
+		CatchRedeliver: func(ctx context.Context, id uint64) error {
+			switch id {
+			case 1:
+				return nil
+			case 2:
+				return verifier.ErrWebhooksDisabled
+			case 3:
+				return fmt.Errorf("503 Service Unavailable")
+			}
+			return verifier.ErrDeadLetterNotFound
+		},
+	}
+	router := mux.NewRouter()
+	r.handleRequests(router)
+	testServer := httptest.NewServer(router)
+	defer testServer.Close()
+	r.v = &mock
+
+	var testSuite = []struct {
+		name     string
+		method   string
+		path     string
+		operator string
+		want     int
+		wantBody string
+	}{
+		{name: "dead letters", method: "GET", path: "/webhooks/dead-letters", operator: "alice", want: http.StatusOK, wantBody: `"Attempts":5`},
+		{name: "dead letters without operator", method: "GET", path: "/webhooks/dead-letters", want: http.StatusForbidden},
+		{name: "redeliver", method: "POST", path: "/webhooks/dead-letters/1/redeliver", operator: "alice", want: http.StatusNoContent},
+		{name: "redeliver without operator", method: "POST", path: "/webhooks/dead-letters/1/redeliver", want: http.StatusForbidden},
+		{name: "redeliver without webhooks", method: "POST", path: "/webhooks/dead-letters/2/redeliver", operator: "alice", want: http.StatusConflict},
+		{name: "redeliver failed", method: "POST", path: "/webhooks/dead-letters/3/redeliver", operator: "alice", want: http.StatusBadGateway},
+		{name: "redeliver unknown letter", method: "POST", path: "/webhooks/dead-letters/4/redeliver", operator: "alice", want: http.StatusNotFound},
+		{name: "redeliver invalid ID", method: "POST", path: "/webhooks/dead-letters/first/redeliver", operator: "alice", want: http.StatusBadRequest},
+	}
+	for _, test := range testSuite {
+		t.Run(test.name, func(t *testing.T) {
+			req, err := http.NewRequest(test.method, testServer.URL+test.path, nil)
+			if err != nil {
+				t.Fatalf("NewRequest() returned an error: %v", err)
+			}
+			if test.operator != "" {
+				req.Header.Set("Operator", test.operator)
+			}
+			resp, err := http.DefaultClient.Do(req)
+			if err != nil {
+				t.Fatalf("Do() returned an error: %v", err)
+			}
+			defer resp.Body.Close()
+			if resp.StatusCode != test.want {
+				t.Error(tests.Failure(t, resp.StatusCode, test.want, ""))
+			}
+			body, err := ioutil.ReadAll(resp.Body)
+			if err != nil {
+				t.Fatalf("ReadAll() returned an error: %v", err)
+			}
+			if !strings.Contains(string(body), test.wantBody) {
+				t.Error(tests.Failure(t, string(body), test.wantBody, "body"))
+			}
+		})
+	}
+}
+
+func TestRestServer_metrics(t *testing.T) {
+	router := mux.NewRouter()
+	r.handleRequests(router)
+	testServer := httptest.NewServer(router)
+	defer testServer.Close()
+
+	resp, err := http.Get(testServer.URL + "/metrics")
+	if err != nil {
+		t.Fatalf("Get() returned an error: %v", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Error(tests.Failure(t, resp.StatusCode, http.StatusOK, ""))
+	}
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatalf("ReadAll() returned an error: %v", err)
+	}
+	for _, want := range []string{"# TYPE verifier_attestations_total counter", "# TYPE verifier_quote_verification_seconds histogram", "verifier_scheduler_queue_depth"} {
+		if !strings.Contains(string(body), want) {
+			t.Error(tests.Failure(t, string(body), want, "metrics"))
+		}
+	}
+}
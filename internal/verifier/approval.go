@@ -0,0 +1,128 @@
+package verifier
+
+import (
+	"errors"
+	"fmt"
+	log "github.com/sirupsen/logrus"
+	"sort"
+	"time"
+)
+
+var (
+	// ErrRegistrationPending is returned when attesting a prover whose registration is not approved yet.
+	ErrRegistrationPending = errors.New("registration pending approval")
+	// ErrNotPending is returned when approving or rejecting a prover whose registration is not pending.
+	ErrNotPending = errors.New("registration not pending")
+)
+
+const (
+	AuditApprove AuditAction = "approve"
+	AuditReject  AuditAction = "reject"
+	AuditLabels  AuditAction = "labels"
+)
+
+// PendingRegistrations returns the provers whose registration waits for approval, sorted by name.
+// Their ID is the fingerprint of their EK, Endpoint and Port the address they claimed at registration.
+func (v *DataVerifier) PendingRegistrations() ([]ProverStatus, error) {
+	provers, err := v.Provers.List()
+	if err != nil {
+		return nil, fmt.Errorf("error listing provers: %v", err)
+	}
+	statuses := []ProverStatus{}
+	for _, p := range provers {
+		if p.Pending {
+			statuses = append(statuses, newProverStatus(p))
+		}
+	}
+	sort.Slice(statuses, func(i, j int) bool {
+		if statuses[i].Name != statuses[j].Name {
+			return statuses[i].Name < statuses[j].Name
+		}
+		return statuses[i].ID < statuses[j].ID
+	})
+	return statuses, nil
+}
+
+// ApproveRegistration approves the pending registration of the prover with ID id, it is attested from then on.
+// The labels claimed at registration become its labels, unless an operator already set them.
+func (v *DataVerifier) ApproveRegistration(id, actor, reason string) error {
+	if actor == "" || reason == "" {
+		return ErrMissingActor
+	}
+	p, err := v.proverByID(id)
+	if err != nil {
+		return err
+	}
+	now := time.Now()
+	var from TrustState
+	var changed *Prover
+	var moved bool
+	err = v.Provers.Update(p.EK.PublicKey(), func(stored *Prover) error {
+		if !stored.Pending {
+			return ErrNotPending
+		}
+		from = stored.State
+		stored.Pending = false
+		if stored.Labels == nil {
+			stored.Labels = copyLabels(stored.ClaimedLabels)
+		}
+		// A revoked prover stays revoked, it is registered once the revocation is withdrawn.
+		if !stored.Revoked {
+			if moved = stored.setState(StateRegistered, "registration approved: "+reason, now); !moved {
+				return fmt.Errorf("%w: %v to %v", ErrInvalidTransition, stored.State, StateRegistered)
+			}
+		}
+		changed = stored.clone()
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	if moved {
+		v.notify(changed, from, nil)
+	}
+	log.Infof("%v(%v:%v): registration approved by %v: %v", p.Name, p.Endpoint, p.Port, actor, reason)
+	v.audit(AuditEntry{Time: now, Actor: actor, Action: AuditApprove, Prover: id, Name: p.Name, Reason: reason})
+	return nil
+}
+
+// RejectRegistration removes the prover with ID id whose registration is pending, like Deregister.
+// It can register again, pending approval, unless request.Block is set.
+func (v *DataVerifier) RejectRegistration(id string, request Deregistration) error {
+	if request.Actor == "" || request.Reason == "" {
+		return ErrMissingActor
+	}
+	p, err := v.proverByID(id)
+	if err != nil {
+		return err
+	}
+	if !p.Pending {
+		return ErrNotPending
+	}
+	if err = v.remove(id, p, request, AuditReject); err != nil {
+		return err
+	}
+	log.Warnf("%v(%v:%v): registration rejected by %v: %v", p.Name, p.Endpoint, p.Port, request.Actor, request.Reason)
+	return nil
+}
+
+// SetLabels replaces the labels of the prover with ID id, they select its PCR policy and its attestation interval.
+func (v *DataVerifier) SetLabels(id, actor, reason string, labels map[string]string) error {
+	if actor == "" || reason == "" {
+		return ErrMissingActor
+	}
+	p, err := v.proverByID(id)
+	if err != nil {
+		return err
+	}
+	err = v.Provers.Update(p.EK.PublicKey(), func(stored *Prover) error {
+		stored.Labels = copyLabels(labels)
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	log.Infof("%v(%v:%v): labels set to %v by %v: %v", p.Name, p.Endpoint, p.Port, labels, actor, reason)
+	v.audit(AuditEntry{Time: time.Now(), Actor: actor, Action: AuditLabels, Prover: id, Name: p.Name, Reason: reason})
+	return nil
+}
@@ -0,0 +1,172 @@
+package verifier
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"errors"
+	"github.com/google/go-cmp/cmp"
+	"github.com/xcaliburne/RemoteAttestations/pkg/tests"
+	"github.com/xcaliburne/RemoteAttestations/pkg/tpm"
+	tpmMocks "github.com/xcaliburne/RemoteAttestations/pkg/tpm/tests/mocks"
+	verifierDB "github.com/xcaliburne/RemoteAttestations/pkg/verifier"
+	"testing"
+)
+
+// registeredProver registers the prover name through RegisterNewEK and activates its AK.
+func registeredProver(t *testing.T, v *DataVerifier, name string) *Prover {
+	ek, err := rsa.GenerateKey(rand.Reader, 1024)
+	if err != nil {
+		t.Fatalf("GenerateKey() returned an error: %v", err)
+	}
+	p := &Prover{
+		Name:     name,
+		Endpoint: "10.42.0.7",
+		Port:     "8080",
+		EK: &tpmMocks.MockEndorsementKey{
+			CatchPublicKey:    func() *rsa.PublicKey { return &ek.PublicKey },
+			CatchVerifyEKCert: func() error { return nil },
+		},
+	}
+	if err = v.RegisterNewEK(p); err != nil {
+		t.Fatalf("RegisterNewEK() returned an error: %v", err)
+	}
+	err = v.Provers.Update(p.EK.PublicKey(), func(stored *Prover) error {
+		stored.AK = &tpm.AttestationKeyData{PK: &ek.PublicKey}
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("Update() returned an error: %v", err)
+	}
+	return p
+}
+
+func TestDataVerifier_ApproveRegistration(t *testing.T) {
+	v := NewVerifier(&Config{RequireApproval: true})
+	edge := registeredProver(t, v, "edge")
+	gateway := registeredProver(t, v, "gateway")
+	edgeID, gatewayID := Fingerprint(edge.EK.PublicKey()), Fingerprint(gateway.EK.PublicKey())
+	s := NewScheduler(v)
+
+	pending, err := v.PendingRegistrations()
+	if err != nil {
+		t.Fatalf("PendingRegistrations() returned an error: %v", err)
+	}
+	if len(pending) != 2 || pending[0].ID != edgeID || pending[0].Endpoint != "10.42.0.7" || pending[0].State != StatePending {
+		t.Fatal(tests.Failure(t, pending, "pending registrations of edge and gateway", ""))
+	}
+	if due := s.due(); len(due) != 0 {
+		t.Error(tests.Failure(t, len(due), 0, "pending provers scheduled"))
+	}
+	if _, err = v.AttestProver(context.Background(), edgeID); !errors.Is(err, ErrRegistrationPending) {
+		t.Error(tests.Failure(t, err, ErrRegistrationPending, "on-demand attestation of a pending prover"))
+	}
+
+	if err = v.ApproveRegistration(edgeID, "alice", ""); !errors.Is(err, ErrMissingActor) {
+		t.Error(tests.Failure(t, err, ErrMissingActor, "approval without reason"))
+	}
+	if err = v.ApproveRegistration(edgeID, "alice", "asset 4242"); err != nil {
+		t.Fatalf("ApproveRegistration() returned an error: %v", err)
+	}
+	if err = v.ApproveRegistration(edgeID, "alice", "asset 4242"); !errors.Is(err, ErrNotPending) {
+		t.Error(tests.Failure(t, err, ErrNotPending, "registration approved twice"))
+	}
+	if status, _ := v.GetProver(edgeID); status.State != StateRegistered || status.Pending {
+		t.Error(tests.Failure(t, status, "approved prover registered", ""))
+	}
+	if due := s.due(); len(due) != 1 || due[0].Name != "edge" {
+		t.Error(tests.Failure(t, due, "edge", "provers scheduled after the approval"))
+	}
+
+	if err = v.RejectRegistration(edgeID, Deregistration{Actor: "alice", Reason: "unknown device"}); !errors.Is(err, ErrNotPending) {
+		t.Error(tests.Failure(t, err, ErrNotPending, "rejection of an approved prover"))
+	}
+	if err = v.RejectRegistration(gatewayID, Deregistration{Actor: "alice", Reason: "unknown device", Block: true}); err != nil {
+		t.Fatalf("RejectRegistration() returned an error: %v", err)
+	}
+	if pending, _ = v.PendingRegistrations(); len(pending) != 0 {
+		t.Error(tests.Failure(t, pending, nil, "pending registrations after the rejection"))
+	}
+	if err = v.RegisterNewEK(gateway); err == nil {
+		t.Error(tests.Failure(t, err, "blocked EK", "registration of a rejected and blocked prover"))
+	}
+
+	entries, err := v.AuditLog()
+	if err != nil {
+		t.Fatalf("AuditLog() returned an error: %v", err)
+	}
+	var actions []AuditAction
+	for _, e := range entries {
+		actions = append(actions, e.Action)
+	}
+	wantActions := []AuditAction{AuditApprove, AuditBlock, AuditReject}
+	if !cmp.Equal(actions, wantActions) {
+		t.Error(tests.Failure(t, actions, wantActions, "audit log"))
+	}
+}
+
+func TestDataVerifier_labels(t *testing.T) {
+	v := NewVerifier(&Config{RequireApproval: true})
+	v.PCRPolicies = verifierDB.PCRPolicies{{Name: "paris", Labels: map[string]string{"site": "paris"}, Rules: []verifierDB.PCRRule{{PCR: 0}}}}
+	ek, err := rsa.GenerateKey(rand.Reader, 1024)
+	if err != nil {
+		t.Fatalf("GenerateKey() returned an error: %v", err)
+	}
+	paris := map[string]string{"site": "paris"}
+	p := &Prover{
+		Name:          "edge",
+		Endpoint:      "10.42.0.7",
+		Port:          "8080",
+		Labels:        paris,
+		ClaimedLabels: paris,
+		EK: &tpmMocks.MockEndorsementKey{
+			CatchPublicKey:    func() *rsa.PublicKey { return &ek.PublicKey },
+			CatchVerifyEKCert: func() error { return nil },
+		},
+	}
+	if err = v.RegisterNewEK(p); err != nil {
+		t.Fatalf("RegisterNewEK() returned an error: %v", err)
+	}
+	id := Fingerprint(&ek.PublicKey)
+	labels := func() map[string]string {
+		stored, err := v.Provers.GetByEK(&ek.PublicKey)
+		if err != nil {
+			t.Fatalf("GetByEK() returned an error: %v", err)
+		}
+		return stored.Labels
+	}
+
+	if got := labels(); got != nil || v.PCRPolicies.Match(p.Name, got) != nil {
+		t.Error(tests.Failure(t, got, nil, "labels claimed at registration"))
+	}
+	if status, _ := v.GetProver(id); !cmp.Equal(status.ClaimedLabels, paris) {
+		t.Error(tests.Failure(t, status.ClaimedLabels, paris, "claimed labels"))
+	}
+	if err = v.ApproveRegistration(id, "alice", "asset 4242"); err != nil {
+		t.Fatalf("ApproveRegistration() returned an error: %v", err)
+	}
+	if got := labels(); !cmp.Equal(got, paris) || v.PCRPolicies.Match(p.Name, got) == nil {
+		t.Error(tests.Failure(t, got, paris, "labels after the approval"))
+	}
+
+	lyon := map[string]string{"site": "lyon"}
+	if err = v.SetLabels(id, "alice", "", lyon); !errors.Is(err, ErrMissingActor) {
+		t.Error(tests.Failure(t, err, ErrMissingActor, "labels set without reason"))
+	}
+	if err = v.SetLabels("ffff", "alice", "moved", lyon); !errors.Is(err, ErrProverNotFound) {
+		t.Error(tests.Failure(t, err, ErrProverNotFound, "labels of an unknown prover"))
+	}
+	if err = v.SetLabels(id, "alice", "moved", lyon); err != nil {
+		t.Fatalf("SetLabels() returned an error: %v", err)
+	}
+	if got := labels(); !cmp.Equal(got, lyon) || v.PCRPolicies.Match(p.Name, got) != nil {
+		t.Error(tests.Failure(t, got, lyon, "labels set by an operator"))
+	}
+	entries, err := v.AuditLog()
+	if err != nil {
+		t.Fatalf("AuditLog() returned an error: %v", err)
+	}
+	if last := entries[len(entries)-1]; last.Action != AuditLabels || last.Actor != "alice" {
+		t.Error(tests.Failure(t, last, AuditLabels, "audit log"))
+	}
+}
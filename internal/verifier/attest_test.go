@@ -0,0 +1,182 @@
+package verifier
+
+import (
+	"bytes"
+	"crypto/sha1"
+	"encoding/binary"
+	"encoding/json"
+	"github.com/xcaliburne/RemoteAttestations/pkg/eventlog"
+	"github.com/xcaliburne/RemoteAttestations/pkg/httpClient"
+	"github.com/xcaliburne/RemoteAttestations/pkg/httpClient/tests/mocks"
+	"github.com/xcaliburne/RemoteAttestations/pkg/tests"
+	"github.com/xcaliburne/RemoteAttestations/pkg/tpm"
+	tpmMocks "github.com/xcaliburne/RemoteAttestations/pkg/tpm/tests/mocks"
+	verifierDB "github.com/xcaliburne/RemoteAttestations/pkg/verifier"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+// softwareAttestedProver returns a prover whose AK is held by a software TPM with PCRs 0 to 7 extended by measuredPCR.
+func softwareAttestedProver(t *testing.T) (*Prover, tpm.TPM) {
+	swTPM, err := tpm.OpenSoftware(t.TempDir())
+	if err != nil {
+		t.Fatalf("OpenSoftware() returned an error: %v", err)
+	}
+	if err = swTPM.TakeOwnership("owner", "user"); err != nil {
+		t.Fatalf("TakeOwnership() returned an error: %v", err)
+	}
+	if err = swTPM.ProveUsership("user"); err != nil {
+		t.Fatalf("ProveUsership() returned an error: %v", err)
+	}
+	ak, err := swTPM.CreateAK()
+	if err != nil {
+		t.Fatalf("CreateAK() returned an error: %v", err)
+	}
+	for pcr := 0; pcr < 8; pcr++ {
+		if err = swTPM.ExtendPCR(pcr, measuredPCR(pcr), 0, ""); err != nil {
+			t.Fatalf("ExtendPCR() returned an error: %v", err)
+		}
+	}
+	return &Prover{Name: "edge", Endpoint: "10.42.0.7", Port: "8080", EK: &tpmMocks.MockEndorsementKey{}, AK: ak}, swTPM
+}
+
+func measuredPCR(pcr int) []byte {
+	return []byte{byte(pcr)}
+}
+
+// sha1EventLog encodes the event log of the PCRs extended by softwareAttestedProver in the SHA-1 format, the events of skipped are left out.
+func sha1EventLog(skipped ...int) []byte {
+	buf := &bytes.Buffer{}
+	for pcr := 0; pcr < 8; pcr++ {
+		if containsPCR(skipped, pcr) {
+			continue
+		}
+		data := measuredPCR(pcr)
+		digest := sha1.Sum(data)
+		_ = binary.Write(buf, binary.LittleEndian, uint32(pcr))
+		_ = binary.Write(buf, binary.LittleEndian, uint32(eventlog.PostCode))
+		buf.Write(digest[:])
+		_ = binary.Write(buf, binary.LittleEndian, uint32(len(data)))
+		buf.Write(data)
+	}
+	return buf.Bytes()
+}
+
+// proverClient serves the quotes of swTPM for the AK of p and eventLog, the prover has no event log when it is nil.
+// The prover quotes every PCR of the requested bank instead of the requested ones when quoteAll is set.
+func proverClient(t *testing.T, p *Prover, swTPM tpm.TPM, eventLog []byte, quoteAll bool) *mocks.MockHttpClient {
+	respond := func(status int, body []byte) (*http.Response, error) {
+		return &http.Response{StatusCode: status, Status: http.StatusText(status), Body: ioutil.NopCloser(bytes.NewReader(body))}, nil
+	}
+	return &mocks.MockHttpClient{
+		CatchGet: func(url string) (*http.Response, error) {
+			if strings.HasSuffix(url, "/eventlog") && eventLog != nil {
+				return respond(http.StatusOK, eventLog)
+			}
+			return respond(http.StatusNotFound, nil)
+		},
+		CatchPost: func(url string, contentType string, body []byte) (*http.Response, error) {
+			var request struct {
+				Nonce        []byte
+				PCRSelection tpm.PCRSelection
+			}
+			if err := json.Unmarshal(body, &request); err != nil {
+				t.Fatalf("invalid attestation request: %v", err)
+			}
+			sel := request.PCRSelection
+			if quoteAll || len(sel.PCRs) == 0 {
+				sel = tpm.AllPCRs(sel.Bank)
+			}
+			quote, err := swTPM.Quote(p.AK, request.Nonce, sel)
+			if err != nil {
+				return respond(http.StatusInternalServerError, nil)
+			}
+			raw, err := tpm.SerializeQuote(quote)
+			if err != nil {
+				t.Fatalf("SerializeQuote() returned an error: %v", err)
+			}
+			return respond(http.StatusOK, raw)
+		},
+	}
+}
+
+func TestDataVerifier_attest(t *testing.T) {
+	p, swTPM := softwareAttestedProver(t)
+	pcrs, err := swTPM.ListPCRs(tpm.SHA1)
+	if err != nil {
+		t.Fatalf("ListPCRs() returned an error: %v", err)
+	}
+	reference := []tpm.PCR{pcrs[0], pcrs[7]}
+	policy := &verifierDB.PCRPolicy{Name: "fleet", Bank: tpm.SHA1, Rules: []verifierDB.PCRRule{{PCR: 0, Values: [][]byte{pcrs[0].Value}}, {PCR: 7}}}
+
+	var testSuite = []struct {
+		name         string
+		sel          tpm.PCRSelection
+		expectedPCRs []tpm.PCR
+		policy       *verifierDB.PCRPolicy
+		quoteAll     bool
+		wantFailures bool
+	}{
+		{name: "Reference values", sel: tpm.SelectPCRs(reference, tpm.SHA1), expectedPCRs: reference, wantFailures: false},
+		{name: "No reference values", sel: tpm.PCRSelection{Bank: tpm.SHA1}, wantFailures: true},
+		{name: "Quoted PCR without reference value", sel: tpm.SelectPCRs(reference, tpm.SHA1), expectedPCRs: reference, quoteAll: true, wantFailures: true},
+		{name: "PCR policy", sel: policy.Selection(), policy: policy, wantFailures: false},
+		{name: "Quoted PCR outside the PCR policy", sel: policy.Selection(), policy: policy, quoteAll: true, wantFailures: true},
+	}
+	for _, test := range testSuite {
+		t.Run(test.name, func(t *testing.T) {
+			httpClient.Client = proverClient(t, p, swTPM, nil, test.quoteAll)
+			v := NewVerifier(&Config{})
+			record := &Attestation{}
+			if err := v.attest(p, test.sel, test.expectedPCRs, test.policy, record); err != nil {
+				t.Fatalf("attest() returned an error: %v", err)
+			}
+			if (len(record.Failures) > 0) != test.wantFailures {
+				t.Error(tests.Failure(t, record.Failures, test.wantFailures, "failures"))
+			}
+		})
+	}
+}
+
+func TestDataVerifier_attest_requiredPCRs(t *testing.T) {
+	p, swTPM := softwareAttestedProver(t)
+	pcrs, err := swTPM.ListPCRs(tpm.SHA1)
+	if err != nil {
+		t.Fatalf("ListPCRs() returned an error: %v", err)
+	}
+	eventPolicy := verifierDB.EventPolicy{BootApplications: [][]byte{make([]byte, sha1.Size)}}
+	tampered := tpm.PCR{Id: 4, Bank: tpm.SHA1, Value: make([]byte, sha1.Size)}
+
+	var testSuite = []struct {
+		name         string
+		eventLog     []byte
+		requiredPCRs []int
+		expectedPCRs []tpm.PCR
+		eventPolicy  verifierDB.EventPolicy
+		wantFailures bool
+	}{
+		{name: "Event log measuring the required PCRs", eventLog: sha1EventLog(), wantFailures: true},
+		{name: "Event log appraised by the event policy", eventLog: sha1EventLog(), eventPolicy: eventPolicy, wantFailures: false},
+		{name: "Event log matching the reference values", eventLog: sha1EventLog(), expectedPCRs: []tpm.PCR{pcrs[0], pcrs[4]}, wantFailures: false},
+		{name: "Event log not matching the reference values", eventLog: sha1EventLog(), expectedPCRs: []tpm.PCR{pcrs[0], tampered}, wantFailures: true},
+		{name: "Event log without a required PCR", eventLog: sha1EventLog(5), eventPolicy: eventPolicy, wantFailures: true},
+		{name: "Event log without a PCR that is not required", eventLog: sha1EventLog(5), requiredPCRs: []int{0, 4, 7}, eventPolicy: eventPolicy, wantFailures: false},
+		{name: "Event log without a PCR with a reference value", eventLog: sha1EventLog(5), requiredPCRs: []int{0, 4, 7}, expectedPCRs: []tpm.PCR{pcrs[5]}, wantFailures: false},
+	}
+	for _, test := range testSuite {
+		t.Run(test.name, func(t *testing.T) {
+			httpClient.Client = proverClient(t, p, swTPM, test.eventLog, false)
+			v := NewVerifier(&Config{EventLog: EventLogConfig{RequiredPCRs: test.requiredPCRs}})
+			v.EventPolicy = test.eventPolicy
+			record := &Attestation{}
+			if err := v.attest(p, tpm.SelectPCRs(test.expectedPCRs, tpm.SHA1), test.expectedPCRs, nil, record); err != nil {
+				t.Fatalf("attest() returned an error: %v", err)
+			}
+			if (len(record.Failures) > 0) != test.wantFailures {
+				t.Error(tests.Failure(t, record.Failures, test.wantFailures, "failures"))
+			}
+		})
+	}
+}
@@ -0,0 +1,181 @@
+package verifier
+
+import (
+	"errors"
+	"fmt"
+	log "github.com/sirupsen/logrus"
+	"sort"
+	"time"
+)
+
+// ErrMissingActor is returned for operator actions missing who acts or why.
+var ErrMissingActor = errors.New("actor and reason are required")
+
+// BlockedEK is an EK whose registration is rejected, ID is its fingerprint.
+type BlockedEK struct {
+	ID     string
+	Name   string `json:",omitempty"`
+	Time   time.Time
+	Actor  string
+	Reason string
+}
+
+// AuditAction is an operator action recorded in the audit log.
+type AuditAction string
+
+const (
+	AuditDeregister AuditAction = "deregister"
+	AuditDropAK     AuditAction = "drop_ak"
+	AuditBlock      AuditAction = "block"
+	AuditUnblock    AuditAction = "unblock"
+)
+
+// AuditEntry records who acted on a prover or on the blocklist and why. Prover is the fingerprint of the EK.
+type AuditEntry struct {
+	Time   time.Time
+	Actor  string
+	Action AuditAction
+	Prover string
+	Name   string `json:",omitempty"`
+	Reason string
+}
+
+// Deregistration is an operator request to remove a prover, Block also adds its EK to the blocklist.
+type Deregistration struct {
+	Actor  string
+	Reason string
+	Block  bool
+}
+
+// Deregister removes the prover with ID id, its AK and its history. The prover has to register again to be attested,
+// which is rejected when request.Block is set.
+func (v *DataVerifier) Deregister(id string, request Deregistration) error {
+	if request.Actor == "" || request.Reason == "" {
+		return ErrMissingActor
+	}
+	p, err := v.proverByID(id)
+	if err != nil {
+		return err
+	}
+	if err = v.remove(id, p, request, AuditDeregister); err != nil {
+		return err
+	}
+	log.Warnf("%v(%v:%v): deregistered by %v: %v", p.Name, p.Endpoint, p.Port, request.Actor, request.Reason)
+	return nil
+}
+
+// remove deletes p, whose ID is id, with its AK and its history, and blocks its EK when request.Block is set.
+// The removal is recorded in the audit log as action.
+func (v *DataVerifier) remove(id string, p *Prover, request Deregistration, action AuditAction) error {
+	now := time.Now()
+	if request.Block {
+		err := v.Blocks.Block(BlockedEK{ID: id, Name: p.Name, Time: now, Actor: request.Actor, Reason: request.Reason})
+		if err != nil {
+			return fmt.Errorf("error blocking EK: %v", err)
+		}
+		v.audit(AuditEntry{Time: now, Actor: request.Actor, Action: AuditBlock, Prover: id, Name: p.Name, Reason: request.Reason})
+	}
+	if err := v.Provers.Delete(p.EK.PublicKey()); err != nil {
+		return fmt.Errorf("error deleting prover: %v", err)
+	}
+	key := keyID(p.EK.PublicKey())
+	v.pendingMutex.Lock()
+	delete(v.PendingAKs, key)
+	v.pendingMutex.Unlock()
+	lastSuccess.Delete(id, p.Name)
+	v.audit(AuditEntry{Time: now, Actor: request.Actor, Action: action, Prover: id, Name: p.Name, Reason: request.Reason})
+	return nil
+}
+
+// DropAK removes the AK of the prover with ID id, it is no longer attested until it registers a new AK.
+func (v *DataVerifier) DropAK(id, actor, reason string) error {
+	if actor == "" || reason == "" {
+		return ErrMissingActor
+	}
+	p, err := v.proverByID(id)
+	if err != nil {
+		return err
+	}
+	now := time.Now()
+	var from TrustState
+	var changed *Prover
+	var moved bool
+	err = v.Provers.Update(p.EK.PublicKey(), func(stored *Prover) error {
+		if stored.AK == nil {
+			return ErrAKNotActivated
+		}
+		from = stored.State
+		stored.AK = nil
+		// Revoked and pending provers keep their state, they are not attested either way.
+		if !stored.Revoked && !stored.Pending {
+			moved = stored.setState(StateRegistered, "AK dropped: "+reason, now)
+		}
+		changed = stored.clone()
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	if moved {
+		v.notify(changed, from, nil)
+	}
+	v.pendingMutex.Lock()
+	delete(v.PendingAKs, keyID(p.EK.PublicKey()))
+	v.pendingMutex.Unlock()
+	log.Warnf("%v(%v:%v): AK dropped by %v: %v", p.Name, p.Endpoint, p.Port, actor, reason)
+	v.audit(AuditEntry{Time: now, Actor: actor, Action: AuditDropAK, Prover: id, Name: p.Name, Reason: reason})
+	return nil
+}
+
+// UnblockEK removes the EK whose fingerprint is id from the blocklist, it can register again.
+func (v *DataVerifier) UnblockEK(id, actor, reason string) error {
+	if actor == "" || reason == "" {
+		return ErrMissingActor
+	}
+	blocked, err := v.Blocks.Blocked(id)
+	if err != nil {
+		return fmt.Errorf("error reading blocklist: %v", err)
+	}
+	if blocked == nil {
+		return ErrEKNotBlocked
+	}
+	if err = v.Blocks.Unblock(id); err != nil {
+		return err
+	}
+	log.Warnf("EK %v of %v unblocked by %v: %v", id, blocked.Name, actor, reason)
+	v.audit(AuditEntry{Time: time.Now(), Actor: actor, Action: AuditUnblock, Prover: id, Name: blocked.Name, Reason: reason})
+	return nil
+}
+
+// Blocklist returns the blocked EKs, oldest first.
+func (v *DataVerifier) Blocklist() ([]BlockedEK, error) {
+	blocklist, err := v.Blocks.Blocklist()
+	if err != nil {
+		return nil, fmt.Errorf("error reading blocklist: %v", err)
+	}
+	sort.Slice(blocklist, func(i, j int) bool {
+		if !blocklist[i].Time.Equal(blocklist[j].Time) {
+			return blocklist[i].Time.Before(blocklist[j].Time)
+		}
+		return blocklist[i].ID < blocklist[j].ID
+	})
+	return blocklist, nil
+}
+
+// AuditLog returns the audit log, oldest first.
+func (v *DataVerifier) AuditLog() ([]AuditEntry, error) {
+	entries, err := v.Audits.Audit()
+	if err != nil {
+		return nil, fmt.Errorf("error reading audit log: %v", err)
+	}
+	if entries == nil {
+		entries = []AuditEntry{}
+	}
+	return entries, nil
+}
+
+func (v *DataVerifier) audit(entry AuditEntry) {
+	if err := v.Audits.AddAudit(entry); err != nil {
+		log.Errorf("error storing audit entry %+v: %v", entry, err)
+	}
+}
@@ -0,0 +1,183 @@
+package verifier
+
+import (
+	"errors"
+	"fmt"
+	"github.com/google/go-cmp/cmp"
+	"github.com/xcaliburne/RemoteAttestations/pkg/httpClient"
+	"github.com/xcaliburne/RemoteAttestations/pkg/httpClient/tests/mocks"
+	"github.com/xcaliburne/RemoteAttestations/pkg/tests"
+	tpmMocks "github.com/xcaliburne/RemoteAttestations/pkg/tpm/tests/mocks"
+	verifierDB "github.com/xcaliburne/RemoteAttestations/pkg/verifier"
+	"net/http"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestDataVerifier_Deregister(t *testing.T) {
+	v := NewVerifier(&Config{})
+	stolen := activatedProver(t, v, "stolen", nil)
+	retired := activatedProver(t, v, "retired", nil)
+	for _, p := range []*Prover{stolen, retired} {
+		p.EK.(*tpmMocks.MockEndorsementKey).CatchVerifyEKCert = func() error { return nil }
+		if err := v.Provers.AddAttestation(p.EK.PublicKey(), &Attestation{Time: time.Now(), State: StateTrusted}, HistoryConfig{}); err != nil {
+			t.Fatalf("AddAttestation() returned an error: %v", err)
+		}
+	}
+	stolenID, retiredID := Fingerprint(stolen.EK.PublicKey()), Fingerprint(retired.EK.PublicKey())
+
+	if err := v.Deregister(stolenID, Deregistration{Reason: "stolen"}); !errors.Is(err, ErrMissingActor) {
+		t.Error(tests.Failure(t, err, ErrMissingActor, "deregistration without actor"))
+	}
+	if err := v.Deregister(stolenID, Deregistration{Actor: "alice", Reason: "stolen", Block: true}); err != nil {
+		t.Fatalf("Deregister() returned an error: %v", err)
+	}
+	if err := v.Deregister(retiredID, Deregistration{Actor: "bob", Reason: "retired"}); err != nil {
+		t.Fatalf("Deregister() returned an error: %v", err)
+	}
+	if err := v.Deregister(retiredID, Deregistration{Actor: "bob", Reason: "retired"}); !errors.Is(err, ErrProverNotFound) {
+		t.Error(tests.Failure(t, err, ErrProverNotFound, "prover deregistered twice"))
+	}
+	if _, err := v.Provers.GetByAK(stolen.AK.PublicKey()); !errors.Is(err, ErrProverNotFound) {
+		t.Error(tests.Failure(t, err, ErrProverNotFound, "AK of a deregistered prover"))
+	}
+
+	var testSuite = []struct {
+		name       string
+		prover     *Prover
+		wantReason verifierDB.EKRejectionReason
+	}{
+		{name: "Blocked EK", prover: stolen, wantReason: verifierDB.EKBlocked},
+		{name: "Deregistered EK", prover: retired},
+	}
+	for _, test := range testSuite {
+		t.Run(test.name, func(t *testing.T) {
+			err := v.RegisterNewEK(test.prover)
+			var ekErr *verifierDB.EKCertError
+			if errors.As(err, &ekErr) != (test.wantReason != "") || (ekErr != nil && ekErr.Reason != test.wantReason) {
+				t.Fatal(tests.Failure(t, err, test.wantReason, "registration"))
+			}
+			if err != nil {
+				return
+			}
+			page, err := v.ProverHistory(Fingerprint(test.prover.EK.PublicKey()), 0, 0)
+			if err != nil || page.Total != 0 {
+				t.Error(tests.Failure(t, page, "empty history", "history of a registered again prover"))
+			}
+		})
+	}
+
+	blocklist, err := v.Blocklist()
+	if err != nil || len(blocklist) != 1 || blocklist[0].ID != stolenID || blocklist[0].Actor != "alice" {
+		t.Error(tests.Failure(t, blocklist, "stolen EK blocked by alice", "blocklist"))
+	}
+	if err = v.UnblockEK(retiredID, "alice", "mistake"); !errors.Is(err, ErrEKNotBlocked) {
+		t.Error(tests.Failure(t, err, ErrEKNotBlocked, "unblocking an EK not blocked"))
+	}
+	if err = v.UnblockEK(stolenID, "alice", "found again"); err != nil {
+		t.Fatalf("UnblockEK() returned an error: %v", err)
+	}
+	if err = v.RegisterNewEK(stolen); err != nil {
+		t.Error(tests.Failure(t, err, nil, "registration of an unblocked EK"))
+	}
+
+	entries, err := v.AuditLog()
+	if err != nil {
+		t.Fatalf("AuditLog() returned an error: %v", err)
+	}
+	var actions []AuditAction
+	for _, e := range entries {
+		actions = append(actions, e.Action)
+	}
+	wantActions := []AuditAction{AuditBlock, AuditDeregister, AuditDeregister, AuditUnblock}
+	if !cmp.Equal(actions, wantActions) {
+		t.Error(tests.Failure(t, actions, wantActions, "audit log"))
+	}
+}
+
+func TestDataVerifier_DropAK(t *testing.T) {
+	v := NewVerifier(&Config{})
+	p := activatedProver(t, v, "edge", nil)
+	id := Fingerprint(p.EK.PublicKey())
+	err := v.Provers.Update(p.EK.PublicKey(), func(stored *Prover) error {
+		stored.setState(StateTrusted, "attestation passed", time.Now())
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("Update() returned an error: %v", err)
+	}
+	if err = v.DropAK(id, "alice", "AK leaked"); err != nil {
+		t.Fatalf("DropAK() returned an error: %v", err)
+	}
+	status, err := v.GetProver(id)
+	if err != nil {
+		t.Fatalf("GetProver() returned an error: %v", err)
+	}
+	if status.AK != "" || status.State != StateRegistered {
+		t.Error(tests.Failure(t, status, "registered prover without AK", ""))
+	}
+	if _, err = v.Provers.GetByAK(p.AK.PublicKey()); !errors.Is(err, ErrProverNotFound) {
+		t.Error(tests.Failure(t, err, ErrProverNotFound, "dropped AK"))
+	}
+	if err = v.DropAK(id, "alice", "AK leaked"); !errors.Is(err, ErrAKNotActivated) {
+		t.Error(tests.Failure(t, err, ErrAKNotActivated, "AK dropped twice"))
+	}
+}
+
+func TestDataVerifier_attestProverRemoved(t *testing.T) {
+	httpClient.Client = &mocks.MockHttpClient{CatchGet: func(url string) (*http.Response, error) {
+		return nil, fmt.Errorf("unreachable")
+	}}
+	var testSuite = []struct {
+		name    string
+		remove  func(v *DataVerifier, id string) error
+		wantErr error
+	}{
+		{
+			name: "AK dropped",
+			remove: func(v *DataVerifier, id string) error {
+				return v.DropAK(id, "alice", "compromised")
+			},
+			wantErr: ErrAKNotActivated,
+		},
+		{
+			name: "Deregistered",
+			remove: func(v *DataVerifier, id string) error {
+				return v.Deregister(id, Deregistration{Actor: "alice", Reason: "retired"})
+			},
+			wantErr: ErrProverNotFound,
+		},
+	}
+	for _, test := range testSuite {
+		t.Run(test.name, func(t *testing.T) {
+			v := NewVerifier(&Config{})
+			p := activatedProver(t, v, "edge", nil)
+			key := keyID(p.EK.PublicKey())
+			// Hold the attestation mutex of p so that the attestation starts with the prover it was scheduled with.
+			mutex := &sync.Mutex{}
+			v.attestMutexes.Store(key, mutex)
+			mutex.Lock()
+			type result struct {
+				record *Attestation
+				err    error
+			}
+			done := make(chan result, 1)
+			go func() {
+				record, err := v.attestProver(p)
+				done <- result{record, err}
+			}()
+			if err := test.remove(v, Fingerprint(p.EK.PublicKey())); err != nil {
+				t.Fatalf("removing the prover returned an error: %v", err)
+			}
+			if got, _ := v.attestMutexes.Load(key); got != mutex {
+				t.Error(tests.Failure(t, got, mutex, "attestation mutex while attesting"))
+			}
+			mutex.Unlock()
+			r := <-done
+			if r.record != nil || !errors.Is(r.err, test.wantErr) {
+				t.Error(tests.Failure(t, r, test.wantErr, "attestation of a removed prover"))
+			}
+		})
+	}
+}
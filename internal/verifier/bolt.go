@@ -0,0 +1,490 @@
+package verifier
+
+import (
+	"crypto/rsa"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"github.com/xcaliburne/RemoteAttestations/pkg/tpm"
+	bolt "go.etcd.io/bbolt"
+	"time"
+)
+
+var (
+	proversBucket = []byte("provers")
+	aksBucket     = []byte("aks")
+	// historyBucket holds a bucket of attestations for each EK, keyed by attestation time and sequence number.
+	historyBucket = []byte("history")
+	// blocklistBucket holds the blocked EKs by fingerprint, auditBucket the audit log by sequence number.
+	blocklistBucket = []byte("blocklist")
+	auditBucket     = []byte("audit")
+	// deadLettersBucket holds the failed webhook deliveries by ID.
+	deadLettersBucket = []byte("dead_letters")
+	// enrollmentTokensBucket holds the enrollment tokens by ID.
+	enrollmentTokensBucket = []byte("enrollment_tokens")
+)
+
+// BoltStore is a Store persisting the provers in a bbolt database file,
+// the provers stored by a previous run are attested again without registering.
+type BoltStore struct {
+	db *bolt.DB
+}
+
+var _ Store = (*BoltStore)(nil) // Verify that *BoltStore implements Store.
+
+// boltProver is the JSON encoding of a stored prover, its keys are stored with their JSON encoding.
+type boltProver struct {
+	Prover
+	EK *tpm.EndorsementKeyData
+	AK *tpm.AttestationKeyData `json:",omitempty"`
+}
+
+// OpenBoltStore opens the database file, it is created if it does not exist.
+func OpenBoltStore(path string) (*BoltStore, error) {
+	db, err := bolt.Open(path, 0600, &bolt.Options{Timeout: time.Second})
+	if err != nil {
+		return nil, fmt.Errorf("error opening prover store %v: %v", path, err)
+	}
+	err = db.Update(func(tx *bolt.Tx) error {
+		for _, bucket := range [][]byte{proversBucket, aksBucket, historyBucket, blocklistBucket, auditBucket, deadLettersBucket, enrollmentTokensBucket} {
+			if _, err := tx.CreateBucketIfNotExists(bucket); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		db.Close()
+		return nil, fmt.Errorf("error initializing prover store %v: %v", path, err)
+	}
+	return &BoltStore{db: db}, nil
+}
+
+func encodeProver(p *Prover) ([]byte, error) {
+	if p.EK == nil || p.EK.Certificate() == nil {
+		return nil, fmt.Errorf("endorsement key certificate not set")
+	}
+	b := boltProver{Prover: *p, EK: &tpm.EndorsementKeyData{PK: p.EK.PublicKey(), C: p.EK.Certificate()}}
+	if p.AK != nil {
+		b.AK = &tpm.AttestationKeyData{PK: p.AK.PublicKey(), B: p.AK.Blob(), P: p.AK.PublicArea(), V: p.AK.TPMVersion()}
+	}
+	return json.Marshal(b)
+}
+
+func decodeProver(data []byte) (*Prover, error) {
+	var b boltProver
+	if err := json.Unmarshal(data, &b); err != nil {
+		return nil, fmt.Errorf("error decoding stored prover: %v", err)
+	}
+	p := b.Prover
+	p.EK = b.EK
+	if b.AK != nil {
+		p.AK = b.AK
+	}
+	return &p, nil
+}
+
+func (s *BoltStore) Add(p *Prover) error {
+	if p.EK == nil {
+		return fmt.Errorf("endorsement key not set")
+	}
+	data, err := encodeProver(p)
+	if err != nil {
+		return err
+	}
+	ek := []byte(keyID(p.EK.PublicKey()))
+	return s.db.Update(func(tx *bolt.Tx) error {
+		provers := tx.Bucket(proversBucket)
+		if provers.Get(ek) != nil {
+			return ErrProverExists
+		}
+		if err := indexBoltAK(tx, ek, nil, p.AK); err != nil {
+			return err
+		}
+		return provers.Put(ek, data)
+	})
+}
+
+func (s *BoltStore) Update(k *rsa.PublicKey, update func(p *Prover) error) error {
+	ek := []byte(keyID(k))
+	return s.db.Update(func(tx *bolt.Tx) error {
+		provers := tx.Bucket(proversBucket)
+		data := provers.Get(ek)
+		if data == nil {
+			return ErrProverNotFound
+		}
+		stored, err := decodeProver(data)
+		if err != nil {
+			return err
+		}
+		oldAK := stored.AK
+		if err = update(stored); err != nil {
+			return err
+		}
+		if stored.EK == nil || keyID(stored.EK.PublicKey()) != string(ek) {
+			return fmt.Errorf("the endorsement key of a prover cannot be changed")
+		}
+		if err = indexBoltAK(tx, ek, oldAK, stored.AK); err != nil {
+			return err
+		}
+		if data, err = encodeProver(stored); err != nil {
+			return err
+		}
+		return provers.Put(ek, data)
+	})
+}
+
+// indexBoltAK replaces old by ak in the AK index of the prover with EK ek.
+func indexBoltAK(tx *bolt.Tx, ek []byte, old, ak tpm.AttestationKey) error {
+	aks := tx.Bucket(aksBucket)
+	if ak != nil {
+		if owner := aks.Get([]byte(keyID(ak.PublicKey()))); owner != nil && string(owner) != string(ek) {
+			return fmt.Errorf("attestation key already set")
+		}
+	}
+	if old != nil {
+		if err := aks.Delete([]byte(keyID(old.PublicKey()))); err != nil {
+			return err
+		}
+	}
+	if ak != nil {
+		return aks.Put([]byte(keyID(ak.PublicKey())), ek)
+	}
+	return nil
+}
+
+func (s *BoltStore) GetByEK(k *rsa.PublicKey) (*Prover, error) {
+	var p *Prover
+	err := s.db.View(func(tx *bolt.Tx) error {
+		data := tx.Bucket(proversBucket).Get([]byte(keyID(k)))
+		if data == nil {
+			return ErrProverNotFound
+		}
+		var err error
+		p, err = decodeProver(data)
+		return err
+	})
+	return p, err
+}
+
+func (s *BoltStore) GetByAK(k *rsa.PublicKey) (*Prover, error) {
+	var p *Prover
+	err := s.db.View(func(tx *bolt.Tx) error {
+		ek := tx.Bucket(aksBucket).Get([]byte(keyID(k)))
+		if ek == nil {
+			return ErrProverNotFound
+		}
+		data := tx.Bucket(proversBucket).Get(ek)
+		if data == nil {
+			return ErrProverNotFound
+		}
+		var err error
+		p, err = decodeProver(data)
+		return err
+	})
+	return p, err
+}
+
+func (s *BoltStore) List() ([]*Prover, error) {
+	var provers []*Prover
+	err := s.db.View(func(tx *bolt.Tx) error {
+		return tx.Bucket(proversBucket).ForEach(func(k, data []byte) error {
+			p, err := decodeProver(data)
+			if err != nil {
+				return fmt.Errorf("prover %s: %v", k, err)
+			}
+			provers = append(provers, p)
+			return nil
+		})
+	})
+	return provers, err
+}
+
+func (s *BoltStore) AddAttestation(k *rsa.PublicKey, a *Attestation, retention HistoryConfig) error {
+	ek := []byte(keyID(k))
+	entry := *a
+	err := s.db.Update(func(tx *bolt.Tx) error {
+		if tx.Bucket(proversBucket).Get(ek) == nil {
+			return ErrProverNotFound
+		}
+		history, err := tx.Bucket(historyBucket).CreateBucketIfNotExists(ek)
+		if err != nil {
+			return err
+		}
+		if entry.ID, err = history.NextSequence(); err != nil {
+			return err
+		}
+		data, err := json.Marshal(entry)
+		if err != nil {
+			return fmt.Errorf("error encoding attestation: %v", err)
+		}
+		key := make([]byte, 16)
+		binary.BigEndian.PutUint64(key, uint64(entry.Time.UnixNano()))
+		binary.BigEndian.PutUint64(key[8:], entry.ID)
+		if err = history.Put(key, data); err != nil {
+			return err
+		}
+		var keys [][]byte
+		var times []time.Time
+		err = history.ForEach(func(k, _ []byte) error {
+			keys = append(keys, append([]byte(nil), k...))
+			times = append(times, time.Unix(0, int64(binary.BigEndian.Uint64(k))))
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+		for _, k := range keys[:retention.keep(times, entry.Time)] {
+			if err = history.Delete(k); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	a.ID = entry.ID
+	return nil
+}
+
+func (s *BoltStore) History(k *rsa.PublicKey) ([]Attestation, error) {
+	ek := []byte(keyID(k))
+	var attestations []Attestation
+	err := s.db.View(func(tx *bolt.Tx) error {
+		if tx.Bucket(proversBucket).Get(ek) == nil {
+			return ErrProverNotFound
+		}
+		history := tx.Bucket(historyBucket).Bucket(ek)
+		if history == nil {
+			return nil
+		}
+		return history.ForEach(func(_, data []byte) error {
+			var a Attestation
+			if err := json.Unmarshal(data, &a); err != nil {
+				return fmt.Errorf("error decoding attestation: %v", err)
+			}
+			attestations = append(attestations, a)
+			return nil
+		})
+	})
+	return attestations, err
+}
+
+func (s *BoltStore) Delete(k *rsa.PublicKey) error {
+	ek := []byte(keyID(k))
+	return s.db.Update(func(tx *bolt.Tx) error {
+		provers := tx.Bucket(proversBucket)
+		data := provers.Get(ek)
+		if data == nil {
+			return ErrProverNotFound
+		}
+		stored, err := decodeProver(data)
+		if err != nil {
+			return err
+		}
+		if err = indexBoltAK(tx, ek, stored.AK, nil); err != nil {
+			return err
+		}
+		if err = tx.Bucket(historyBucket).DeleteBucket(ek); err != nil && err != bolt.ErrBucketNotFound {
+			return err
+		}
+		return provers.Delete(ek)
+	})
+}
+
+func (s *BoltStore) Block(entry BlockedEK) error {
+	data, err := json.Marshal(entry)
+	if err != nil {
+		return fmt.Errorf("error encoding blocked EK: %v", err)
+	}
+	return s.db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket(blocklistBucket).Put([]byte(entry.ID), data)
+	})
+}
+
+func (s *BoltStore) Unblock(id string) error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		blocklist := tx.Bucket(blocklistBucket)
+		if blocklist.Get([]byte(id)) == nil {
+			return ErrEKNotBlocked
+		}
+		return blocklist.Delete([]byte(id))
+	})
+}
+
+func (s *BoltStore) Blocked(id string) (*BlockedEK, error) {
+	var entry *BlockedEK
+	err := s.db.View(func(tx *bolt.Tx) error {
+		data := tx.Bucket(blocklistBucket).Get([]byte(id))
+		if data == nil {
+			return nil
+		}
+		entry = &BlockedEK{}
+		if err := json.Unmarshal(data, entry); err != nil {
+			return fmt.Errorf("error decoding blocked EK: %v", err)
+		}
+		return nil
+	})
+	return entry, err
+}
+
+func (s *BoltStore) Blocklist() ([]BlockedEK, error) {
+	blocklist := []BlockedEK{}
+	err := s.db.View(func(tx *bolt.Tx) error {
+		return tx.Bucket(blocklistBucket).ForEach(func(_, data []byte) error {
+			var entry BlockedEK
+			if err := json.Unmarshal(data, &entry); err != nil {
+				return fmt.Errorf("error decoding blocked EK: %v", err)
+			}
+			blocklist = append(blocklist, entry)
+			return nil
+		})
+	})
+	return blocklist, err
+}
+
+func (s *BoltStore) AddAudit(entry AuditEntry) error {
+	data, err := json.Marshal(entry)
+	if err != nil {
+		return fmt.Errorf("error encoding audit entry: %v", err)
+	}
+	return s.db.Update(func(tx *bolt.Tx) error {
+		audit := tx.Bucket(auditBucket)
+		seq, err := audit.NextSequence()
+		if err != nil {
+			return err
+		}
+		return audit.Put(sequenceKey(seq), data)
+	})
+}
+
+func (s *BoltStore) Audit() ([]AuditEntry, error) {
+	var entries []AuditEntry
+	err := s.db.View(func(tx *bolt.Tx) error {
+		return tx.Bucket(auditBucket).ForEach(func(_, data []byte) error {
+			var entry AuditEntry
+			if err := json.Unmarshal(data, &entry); err != nil {
+				return fmt.Errorf("error decoding audit entry: %v", err)
+			}
+			entries = append(entries, entry)
+			return nil
+		})
+	})
+	return entries, err
+}
+
+func (s *BoltStore) AddDeadLetter(letter *DeadLetter) error {
+	entry := *letter
+	err := s.db.Update(func(tx *bolt.Tx) error {
+		letters := tx.Bucket(deadLettersBucket)
+		var err error
+		if entry.ID, err = letters.NextSequence(); err != nil {
+			return err
+		}
+		data, err := json.Marshal(entry)
+		if err != nil {
+			return fmt.Errorf("error encoding dead letter: %v", err)
+		}
+		return letters.Put(sequenceKey(entry.ID), data)
+	})
+	if err != nil {
+		return err
+	}
+	letter.ID = entry.ID
+	return nil
+}
+
+func (s *BoltStore) DeadLetters() ([]DeadLetter, error) {
+	var letters []DeadLetter
+	err := s.db.View(func(tx *bolt.Tx) error {
+		return tx.Bucket(deadLettersBucket).ForEach(func(_, data []byte) error {
+			var letter DeadLetter
+			if err := json.Unmarshal(data, &letter); err != nil {
+				return fmt.Errorf("error decoding dead letter: %v", err)
+			}
+			letters = append(letters, letter)
+			return nil
+		})
+	})
+	return letters, err
+}
+
+func (s *BoltStore) DeleteDeadLetter(id uint64) error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		letters := tx.Bucket(deadLettersBucket)
+		if letters.Get(sequenceKey(id)) == nil {
+			return ErrDeadLetterNotFound
+		}
+		return letters.Delete(sequenceKey(id))
+	})
+}
+
+func (s *BoltStore) AddEnrollmentToken(token EnrollmentToken) error {
+	data, err := json.Marshal(token)
+	if err != nil {
+		return fmt.Errorf("error encoding enrollment token: %v", err)
+	}
+	return s.db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket(enrollmentTokensBucket).Put([]byte(token.ID), data)
+	})
+}
+
+func (s *BoltStore) EnrollmentTokens() ([]EnrollmentToken, error) {
+	tokens := []EnrollmentToken{}
+	err := s.db.View(func(tx *bolt.Tx) error {
+		return tx.Bucket(enrollmentTokensBucket).ForEach(func(_, data []byte) error {
+			var token EnrollmentToken
+			if err := json.Unmarshal(data, &token); err != nil {
+				return fmt.Errorf("error decoding enrollment token: %v", err)
+			}
+			tokens = append(tokens, token)
+			return nil
+		})
+	})
+	return tokens, err
+}
+
+func (s *BoltStore) UpdateEnrollmentToken(id string, update func(t *EnrollmentToken) error) error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		tokens := tx.Bucket(enrollmentTokensBucket)
+		data := tokens.Get([]byte(id))
+		if data == nil {
+			return ErrEnrollmentTokenNotFound
+		}
+		var token EnrollmentToken
+		if err := json.Unmarshal(data, &token); err != nil {
+			return fmt.Errorf("error decoding enrollment token: %v", err)
+		}
+		if err := update(&token); err != nil {
+			return err
+		}
+		token.ID = id
+		data, err := json.Marshal(token)
+		if err != nil {
+			return fmt.Errorf("error encoding enrollment token: %v", err)
+		}
+		return tokens.Put([]byte(id), data)
+	})
+}
+
+func (s *BoltStore) DeleteEnrollmentToken(id string) error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		tokens := tx.Bucket(enrollmentTokensBucket)
+		if tokens.Get([]byte(id)) == nil {
+			return ErrEnrollmentTokenNotFound
+		}
+		return tokens.Delete([]byte(id))
+	})
+}
+
+// sequenceKey is the key of the entry with sequence number seq, the keys sort like the numbers.
+func sequenceKey(seq uint64) []byte {
+	key := make([]byte, 8)
+	binary.BigEndian.PutUint64(key, seq)
+	return key
+}
+
+func (s *BoltStore) Close() error {
+	return s.db.Close()
+}
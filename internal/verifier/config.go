@@ -1,13 +1,183 @@
 package verifier
 
-import "time"
+import (
+	"encoding/hex"
+	"fmt"
+	verifierDB "github.com/xcaliburne/RemoteAttestations/pkg/verifier"
+	"time"
+)
 
-type InitializationParams struct {
-	OwnerPassword string `yaml:"owner_password"`
-	UserPassword  string `yaml:"user_password"`
+// EnrollmentConfig sets how long the enrollment tokens created without TTL are valid, 24h when unset.
+type EnrollmentConfig struct {
+	TokenTTL time.Duration `yaml:"token_ttl"`
+}
+
+// PrivacyCAConfig enables the privacy CA when Certificate is set.
+type PrivacyCAConfig struct {
+	Certificate string        `yaml:"certificate"`
+	Key         string        `yaml:"key"`
+	Validity    time.Duration `yaml:"validity"`
+}
+
+// EKTrustConfig replaces the built-in EK certificate verification when Paths is set.
+// Paths are PEM bundles, DER certificates or directories of manufacturer roots and intermediates.
+// CRLFiles are loaded at startup, CRLURLs are fetched every CRLRefreshInterval along with CRLFiles.
+type EKTrustConfig struct {
+	Paths                []string      `yaml:"paths"`
+	AllowedManufacturers []string      `yaml:"allowed_manufacturers"`
+	MinKeySize           int           `yaml:"min_key_size"`
+	CRLFiles             []string      `yaml:"crl_files"`
+	CRLURLs              []string      `yaml:"crl_urls"`
+	CRLRefreshInterval   time.Duration `yaml:"crl_refresh_interval"`
+}
+
+// EventLogConfig is the policy the firmware event logs of the provers are appraised with.
+// BootApplications are the hex digests of the accepted EFI boot applications in the quoted bank.
+// RequiredPCRs are always quoted and must be measured by the event log, DefaultRequiredPCRs when empty.
+type EventLogConfig struct {
+	RequireSecureBoot bool     `yaml:"require_secure_boot"`
+	BootApplications  []string `yaml:"boot_applications"`
+	RequiredPCRs      []int    `yaml:"required_pcrs"`
+}
+
+// DefaultRequiredPCRs are the PCRs measured by the firmware and the boot loader.
+var DefaultRequiredPCRs = []int{0, 1, 2, 3, 4, 5, 6, 7}
+
+func (c EventLogConfig) requiredPCRs() []int {
+	if len(c.RequiredPCRs) == 0 {
+		return DefaultRequiredPCRs
+	}
+	return c.RequiredPCRs
+}
+
+// IMAConfig enables the verification of the IMA measurement lists of the provers against PCR 10.
+// Allowlist and Denylist are files in the sha256sum format, every file is accepted when the allowlist is not set.
+type IMAConfig struct {
+	Enabled   bool   `yaml:"enabled"`
+	Allowlist string `yaml:"allowlist"`
+	Denylist  string `yaml:"denylist"`
+}
+
+// IntervalGroup sets the attestation interval of the provers it selects.
+type IntervalGroup struct {
+	verifierDB.ProverSelector `yaml:",inline"`
+	Interval                  time.Duration `yaml:"interval"`
+}
+
+// SchedulerConfig tunes the Scheduler. Workers bounds the concurrent attestations, Jitter is the fraction of the
+// interval next runs are randomly moved by and MaxBackoff caps the delay before attesting an unreachable prover again.
+// Intervals replace the attestation interval of the provers of the first matching group.
+type SchedulerConfig struct {
+	Workers    int             `yaml:"workers"`
+	Jitter     float64         `yaml:"jitter"`
+	MaxBackoff time.Duration   `yaml:"max_backoff"`
+	Intervals  []IntervalGroup `yaml:"intervals"`
+}
+
+// HistoryConfig bounds the attestation history kept for each prover, to the last 100 attestations when empty.
+type HistoryConfig struct {
+	MaxEntries int           `yaml:"max_entries"`
+	MaxAge     time.Duration `yaml:"max_age"`
+}
+
+// WebhookConfig is an endpoint notified of the trust state changes of the provers. The payloads are signed with
+// HMAC-SHA256 keyed by Secret. Events are the states whose transitions are sent, every transition when empty.
+type WebhookConfig struct {
+	URL    string       `yaml:"url"`
+	Secret string       `yaml:"secret"`
+	Events []TrustState `yaml:"events"`
+}
+
+// WebhooksConfig configures the webhook deliveries. A delivery is attempted MaxAttempts times, the delay between
+// two attempts doubles from Backoff up to MaxBackoff. The deliveries still failing are kept as dead letters.
+type WebhooksConfig struct {
+	Endpoints   []WebhookConfig `yaml:"endpoints"`
+	Timeout     time.Duration   `yaml:"timeout"`
+	MaxAttempts int             `yaml:"max_attempts"`
+	Backoff     time.Duration   `yaml:"backoff"`
+	MaxBackoff  time.Duration   `yaml:"max_backoff"`
 }
 
 type Config struct {
-	Init                InitializationParams `yaml:"init"`
-	AttestationInterval time.Duration        `yaml:"attestation_interval"`
+	AttestationInterval time.Duration    `yaml:"attestation_interval"`
+	Enrollment          EnrollmentConfig `yaml:"enrollment"`
+	PrivacyCA           PrivacyCAConfig  `yaml:"privacy_ca"`
+	EKTrust             EKTrustConfig    `yaml:"ek_trust"`
+	EventLog            EventLogConfig   `yaml:"event_log"`
+	IMA                 IMAConfig        `yaml:"ima"`
+	Scheduler           SchedulerConfig  `yaml:"scheduler"`
+	History             HistoryConfig    `yaml:"history"`
+	// AttestTimeout bounds the wait for on-demand attestations, 10s when unset. It must stay below the 15s write timeout of the REST server.
+	AttestTimeout time.Duration  `yaml:"attest_timeout"`
+	Webhooks      WebhooksConfig `yaml:"webhooks"`
+	// PCRPolicy is a YAML or JSON policy file replacing the reference values of /pcrs.
+	PCRPolicy string `yaml:"pcr_policy"`
+	// RequireApproval keeps new registrations pending until an operator approves them, only approved provers are attested.
+	RequireApproval bool `yaml:"require_approval"`
+	// ProverStore is the bbolt database file the registered provers are kept in, they are kept in memory when empty.
+	ProverStore string `yaml:"prover_store"`
+}
+
+// LoadPrivacyCA returns the configured privacy CA, nil if it is disabled.
+func (c *Config) LoadPrivacyCA() (*verifierDB.PrivacyCA, error) {
+	if c.PrivacyCA.Certificate == "" {
+		return nil, nil
+	}
+	return verifierDB.LoadPrivacyCA(c.PrivacyCA.Certificate, c.PrivacyCA.Key, c.PrivacyCA.Validity)
+}
+
+// LoadEKTrustStore returns the configured EK trust store, nil if the built-in verification is used.
+func (c *Config) LoadEKTrustStore() (*verifierDB.EKTrustStore, error) {
+	if len(c.EKTrust.Paths) == 0 {
+		return nil, nil
+	}
+	policy := verifierDB.EKPolicy{AllowedManufacturers: c.EKTrust.AllowedManufacturers, MinKeySize: c.EKTrust.MinKeySize}
+	store, err := verifierDB.LoadEKTrustStore(c.EKTrust.Paths, policy)
+	if err != nil {
+		return nil, err
+	}
+	for _, file := range c.EKTrust.CRLFiles {
+		if err = store.LoadCRL(file); err != nil {
+			return nil, err
+		}
+	}
+	return store, nil
+}
+
+// LoadEventPolicy returns the configured event log policy.
+func (c *Config) LoadEventPolicy() (verifierDB.EventPolicy, error) {
+	policy := verifierDB.EventPolicy{RequireSecureBoot: c.EventLog.RequireSecureBoot}
+	for _, application := range c.EventLog.BootApplications {
+		digest, err := hex.DecodeString(application)
+		if err != nil {
+			return verifierDB.EventPolicy{}, fmt.Errorf("invalid boot application digest %v: %v", application, err)
+		}
+		policy.BootApplications = append(policy.BootApplications, digest)
+	}
+	return policy, nil
+}
+
+// LoadIMAPolicy returns the configured IMA policy, nil if IMA is not verified.
+func (c *Config) LoadIMAPolicy() (*verifierDB.IMAPolicy, error) {
+	if !c.IMA.Enabled {
+		return nil, nil
+	}
+	return verifierDB.LoadIMAPolicy(c.IMA.Allowlist, c.IMA.Denylist)
+}
+
+// LoadPCRPolicies returns the configured PCR policies, nil if the reference values of /pcrs are used.
+func (c *Config) LoadPCRPolicies() (verifierDB.PCRPolicies, error) {
+	if c.PCRPolicy == "" {
+		return nil, nil
+	}
+	return verifierDB.LoadPCRPolicies(c.PCRPolicy)
+}
+
+// OpenProverStore opens the configured prover store, it also keeps the blocklist, the audit log,
+// the dead letters and the enrollment tokens.
+func (c *Config) OpenProverStore() (Store, error) {
+	if c.ProverStore == "" {
+		return NewMemoryStore(), nil
+	}
+	return OpenBoltStore(c.ProverStore)
 }
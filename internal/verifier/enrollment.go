@@ -0,0 +1,174 @@
+package verifier
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	log "github.com/sirupsen/logrus"
+	"sort"
+	"time"
+)
+
+const (
+	defaultEnrollmentTokenTTL = 24 * time.Hour
+	// enrollmentTokenSize is the size of the random enrollment tokens, tpmPasswordSize the one of the TPM passwords.
+	enrollmentTokenSize = 32
+	tpmPasswordSize     = 24
+)
+
+var (
+	ErrEnrollmentTokenNotFound = errors.New("enrollment token not found")
+	// ErrInvalidEnrollmentToken is returned for unknown, used, expired and out of scope tokens alike.
+	ErrInvalidEnrollmentToken = errors.New("invalid enrollment token")
+)
+
+const (
+	AuditCreateToken AuditAction = "create_token"
+	AuditRevokeToken AuditAction = "revoke_token"
+	AuditEnroll      AuditAction = "enroll"
+)
+
+// EnrollmentToken is a single use token a device exchanges for its TPM credentials. Only the SHA-256 of the token
+// is kept, ID is its hex encoding. Used is set once the token is exchanged.
+type EnrollmentToken struct {
+	ID        string
+	Device    string
+	Created   time.Time
+	Expires   time.Time
+	CreatedBy string
+	Used      *time.Time `json:",omitempty"`
+}
+
+// EnrollmentRequest is an operator request for the enrollment token of Device, valid for TTL, the default TTL when 0.
+type EnrollmentRequest struct {
+	Device string
+	Actor  string
+	TTL    time.Duration
+}
+
+// DeviceCredentials are the TPM passwords generated for a device when it enrolls, they are not kept by the verifier.
+type DeviceCredentials struct {
+	OwnerPassword string
+	UserPassword  string
+}
+
+// enrollmentTokenID returns the ID of token.
+func enrollmentTokenID(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// randomString returns size random bytes, base64 encoded for URLs.
+func randomString(size int) (string, error) {
+	b := make([]byte, size)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+// CreateEnrollmentToken creates the enrollment token of request.Device. The token is only returned here.
+func (v *DataVerifier) CreateEnrollmentToken(request EnrollmentRequest) (string, *EnrollmentToken, error) {
+	if request.Device == "" || request.Actor == "" {
+		return "", nil, fmt.Errorf("device and actor are required")
+	}
+	ttl := request.TTL
+	if ttl <= 0 {
+		ttl = v.Config.Enrollment.TokenTTL
+	}
+	if ttl <= 0 {
+		ttl = defaultEnrollmentTokenTTL
+	}
+	token, err := randomString(enrollmentTokenSize)
+	if err != nil {
+		return "", nil, fmt.Errorf("error generating enrollment token: %v", err)
+	}
+	now := time.Now()
+	entry := EnrollmentToken{ID: enrollmentTokenID(token), Device: request.Device, Created: now, Expires: now.Add(ttl), CreatedBy: request.Actor}
+	if err = v.Tokens.AddEnrollmentToken(entry); err != nil {
+		return "", nil, fmt.Errorf("error storing enrollment token: %v", err)
+	}
+	log.Infof("enrollment token of %v created by %v, expires %v", request.Device, request.Actor, entry.Expires.Format(time.RFC3339))
+	v.audit(AuditEntry{Time: now, Actor: request.Actor, Action: AuditCreateToken, Name: request.Device, Reason: "expires " + entry.Expires.Format(time.RFC3339)})
+	return token, &entry, nil
+}
+
+// EnrollmentTokens returns the enrollment tokens, oldest first.
+func (v *DataVerifier) EnrollmentTokens() ([]EnrollmentToken, error) {
+	tokens, err := v.Tokens.EnrollmentTokens()
+	if err != nil {
+		return nil, fmt.Errorf("error reading enrollment tokens: %v", err)
+	}
+	if tokens == nil {
+		tokens = []EnrollmentToken{}
+	}
+	sort.Slice(tokens, func(i, j int) bool {
+		if !tokens[i].Created.Equal(tokens[j].Created) {
+			return tokens[i].Created.Before(tokens[j].Created)
+		}
+		return tokens[i].ID < tokens[j].ID
+	})
+	return tokens, nil
+}
+
+// RevokeEnrollmentToken deletes the enrollment token with ID id, it can no longer be exchanged.
+func (v *DataVerifier) RevokeEnrollmentToken(id, actor, reason string) error {
+	if actor == "" || reason == "" {
+		return ErrMissingActor
+	}
+	tokens, err := v.Tokens.EnrollmentTokens()
+	if err != nil {
+		return fmt.Errorf("error reading enrollment tokens: %v", err)
+	}
+	var device string
+	for _, t := range tokens {
+		if t.ID == id {
+			device = t.Device
+		}
+	}
+	if err = v.Tokens.DeleteEnrollmentToken(id); err != nil {
+		return err
+	}
+	log.Warnf("enrollment token of %v revoked by %v: %v", device, actor, reason)
+	v.audit(AuditEntry{Time: time.Now(), Actor: actor, Action: AuditRevokeToken, Name: device, Reason: reason})
+	return nil
+}
+
+// Enroll exchanges token for the TPM credentials of device. The token must be unused, unexpired and created for device.
+func (v *DataVerifier) Enroll(token, device string) (_ *DeviceCredentials, err error) {
+	defer func() { countRegistration("enroll", err) }()
+	// The credentials are generated first, a token is not used up by a failure past its check.
+	credentials := &DeviceCredentials{}
+	if credentials.OwnerPassword, err = randomString(tpmPasswordSize); err != nil {
+		return nil, fmt.Errorf("error generating TPM credentials: %v", err)
+	}
+	if credentials.UserPassword, err = randomString(tpmPasswordSize); err != nil {
+		return nil, fmt.Errorf("error generating TPM credentials: %v", err)
+	}
+	now := time.Now()
+	err = v.Tokens.UpdateEnrollmentToken(enrollmentTokenID(token), func(t *EnrollmentToken) error {
+		switch {
+		case t.Used != nil:
+			return fmt.Errorf("%w: used on %v", ErrInvalidEnrollmentToken, t.Used.Format(time.RFC3339))
+		case now.After(t.Expires):
+			return fmt.Errorf("%w: expired on %v", ErrInvalidEnrollmentToken, t.Expires.Format(time.RFC3339))
+		case t.Device != device:
+			return fmt.Errorf("%w: created for %v", ErrInvalidEnrollmentToken, t.Device)
+		}
+		t.Used = &now
+		return nil
+	})
+	if errors.Is(err, ErrEnrollmentTokenNotFound) {
+		err = fmt.Errorf("%w: unknown token", ErrInvalidEnrollmentToken)
+	}
+	if err != nil {
+		log.Warnf("enrollment of %v rejected: %v", device, err)
+		return nil, err
+	}
+	log.Infof("%v enrolled", device)
+	v.audit(AuditEntry{Time: now, Actor: device, Action: AuditEnroll, Name: device, Reason: "enrollment token exchanged"})
+	return credentials, nil
+}
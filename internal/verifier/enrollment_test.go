@@ -0,0 +1,89 @@
+package verifier
+
+import (
+	"errors"
+	"github.com/google/go-cmp/cmp"
+	"github.com/xcaliburne/RemoteAttestations/pkg/tests"
+	"testing"
+	"time"
+)
+
+func TestDataVerifier_Enroll(t *testing.T) {
+	v := NewVerifier(&Config{Enrollment: EnrollmentConfig{TokenTTL: time.Hour}})
+	if _, _, err := v.CreateEnrollmentToken(EnrollmentRequest{Device: "edge"}); err == nil {
+		t.Error(tests.Failure(t, err, "error", "token without actor"))
+	}
+	token, entry, err := v.CreateEnrollmentToken(EnrollmentRequest{Device: "edge", Actor: "alice"})
+	if err != nil {
+		t.Fatalf("CreateEnrollmentToken() returned an error: %v", err)
+	}
+	if entry.ID != enrollmentTokenID(token) || entry.Expires.Sub(entry.Created) != time.Hour {
+		t.Error(tests.Failure(t, entry, "token of edge valid for an hour", ""))
+	}
+	expired, _, err := v.CreateEnrollmentToken(EnrollmentRequest{Device: "gateway", Actor: "alice", TTL: time.Nanosecond})
+	if err != nil {
+		t.Fatalf("CreateEnrollmentToken() returned an error: %v", err)
+	}
+	revoked, revokedEntry, err := v.CreateEnrollmentToken(EnrollmentRequest{Device: "sensor", Actor: "alice"})
+	if err != nil {
+		t.Fatalf("CreateEnrollmentToken() returned an error: %v", err)
+	}
+	if err = v.RevokeEnrollmentToken(revokedEntry.ID, "bob", ""); !errors.Is(err, ErrMissingActor) {
+		t.Error(tests.Failure(t, err, ErrMissingActor, "revocation without reason"))
+	}
+	if err = v.RevokeEnrollmentToken(revokedEntry.ID, "bob", "sensor returned"); err != nil {
+		t.Fatalf("RevokeEnrollmentToken() returned an error: %v", err)
+	}
+	if err = v.RevokeEnrollmentToken(revokedEntry.ID, "bob", "sensor returned"); !errors.Is(err, ErrEnrollmentTokenNotFound) {
+		t.Error(tests.Failure(t, err, ErrEnrollmentTokenNotFound, "token revoked twice"))
+	}
+	time.Sleep(time.Millisecond)
+
+	var testSuite = []struct {
+		name    string
+		token   string
+		device  string
+		wantErr error
+	}{
+		{name: "Token of another device", token: token, device: "gateway", wantErr: ErrInvalidEnrollmentToken},
+		{name: "Correct use", token: token, device: "edge"},
+		{name: "Token used twice", token: token, device: "edge", wantErr: ErrInvalidEnrollmentToken},
+		{name: "Expired token", token: expired, device: "gateway", wantErr: ErrInvalidEnrollmentToken},
+		{name: "Revoked token", token: revoked, device: "sensor", wantErr: ErrInvalidEnrollmentToken},
+		{name: "Unknown token", token: "unknown", device: "edge", wantErr: ErrInvalidEnrollmentToken},
+	}
+	for _, test := range testSuite {
+		t.Run(test.name, func(t *testing.T) {
+			credentials, err := v.Enroll(test.token, test.device)
+			if !errors.Is(err, test.wantErr) {
+				t.Fatal(tests.Failure(t, err, test.wantErr, ""))
+			}
+			if err != nil {
+				return
+			}
+			if credentials.OwnerPassword == "" || credentials.UserPassword == "" || credentials.OwnerPassword == credentials.UserPassword {
+				t.Error(tests.Failure(t, credentials, "distinct random passwords", "credentials"))
+			}
+		})
+	}
+
+	tokens, err := v.EnrollmentTokens()
+	if err != nil || len(tokens) != 2 {
+		t.Fatal(tests.Failure(t, tokens, "tokens of edge and gateway", ""))
+	}
+	if tokens[0].Device != "edge" || tokens[0].Used == nil || tokens[1].Used != nil {
+		t.Error(tests.Failure(t, tokens, "used token of edge first", "enrollment tokens"))
+	}
+	entries, err := v.AuditLog()
+	if err != nil {
+		t.Fatalf("AuditLog() returned an error: %v", err)
+	}
+	var actions []AuditAction
+	for _, e := range entries {
+		actions = append(actions, e.Action)
+	}
+	wantActions := []AuditAction{AuditCreateToken, AuditCreateToken, AuditCreateToken, AuditRevokeToken, AuditEnroll}
+	if !cmp.Equal(actions, wantActions) {
+		t.Error(tests.Failure(t, actions, wantActions, "audit log"))
+	}
+}
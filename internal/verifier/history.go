@@ -0,0 +1,121 @@
+package verifier
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	log "github.com/sirupsen/logrus"
+	"time"
+)
+
+// TrustState is the trust the verifier puts in a prover.
+type TrustState string
+
+const (
+	// StateRegistered is a prover whose EK is registered and that was not attested yet.
+	StateRegistered TrustState = "registered"
+	// StateTrusted is a prover whose last attestation passed.
+	StateTrusted TrustState = "trusted"
+	// StateUntrusted is a prover whose last attestation failed.
+	StateUntrusted TrustState = "untrusted"
+	// StateUnreachable is a prover that could not be attested.
+	StateUnreachable TrustState = "unreachable"
+	// StateRevoked is a prover whose EK certificate is revoked, it is no longer attested.
+	StateRevoked TrustState = "revoked"
+	// StatePending is a prover whose registration waits for the approval of an operator, it is not attested yet.
+	StatePending TrustState = "pending"
+)
+
+// ErrInvalidTransition is returned when an operator action would move a prover to a state its state cannot move to.
+var ErrInvalidTransition = errors.New("trust state transition not allowed")
+
+// transitions are the states each state can move to.
+var transitions = map[TrustState][]TrustState{
+	StateRegistered: {StateTrusted, StateUntrusted, StateUnreachable, StateRevoked},
+	// Provers whose AK is dropped are registered again.
+	StateTrusted:     {StateRegistered, StateUntrusted, StateUnreachable, StateRevoked},
+	StateUntrusted:   {StateRegistered, StateTrusted, StateUnreachable, StateRevoked},
+	StateUnreachable: {StateRegistered, StateTrusted, StateUntrusted, StateRevoked},
+	// A revoked prover only leaves the state when the revocation is withdrawn from the CRL.
+	StateRevoked: {StateRegistered, StatePending},
+	// A pending prover is registered once approved, a rejected one is removed.
+	StatePending: {StateRegistered, StateRevoked},
+}
+
+// Valid tells whether s is one of the trust states.
+func (s TrustState) Valid() bool {
+	_, ok := transitions[s]
+	return ok
+}
+
+// CanMoveTo tells whether the state machine allows moving from s to state.
+func (s TrustState) CanMoveTo(state TrustState) bool {
+	for _, to := range transitions[s] {
+		if to == state {
+			return true
+		}
+	}
+	return false
+}
+
+// setState moves p to state for reason. It returns false and leaves p unchanged if the transition is not allowed,
+// the caller then skips the side effects of the change. Provers stored without a state are registered,
+// new provers start pending when their registration needs approval.
+func (p *Prover) setState(state TrustState, reason string, at time.Time) bool {
+	from := p.State
+	if from == "" {
+		from = StateRegistered
+	}
+	if from != state && !from.CanMoveTo(state) && !(p.State == "" && state == StatePending) {
+		log.Warnf("%v(%v:%v): %v -> %v not allowed, staying %v: %v", p.Name, p.Endpoint, p.Port, from, state, from, reason)
+		return false
+	}
+	if p.State != state {
+		if p.State != "" {
+			log.Infof("%v(%v:%v): %v -> %v: %v", p.Name, p.Endpoint, p.Port, p.State, state, reason)
+		}
+		p.StateSince = at
+	}
+	p.State, p.StateReason = state, reason
+	return true
+}
+
+// Attestation is an entry of the attestation history of a prover.
+type Attestation struct {
+	// ID identifies the attestation in the history of the prover.
+	ID    uint64
+	Time  time.Time
+	Nonce []byte
+	// Quote is the JSON quote returned by the prover, it is empty when the prover could not be reached.
+	Quote json.RawMessage `json:",omitempty"`
+	// State is the state the attestation moved the prover to and Reason why.
+	State  TrustState
+	Reason string
+	// Failures are the failed checks and rules, the prover is untrusted when there is any.
+	Failures []string `json:",omitempty"`
+}
+
+// fail logs a failed check of the attestation of p and records it.
+func (a *Attestation) fail(p *Prover, format string, args ...interface{}) {
+	reason := fmt.Sprintf(format, args...)
+	a.Failures = append(a.Failures, reason)
+	log.Errorf("%v(%v:%v): %v", p.Name, p.Endpoint, p.Port, reason)
+}
+
+const defaultHistoryEntries = 100
+
+// keep returns the index of the first entry of history to keep at now, history is sorted oldest first.
+func (c HistoryConfig) keep(history []time.Time, now time.Time) int {
+	maxEntries := c.MaxEntries
+	if maxEntries <= 0 && c.MaxAge <= 0 {
+		maxEntries = defaultHistoryEntries
+	}
+	first := 0
+	if maxEntries > 0 && len(history) > maxEntries {
+		first = len(history) - maxEntries
+	}
+	for c.MaxAge > 0 && first < len(history) && now.Sub(history[first]) > c.MaxAge {
+		first++
+	}
+	return first
+}
@@ -0,0 +1,74 @@
+package verifier
+
+import (
+	"github.com/xcaliburne/RemoteAttestations/pkg/tests"
+	"testing"
+	"time"
+)
+
+func TestProver_setState(t *testing.T) {
+	var testSuite = []struct {
+		name      string
+		from      TrustState
+		to        TrustState
+		wantMoved bool
+		wantState TrustState
+	}{
+		{name: "Stored without state", from: "", to: StateTrusted, wantMoved: true, wantState: StateTrusted},
+		{name: "Trusted to untrusted", from: StateTrusted, to: StateUntrusted, wantMoved: true, wantState: StateUntrusted},
+		{name: "Unreachable to trusted", from: StateUnreachable, to: StateTrusted, wantMoved: true, wantState: StateTrusted},
+		{name: "Same state", from: StateTrusted, to: StateTrusted, wantMoved: true, wantState: StateTrusted},
+		{name: "Revoked to trusted", from: StateRevoked, to: StateTrusted, wantMoved: false, wantState: StateRevoked},
+		{name: "Revocation withdrawn", from: StateRevoked, to: StateRegistered, wantMoved: true, wantState: StateRegistered},
+		{name: "AK dropped", from: StateTrusted, to: StateRegistered, wantMoved: true, wantState: StateRegistered},
+		{name: "Revoked to untrusted", from: StateRevoked, to: StateUntrusted, wantMoved: false, wantState: StateRevoked},
+		{name: "Registration pending", from: "", to: StatePending, wantMoved: true, wantState: StatePending},
+		{name: "Registration approved", from: StatePending, to: StateRegistered, wantMoved: true, wantState: StateRegistered},
+		{name: "Pending to trusted", from: StatePending, to: StateTrusted, wantMoved: false, wantState: StatePending},
+		{name: "Registered to pending", from: StateRegistered, to: StatePending, wantMoved: false, wantState: StateRegistered},
+	}
+	since := time.Unix(1000, 0)
+	for _, test := range testSuite {
+		t.Run(test.name, func(t *testing.T) {
+			p := &Prover{Name: "prover", State: test.from, StateSince: since}
+			at := since.Add(time.Minute)
+			if got := p.setState(test.to, "reason", at); got != test.wantMoved {
+				t.Error(tests.Failure(t, got, test.wantMoved, ""))
+			}
+			if p.State != test.wantState {
+				t.Error(tests.Failure(t, p.State, test.wantState, "state"))
+			}
+			wantSince := since
+			if test.wantState != test.from {
+				wantSince = at
+			}
+			if !p.StateSince.Equal(wantSince) {
+				t.Error(tests.Failure(t, p.StateSince, wantSince, "state since"))
+			}
+		})
+	}
+}
+
+func TestHistoryConfig_keep(t *testing.T) {
+	now := time.Unix(10000, 0)
+	history := []time.Time{now.Add(-3 * time.Hour), now.Add(-2 * time.Hour), now.Add(-time.Hour), now}
+	var testSuite = []struct {
+		name   string
+		config HistoryConfig
+		want   int
+	}{
+		{name: "Default", config: HistoryConfig{}, want: 0},
+		{name: "Max entries", config: HistoryConfig{MaxEntries: 2}, want: 2},
+		{name: "Max age", config: HistoryConfig{MaxAge: 90 * time.Minute}, want: 2},
+		{name: "Max age before max entries", config: HistoryConfig{MaxEntries: 3, MaxAge: 90 * time.Minute}, want: 2},
+		{name: "Max entries before max age", config: HistoryConfig{MaxEntries: 1, MaxAge: 150 * time.Minute}, want: 3},
+		{name: "More entries than kept", config: HistoryConfig{MaxEntries: 10}, want: 0},
+	}
+	for _, test := range testSuite {
+		t.Run(test.name, func(t *testing.T) {
+			if got := test.config.keep(history, now); got != test.want {
+				t.Error(tests.Failure(t, got, test.want, ""))
+			}
+		})
+	}
+}
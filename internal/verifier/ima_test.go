@@ -0,0 +1,83 @@
+package verifier
+
+import (
+	"bytes"
+	"encoding/json"
+	"github.com/xcaliburne/RemoteAttestations/pkg/httpClient"
+	"github.com/xcaliburne/RemoteAttestations/pkg/httpClient/tests/mocks"
+	"github.com/xcaliburne/RemoteAttestations/pkg/ima"
+	"github.com/xcaliburne/RemoteAttestations/pkg/tests"
+	"github.com/xcaliburne/RemoteAttestations/pkg/tpm"
+	tpmMocks "github.com/xcaliburne/RemoteAttestations/pkg/tpm/tests/mocks"
+	verifierDB "github.com/xcaliburne/RemoteAttestations/pkg/verifier"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+// imaEntries returns boot aggregate entries of the sha1 bank with the template hashes filled with ids, and the PCR they extend.
+func imaEntries(t *testing.T, ids ...byte) ([]ima.Entry, []byte) {
+	value := make([]byte, tpm.SHA1.Size())
+	var entries []ima.Entry
+	for _, id := range ids {
+		entry := ima.Entry{PCR: ima.PCR, TemplateHash: bytes.Repeat([]byte{id}, len(value)), TemplateName: "ima-ng", Path: ima.BootAggregate}
+		var err error
+		if value, err = entry.Extend(value, tpm.SHA1); err != nil {
+			t.Fatalf("Extend() returned an error: %v", err)
+		}
+		entries = append(entries, entry)
+	}
+	return entries, value
+}
+
+func TestDataVerifier_attestIMA(t *testing.T) {
+	v := NewVerifier(&Config{})
+	v.IMAPolicy = &verifierDB.IMAPolicy{}
+	_, verified := imaEntries(t, 1, 2, 3)
+	extended, extendedPCR := imaEntries(t, 1, 2, 3, 4)
+	rebooted, rebootedPCR := imaEntries(t, 5)
+	rebootedLonger, rebootedLongerPCR := imaEntries(t, 5, 6, 7, 8, 9)
+
+	var testSuite = []struct {
+		name       string
+		list       []ima.Entry
+		quoted     []byte
+		wantOffset int
+	}{
+		{name: "New entries", list: extended, quoted: extendedPCR, wantOffset: 4},
+		{name: "Reboot with a shorter list", list: rebooted, quoted: rebootedPCR, wantOffset: 1},
+		{name: "Reboot with a longer list", list: rebootedLonger, quoted: rebootedLongerPCR, wantOffset: 5},
+	}
+	for _, test := range testSuite {
+		t.Run(test.name, func(t *testing.T) {
+			httpClient.Client = &mocks.MockHttpClient{CatchGet: func(url string) (*http.Response, error) {
+				offset := 0
+				if !strings.HasSuffix(url, "/ima?offset=0") {
+					offset = 3
+				}
+				if offset > len(test.list) {
+					return &http.Response{StatusCode: http.StatusRequestedRangeNotSatisfiable, Status: "416 Requested Range Not Satisfiable", Body: ioutil.NopCloser(bytes.NewReader(nil))}, nil
+				}
+				body, err := json.Marshal(test.list[offset:])
+				if err != nil {
+					return nil, err
+				}
+				return &http.Response{StatusCode: http.StatusOK, Status: "200 OK", Body: ioutil.NopCloser(bytes.NewReader(body))}, nil
+			}}
+			quote := &tpmMocks.MockQuote{
+				CatchSelection: func() tpm.PCRSelection { return tpm.PCRSelection{Bank: tpm.SHA1, PCRs: []int{ima.PCR}} },
+				CatchPCRValues: func() []tpm.PCR { return []tpm.PCR{{Id: ima.PCR, Bank: tpm.SHA1, Value: test.quoted}} },
+			}
+			p := &Prover{Name: "edge", IMA: IMAState{Bank: tpm.SHA1, Offset: 3, PCR: verified}}
+			record := &Attestation{}
+			v.attestIMA(p, "http://10.42.0.7:8080", quote, record)
+			if len(record.Failures) != 0 {
+				t.Error(tests.Failure(t, record.Failures, nil, "failures"))
+			}
+			if p.IMA.Offset != test.wantOffset {
+				t.Error(tests.Failure(t, p.IMA.Offset, test.wantOffset, "verified entries"))
+			}
+		})
+	}
+}
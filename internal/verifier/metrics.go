@@ -0,0 +1,30 @@
+package verifier
+
+import (
+	"github.com/xcaliburne/RemoteAttestations/pkg/metrics"
+)
+
+// Metrics are the metrics of the verifier, served on /metrics.
+var Metrics = metrics.NewRegistry()
+
+var (
+	attestationsTotal = Metrics.NewCounter("verifier_attestations_total",
+		"Attestations of provers by result: trusted, untrusted or unreachable.", "result")
+	lastSuccess = Metrics.NewGauge("verifier_prover_last_success_timestamp_seconds",
+		"Unix time of the last attestation a prover passed.", "prover", "name")
+	quoteVerificationSeconds = Metrics.NewHistogram("verifier_quote_verification_seconds",
+		"Time spent verifying the signature and nonce of quotes.", nil)
+	registrationsTotal = Metrics.NewCounter("verifier_registrations_total",
+		"Registration requests by step, enroll, ek, ak or activate, and result, accepted or rejected.", "step", "result")
+	schedulerQueueDepth = Metrics.NewGauge("verifier_scheduler_queue_depth",
+		"Provers due for attestation waiting for a scheduler worker.")
+)
+
+// countRegistration counts a registration request of step, rejected when err is set.
+func countRegistration(step string, err error) {
+	result := "accepted"
+	if err != nil {
+		result = "rejected"
+	}
+	registrationsTotal.Inc(step, result)
+}
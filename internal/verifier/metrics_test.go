@@ -0,0 +1,75 @@
+package verifier
+
+import (
+	"fmt"
+	"github.com/xcaliburne/RemoteAttestations/pkg/httpClient"
+	"github.com/xcaliburne/RemoteAttestations/pkg/httpClient/tests/mocks"
+	"github.com/xcaliburne/RemoteAttestations/pkg/tests"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestMetrics(t *testing.T) {
+	v := NewVerifier(&Config{})
+	p := activatedProver(t, v, "edge", nil)
+	id := Fingerprint(p.EK.PublicKey())
+	unreachable, rejected := attestationsTotal.Value("unreachable"), registrationsTotal.Value("ek", "rejected")
+
+	httpClient.Client = &mocks.MockHttpClient{CatchGet: func(url string) (*http.Response, error) {
+		return nil, fmt.Errorf("unreachable")
+	}}
+	if _, err := v.attestProver(p); err == nil {
+		t.Fatal(tests.Failure(t, err, "unreachable", "attestProver() error"))
+	}
+	if got := attestationsTotal.Value("unreachable") - unreachable; got != 1 {
+		t.Error(tests.Failure(t, got, 1, "unreachable attestations"))
+	}
+	if err := v.RegisterNewEK(&Prover{Name: "without EK"}); err == nil {
+		t.Fatal(tests.Failure(t, err, "endorsement key not set", "RegisterNewEK() error"))
+	}
+	if got := registrationsTotal.Value("ek", "rejected") - rejected; got != 1 {
+		t.Error(tests.Failure(t, got, 1, "rejected EK registrations"))
+	}
+
+	now := time.Now()
+	if err := v.storeAttestation(p, &Attestation{Time: now, State: StateTrusted, Reason: "attestation passed"}); err != nil {
+		t.Fatalf("storeAttestation() returned an error: %v", err)
+	}
+	if got := lastSuccess.Value(id, "edge"); got != float64(now.Unix()) {
+		t.Error(tests.Failure(t, got, now.Unix(), "last success"))
+	}
+	w := httptest.NewRecorder()
+	Metrics.ServeHTTP(w, httptest.NewRequest("GET", "/metrics", nil))
+	if want := fmt.Sprintf(`verifier_prover_last_success_timestamp_seconds{prover="%v",name="edge"} %v`, id, float64(now.Unix())); !strings.Contains(w.Body.String(), want) {
+		t.Error(tests.Failure(t, w.Body.String(), want, "metrics"))
+	}
+	// A prover revoked during the attestation stays revoked, its trusted attestation is not a success.
+	err := v.Provers.Update(p.EK.PublicKey(), func(stored *Prover) error {
+		stored.Revoked = true
+		stored.setState(StateRevoked, "EK certificate revoked", now)
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("Update() returned an error: %v", err)
+	}
+	if err = v.storeAttestation(p, &Attestation{Time: now.Add(time.Minute), State: StateTrusted, Reason: "attestation passed"}); err != nil {
+		t.Fatalf("storeAttestation() returned an error: %v", err)
+	}
+	if got := lastSuccess.Value(id, "edge"); got != float64(now.Unix()) {
+		t.Error(tests.Failure(t, got, now.Unix(), "last success after a rejected transition"))
+	}
+	if status, _ := v.GetProver(id); status.State != StateRevoked {
+		t.Error(tests.Failure(t, status.State, StateRevoked, "state after a rejected transition"))
+	}
+	if err := v.Deregister(id, Deregistration{Actor: "alice", Reason: "retired"}); err != nil {
+		t.Fatalf("Deregister() returned an error: %v", err)
+	}
+	w = httptest.NewRecorder()
+	Metrics.ServeHTTP(w, httptest.NewRequest("GET", "/metrics", nil))
+	if strings.Contains(w.Body.String(), id) {
+		t.Error(tests.Failure(t, w.Body.String(), "no series of "+id, "metrics after deregistration"))
+	}
+}
@@ -0,0 +1,124 @@
+package verifier
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// defaultAttestTimeout is below the write timeout of the REST server.
+const defaultAttestTimeout = 10 * time.Second
+
+var (
+	ErrProverRevoked  = errors.New("EK certificate revoked")
+	ErrAKNotActivated = errors.New("attestation key not activated")
+)
+
+// AttestationResult is the result of the on-demand attestation of a prover, Error tells why it is missing.
+type AttestationResult struct {
+	ID          string
+	Name        string
+	Attestation *Attestation `json:",omitempty"`
+	Error       string       `json:",omitempty"`
+}
+
+// AttestProver attests the prover with ID id right away and returns the attestation stored in its history.
+// It stops waiting after the attest timeout or once ctx is done, the attestation then completes in the background.
+func (v *DataVerifier) AttestProver(ctx context.Context, id string) (*Attestation, error) {
+	p, err := v.proverByID(id)
+	if err != nil {
+		return nil, err
+	}
+	ctx, cancel := context.WithTimeout(ctx, v.attestTimeout())
+	defer cancel()
+	return v.attestNow(ctx, p)
+}
+
+// AttestProvers attests the provers selected by filter right away, as many at once as the scheduler workers.
+// The results are sorted like ListProvers, the provers not attested before the attest timeout have an error.
+func (v *DataVerifier) AttestProvers(ctx context.Context, filter ProverFilter) ([]AttestationResult, error) {
+	statuses, err := v.ListProvers(filter)
+	if err != nil {
+		return nil, err
+	}
+	ctx, cancel := context.WithTimeout(ctx, v.attestTimeout())
+	defer cancel()
+	workers := v.Config.Scheduler.Workers
+	if workers <= 0 {
+		workers = defaultWorkers
+	}
+	slots := make(chan struct{}, workers)
+	results := make([]AttestationResult, len(statuses))
+	var wg sync.WaitGroup
+	for i, status := range statuses {
+		results[i] = AttestationResult{ID: status.ID, Name: status.Name}
+		wg.Add(1)
+		go func(result *AttestationResult) {
+			defer wg.Done()
+			select {
+			case slots <- struct{}{}:
+				defer func() { <-slots }()
+			case <-ctx.Done():
+				result.Error = fmt.Sprintf("not attested: %v", ctx.Err())
+				return
+			}
+			p, err := v.proverByID(result.ID)
+			if err == nil {
+				result.Attestation, err = v.attestNow(ctx, p)
+			}
+			if err != nil {
+				result.Error = err.Error()
+			}
+		}(&results[i])
+	}
+	wg.Wait()
+	return results, nil
+}
+
+// attestable tells why p cannot be attested, nil if it can.
+func attestable(p *Prover) error {
+	switch {
+	case p.AK == nil:
+		return ErrAKNotActivated
+	case p.Revoked:
+		return ErrProverRevoked
+	case p.Pending:
+		return ErrRegistrationPending
+	}
+	return nil
+}
+
+// attestNow attests p and waits for the result until ctx is done.
+func (v *DataVerifier) attestNow(ctx context.Context, p *Prover) (*Attestation, error) {
+	if err := attestable(p); err != nil {
+		return nil, err
+	}
+	type result struct {
+		record *Attestation
+		err    error
+	}
+	done := make(chan result, 1)
+	go func() {
+		record, err := v.attestProver(p)
+		done <- result{record, err}
+	}()
+	select {
+	case r := <-done:
+		// An unreachable prover has an attestation, only the provers not attested have none.
+		if r.record == nil {
+			return nil, r.err
+		}
+		return r.record, nil
+	case <-ctx.Done():
+		return nil, fmt.Errorf("attestation of %v still running: %w", p.Name, ctx.Err())
+	}
+}
+
+func (v *DataVerifier) attestTimeout() time.Duration {
+	if v.Config.AttestTimeout > 0 {
+		return v.Config.AttestTimeout
+	}
+	return defaultAttestTimeout
+}
@@ -0,0 +1,92 @@
+package verifier
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/xcaliburne/RemoteAttestations/pkg/httpClient"
+	"github.com/xcaliburne/RemoteAttestations/pkg/httpClient/tests/mocks"
+	"github.com/xcaliburne/RemoteAttestations/pkg/tests"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestDataVerifier_AttestProver(t *testing.T) {
+	v := NewVerifier(&Config{AttestTimeout: time.Second})
+	edge := activatedProver(t, v, "edge", map[string]string{"role": "edge"})
+	slow := activatedProver(t, v, "slow", map[string]string{"role": "edge"})
+	revoked := activatedProver(t, v, "revoked", nil)
+	registered := activatedProver(t, v, "registered", nil)
+	err := v.Provers.Update(revoked.EK.PublicKey(), func(p *Prover) error {
+		p.Revoked = true
+		return nil
+	})
+	if err == nil {
+		err = v.Provers.Update(registered.EK.PublicKey(), func(p *Prover) error {
+			p.AK = nil
+			return nil
+		})
+	}
+	if err != nil {
+		t.Fatalf("Update() returned an error: %v", err)
+	}
+	release := make(chan struct{})
+	defer close(release)
+	httpClient.Client = &mocks.MockHttpClient{CatchGet: func(url string) (*http.Response, error) {
+		if strings.HasPrefix(url, "http://127.0.0.2:") {
+			<-release
+		}
+		return nil, fmt.Errorf("unreachable")
+	}}
+	err = v.Provers.Update(slow.EK.PublicKey(), func(p *Prover) error {
+		p.Endpoint = "127.0.0.2"
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("Update() returned an error: %v", err)
+	}
+
+	var testSuite = []struct {
+		name      string
+		prover    *Prover
+		wantState TrustState
+		wantErr   error
+	}{
+		{name: "Unreachable", prover: edge, wantState: StateUnreachable},
+		{name: "Timeout", prover: slow, wantErr: context.DeadlineExceeded},
+		{name: "Revoked", prover: revoked, wantErr: ErrProverRevoked},
+		{name: "AK not activated", prover: registered, wantErr: ErrAKNotActivated},
+	}
+	for _, test := range testSuite {
+		t.Run(test.name, func(t *testing.T) {
+			id := Fingerprint(test.prover.EK.PublicKey())
+			got, err := v.AttestProver(context.Background(), id)
+			if !errors.Is(err, test.wantErr) {
+				t.Fatal(tests.Failure(t, err, test.wantErr, ""))
+			}
+			if err != nil {
+				return
+			}
+			if got.State != test.wantState || got.Reason != "unreachable" {
+				t.Error(tests.Failure(t, got, test.wantState, "attestation"))
+			}
+			history, _ := v.ProverHistory(id, 0, 0)
+			if history.Total != 1 || history.Attestations[0].ID != got.ID {
+				t.Error(tests.Failure(t, history, got, "attestation stored in the history"))
+			}
+		})
+	}
+	if _, err = v.AttestProver(context.Background(), "ffff"); !errors.Is(err, ErrProverNotFound) {
+		t.Error(tests.Failure(t, err, ErrProverNotFound, "unknown prover"))
+	}
+
+	results, err := v.AttestProvers(context.Background(), ProverFilter{Labels: map[string]string{"role": "edge"}})
+	if err != nil {
+		t.Fatalf("AttestProvers() returned an error: %v", err)
+	}
+	if len(results) != 2 || results[0].Name != "edge" || results[0].Attestation == nil || results[1].Error == "" {
+		t.Error(tests.Failure(t, results, "edge attested and slow timed out", ""))
+	}
+}
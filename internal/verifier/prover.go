@@ -1,6 +1,10 @@
 package verifier
 
-import "github.com/xcaliburne/RemoteAttestations/pkg/tpm"
+import (
+	"github.com/xcaliburne/RemoteAttestations/pkg/tpm"
+	verifierDB "github.com/xcaliburne/RemoteAttestations/pkg/verifier"
+	"time"
+)
 
 type Prover struct {
 	Name     string
@@ -8,4 +12,35 @@ type Prover struct {
 	Port     string
 	EK       tpm.EndorsementKey
 	AK       tpm.AttestationKey
+	// Labels select the PCR policy of the prover along with its name, only operators set them.
+	Labels map[string]string
+	// ClaimedLabels are the labels the prover declared at registration, they become its labels once an operator
+	// approves the registration.
+	ClaimedLabels map[string]string `json:",omitempty"`
+	// State is the trust state of the prover since StateSince, StateReason tells why it is in it.
+	State       TrustState
+	StateSince  time.Time
+	StateReason string
+	// Revoked is set when the EK certificate is found revoked after the registration.
+	Revoked bool
+	// Pending is set while the registration waits for the approval of an operator, the prover is not attested until then.
+	Pending bool `json:",omitempty"`
+	// PCRMismatches are the PCRs that differed from their reference values at the last attestation.
+	PCRMismatches []tpm.PCRMismatch
+	// PCRPolicy is the name of the PCR policy applied at the last attestation and PCRRules the result of its rules.
+	PCRPolicy string
+	PCRRules  []verifierDB.PCRRuleResult
+	// LastPCRs are the values quoted at the last attestation that passed the PCR policy.
+	LastPCRs []tpm.PCR
+	// IMA is the part of the IMA measurement list already verified.
+	IMA IMAState
+}
+
+// IMAState is the IMA measurement list verified up to Offset entries, which extend PCR 10 of Bank to PCR.
+// Findings are the files rejected by the IMA policy since the list started.
+type IMAState struct {
+	Offset   int
+	Bank     tpm.Bank
+	PCR      []byte
+	Findings []verifierDB.IMAFinding
 }
@@ -0,0 +1,196 @@
+package verifier
+
+import (
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/hex"
+	"fmt"
+	"github.com/xcaliburne/RemoteAttestations/pkg/tpm"
+	verifierDB "github.com/xcaliburne/RemoteAttestations/pkg/verifier"
+	"sort"
+	"time"
+)
+
+const (
+	defaultHistoryPage = 20
+	maxHistoryPage     = 100
+)
+
+// Fingerprint is the hex SHA-256 digest of the PKCS #1 encoding of k, the ID of a prover is the fingerprint of its EK.
+func Fingerprint(k *rsa.PublicKey) string {
+	digest := sha256.Sum256(x509.MarshalPKCS1PublicKey(k))
+	return hex.EncodeToString(digest[:])
+}
+
+// ProverFilter selects the provers in State carrying all of Labels, the empty filter selects every prover.
+type ProverFilter struct {
+	State  TrustState
+	Labels map[string]string
+}
+
+func (f ProverFilter) matches(p *Prover) bool {
+	state := p.State
+	if state == "" {
+		state = StateRegistered
+	}
+	if f.State != "" && f.State != state {
+		return false
+	}
+	return verifierDB.ProverSelector{Labels: f.Labels}.Matches(p.Name, p.Labels)
+}
+
+// ProverStatus is a prover as served by the query API.
+type ProverStatus struct {
+	// ID is the fingerprint of the EK, AK the fingerprint of the activated AK. ClaimedLabels are the labels declared
+	// at registration, they are not used until an operator approves them.
+	ID            string
+	Name          string
+	Endpoint      string
+	Port          string
+	Labels        map[string]string `json:",omitempty"`
+	ClaimedLabels map[string]string `json:",omitempty"`
+	AK            string            `json:",omitempty"`
+	State         TrustState
+	StateSince    time.Time
+	StateReason   string
+	Revoked       bool
+	Pending       bool `json:",omitempty"`
+	// The results of the last attestation.
+	PCRPolicy     string                     `json:",omitempty"`
+	PCRRules      []verifierDB.PCRRuleResult `json:",omitempty"`
+	PCRMismatches []tpm.PCRMismatch          `json:",omitempty"`
+	IMAEntries    int                        `json:",omitempty"`
+	IMAFindings   []verifierDB.IMAFinding    `json:",omitempty"`
+}
+
+func newProverStatus(p *Prover) ProverStatus {
+	status := ProverStatus{
+		ID:            Fingerprint(p.EK.PublicKey()),
+		Name:          p.Name,
+		Endpoint:      p.Endpoint,
+		Port:          p.Port,
+		Labels:        p.Labels,
+		ClaimedLabels: p.ClaimedLabels,
+		State:         p.State,
+		StateSince:    p.StateSince,
+		StateReason:   p.StateReason,
+		Revoked:       p.Revoked,
+		Pending:       p.Pending,
+		PCRPolicy:     p.PCRPolicy,
+		PCRRules:      p.PCRRules,
+		PCRMismatches: p.PCRMismatches,
+		IMAEntries:    p.IMA.Offset,
+		IMAFindings:   p.IMA.Findings,
+	}
+	if status.State == "" {
+		status.State = StateRegistered
+	}
+	if p.AK != nil {
+		status.AK = Fingerprint(p.AK.PublicKey())
+	}
+	return status
+}
+
+// HistoryPage is a page of the attestation history of a prover, newest first.
+// Total is the number of attestations in the history, the quotes are left out.
+type HistoryPage struct {
+	Total        int
+	Offset       int
+	Attestations []Attestation
+}
+
+// ListProvers returns the provers selected by filter, sorted by name.
+func (v *DataVerifier) ListProvers(filter ProverFilter) ([]ProverStatus, error) {
+	if filter.State != "" && !filter.State.Valid() {
+		return nil, fmt.Errorf("unknown trust state %q", filter.State)
+	}
+	provers, err := v.Provers.List()
+	if err != nil {
+		return nil, fmt.Errorf("error listing provers: %v", err)
+	}
+	statuses := []ProverStatus{}
+	for _, p := range provers {
+		if filter.matches(p) {
+			statuses = append(statuses, newProverStatus(p))
+		}
+	}
+	sort.Slice(statuses, func(i, j int) bool {
+		if statuses[i].Name != statuses[j].Name {
+			return statuses[i].Name < statuses[j].Name
+		}
+		return statuses[i].ID < statuses[j].ID
+	})
+	return statuses, nil
+}
+
+// GetProver returns the prover whose EK fingerprint is id, ErrProverNotFound if there is none.
+func (v *DataVerifier) GetProver(id string) (*ProverStatus, error) {
+	p, err := v.proverByID(id)
+	if err != nil {
+		return nil, err
+	}
+	status := newProverStatus(p)
+	return &status, nil
+}
+
+// ProverHistory returns limit attestations of the history of the prover with ID id, newest first, after skipping offset of them.
+// limit defaults to 20 and cannot exceed 100.
+func (v *DataVerifier) ProverHistory(id string, offset, limit int) (*HistoryPage, error) {
+	if offset < 0 {
+		return nil, fmt.Errorf("negative offset %d", offset)
+	}
+	if limit <= 0 {
+		limit = defaultHistoryPage
+	} else if limit > maxHistoryPage {
+		limit = maxHistoryPage
+	}
+	p, err := v.proverByID(id)
+	if err != nil {
+		return nil, err
+	}
+	history, err := v.Provers.History(p.EK.PublicKey())
+	if err != nil {
+		return nil, err
+	}
+	page := &HistoryPage{Total: len(history), Offset: offset, Attestations: []Attestation{}}
+	for i := len(history) - 1 - offset; i >= 0 && len(page.Attestations) < limit; i-- {
+		a := history[i]
+		a.Quote = nil
+		page.Attestations = append(page.Attestations, a)
+	}
+	return page, nil
+}
+
+// ProverAttestation returns the evidence of the attestation with ID attestation of the prover with ID id,
+// ErrAttestationNotFound if it is no longer in the history.
+func (v *DataVerifier) ProverAttestation(id string, attestation uint64) (*Attestation, error) {
+	p, err := v.proverByID(id)
+	if err != nil {
+		return nil, err
+	}
+	history, err := v.Provers.History(p.EK.PublicKey())
+	if err != nil {
+		return nil, err
+	}
+	for i := range history {
+		if history[i].ID == attestation {
+			return &history[i], nil
+		}
+	}
+	return nil, ErrAttestationNotFound
+}
+
+// proverByID returns the prover whose EK fingerprint is id.
+func (v *DataVerifier) proverByID(id string) (*Prover, error) {
+	provers, err := v.Provers.List()
+	if err != nil {
+		return nil, fmt.Errorf("error listing provers: %v", err)
+	}
+	for _, p := range provers {
+		if Fingerprint(p.EK.PublicKey()) == id {
+			return p, nil
+		}
+	}
+	return nil, ErrProverNotFound
+}
@@ -0,0 +1,115 @@
+package verifier
+
+import (
+	"errors"
+	"github.com/google/go-cmp/cmp"
+	"github.com/xcaliburne/RemoteAttestations/pkg/tests"
+	"testing"
+	"time"
+)
+
+func TestDataVerifier_queries(t *testing.T) {
+	v := NewVerifier(&Config{})
+	edge := activatedProver(t, v, "edge", map[string]string{"role": "edge"})
+	gateway := activatedProver(t, v, "gateway", map[string]string{"role": "gateway"})
+	err := v.Provers.Update(gateway.EK.PublicKey(), func(p *Prover) error {
+		p.setState(StateUntrusted, "PCR 0 differs", time.Now())
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("Update() returned an error: %v", err)
+	}
+	id := Fingerprint(gateway.EK.PublicKey())
+
+	var listSuite = []struct {
+		name    string
+		filter  ProverFilter
+		want    []string
+		wantErr bool
+	}{
+		{name: "Every prover", filter: ProverFilter{}, want: []string{"edge", "gateway"}},
+		{name: "Registered", filter: ProverFilter{State: StateRegistered}, want: []string{"edge"}},
+		{name: "Untrusted", filter: ProverFilter{State: StateUntrusted}, want: []string{"gateway"}},
+		{name: "Label", filter: ProverFilter{Labels: map[string]string{"role": "edge"}}, want: []string{"edge"}},
+		{name: "State and label", filter: ProverFilter{State: StateUntrusted, Labels: map[string]string{"role": "edge"}}, want: []string{}},
+		{name: "Unknown state", filter: ProverFilter{State: "compromised"}, wantErr: true},
+	}
+	for _, test := range listSuite {
+		t.Run(test.name, func(t *testing.T) {
+			provers, err := v.ListProvers(test.filter)
+			if (err != nil) != test.wantErr {
+				t.Fatal(tests.Failure(t, err, test.wantErr, "error"))
+			}
+			got := []string{}
+			for _, p := range provers {
+				got = append(got, p.Name)
+			}
+			if err == nil && !cmp.Equal(got, test.want) {
+				t.Error(tests.Failure(t, got, test.want, ""))
+			}
+		})
+	}
+
+	status, err := v.GetProver(id)
+	if err != nil {
+		t.Fatalf("GetProver() returned an error: %v", err)
+	}
+	if status.Name != "gateway" || status.State != StateUntrusted || status.StateReason != "PCR 0 differs" || status.AK != Fingerprint(gateway.AK.PublicKey()) {
+		t.Error(tests.Failure(t, status, "untrusted gateway", ""))
+	}
+	if _, err = v.GetProver(Fingerprint(gateway.AK.PublicKey())); !errors.Is(err, ErrProverNotFound) {
+		t.Error(tests.Failure(t, err, ErrProverNotFound, "AK fingerprint"))
+	}
+
+	start := time.Now()
+	for i := 0; i < 25; i++ {
+		a := Attestation{Time: start.Add(time.Duration(i) * time.Second), Nonce: []byte{byte(i)}, Quote: []byte(`{"Version":"2.0"}`)}
+		if err = v.Provers.AddAttestation(gateway.EK.PublicKey(), &a, HistoryConfig{}); err != nil {
+			t.Fatalf("AddAttestation() returned an error: %v", err)
+		}
+	}
+	var historySuite = []struct {
+		name      string
+		offset    int
+		limit     int
+		wantFirst uint64
+		wantLen   int
+	}{
+		{name: "Default limit", wantFirst: 25, wantLen: 20},
+		{name: "Second page", offset: 20, limit: 20, wantFirst: 5, wantLen: 5},
+		{name: "Past the end", offset: 30, limit: 5, wantLen: 0},
+	}
+	for _, test := range historySuite {
+		t.Run(test.name, func(t *testing.T) {
+			page, err := v.ProverHistory(id, test.offset, test.limit)
+			if err != nil {
+				t.Fatalf("ProverHistory() returned an error: %v", err)
+			}
+			if page.Total != 25 || len(page.Attestations) != test.wantLen {
+				t.Fatal(tests.Failure(t, len(page.Attestations), test.wantLen, "page length"))
+			}
+			if test.wantLen > 0 && page.Attestations[0].ID != test.wantFirst {
+				t.Error(tests.Failure(t, page.Attestations[0].ID, test.wantFirst, "newest attestation of the page"))
+			}
+			for _, a := range page.Attestations {
+				if a.Quote != nil {
+					t.Error(tests.Failure(t, string(a.Quote), nil, "quote in history page"))
+				}
+			}
+		})
+	}
+	if page, err := v.ProverHistory(Fingerprint(edge.EK.PublicKey()), 0, 0); err != nil || page.Total != 0 {
+		t.Error(tests.Failure(t, page, "empty history", ""))
+	}
+
+	evidence, err := v.ProverAttestation(id, 3)
+	if err != nil {
+		t.Fatalf("ProverAttestation() returned an error: %v", err)
+	}
+	if evidence.Nonce[0] != 2 || string(evidence.Quote) != `{"Version":"2.0"}` {
+		t.Error(tests.Failure(t, evidence, "third attestation with its quote", ""))
+	}
+	if _, err = v.ProverAttestation(id, 26); !errors.Is(err, ErrAttestationNotFound) {
+		t.Error(tests.Failure(t, err, ErrAttestationNotFound, "unknown attestation"))
+	}
+}
@@ -0,0 +1,195 @@
+package verifier
+
+import (
+	"context"
+	log "github.com/sirupsen/logrus"
+	"math/rand"
+	"sync"
+	"time"
+)
+
+const (
+	defaultWorkers             = 8
+	defaultAttestationInterval = 15 * time.Second
+	defaultMaxBackoff          = 10 * time.Minute
+	// schedulerTick is how often the scheduler looks for provers due for attestation.
+	schedulerTick = time.Second
+)
+
+// Scheduler attests the provers of a verifier concurrently, each one at its own interval.
+// Unreachable provers are attested again after an exponential backoff.
+type Scheduler struct {
+	v      *DataVerifier
+	config SchedulerConfig
+	// interval is the default attestation interval.
+	interval time.Duration
+
+	mutex     sync.Mutex
+	schedules map[string]*schedule
+
+	// now and random are replaced in tests.
+	now    func() time.Time
+	random func() float64
+}
+
+// schedule is the next attestation of a prover.
+type schedule struct {
+	next     time.Time
+	failures int
+	running  bool
+}
+
+// NewScheduler returns a scheduler for the provers of v, configured by v.Config.
+func NewScheduler(v *DataVerifier) *Scheduler {
+	s := &Scheduler{
+		v:         v,
+		config:    v.Config.Scheduler,
+		interval:  v.Config.AttestationInterval,
+		schedules: map[string]*schedule{},
+		now:       time.Now,
+		random:    rand.Float64,
+	}
+	if s.config.Workers <= 0 {
+		s.config.Workers = defaultWorkers
+	}
+	if s.interval <= 0 {
+		s.interval = defaultAttestationInterval
+	}
+	if s.config.MaxBackoff <= 0 {
+		s.config.MaxBackoff = defaultMaxBackoff
+	}
+	return s
+}
+
+// Run attests the provers until ctx is done, it returns once the running attestations are over.
+func (s *Scheduler) Run(ctx context.Context) {
+	log.Infof("Starting attestations with %d workers", s.config.Workers)
+	jobs := make(chan *Prover)
+	var wg sync.WaitGroup
+	for i := 0; i < s.config.Workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for p := range jobs {
+				record, err := s.v.attestProver(p)
+				if record == nil {
+					// Not attested, the prover changed since it was scheduled, it is not unreachable.
+					err = nil
+				}
+				s.done(p, err)
+			}
+		}()
+	}
+	defer wg.Wait()
+	defer close(jobs)
+
+	ticker := time.NewTicker(schedulerTick)
+	defer ticker.Stop()
+	for {
+		due := s.due()
+		schedulerQueueDepth.Set(float64(len(due)))
+		for _, p := range due {
+			select {
+			case jobs <- p:
+				schedulerQueueDepth.Add(-1)
+			case <-ctx.Done():
+				schedulerQueueDepth.Set(0)
+				log.Info("stopping attestations")
+				return
+			}
+		}
+		select {
+		case <-ctx.Done():
+			log.Info("stopping attestations")
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// due returns the provers whose next attestation is due and marks them running.
+// New and newly approved provers are due at once, the provers no longer stored are forgotten.
+func (s *Scheduler) due() []*Prover {
+	provers, err := s.v.Provers.List()
+	if err != nil {
+		log.Errorf("error listing provers: %v", err)
+		return nil
+	}
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	now := s.now()
+	stored := map[string]bool{}
+	var due []*Prover
+	for _, p := range provers {
+		if p.AK == nil || p.Pending {
+			continue
+		}
+		key := keyID(p.EK.PublicKey())
+		stored[key] = true
+		sch, ok := s.schedules[key]
+		if !ok {
+			sch = &schedule{next: now}
+			s.schedules[key] = sch
+		}
+		if !sch.running && !now.Before(sch.next) {
+			sch.running = true
+			due = append(due, p)
+		}
+	}
+	for key := range s.schedules {
+		if !stored[key] {
+			delete(s.schedules, key)
+		}
+	}
+	return due
+}
+
+// done schedules the next attestation of p, err is the error of its last attestation.
+func (s *Scheduler) done(p *Prover, err error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	sch, ok := s.schedules[keyID(p.EK.PublicKey())]
+	if !ok {
+		return
+	}
+	sch.running = false
+	delay := s.Interval(p)
+	if err != nil {
+		sch.failures++
+		delay = s.backoff(delay, sch.failures)
+		log.Warnf("%v(%v:%v): unreachable %d times, next attestation in %v", p.Name, p.Endpoint, p.Port, sch.failures, delay)
+	} else {
+		sch.failures = 0
+	}
+	sch.next = s.now().Add(s.jitter(delay))
+}
+
+// Interval returns the attestation interval of p, the one of the first interval group selecting it.
+func (s *Scheduler) Interval(p *Prover) time.Duration {
+	for _, group := range s.config.Intervals {
+		if group.Interval > 0 && group.Matches(p.Name, p.Labels) {
+			return group.Interval
+		}
+	}
+	return s.interval
+}
+
+// backoff doubles interval for each failure, up to the maximum backoff.
+func (s *Scheduler) backoff(interval time.Duration, failures int) time.Duration {
+	if interval >= s.config.MaxBackoff {
+		return interval
+	}
+	delay := interval
+	for i := 0; i < failures && delay < s.config.MaxBackoff; i++ {
+		delay *= 2
+	}
+	if delay > s.config.MaxBackoff {
+		return s.config.MaxBackoff
+	}
+	return delay
+}
+
+// jitter moves delay randomly by up to the configured fraction of it.
+func (s *Scheduler) jitter(delay time.Duration) time.Duration {
+	return delay + time.Duration((s.random()*2-1)*s.config.Jitter*float64(delay))
+}
@@ -0,0 +1,138 @@
+package verifier
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"fmt"
+	"github.com/xcaliburne/RemoteAttestations/pkg/httpClient"
+	"github.com/xcaliburne/RemoteAttestations/pkg/httpClient/tests/mocks"
+	"github.com/xcaliburne/RemoteAttestations/pkg/tests"
+	"github.com/xcaliburne/RemoteAttestations/pkg/tpm"
+	tpmMocks "github.com/xcaliburne/RemoteAttestations/pkg/tpm/tests/mocks"
+	verifierDB "github.com/xcaliburne/RemoteAttestations/pkg/verifier"
+	"net/http"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// activatedProver stores a prover with an activated AK in v.
+func activatedProver(t *testing.T, v *DataVerifier, name string, labels map[string]string) *Prover {
+	key := func() *rsa.PublicKey {
+		k, err := rsa.GenerateKey(rand.Reader, 1024)
+		if err != nil {
+			t.Fatalf("GenerateKey() returned an error: %v", err)
+		}
+		return &k.PublicKey
+	}
+	ek := key()
+	p := &Prover{
+		Name:     name,
+		Endpoint: "127.0.0.1",
+		Port:     "8080",
+		Labels:   labels,
+		EK:       &tpmMocks.MockEndorsementKey{CatchPublicKey: func() *rsa.PublicKey { return ek }},
+		AK:       &tpm.AttestationKeyData{PK: key()},
+	}
+	if err := v.Provers.Add(p); err != nil {
+		t.Fatalf("Add() returned an error: %v", err)
+	}
+	return p
+}
+
+func TestScheduler(t *testing.T) {
+	v := NewVerifier(&Config{
+		AttestationInterval: time.Minute,
+		Scheduler: SchedulerConfig{
+			MaxBackoff: 5 * time.Minute,
+			Intervals: []IntervalGroup{
+				{ProverSelector: verifierDB.ProverSelector{Labels: map[string]string{"role": "gateway"}}, Interval: 10 * time.Second},
+			},
+		},
+	})
+	edge := activatedProver(t, v, "edge", nil)
+	gateway := activatedProver(t, v, "gateway", map[string]string{"role": "gateway"})
+	s := NewScheduler(v)
+	now := time.Unix(1000, 0)
+	s.now = func() time.Time { return now }
+	s.random = func() float64 { return 0.5 }
+
+	if got := s.Interval(gateway); got != 10*time.Second {
+		t.Error(tests.Failure(t, got, 10*time.Second, "group interval"))
+	}
+	if got := s.Interval(edge); got != time.Minute {
+		t.Error(tests.Failure(t, got, time.Minute, "default interval"))
+	}
+	if got := len(s.due()); got != 2 {
+		t.Fatal(tests.Failure(t, got, 2, "new provers due"))
+	}
+	if got := len(s.due()); got != 0 {
+		t.Error(tests.Failure(t, got, 0, "running provers due"))
+	}
+
+	var testSuite = []struct {
+		name string
+		err  error
+		want time.Duration
+	}{
+		{name: "reachable", want: time.Minute},
+		{name: "first failure", err: fmt.Errorf("unreachable"), want: 2 * time.Minute},
+		{name: "second failure", err: fmt.Errorf("unreachable"), want: 4 * time.Minute},
+		{name: "backoff capped", err: fmt.Errorf("unreachable"), want: 5 * time.Minute},
+		{name: "reachable again", want: time.Minute},
+	}
+	for _, test := range testSuite {
+		t.Run(test.name, func(t *testing.T) {
+			s.done(edge, test.err)
+			sch := s.schedules[keyID(edge.EK.PublicKey())]
+			if got := sch.next.Sub(now); got != test.want {
+				t.Error(tests.Failure(t, got, test.want, "next run"))
+			}
+		})
+	}
+
+	s.config.Jitter = 0.5
+	s.random = func() float64 { return 1 }
+	s.done(gateway, nil)
+	if got := s.schedules[keyID(gateway.EK.PublicKey())].next.Sub(now); got != 15*time.Second {
+		t.Error(tests.Failure(t, got, 15*time.Second, "jitter"))
+	}
+
+	now = now.Add(time.Minute)
+	due := s.due()
+	if len(due) != 2 {
+		t.Error(tests.Failure(t, len(due), 2, "provers due after their interval"))
+	}
+}
+
+func TestScheduler_Run(t *testing.T) {
+	v := NewVerifier(&Config{AttestationInterval: time.Hour, Scheduler: SchedulerConfig{Workers: 2}})
+	for i := 0; i < 4; i++ {
+		activatedProver(t, v, fmt.Sprintf("prover-%d", i), nil)
+	}
+	var calls int32
+	httpClient.Client = &mocks.MockHttpClient{CatchGet: func(url string) (*http.Response, error) {
+		atomic.AddInt32(&calls, 1)
+		return nil, fmt.Errorf("unreachable")
+	}}
+	ctx, cancel := context.WithCancel(context.Background())
+	stopped := make(chan struct{})
+	go func() {
+		NewScheduler(v).Run(ctx)
+		close(stopped)
+	}()
+	deadline := time.Now().Add(5 * time.Second)
+	for atomic.LoadInt32(&calls) < 4 && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	cancel()
+	select {
+	case <-stopped:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Run() did not return once the context was cancelled")
+	}
+	if got := atomic.LoadInt32(&calls); got != 4 {
+		t.Error(tests.Failure(t, got, 4, "attested provers"))
+	}
+}
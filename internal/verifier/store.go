@@ -0,0 +1,400 @@
+package verifier
+
+import (
+	"crypto/rsa"
+	"errors"
+	"fmt"
+	"github.com/xcaliburne/RemoteAttestations/pkg/tpm"
+	verifierDB "github.com/xcaliburne/RemoteAttestations/pkg/verifier"
+	"sync"
+	"time"
+)
+
+// ProverStore keeps the registered provers, indexed by EK and by activated AK.
+// Implementations are safe for concurrent use. They store copies of the provers,
+// changes to a returned prover are only kept once written back with Update.
+type ProverStore interface {
+	// Add stores a new prover, it returns ErrProverExists if a prover with the same EK is registered.
+	Add(p *Prover) error
+	// Update applies update to the prover with EK ek and stores the result unless update returns an error.
+	// It returns ErrProverNotFound if there is no such prover.
+	Update(ek *rsa.PublicKey, update func(p *Prover) error) error
+	// GetByEK returns the prover with EK k, ErrProverNotFound if there is none.
+	GetByEK(k *rsa.PublicKey) (*Prover, error)
+	// GetByAK returns the prover whose activated AK is k, ErrProverNotFound if there is none.
+	GetByAK(k *rsa.PublicKey) (*Prover, error)
+	// List returns every prover.
+	List() ([]*Prover, error)
+	// AddAttestation appends a to the history of the prover with EK ek and drops the entries retention does not keep.
+	// a.ID is set to the next ID of the history, IDs are never reused.
+	AddAttestation(ek *rsa.PublicKey, a *Attestation, retention HistoryConfig) error
+	// History returns the attestations of the prover with EK ek, oldest first.
+	History(ek *rsa.PublicKey) ([]Attestation, error)
+	// Delete removes the prover with EK ek along with its AK and its history.
+	Delete(ek *rsa.PublicKey) error
+}
+
+// BlocklistStore keeps the EKs whose registration is rejected, by fingerprint.
+type BlocklistStore interface {
+	// Block adds an EK to the blocklist, replacing the entry with the same ID.
+	Block(entry BlockedEK) error
+	// Unblock removes the EK whose fingerprint is id from the blocklist, it returns ErrEKNotBlocked if it is not blocked.
+	Unblock(id string) error
+	// Blocked returns the blocklist entry of the EK whose fingerprint is id, nil if it is not blocked.
+	Blocked(id string) (*BlockedEK, error)
+	// Blocklist returns the blocked EKs.
+	Blocklist() ([]BlockedEK, error)
+}
+
+// AuditStore keeps the audit log of the operator actions.
+type AuditStore interface {
+	// AddAudit appends entry to the audit log.
+	AddAudit(entry AuditEntry) error
+	// Audit returns the audit log, oldest first.
+	Audit() ([]AuditEntry, error)
+}
+
+// DeadLetterStore keeps the webhook deliveries that failed.
+type DeadLetterStore interface {
+	// AddDeadLetter stores a failed webhook delivery, letter.ID is set to the next ID.
+	AddDeadLetter(letter *DeadLetter) error
+	// DeadLetters returns the failed webhook deliveries, oldest first.
+	DeadLetters() ([]DeadLetter, error)
+	// DeleteDeadLetter removes the dead letter with ID id, it returns ErrDeadLetterNotFound if there is none.
+	DeleteDeadLetter(id uint64) error
+}
+
+// EnrollmentTokenStore keeps the enrollment tokens, by ID.
+type EnrollmentTokenStore interface {
+	// AddEnrollmentToken stores an enrollment token, replacing the one with the same ID.
+	AddEnrollmentToken(token EnrollmentToken) error
+	// EnrollmentTokens returns the enrollment tokens.
+	EnrollmentTokens() ([]EnrollmentToken, error)
+	// UpdateEnrollmentToken applies update to the enrollment token with ID id and stores the result unless update
+	// returns an error. It returns ErrEnrollmentTokenNotFound if there is no such token.
+	UpdateEnrollmentToken(id string, update func(t *EnrollmentToken) error) error
+	// DeleteEnrollmentToken removes the enrollment token with ID id, it returns ErrEnrollmentTokenNotFound if there is none.
+	DeleteEnrollmentToken(id string) error
+}
+
+// Store is the state of the verifier kept by one backend, MemoryStore or BoltStore.
+// Like ProverStore, the other stores are safe for concurrent use.
+type Store interface {
+	ProverStore
+	BlocklistStore
+	AuditStore
+	DeadLetterStore
+	EnrollmentTokenStore
+	Close() error
+}
+
+var (
+	ErrProverNotFound = errors.New("prover not found")
+	ErrProverExists   = errors.New("endorsement key already set")
+	// ErrAttestationNotFound is returned for an attestation missing from the history of a prover.
+	ErrAttestationNotFound = errors.New("attestation not found")
+	ErrEKNotBlocked        = errors.New("endorsement key not blocked")
+)
+
+// keyID identifies a public key in the indexes of the stores.
+func keyID(k *rsa.PublicKey) string {
+	return fmt.Sprintf("%v:%v", k.N.String(), k.E)
+}
+
+// clone returns a copy of p that does not share its slices and maps, the keys are shared.
+func (p *Prover) clone() *Prover {
+	c := *p
+	c.Labels, c.ClaimedLabels = copyLabels(p.Labels), copyLabels(p.ClaimedLabels)
+	c.PCRMismatches = append([]tpm.PCRMismatch(nil), p.PCRMismatches...)
+	c.PCRRules = append([]verifierDB.PCRRuleResult(nil), p.PCRRules...)
+	c.LastPCRs = append([]tpm.PCR(nil), p.LastPCRs...)
+	c.IMA.Findings = append([]verifierDB.IMAFinding(nil), p.IMA.Findings...)
+	return &c
+}
+
+func copyLabels(labels map[string]string) map[string]string {
+	if labels == nil {
+		return nil
+	}
+	c := make(map[string]string, len(labels))
+	for k, v := range labels {
+		c[k] = v
+	}
+	return c
+}
+
+// MemoryStore is a Store losing the provers when the verifier stops.
+type MemoryStore struct {
+	mutex sync.RWMutex
+	byEK  map[string]*Prover
+	// byAK maps the activated AKs to the EK of their prover.
+	byAK    map[string]string
+	history map[string][]Attestation
+	// lastIDs are the IDs of the last attestations added to the histories.
+	lastIDs   map[string]uint64
+	blocklist map[string]BlockedEK
+	audit     []AuditEntry
+	// deadLetters are sorted by ID, lastDeadLetter is the ID of the last one.
+	deadLetters      []DeadLetter
+	lastDeadLetter   uint64
+	enrollmentTokens map[string]EnrollmentToken
+}
+
+var _ Store = (*MemoryStore)(nil) // Verify that *MemoryStore implements Store.
+
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{byEK: map[string]*Prover{}, byAK: map[string]string{}, history: map[string][]Attestation{}, lastIDs: map[string]uint64{}, blocklist: map[string]BlockedEK{}, enrollmentTokens: map[string]EnrollmentToken{}}
+}
+
+func (s *MemoryStore) Add(p *Prover) error {
+	if p.EK == nil {
+		return fmt.Errorf("endorsement key not set")
+	}
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	ek := keyID(p.EK.PublicKey())
+	if _, ok := s.byEK[ek]; ok {
+		return ErrProverExists
+	}
+	if err := s.indexAK(ek, nil, p.AK); err != nil {
+		return err
+	}
+	s.byEK[ek] = p.clone()
+	return nil
+}
+
+func (s *MemoryStore) Update(k *rsa.PublicKey, update func(p *Prover) error) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	ek := keyID(k)
+	stored, ok := s.byEK[ek]
+	if !ok {
+		return ErrProverNotFound
+	}
+	p := stored.clone()
+	if err := update(p); err != nil {
+		return err
+	}
+	if p.EK == nil || keyID(p.EK.PublicKey()) != ek {
+		return fmt.Errorf("the endorsement key of a prover cannot be changed")
+	}
+	if err := s.indexAK(ek, stored.AK, p.AK); err != nil {
+		return err
+	}
+	s.byEK[ek] = p
+	return nil
+}
+
+// indexAK replaces old by ak in the AK index of the prover with EK ek.
+func (s *MemoryStore) indexAK(ek string, old, ak tpm.AttestationKey) error {
+	if ak != nil {
+		if owner, ok := s.byAK[keyID(ak.PublicKey())]; ok && owner != ek {
+			return fmt.Errorf("attestation key already set")
+		}
+	}
+	if old != nil {
+		delete(s.byAK, keyID(old.PublicKey()))
+	}
+	if ak != nil {
+		s.byAK[keyID(ak.PublicKey())] = ek
+	}
+	return nil
+}
+
+func (s *MemoryStore) GetByEK(k *rsa.PublicKey) (*Prover, error) {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+	p, ok := s.byEK[keyID(k)]
+	if !ok {
+		return nil, ErrProverNotFound
+	}
+	return p.clone(), nil
+}
+
+func (s *MemoryStore) GetByAK(k *rsa.PublicKey) (*Prover, error) {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+	ek, ok := s.byAK[keyID(k)]
+	if !ok {
+		return nil, ErrProverNotFound
+	}
+	return s.byEK[ek].clone(), nil
+}
+
+func (s *MemoryStore) List() ([]*Prover, error) {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+	provers := make([]*Prover, 0, len(s.byEK))
+	for _, p := range s.byEK {
+		provers = append(provers, p.clone())
+	}
+	return provers, nil
+}
+
+func (s *MemoryStore) AddAttestation(k *rsa.PublicKey, a *Attestation, retention HistoryConfig) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	ek := keyID(k)
+	if _, ok := s.byEK[ek]; !ok {
+		return ErrProverNotFound
+	}
+	s.lastIDs[ek]++
+	a.ID = s.lastIDs[ek]
+	history := append(s.history[ek], *a)
+	times := make([]time.Time, len(history))
+	for i, entry := range history {
+		times[i] = entry.Time
+	}
+	s.history[ek] = append([]Attestation(nil), history[retention.keep(times, a.Time):]...)
+	return nil
+}
+
+func (s *MemoryStore) History(k *rsa.PublicKey) ([]Attestation, error) {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+	ek := keyID(k)
+	if _, ok := s.byEK[ek]; !ok {
+		return nil, ErrProverNotFound
+	}
+	return append([]Attestation(nil), s.history[ek]...), nil
+}
+
+func (s *MemoryStore) Delete(k *rsa.PublicKey) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	ek := keyID(k)
+	p, ok := s.byEK[ek]
+	if !ok {
+		return ErrProverNotFound
+	}
+	if p.AK != nil {
+		delete(s.byAK, keyID(p.AK.PublicKey()))
+	}
+	delete(s.byEK, ek)
+	delete(s.history, ek)
+	delete(s.lastIDs, ek)
+	return nil
+}
+
+func (s *MemoryStore) Block(entry BlockedEK) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.blocklist[entry.ID] = entry
+	return nil
+}
+
+func (s *MemoryStore) Unblock(id string) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if _, ok := s.blocklist[id]; !ok {
+		return ErrEKNotBlocked
+	}
+	delete(s.blocklist, id)
+	return nil
+}
+
+func (s *MemoryStore) Blocked(id string) (*BlockedEK, error) {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+	entry, ok := s.blocklist[id]
+	if !ok {
+		return nil, nil
+	}
+	return &entry, nil
+}
+
+func (s *MemoryStore) Blocklist() ([]BlockedEK, error) {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+	blocklist := make([]BlockedEK, 0, len(s.blocklist))
+	for _, entry := range s.blocklist {
+		blocklist = append(blocklist, entry)
+	}
+	return blocklist, nil
+}
+
+func (s *MemoryStore) AddAudit(entry AuditEntry) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.audit = append(s.audit, entry)
+	return nil
+}
+
+func (s *MemoryStore) Audit() ([]AuditEntry, error) {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+	return append([]AuditEntry(nil), s.audit...), nil
+}
+
+func (s *MemoryStore) AddDeadLetter(letter *DeadLetter) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.lastDeadLetter++
+	letter.ID = s.lastDeadLetter
+	s.deadLetters = append(s.deadLetters, *letter)
+	return nil
+}
+
+func (s *MemoryStore) DeadLetters() ([]DeadLetter, error) {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+	return append([]DeadLetter(nil), s.deadLetters...), nil
+}
+
+func (s *MemoryStore) DeleteDeadLetter(id uint64) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	for i, letter := range s.deadLetters {
+		if letter.ID == id {
+			s.deadLetters = append(s.deadLetters[:i:i], s.deadLetters[i+1:]...)
+			return nil
+		}
+	}
+	return ErrDeadLetterNotFound
+}
+
+func (s *MemoryStore) AddEnrollmentToken(token EnrollmentToken) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.enrollmentTokens[token.ID] = token
+	return nil
+}
+
+func (s *MemoryStore) EnrollmentTokens() ([]EnrollmentToken, error) {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+	tokens := make([]EnrollmentToken, 0, len(s.enrollmentTokens))
+	for _, token := range s.enrollmentTokens {
+		tokens = append(tokens, token)
+	}
+	return tokens, nil
+}
+
+func (s *MemoryStore) UpdateEnrollmentToken(id string, update func(t *EnrollmentToken) error) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	token, ok := s.enrollmentTokens[id]
+	if !ok {
+		return ErrEnrollmentTokenNotFound
+	}
+	if err := update(&token); err != nil {
+		return err
+	}
+	token.ID = id
+	s.enrollmentTokens[id] = token
+	return nil
+}
+
+func (s *MemoryStore) DeleteEnrollmentToken(id string) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if _, ok := s.enrollmentTokens[id]; !ok {
+		return ErrEnrollmentTokenNotFound
+	}
+	delete(s.enrollmentTokens, id)
+	return nil
+}
+
+func (s *MemoryStore) Close() error {
+	return nil
+}
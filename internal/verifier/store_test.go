@@ -0,0 +1,227 @@
+package verifier_test
+
+import (
+	"errors"
+	"github.com/google/go-cmp/cmp"
+	"github.com/xcaliburne/RemoteAttestations/internal/verifier"
+	"github.com/xcaliburne/RemoteAttestations/pkg/tests"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestStore(t *testing.T) {
+	dbFile := filepath.Join(t.TempDir(), "provers.db")
+	stores := []struct {
+		name string
+		open func() (verifier.Store, error)
+	}{
+		{name: "memory", open: func() (verifier.Store, error) { return verifier.NewMemoryStore(), nil }},
+		{name: "bolt", open: func() (verifier.Store, error) { return verifier.OpenBoltStore(dbFile) }},
+	}
+	for _, s := range stores {
+		t.Run(s.name, func(t *testing.T) {
+			store, err := s.open()
+			if err != nil {
+				t.Fatalf("open() returned an error: %v", err)
+			}
+			defer store.Close()
+			p, _ := softwareProver(t)
+			other, _ := softwareProver(t)
+			third, _ := softwareProver(t)
+			ak := p.AK
+			p.AK, other.AK, third.AK = nil, nil, nil
+
+			if err = store.Add(p); err != nil {
+				t.Fatalf("Add() returned an error: %v", err)
+			}
+			if err = store.Add(p); !errors.Is(err, verifier.ErrProverExists) {
+				t.Error(tests.Failure(t, err, verifier.ErrProverExists, "EK added twice"))
+			}
+			if _, err = store.GetByAK(ak.PublicKey()); !errors.Is(err, verifier.ErrProverNotFound) {
+				t.Error(tests.Failure(t, err, verifier.ErrProverNotFound, "AK not activated"))
+			}
+			err = store.Update(p.EK.PublicKey(), func(stored *verifier.Prover) error {
+				stored.AK = ak
+				stored.Labels = map[string]string{"site": "paris"}
+				stored.IMA.Offset = 3
+				return nil
+			})
+			if err != nil {
+				t.Fatalf("Update() returned an error: %v", err)
+			}
+			got, err := store.GetByAK(ak.PublicKey())
+			if err != nil {
+				t.Fatalf("GetByAK() returned an error: %v", err)
+			}
+			if got.Name != p.Name || got.Labels["site"] != "paris" || got.IMA.Offset != 3 {
+				t.Error(tests.Failure(t, got, "updated prover", ""))
+			}
+			got.Labels["site"] = "lyon"
+			if got, _ = store.GetByEK(p.EK.PublicKey()); got.Labels["site"] != "paris" {
+				t.Error(tests.Failure(t, got.Labels, "paris", "returned prover shares the stored labels"))
+			}
+
+			if err = store.Add(other); err != nil {
+				t.Fatalf("Add() returned an error: %v", err)
+			}
+			err = store.Update(other.EK.PublicKey(), func(stored *verifier.Prover) error {
+				stored.AK = ak
+				return nil
+			})
+			if err == nil {
+				t.Error(tests.Failure(t, err, "error", "AK of another prover"))
+			}
+			if err = store.Update(ak.PublicKey(), func(*verifier.Prover) error { return nil }); !errors.Is(err, verifier.ErrProverNotFound) {
+				t.Error(tests.Failure(t, err, verifier.ErrProverNotFound, "unknown EK"))
+			}
+			provers, err := store.List()
+			if err != nil || len(provers) != 2 {
+				t.Error(tests.Failure(t, len(provers), 2, "listed provers"))
+			}
+
+			start := time.Unix(1000, 0)
+			retention := verifier.HistoryConfig{MaxEntries: 3, MaxAge: time.Hour}
+			for i := 0; i < 5; i++ {
+				a := verifier.Attestation{Time: start.Add(time.Duration(i) * time.Minute), Nonce: []byte{byte(i)}, State: verifier.StateTrusted}
+				if err = store.AddAttestation(p.EK.PublicKey(), &a, retention); err != nil {
+					t.Fatalf("AddAttestation() returned an error: %v", err)
+				}
+			}
+			history, err := store.History(p.EK.PublicKey())
+			if err != nil {
+				t.Fatalf("History() returned an error: %v", err)
+			}
+			var nonces []byte
+			for _, a := range history {
+				nonces = append(nonces, a.Nonce...)
+			}
+			if !cmp.Equal(nonces, []byte{2, 3, 4}) {
+				t.Error(tests.Failure(t, nonces, []byte{2, 3, 4}, "entries kept"))
+			}
+			if history[0].ID != 3 {
+				t.Error(tests.Failure(t, history[0].ID, 3, "ID of the oldest entry kept"))
+			}
+			late := verifier.Attestation{Time: start.Add(2 * time.Hour), Nonce: []byte{5}, State: verifier.StateUntrusted, Failures: []string{"failed"}}
+			if err = store.AddAttestation(p.EK.PublicKey(), &late, retention); err != nil {
+				t.Fatalf("AddAttestation() returned an error: %v", err)
+			}
+			if history, _ = store.History(p.EK.PublicKey()); len(history) != 1 || !cmp.Equal(history[0].Failures, late.Failures) {
+				t.Error(tests.Failure(t, history, []verifier.Attestation{late}, "entries older than the maximum age"))
+			}
+			if history, err = store.History(other.EK.PublicKey()); err != nil || len(history) != 0 {
+				t.Error(tests.Failure(t, history, nil, "history of another prover"))
+			}
+			if err = store.AddAttestation(ak.PublicKey(), &late, retention); !errors.Is(err, verifier.ErrProverNotFound) {
+				t.Error(tests.Failure(t, err, verifier.ErrProverNotFound, "attestation of an unknown EK"))
+			}
+
+			if err = store.Add(third); err != nil {
+				t.Fatalf("Add() returned an error: %v", err)
+			}
+			if err = store.AddAttestation(third.EK.PublicKey(), &late, retention); err != nil {
+				t.Fatalf("AddAttestation() returned an error: %v", err)
+			}
+			if err = store.Delete(third.EK.PublicKey()); err != nil {
+				t.Fatalf("Delete() returned an error: %v", err)
+			}
+			if _, err = store.History(third.EK.PublicKey()); !errors.Is(err, verifier.ErrProverNotFound) {
+				t.Error(tests.Failure(t, err, verifier.ErrProverNotFound, "history of a deleted prover"))
+			}
+			if err = store.Delete(third.EK.PublicKey()); !errors.Is(err, verifier.ErrProverNotFound) {
+				t.Error(tests.Failure(t, err, verifier.ErrProverNotFound, "prover deleted twice"))
+			}
+			if err = store.Block(verifier.BlockedEK{ID: "blocked", Actor: "alice", Reason: "stolen"}); err != nil {
+				t.Fatalf("Block() returned an error: %v", err)
+			}
+			if err = store.Block(verifier.BlockedEK{ID: "unblocked"}); err != nil {
+				t.Fatalf("Block() returned an error: %v", err)
+			}
+			if err = store.Unblock("unblocked"); err != nil {
+				t.Fatalf("Unblock() returned an error: %v", err)
+			}
+			if err = store.Unblock("unblocked"); !errors.Is(err, verifier.ErrEKNotBlocked) {
+				t.Error(tests.Failure(t, err, verifier.ErrEKNotBlocked, "EK unblocked twice"))
+			}
+			if err = store.AddAudit(verifier.AuditEntry{Actor: "alice", Action: verifier.AuditBlock, Prover: "blocked"}); err != nil {
+				t.Fatalf("AddAudit() returned an error: %v", err)
+			}
+			for _, url := range []string{"https://hooks.example.com/a", "https://hooks.example.com/b"} {
+				if err = store.AddDeadLetter(&verifier.DeadLetter{URL: url, Attempts: 5}); err != nil {
+					t.Fatalf("AddDeadLetter() returned an error: %v", err)
+				}
+			}
+			letters, err := store.DeadLetters()
+			if err != nil || len(letters) != 2 || letters[0].ID == letters[1].ID {
+				t.Fatal(tests.Failure(t, letters, "2 dead letters", ""))
+			}
+			if err = store.DeleteDeadLetter(letters[0].ID); err != nil {
+				t.Fatalf("DeleteDeadLetter() returned an error: %v", err)
+			}
+			if err = store.DeleteDeadLetter(letters[0].ID); !errors.Is(err, verifier.ErrDeadLetterNotFound) {
+				t.Error(tests.Failure(t, err, verifier.ErrDeadLetterNotFound, "dead letter deleted twice"))
+			}
+			for _, id := range []string{"used", "revoked"} {
+				if err = store.AddEnrollmentToken(verifier.EnrollmentToken{ID: id, Device: "edge", CreatedBy: "alice"}); err != nil {
+					t.Fatalf("AddEnrollmentToken() returned an error: %v", err)
+				}
+			}
+			used := time.Unix(2000, 0)
+			err = store.UpdateEnrollmentToken("used", func(token *verifier.EnrollmentToken) error {
+				token.Used = &used
+				return nil
+			})
+			if err != nil {
+				t.Fatalf("UpdateEnrollmentToken() returned an error: %v", err)
+			}
+			if err = store.UpdateEnrollmentToken("unknown", func(*verifier.EnrollmentToken) error { return nil }); !errors.Is(err, verifier.ErrEnrollmentTokenNotFound) {
+				t.Error(tests.Failure(t, err, verifier.ErrEnrollmentTokenNotFound, "unknown enrollment token"))
+			}
+			if err = store.DeleteEnrollmentToken("revoked"); err != nil {
+				t.Fatalf("DeleteEnrollmentToken() returned an error: %v", err)
+			}
+			if err = store.DeleteEnrollmentToken("revoked"); !errors.Is(err, verifier.ErrEnrollmentTokenNotFound) {
+				t.Error(tests.Failure(t, err, verifier.ErrEnrollmentTokenNotFound, "enrollment token deleted twice"))
+			}
+		})
+	}
+
+	store, err := verifier.OpenBoltStore(dbFile)
+	if err != nil {
+		t.Fatalf("OpenBoltStore() returned an error: %v", err)
+	}
+	defer store.Close()
+	provers, err := store.List()
+	if err != nil {
+		t.Fatalf("List() returned an error: %v", err)
+	}
+	activated := 0
+	for _, p := range provers {
+		if p.AK == nil {
+			continue
+		}
+		activated++
+		got, err := store.GetByAK(p.AK.PublicKey())
+		if err != nil || !cmp.Equal(got.EK.PublicKey(), p.EK.PublicKey()) || got.IMA.Offset != 3 {
+			t.Error(tests.Failure(t, got, p, "prover reloaded from the database"))
+		}
+	}
+	if len(provers) != 2 || activated != 1 {
+		t.Error(tests.Failure(t, len(provers), 2, "provers reloaded from the database"))
+	}
+	if blocked, err := store.Blocked("blocked"); err != nil || blocked == nil || blocked.Actor != "alice" {
+		t.Error(tests.Failure(t, blocked, "EK blocked by alice", "blocklist reloaded from the database"))
+	}
+	if blocked, err := store.Blocked("unblocked"); err != nil || blocked != nil {
+		t.Error(tests.Failure(t, blocked, nil, "unblocked EK reloaded from the database"))
+	}
+	if entries, err := store.Audit(); err != nil || len(entries) != 1 || entries[0].Action != verifier.AuditBlock {
+		t.Error(tests.Failure(t, entries, "block entry", "audit log reloaded from the database"))
+	}
+	if letters, err := store.DeadLetters(); err != nil || len(letters) != 1 || letters[0].URL != "https://hooks.example.com/b" {
+		t.Error(tests.Failure(t, letters, "dead letter of hook b", "dead letters reloaded from the database"))
+	}
+	if tokens, err := store.EnrollmentTokens(); err != nil || len(tokens) != 1 || tokens[0].Used == nil || !tokens[0].Used.Equal(time.Unix(2000, 0)) {
+		t.Error(tests.Failure(t, tokens, "used enrollment token", "enrollment tokens reloaded from the database"))
+	}
+}
@@ -1,32 +1,64 @@
 package mocks
 
 import (
+	"context"
 	"github.com/xcaliburne/RemoteAttestations/internal/verifier"
+	"github.com/xcaliburne/RemoteAttestations/pkg/ima"
 	"github.com/xcaliburne/RemoteAttestations/pkg/tpm"
 )
 
 type MockVerifier struct {
-	CatchInitParams         func() verifier.InitializationParams
-	CatchRegisterNewEK      func(p *verifier.Prover) error
-	CatchRegisterNewAK      func(p *verifier.Prover) error
-	CatchAttestationRequest func(nonce []byte, url string) (tpm.Quote, error)
-	CatchStartAttestations  func()
-	CatchGetChallenge       func() ([]byte, error)
+	CatchRegisterNewEK         func(p *verifier.Prover) error
+	CatchRegisterNewAK         func(p *verifier.Prover) (*tpm.Credential, error)
+	CatchActivateAK            func(ek tpm.EndorsementKey, secret []byte) ([]byte, error)
+	CatchAttestationRequest    func(nonce []byte, sel tpm.PCRSelection, url string) (tpm.Quote, error)
+	CatchEventLogRequest       func(url string) ([]byte, error)
+	CatchIMARequest            func(url string, offset int) ([]ima.Entry, error)
+	CatchStartAttestations     func()
+	CatchGetChallenge          func() ([]byte, error)
+	CatchListProvers           func(filter verifier.ProverFilter) ([]verifier.ProverStatus, error)
+	CatchGetProver             func(id string) (*verifier.ProverStatus, error)
+	CatchProverHistory         func(id string, offset, limit int) (*verifier.HistoryPage, error)
+	CatchProverAttestation     func(id string, attestation uint64) (*verifier.Attestation, error)
+	CatchAttestProver          func(ctx context.Context, id string) (*verifier.Attestation, error)
+	CatchAttestProvers         func(ctx context.Context, filter verifier.ProverFilter) ([]verifier.AttestationResult, error)
+	CatchDeregister            func(id string, request verifier.Deregistration) error
+	CatchDropAK                func(id, actor, reason string) error
+	CatchUnblockEK             func(id, actor, reason string) error
+	CatchBlocklist             func() ([]verifier.BlockedEK, error)
+	CatchAuditLog              func() ([]verifier.AuditEntry, error)
+	CatchDeadLetters           func() ([]verifier.DeadLetter, error)
+	CatchRedeliver             func(ctx context.Context, id uint64) error
+	CatchCreateEnrollmentToken func(request verifier.EnrollmentRequest) (string, *verifier.EnrollmentToken, error)
+	CatchEnrollmentTokens      func() ([]verifier.EnrollmentToken, error)
+	CatchRevokeEnrollmentToken func(id, actor, reason string) error
+	CatchEnroll                func(token, device string) (*verifier.DeviceCredentials, error)
+	CatchPendingRegistrations  func() ([]verifier.ProverStatus, error)
+	CatchApproveRegistration   func(id, actor, reason string) error
+	CatchRejectRegistration    func(id string, request verifier.Deregistration) error
+	CatchSetLabels             func(id, actor, reason string, labels map[string]string) error
 }
 
 var _ verifier.Verifier = (*MockVerifier)(nil) // Verify that *MockEndorsementKey implements EndorsementKey.
 
-func (v *MockVerifier) InitParams() verifier.InitializationParams {
-	return v.CatchInitParams()
-}
 func (v *MockVerifier) RegisterNewEK(p *verifier.Prover) error {
 	return v.CatchRegisterNewEK(p)
 }
-func (v *MockVerifier) RegisterNewAK(p *verifier.Prover) error {
+func (v *MockVerifier) RegisterNewAK(p *verifier.Prover) (*tpm.Credential, error) {
 	return v.CatchRegisterNewAK(p)
 }
-func (v *MockVerifier) AttestationRequest(nonce []byte, url string) (tpm.Quote, error) {
-	return v.CatchAttestationRequest(nonce, url)
+func (v *MockVerifier) ActivateAK(ek tpm.EndorsementKey, secret []byte) ([]byte, error) {
+	return v.CatchActivateAK(ek, secret)
+}
+func (v *MockVerifier) AttestationRequest(nonce []byte, sel tpm.PCRSelection, url string) (tpm.Quote, error) {
+	return v.CatchAttestationRequest(nonce, sel, url)
+}
+func (v *MockVerifier) EventLogRequest(url string) ([]byte, error) {
+	return v.CatchEventLogRequest(url)
+}
+
+func (v *MockVerifier) IMARequest(url string, offset int) ([]ima.Entry, error) {
+	return v.CatchIMARequest(url, offset)
 }
 func (v *MockVerifier) StartAttestations() {
 	v.CatchStartAttestations()
@@ -34,3 +66,66 @@ func (v *MockVerifier) StartAttestations() {
 func (v *MockVerifier) GetChallenge() ([]byte, error) {
 	return v.CatchGetChallenge()
 }
+func (v *MockVerifier) ListProvers(filter verifier.ProverFilter) ([]verifier.ProverStatus, error) {
+	return v.CatchListProvers(filter)
+}
+func (v *MockVerifier) GetProver(id string) (*verifier.ProverStatus, error) {
+	return v.CatchGetProver(id)
+}
+func (v *MockVerifier) ProverHistory(id string, offset, limit int) (*verifier.HistoryPage, error) {
+	return v.CatchProverHistory(id, offset, limit)
+}
+func (v *MockVerifier) ProverAttestation(id string, attestation uint64) (*verifier.Attestation, error) {
+	return v.CatchProverAttestation(id, attestation)
+}
+func (v *MockVerifier) AttestProver(ctx context.Context, id string) (*verifier.Attestation, error) {
+	return v.CatchAttestProver(ctx, id)
+}
+func (v *MockVerifier) AttestProvers(ctx context.Context, filter verifier.ProverFilter) ([]verifier.AttestationResult, error) {
+	return v.CatchAttestProvers(ctx, filter)
+}
+func (v *MockVerifier) Deregister(id string, request verifier.Deregistration) error {
+	return v.CatchDeregister(id, request)
+}
+func (v *MockVerifier) DropAK(id, actor, reason string) error {
+	return v.CatchDropAK(id, actor, reason)
+}
+func (v *MockVerifier) UnblockEK(id, actor, reason string) error {
+	return v.CatchUnblockEK(id, actor, reason)
+}
+func (v *MockVerifier) Blocklist() ([]verifier.BlockedEK, error) {
+	return v.CatchBlocklist()
+}
+func (v *MockVerifier) AuditLog() ([]verifier.AuditEntry, error) {
+	return v.CatchAuditLog()
+}
+func (v *MockVerifier) DeadLetters() ([]verifier.DeadLetter, error) {
+	return v.CatchDeadLetters()
+}
+func (v *MockVerifier) Redeliver(ctx context.Context, id uint64) error {
+	return v.CatchRedeliver(ctx, id)
+}
+func (v *MockVerifier) CreateEnrollmentToken(request verifier.EnrollmentRequest) (string, *verifier.EnrollmentToken, error) {
+	return v.CatchCreateEnrollmentToken(request)
+}
+func (v *MockVerifier) EnrollmentTokens() ([]verifier.EnrollmentToken, error) {
+	return v.CatchEnrollmentTokens()
+}
+func (v *MockVerifier) RevokeEnrollmentToken(id, actor, reason string) error {
+	return v.CatchRevokeEnrollmentToken(id, actor, reason)
+}
+func (v *MockVerifier) Enroll(token, device string) (*verifier.DeviceCredentials, error) {
+	return v.CatchEnroll(token, device)
+}
+func (v *MockVerifier) PendingRegistrations() ([]verifier.ProverStatus, error) {
+	return v.CatchPendingRegistrations()
+}
+func (v *MockVerifier) ApproveRegistration(id, actor, reason string) error {
+	return v.CatchApproveRegistration(id, actor, reason)
+}
+func (v *MockVerifier) RejectRegistration(id string, request verifier.Deregistration) error {
+	return v.CatchRejectRegistration(id, request)
+}
+func (v *MockVerifier) SetLabels(id, actor, reason string, labels map[string]string) error {
+	return v.CatchSetLabels(id, actor, reason, labels)
+}
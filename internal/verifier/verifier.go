@@ -1,151 +1,750 @@
 package verifier
 
 import (
+	"context"
 	"crypto/rand"
-	"crypto/rsa"
+	"crypto/subtle"
 	"encoding/json"
 	"errors"
 	"fmt"
 	log "github.com/sirupsen/logrus"
+	"github.com/xcaliburne/RemoteAttestations/pkg/eventlog"
 	"github.com/xcaliburne/RemoteAttestations/pkg/httpClient"
+	"github.com/xcaliburne/RemoteAttestations/pkg/ima"
 	"github.com/xcaliburne/RemoteAttestations/pkg/tpm"
+	verifierDB "github.com/xcaliburne/RemoteAttestations/pkg/verifier"
+	"io/ioutil"
+	"net"
 	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
 )
 
 type Verifier interface {
-	InitParams() InitializationParams
 	RegisterNewEK(p *Prover) error
-	RegisterNewAK(p *Prover) error
-	AttestationRequest(nonce []byte, url string) (tpm.Quote, error)
+	RegisterNewAK(p *Prover) (*tpm.Credential, error)
+	ActivateAK(ek tpm.EndorsementKey, secret []byte) ([]byte, error)
+	AttestationRequest(nonce []byte, sel tpm.PCRSelection, url string) (tpm.Quote, error)
+	EventLogRequest(url string) ([]byte, error)
+	IMARequest(url string, offset int) ([]ima.Entry, error)
 	StartAttestations()
 	GetChallenge() ([]byte, error)
+	ListProvers(filter ProverFilter) ([]ProverStatus, error)
+	GetProver(id string) (*ProverStatus, error)
+	ProverHistory(id string, offset, limit int) (*HistoryPage, error)
+	ProverAttestation(id string, attestation uint64) (*Attestation, error)
+	AttestProver(ctx context.Context, id string) (*Attestation, error)
+	AttestProvers(ctx context.Context, filter ProverFilter) ([]AttestationResult, error)
+	Deregister(id string, request Deregistration) error
+	DropAK(id, actor, reason string) error
+	UnblockEK(id, actor, reason string) error
+	Blocklist() ([]BlockedEK, error)
+	AuditLog() ([]AuditEntry, error)
+	DeadLetters() ([]DeadLetter, error)
+	Redeliver(ctx context.Context, id uint64) error
+	CreateEnrollmentToken(request EnrollmentRequest) (string, *EnrollmentToken, error)
+	EnrollmentTokens() ([]EnrollmentToken, error)
+	RevokeEnrollmentToken(id, actor, reason string) error
+	Enroll(token, device string) (*DeviceCredentials, error)
+	PendingRegistrations() ([]ProverStatus, error)
+	ApproveRegistration(id, actor, reason string) error
+	RejectRegistration(id string, request Deregistration) error
+	SetLabels(id, actor, reason string, labels map[string]string) error
 }
 
 type DataVerifier struct {
-	Config    *Config
-	ProversEK map[string]*Prover
-	ProversAK map[string]*Prover
+	Config *Config
+	// Provers keeps the registered provers, the attested ones have an activated AK.
+	Provers ProverStore
+	// Blocks keeps the blocked EKs, Audits the audit log, Letters the failed webhook deliveries and Tokens
+	// the enrollment tokens. SetStore sets them all with Provers.
+	Blocks  BlocklistStore
+	Audits  AuditStore
+	Letters DeadLetterStore
+	Tokens  EnrollmentTokenStore
+	// PendingAKs holds the AKs waiting for credential activation, by EK. It is guarded by pendingMutex.
+	PendingAKs   map[string]*PendingAK
+	pendingMutex sync.Mutex
+	// attestMutexes hold a *sync.Mutex for each prover attested, by EK. They outlive the deregistration of the prover
+	// so that an attestation still running never overlaps with one of the same EK registered again.
+	attestMutexes sync.Map
+	// Scheme is the scheme of the URLs of the provers, https when they serve TLS. It is http when empty.
+	Scheme string
+	// Notifier sends the trust state changes of the provers to the webhooks, it is optional.
+	Notifier *Notifier
+	// CA issues certificates for activated AKs, it is optional.
+	CA *verifierDB.PrivacyCA
+	// EKTrust validates EK certificates, the built-in go-tspi verification is used when nil.
+	EKTrust *verifierDB.EKTrustStore
+	// EventPolicy appraises the firmware event logs of the provers.
+	EventPolicy verifierDB.EventPolicy
+	// IMAPolicy appraises the IMA measurement lists of the provers, they are not verified when nil.
+	IMAPolicy *verifierDB.IMAPolicy
+	// PCRPolicies appraise the quoted PCRs instead of the reference values of /pcrs when set.
+	PCRPolicies verifierDB.PCRPolicies
 }
 
+// PendingAK is an AK submitted by a prover and the secret of the credential it was challenged with.
+// The credential can no longer be activated after Expires.
+type PendingAK struct {
+	AK      tpm.AttestationKey
+	Secret  []byte
+	Expires time.Time
+}
+
+const (
+	// credentialSecretSize is the size of the credential activation secret, TPM 2.0 limits it to the EK name digest size.
+	credentialSecretSize = 32
+	// pendingAKTTL is how long a pending AK waits for its credential activation.
+	pendingAKTTL = 5 * time.Minute
+)
+
+// ErrAKChallengePending is returned by RegisterNewAK while the credential of another AK of the same EK can still be activated.
+var ErrAKChallengePending = errors.New("another attestation key is waiting for activation")
+
 var _ Verifier = (*DataVerifier)(nil) // Verify that *tspiTPM implements TPM.
 
-func NewVerifier(config *Config) *DataVerifier {
-	return &DataVerifier{Config: config, ProversEK: map[string]*Prover{}, ProversAK: map[string]*Prover{}}
-}
+// ErrIMAListRestarted is returned by IMARequest when the offset is past the end of the IMA measurement list of the prover,
+// it restarted with a reboot.
+var ErrIMAListRestarted = errors.New("IMA measurement list restarted")
 
-func (v *DataVerifier) InitParams() InitializationParams {
-	return v.Config.Init
+// NewVerifier returns a verifier keeping its provers in memory, SetStore replaces them by a persistent store.
+func NewVerifier(config *Config) *DataVerifier {
+	v := &DataVerifier{Config: config, PendingAKs: map[string]*PendingAK{}}
+	v.SetStore(NewMemoryStore())
+	return v
 }
 
-func (v *DataVerifier) getProverEK(k *rsa.PublicKey) (*Prover, error) {
-	key := fmt.Sprintf("%v:%v", k.N.String(), k.E)
-	if p, ok := v.ProversEK[key]; ok {
-		return p, nil
-	}
-	return nil, fmt.Errorf("prover not found")
+// SetStore keeps the provers, the blocklist, the audit log, the dead letters and the enrollment tokens in store.
+func (v *DataVerifier) SetStore(store Store) {
+	v.Provers, v.Blocks, v.Audits, v.Letters, v.Tokens = store, store, store, store, store
 }
 
-func (v *DataVerifier) putProverEK(p *Prover) error {
+func (v *DataVerifier) RegisterNewEK(p *Prover) (err error) {
+	defer func() { countRegistration("ek", err) }()
 	if p.EK == nil {
 		return fmt.Errorf("endorsement key not set\n")
 	}
-	key := fmt.Sprintf("%v:%v", p.EK.PublicKey().N.String(), p.EK.PublicKey().E)
-	if _, ok := v.ProversEK[key]; ok {
-		return fmt.Errorf("endorsement key already set\n")
+	if v.EKTrust != nil {
+		if err := v.EKTrust.Verify(p.EK.Certificate()); err != nil {
+			return fmt.Errorf("error verifying EK Certificate: %w", err)
+		}
+	} else if err := p.EK.VerifyEKCert(); err != nil {
+		return fmt.Errorf("error verifying EK Certificate: %v", err)
+	}
+	blocked, err := v.Blocks.Blocked(Fingerprint(p.EK.PublicKey()))
+	if err != nil {
+		return fmt.Errorf("error reading blocklist: %v", err)
+	}
+	if blocked != nil {
+		return fmt.Errorf("error verifying EK Certificate: %w", &verifierDB.EKCertError{Reason: verifierDB.EKBlocked, Err: fmt.Errorf("EK blocked since %v", blocked.Time.Format(time.RFC3339))})
+	}
+	// The AK is only stored once activated, the labels are only set by operators
+	registered := *p
+	registered.AK, registered.Labels = nil, nil
+	if v.Config.RequireApproval {
+		registered.Pending = true
+		registered.setState(StatePending, "EK registered, waiting for approval", time.Now())
+	} else {
+		registered.setState(StateRegistered, "EK registered", time.Now())
+	}
+	err = v.Provers.Add(&registered)
+	if err != nil {
+		return fmt.Errorf("error storing new EK: %w", err)
+	}
+	if registered.Pending {
+		log.Warnf("%v(%v:%v): registration pending approval, EK %v", registered.Name, registered.Endpoint, registered.Port, Fingerprint(registered.EK.PublicKey()))
 	}
-	v.ProversEK[key] = p
+	v.notify(&registered, "", nil)
 	return nil
 }
 
-func (v *DataVerifier) getProverAK(k *rsa.PublicKey) (*Prover, error) {
-	key := fmt.Sprintf("%v:%v", k.N.String(), k.E)
-	if p, ok := v.ProversAK[key]; ok {
-		return p, nil
+// RegisterNewAK challenges the TPM of a registered prover to prove that p.AK lives next to its EK.
+// The AK is only stored once ActivateAK receives the secret of the returned credential, within pendingAKTTL.
+func (v *DataVerifier) RegisterNewAK(p *Prover) (_ *tpm.Credential, err error) {
+	defer func() { countRegistration("ak", err) }()
+	if p.EK == nil {
+		return nil, fmt.Errorf("endorsement key not set\n")
+	}
+	if p.AK == nil {
+		return nil, fmt.Errorf("attestation key not set\n")
+	}
+	newP, err := v.Provers.GetByEK(p.EK.PublicKey())
+	if err != nil {
+		return nil, fmt.Errorf("error retrieving prover: %v", err)
+	}
+	if newP.Revoked {
+		return nil, fmt.Errorf("EK certificate of %v is revoked", newP.Name)
 	}
-	return nil, fmt.Errorf("prover not found")
+	secret := make([]byte, credentialSecretSize)
+	if _, err = rand.Read(secret); err != nil {
+		return nil, fmt.Errorf("error reading random secret: %v", err)
+	}
+	credential, err := tpm.MakeCredential(newP.EK, p.AK, secret)
+	if err != nil {
+		return nil, fmt.Errorf("error making credential: %v", err)
+	}
+	key := keyID(newP.EK.PublicKey())
+	v.pendingMutex.Lock()
+	defer v.pendingMutex.Unlock()
+	// Anyone knowing the EK could otherwise replace the challenge of the prover before it activates its AK
+	if pending, ok := v.PendingAKs[key]; ok && time.Now().Before(pending.Expires) && keyID(pending.AK.PublicKey()) != keyID(p.AK.PublicKey()) {
+		return nil, ErrAKChallengePending
+	}
+	v.PendingAKs[key] = &PendingAK{AK: p.AK, Secret: secret, Expires: time.Now().Add(pendingAKTTL)}
+	return credential, nil
 }
 
-func (v *DataVerifier) putProverAK(p *Prover) error {
-	if p.AK == nil {
-		return fmt.Errorf("attestation key not set\n")
+// ActivateAK stores the AK pending for ek if secret is the one of its credential. A pending AK can only be activated once.
+// It returns the DER certificate of the AK when the privacy CA is enabled, nil otherwise.
+// Activating the AK the prover already registered again only reissues the certificate.
+func (v *DataVerifier) ActivateAK(ek tpm.EndorsementKey, secret []byte) (_ []byte, err error) {
+	defer func() { countRegistration("activate", err) }()
+	if ek == nil {
+		return nil, fmt.Errorf("endorsement key not set\n")
+	}
+	newP, err := v.Provers.GetByEK(ek.PublicKey())
+	if err != nil {
+		return nil, fmt.Errorf("error retrieving prover: %v", err)
 	}
-	key := fmt.Sprintf("%v:%v", p.AK.PublicKey().N.String(), p.AK.PublicKey().E)
-	if _, ok := v.ProversAK[key]; ok {
-		return fmt.Errorf("attestation key already set\n")
+	key := keyID(ek.PublicKey())
+	v.pendingMutex.Lock()
+	pending, ok := v.PendingAKs[key]
+	delete(v.PendingAKs, key)
+	v.pendingMutex.Unlock()
+	if !ok || time.Now().After(pending.Expires) {
+		return nil, fmt.Errorf("no attestation key waiting for activation")
 	}
-	v.ProversAK[key] = p
-	return nil
+	if subtle.ConstantTimeCompare(pending.Secret, secret) != 1 {
+		return nil, fmt.Errorf("credential activation failed: secret mismatch")
+	}
+	// The EK may have been revoked or blocked since the AK was registered
+	if newP.Revoked {
+		return nil, fmt.Errorf("EK certificate of %v is revoked", newP.Name)
+	}
+	blocked, err := v.Blocks.Blocked(Fingerprint(ek.PublicKey()))
+	if err != nil {
+		return nil, fmt.Errorf("error reading blocklist: %v", err)
+	}
+	if blocked != nil {
+		return nil, fmt.Errorf("EK of %v blocked since %v", newP.Name, blocked.Time.Format(time.RFC3339))
+	}
+	if registered, err := v.Provers.GetByAK(pending.AK.PublicKey()); err != nil || keyID(registered.EK.PublicKey()) != key {
+		err = v.Provers.Update(ek.PublicKey(), func(p *Prover) error {
+			if p.Revoked {
+				return fmt.Errorf("EK certificate of %v is revoked", p.Name)
+			}
+			p.AK = pending.AK
+			return nil
+		})
+		if err != nil {
+			return nil, fmt.Errorf("error storing new AK: %v", err)
+		}
+	}
+	newP.AK = pending.AK
+	if v.CA == nil {
+		return nil, nil
+	}
+	cert, err := v.CA.IssueAKCertificate(newP.Name, newP.EK, newP.AK)
+	if err != nil {
+		return nil, fmt.Errorf("error issuing AK certificate: %v", err)
+	}
+	return cert, nil
 }
 
-func (v *DataVerifier) RegisterNewEK(p *Prover) error {
-	if p.EK == nil {
-		return fmt.Errorf("endorsement key not set\n")
+// RefreshRevocations reloads the configured CRLs and checks the EK certificates of the registered provers again.
+// Provers whose EK certificate is revoked are flagged and no longer attested.
+func (v *DataVerifier) RefreshRevocations() {
+	if v.EKTrust == nil {
+		return
 	}
-	if err := p.EK.VerifyEKCert(); err != nil {
-		return fmt.Errorf("error verifying EK Certificate: %v", err)
+	for _, file := range v.Config.EKTrust.CRLFiles {
+		if err := v.EKTrust.LoadCRL(file); err != nil {
+			log.Errorf("error loading CRL: %v", err)
+		}
 	}
-	err := v.putProverEK(p)
+	for _, url := range v.Config.EKTrust.CRLURLs {
+		if err := v.EKTrust.FetchCRL(url); err != nil {
+			log.Errorf("error fetching CRL: %v", err)
+		}
+	}
+	provers, err := v.Provers.List()
 	if err != nil {
-		return fmt.Errorf("error storing new EK: %v", err)
+		log.Errorf("error listing provers: %v", err)
+		return
+	}
+	for _, p := range provers {
+		var ekErr *verifierDB.EKCertError
+		revoked := errors.As(v.EKTrust.Verify(p.EK.Certificate()), &ekErr) && ekErr.Reason == verifierDB.EKRevoked
+		if revoked && !p.Revoked {
+			log.Warnf("%v(%v:%v): EK certificate revoked: %v", p.Name, p.Endpoint, p.Port, ekErr)
+		}
+		if revoked == p.Revoked {
+			continue
+		}
+		var from TrustState
+		var changed *Prover
+		var moved bool
+		err = v.Provers.Update(p.EK.PublicKey(), func(stored *Prover) error {
+			from = stored.State
+			stored.Revoked = revoked
+			switch {
+			case revoked:
+				moved = stored.setState(StateRevoked, ekErr.Error(), time.Now())
+			case stored.Pending:
+				moved = stored.setState(StatePending, "EK certificate no longer revoked", time.Now())
+			default:
+				moved = stored.setState(StateRegistered, "EK certificate no longer revoked", time.Now())
+			}
+			changed = stored.clone()
+			return nil
+		})
+		if err != nil {
+			log.Errorf("%v(%v:%v): error storing revocation: %v", p.Name, p.Endpoint, p.Port, err)
+			continue
+		}
+		if moved {
+			v.notify(changed, from, nil)
+		}
 	}
-	return nil
 }
 
-func (v *DataVerifier) RegisterNewAK(p *Prover) error {
-	if p.EK == nil {
-		return fmt.Errorf("endorsement key not set\n")
+// StartAttestations attests every approved prover with an activated AK once, one after the other.
+// The Scheduler attests them concurrently at their own interval.
+func (v *DataVerifier) StartAttestations() {
+	log.Info("Starting attestations")
+	provers, err := v.Provers.List()
+	if err != nil {
+		log.Errorf("error listing provers: %v", err)
+		return
 	}
-	newP, err := v.getProverEK(p.EK.PublicKey())
+	for _, p := range provers {
+		if p.AK != nil && !p.Pending {
+			_, _ = v.attestProver(p)
+		}
+	}
+}
+
+// attestProver attests p with its PCR policy or with the reference values of /pcrs, moves p to the resulting
+// trust state and stores the results in the history of p. It returns the stored attestation, and an error when p
+// cannot be reached. Attestations of the same prover run one after the other.
+// A prover deregistered, revoked, pending approval or whose AK was dropped meanwhile is not attested,
+// the attestation is then nil and the error tells why.
+func (v *DataVerifier) attestProver(p *Prover) (*Attestation, error) {
+	mutex, _ := v.attestMutexes.LoadOrStore(keyID(p.EK.PublicKey()), &sync.Mutex{})
+	mutex.(*sync.Mutex).Lock()
+	defer mutex.(*sync.Mutex).Unlock()
+	// The IMA state of p is stale when another attestation of p was running.
+	stored, err := v.Provers.GetByEK(p.EK.PublicKey())
 	if err != nil {
-		return fmt.Errorf("error retrieving prover: %v", err)
+		log.Warnf("%v(%v:%v): not attested: %v", p.Name, p.Endpoint, p.Port, err)
+		return nil, fmt.Errorf("error reloading %v: %w", p.Name, err)
+	}
+	p = stored
+	if err = attestable(p); err != nil {
+		log.Warnf("%v(%v:%v): not attested: %v", p.Name, p.Endpoint, p.Port, err)
+		return nil, err
+	}
+	record := &Attestation{Time: time.Now()}
+	if v.PCRPolicies == nil {
+		expectedPCRs, sel := referencePCRs()
+		err = v.attest(p, sel, expectedPCRs, nil, record)
+	} else if policy := v.PCRPolicies.Match(p.Name, p.Labels); policy != nil {
+		err = v.attest(p, policy.Selection(), nil, policy, record)
+	} else {
+		record.fail(p, "not attested, no PCR policy applies")
+	}
+	switch {
+	case err != nil:
+		record.State, record.Reason = StateUnreachable, err.Error()
+	case len(record.Failures) > 0:
+		record.State, record.Reason = StateUntrusted, strings.Join(record.Failures, "; ")
+	default:
+		record.State, record.Reason = StateTrusted, "attestation passed"
+	}
+	attestationsTotal.Inc(string(record.State))
+	if storeErr := v.storeAttestation(p, record); storeErr != nil {
+		log.Errorf("%v(%v:%v): error storing attestation results: %v", p.Name, p.Endpoint, p.Port, storeErr)
 	}
-	newP.AK = p.AK
-	err = v.putProverAK(newP)
+	return record, err
+}
+
+// referencePCRs returns the reference values of /pcrs and the PCRs to quote.
+// Only the PCRs with a reference value are quoted, attest fails when there is none.
+func referencePCRs() ([]tpm.PCR, tpm.PCRSelection) {
+	db := verifierDB.NewFileDB("/pcrs")
+	expectedPCRs, err := db.GetPCRs()
+	if err != nil {
+		log.Errorf("error getting PCRs from DB:  %v", err)
+	}
+	var sel tpm.PCRSelection
+	if len(expectedPCRs) > 0 {
+		sel = tpm.SelectPCRs(expectedPCRs, expectedPCRs[0].Bank)
+	}
+	return expectedPCRs, sel
+}
+
+// storeAttestation stores the attestation results of p and adds record to its history.
+// The rest of the prover may have changed during the attestation, a prover revoked meanwhile stays revoked
+// and the attestation is then neither counted as its last success nor notified.
+func (v *DataVerifier) storeAttestation(p *Prover, record *Attestation) error {
+	var from TrustState
+	var changed *Prover
+	var moved bool
+	err := v.Provers.Update(p.EK.PublicKey(), func(stored *Prover) error {
+		stored.PCRMismatches, stored.PCRPolicy, stored.PCRRules, stored.LastPCRs = p.PCRMismatches, p.PCRPolicy, p.PCRRules, p.LastPCRs
+		stored.IMA = p.IMA
+		from = stored.State
+		moved = stored.setState(record.State, record.Reason, record.Time)
+		changed = stored.clone()
+		return nil
+	})
 	if err != nil {
-		return fmt.Errorf("error storing new AK: %v", err)
+		return err
+	}
+	if err = v.Provers.AddAttestation(p.EK.PublicKey(), record, v.Config.History); err != nil {
+		return err
+	}
+	if !moved {
+		return nil
 	}
+	if record.State == StateTrusted {
+		lastSuccess.Set(float64(record.Time.Unix()), Fingerprint(p.EK.PublicKey()), changed.Name)
+	}
+	v.notify(changed, from, record)
 	return nil
 }
 
-func (v *DataVerifier) StartAttestations() {
-	log.Info("Starting attestations")
-	for _, p := range v.ProversAK {
-		nonce, err := v.GetChallenge()
+// proverURL returns the base URL of the REST API of p.
+func (v *DataVerifier) proverURL(p *Prover) string {
+	scheme := v.Scheme
+	if scheme == "" {
+		scheme = "http"
+	}
+	return fmt.Sprintf("%s://%s", scheme, net.JoinHostPort(p.Endpoint, p.Port))
+}
+
+// attest attests p, it returns an error when p cannot be reached.
+// The firmware event log of p is replayed against the quote when p serves one and its events are appraised once it matches.
+// A log matching the quote is not a reference: the replayed values must still match expectedPCRs or policy, or the event policy must be configured.
+// The log must measure the required PCRs of the event log config, they are quoted whatever the log measures.
+// The quoted values are appraised with policy when it is set. The nonce, the quote and the failed checks are recorded in record.
+func (v *DataVerifier) attest(p *Prover, sel tpm.PCRSelection, expectedPCRs []tpm.PCR, policy *verifierDB.PCRPolicy, record *Attestation) error {
+	baseURL := v.proverURL(p)
+	var eventLog *eventlog.EventLog
+	raw, err := v.EventLogRequest(baseURL + "/eventlog")
+	if err != nil {
+		log.Errorf("%v(%v:%v): error fetching event log: %v", p.Name, p.Endpoint, p.Port, err)
+		return err
+	}
+	if raw != nil {
+		eventLog, err = eventlog.Parse(raw)
 		if err != nil {
-			log.Errorf("error computing challenge: %v", err)
+			record.fail(p, "invalid event log: %v", err)
+			return nil
 		}
-		url := fmt.Sprintf("http://%s:%s/attest", p.Endpoint, p.Port)
-		//_, err = v.AttestationRequest(nonce, url)
-		attestation, err := v.AttestationRequest(nonce, url)
-		if err != nil {
-			log.Errorf("error attesting %v(%v) on URL %v:  %v", p.Name, p.Endpoint, url, err)
-			continue
+		if policy == nil && len(expectedPCRs) == 0 && !v.EventPolicy.Configured() {
+			record.fail(p, "event log not appraised: %v", verifierDB.ErrNoEventPolicy)
+			return nil
 		}
-		err = attestation.Verify(p.AK, nonce)
+		// The required PCRs are quoted even when the log leaves them out, the replay must then account for them
+		pcrs := mergePCRs(mergePCRs(eventLog.PCRs(), v.Config.EventLog.requiredPCRs()), sel.PCRs)
+		sel = tpm.PCRSelection{Bank: sel.Bank, PCRs: pcrs}
+	}
+	if eventLog == nil && policy == nil && len(expectedPCRs) == 0 {
+		record.fail(p, "not attested, no reference PCR values")
+		return nil
+	}
+	if v.IMAPolicy != nil && len(sel.PCRs) > 0 {
+		sel.PCRs = mergePCRs(sel.PCRs, []int{ima.PCR})
+	}
+	nonce, err := v.GetChallenge()
+	if err != nil {
+		log.Errorf("error computing challenge: %v", err)
+	}
+	record.Nonce = nonce
+	url := baseURL + "/attest"
+	attestation, err := v.AttestationRequest(nonce, sel, url)
+	if err != nil {
+		log.Errorf("error attesting %v(%v) on URL %v:  %v", p.Name, p.Endpoint, url, err)
+		return err
+	}
+	if record.Quote, err = tpm.SerializeQuote(attestation); err != nil {
+		log.Errorf("%v(%v:%v): error encoding quote: %v", p.Name, p.Endpoint, p.Port, err)
+	}
+	start := time.Now()
+	err = attestation.Verify(p.AK, nonce)
+	quoteVerificationSeconds.Observe(time.Since(start).Seconds())
+	validQuote := err == nil
+	if err != nil {
+		record.fail(p, "Invalid Quote: %v", err)
+	} else {
+		log.Infof("%v(%v:%v): Valid Quote covering %v PCRs %v", p.Name, p.Endpoint, p.Port, attestation.Selection().Bank, attestation.Selection().PCRs)
+	}
+	var referenceErr error
+	if eventLog != nil {
+		bank := attestation.Selection().Bank
+		replayed, err := eventLog.Replay(bank)
 		if err != nil {
-			log.Errorf("%v(%v:%v): Invalid Quote: %v", p.Name, p.Endpoint, p.Port, err)
+			record.fail(p, "error replaying event log: %v", err)
+			return nil
+		}
+		if missing := missingPCRs(replayed, v.Config.EventLog.requiredPCRs()); len(missing) > 0 {
+			record.fail(p, "event log does not measure the required PCRs %v", missing)
+			return nil
+		}
+		// The replayed values stand for the quoted ones once they match the quote, the reference values still apply to them.
+		// The quoted values of the PCRs the log does not measure are compared to their reference value directly.
+		measured := tpm.SelectPCRs(replayed, bank).PCRs
+		for _, pcr := range tpm.FilterBank(expectedPCRs, bank) {
+			if !containsPCR(measured, pcr.Id) {
+				replayed = append(replayed, pcr)
+			}
+		}
+		referenceErr = dropPCRs(tpm.ComparePCRs(tpm.FilterBank(expectedPCRs, bank), replayed), measured)
+		expectedPCRs = replayed
+	}
+	err = dropPCRs(attestation.VerifyPCRs(expectedPCRs), v.appraisedPCRs(policy))
+	var mismatchErr *tpm.PCRMismatchError
+	// The quoted values were checked against the quote digest unless VerifyPCRs failed with another error.
+	trustedValues := validQuote && (err == nil || errors.As(err, &mismatchErr))
+	if policy != nil {
+		p.PCRPolicy, p.PCRRules = policy.Name, nil
+		if trustedValues {
+			v.appraisePCRs(p, policy, attestation, record)
+		}
+	}
+	if v.IMAPolicy != nil && trustedValues {
+		v.attestIMA(p, baseURL, attestation, record)
+	}
+	p.PCRMismatches = nil
+	if errors.As(err, &mismatchErr) {
+		p.PCRMismatches = mismatchErr.Mismatches
+		if eventLog != nil {
+			log.Errorf("%v(%v:%v): Event log does not match the quote: %d PCRs differ", p.Name, p.Endpoint, p.Port, len(mismatchErr.Mismatches))
 		} else {
-			log.Infof("%v(%v:%v): Valid Quote", p.Name, p.Endpoint, p.Port)
+			log.Errorf("%v(%v:%v): Illegitimate PCR state: %d PCRs differ", p.Name, p.Endpoint, p.Port, len(mismatchErr.Mismatches))
+		}
+		for _, m := range mismatchErr.Mismatches {
+			record.fail(p, "%v", m)
+		}
+		return nil
+	} else if err != nil {
+		record.fail(p, "Illegitimate PCR state: %v", err)
+		return nil
+	}
+	if eventLog == nil {
+		if policy == nil {
+			log.Infof("%v(%v:%v): Valid PCR state", p.Name, p.Endpoint, p.Port)
+		}
+		return nil
+	}
+	if errors.As(referenceErr, &mismatchErr) {
+		p.PCRMismatches = mismatchErr.Mismatches
+		log.Errorf("%v(%v:%v): Illegitimate PCR state: %d PCRs differ", p.Name, p.Endpoint, p.Port, len(mismatchErr.Mismatches))
+		for _, m := range mismatchErr.Mismatches {
+			record.fail(p, "%v", m)
+		}
+		return nil
+	}
+	err = v.EventPolicy.Appraise(eventLog, attestation.Selection().Bank)
+	var appraisalErr *verifierDB.EventAppraisalError
+	if errors.As(err, &appraisalErr) {
+		log.Errorf("%v(%v:%v): Illegitimate boot: %d events rejected", p.Name, p.Endpoint, p.Port, len(appraisalErr.Failures))
+		for _, f := range appraisalErr.Failures {
+			record.fail(p, "%v", f)
+		}
+	} else if err != nil {
+		record.fail(p, "error appraising event log: %v", err)
+	} else {
+		log.Infof("%v(%v:%v): Valid PCR state, event log of %d events accepted", p.Name, p.Endpoint, p.Port, len(eventLog.Events))
+	}
+	return nil
+}
+
+// attestIMA replays the IMA measurement list of p against the quoted PCR 10 and appraises the new entries.
+// Only the entries added since the last attestation are fetched, the whole list is verified again
+// when they no longer extend the PCR or the list is shorter than the entries already verified, after a reboot of p.
+func (v *DataVerifier) attestIMA(p *Prover, baseURL string, attestation tpm.Quote, record *Attestation) {
+	bank := attestation.Selection().Bank
+	var quoted []byte
+	for _, pcr := range attestation.PCRValues() {
+		if pcr.Id == ima.PCR && pcr.Bank.Equal(bank) {
+			quoted = pcr.Value
+		}
+	}
+	if quoted == nil {
+		record.fail(p, "PCR %d not quoted, IMA measurement list not verified", ima.PCR)
+		return
+	}
+	if !p.IMA.Bank.Equal(bank) {
+		p.IMA = IMAState{Bank: bank}
+	}
+	for {
+		value := p.IMA.PCR
+		if value == nil {
+			value = make([]byte, bank.Size())
+		}
+		entries, err := v.IMARequest(baseURL+"/ima", p.IMA.Offset)
+		if errors.Is(err, ErrIMAListRestarted) && p.IMA.Offset > 0 {
+			log.Warnf("%v(%v:%v): IMA measurement list shorter than %d entries, verifying it again", p.Name, p.Endpoint, p.Port, p.IMA.Offset)
+			p.IMA = IMAState{Bank: bank}
+			continue
 		}
-		db := FileDB{filepath: "/pcrs"}
-		expectedPCRs, err := db.GetPCRs()
 		if err != nil {
-			log.Errorf("error getting PCRs from DB:  %v", err)
+			record.fail(p, "error fetching IMA measurement list: %v", err)
+			return
 		}
-		err = attestation.VerifyPCRs(expectedPCRs)
+		n, err := ima.Match(entries, value, quoted, bank)
 		if err != nil {
-			log.Errorf("%v(%v:%v): Illegitimate PCR state: %v", p.Name, p.Endpoint, p.Port, err)
+			record.fail(p, "error replaying IMA measurement list: %v", err)
+			return
+		}
+		if n < 0 && p.IMA.Offset > 0 {
+			log.Warnf("%v(%v:%v): IMA measurement list restarted, verifying it again", p.Name, p.Endpoint, p.Port)
+			p.IMA = IMAState{Bank: bank}
+			continue
+		}
+		if n < 0 {
+			record.fail(p, "IMA measurement list does not match PCR %d", ima.PCR)
+			return
+		}
+		var findings []verifierDB.IMAFinding
+		for _, finding := range v.IMAPolicy.Appraise(entries[:n]) {
+			if !containsFinding(p.IMA.Findings, finding) {
+				p.IMA.Findings = append(p.IMA.Findings, finding)
+				findings = append(findings, finding)
+			}
+		}
+		p.IMA.Offset += n
+		p.IMA.PCR = quoted
+		for _, f := range findings {
+			log.Errorf("%v(%v:%v): IMA: %v", p.Name, p.Endpoint, p.Port, f)
+		}
+		// The findings stay until the list restarts, only the new ones are logged.
+		for _, f := range p.IMA.Findings {
+			record.Failures = append(record.Failures, fmt.Sprintf("IMA: %v", f))
+		}
+		if len(p.IMA.Findings) > 0 {
+			log.Errorf("%v(%v:%v): Illegitimate runtime state: %d files rejected in %d IMA entries", p.Name, p.Endpoint, p.Port, len(p.IMA.Findings), p.IMA.Offset)
 		} else {
-			log.Infof("%v(%v:%v): Valid PCR state", p.Name, p.Endpoint, p.Port)
+			log.Infof("%v(%v:%v): Valid runtime state, %d IMA entries accepted", p.Name, p.Endpoint, p.Port, p.IMA.Offset)
+		}
+		return
+	}
+}
+
+// appraisePCRs evaluates policy on the values quoted by p and records the result of each rule.
+func (v *DataVerifier) appraisePCRs(p *Prover, policy *verifierDB.PCRPolicy, attestation tpm.Quote, record *Attestation) {
+	values := attestation.PCRValues()
+	p.PCRRules = policy.Evaluate(values, p.LastPCRs)
+	if !verifierDB.PCRRulesPassed(p.PCRRules) {
+		failed := 0
+		for _, r := range p.PCRRules {
+			if !r.Passed {
+				failed++
+				record.fail(p, "PCR policy %v: %v", policy.Name, r)
+			}
+		}
+		log.Errorf("%v(%v:%v): Illegitimate PCR state: %d of %d rules of PCR policy %v failed", p.Name, p.Endpoint, p.Port, failed, len(p.PCRRules), policy.Name)
+		return
+	}
+	p.LastPCRs = values
+	log.Infof("%v(%v:%v): Valid PCR state, PCR policy %v passed", p.Name, p.Endpoint, p.Port, policy.Name)
+}
+
+// appraisedPCRs returns the PCRs whose quoted values are appraised without a reference value: the PCRs of the rules
+// of policy, the ones it ignores included, and the PCR of the IMA measurement list.
+func (v *DataVerifier) appraisedPCRs(policy *verifierDB.PCRPolicy) []int {
+	var ids []int
+	if policy != nil {
+		for _, rule := range policy.Rules {
+			ids = append(ids, rule.PCR)
+		}
+	}
+	if v.IMAPolicy != nil {
+		ids = append(ids, ima.PCR)
+	}
+	return ids
+}
+
+// dropPCRs removes from the mismatches of err the quoted PCRs of ids without a reference value.
+// It returns nil when no mismatch remains.
+func dropPCRs(err error, ids []int) error {
+	var mismatchErr *tpm.PCRMismatchError
+	if !errors.As(err, &mismatchErr) {
+		return err
+	}
+	var mismatches []tpm.PCRMismatch
+	for _, m := range mismatchErr.Mismatches {
+		if m.Expected != nil || !containsPCR(ids, m.Id) {
+			mismatches = append(mismatches, m)
+		}
+	}
+	if len(mismatches) == 0 {
+		return nil
+	}
+	return &tpm.PCRMismatchError{Mismatches: mismatches}
+}
+
+func containsPCR(ids []int, id int) bool {
+	for _, i := range ids {
+		if i == id {
+			return true
 		}
 	}
+	return false
 }
 
-func (v *DataVerifier) AttestationRequest(nonce []byte, url string) (tpm.Quote, error) {
+// missingPCRs returns the PCRs of required that are not in pcrs.
+func missingPCRs(pcrs []tpm.PCR, required []int) []int {
+	var ids, missing []int
+	for _, pcr := range pcrs {
+		ids = append(ids, pcr.Id)
+	}
+	for _, id := range required {
+		if !containsPCR(ids, id) {
+			missing = append(missing, id)
+		}
+	}
+	return missing
+}
+
+// mergePCRs returns the sorted union of a and b.
+func mergePCRs(a, b []int) []int {
+	seen := map[int]bool{}
+	var merged []int
+	for _, id := range append(append([]int{}, a...), b...) {
+		if !seen[id] {
+			seen[id] = true
+			merged = append(merged, id)
+		}
+	}
+	sort.Ints(merged)
+	return merged
+}
+
+func containsFinding(findings []verifierDB.IMAFinding, finding verifierDB.IMAFinding) bool {
+	for _, f := range findings {
+		if f.Equal(finding) {
+			return true
+		}
+	}
+	return false
+}
+
+// AttestationRequest asks the prover at url to quote the PCRs of sel, every PCR of its configured bank when sel is empty.
+func (v *DataVerifier) AttestationRequest(nonce []byte, sel tpm.PCRSelection, url string) (tpm.Quote, error) {
 	if len(nonce) == 0 {
 		return nil,fmt.Errorf("empty nonce")
 	}
-	body := struct{ Nonce []byte }{Nonce: nonce}
+	body := struct {
+		Nonce        []byte
+		PCRSelection tpm.PCRSelection
+	}{Nonce: nonce, PCRSelection: sel}
 	jsonBody, err := json.Marshal(body)
 	if err != nil {
 		return nil, err
@@ -170,6 +769,22 @@ func (v *DataVerifier) AttestationRequest(nonce []byte, url string) (tpm.Quote,
 	return attestation, nil
 }
 
+// EventLogRequest fetches the binary firmware event log served by the prover at url, nil if the prover has none.
+func (v *DataVerifier) EventLogRequest(url string) ([]byte, error) {
+	r, err := httpClient.Client.Get(url)
+	if err != nil {
+		return nil, err
+	}
+	defer r.Body.Close()
+	if r.StatusCode == http.StatusNotFound {
+		return nil, nil
+	}
+	if r.StatusCode != http.StatusOK {
+		return nil, errors.New(r.Status)
+	}
+	return ioutil.ReadAll(r.Body)
+}
+
 func (v *DataVerifier) GetChallenge() ([]byte, error) {
 	nonce := [8]byte{}
 	_, err := rand.Read(nonce[:])
@@ -178,3 +793,27 @@ func (v *DataVerifier) GetChallenge() ([]byte, error) {
 	}
 	return nonce[:], nil
 }
+
+// IMARequest fetches the entries of the IMA measurement list served by the prover at url from offset,
+// nil if the prover does not serve it. ErrIMAListRestarted is returned when the list is shorter than offset.
+func (v *DataVerifier) IMARequest(url string, offset int) ([]ima.Entry, error) {
+	r, err := httpClient.Client.Get(url + "?offset=" + strconv.Itoa(offset))
+	if err != nil {
+		return nil, err
+	}
+	defer r.Body.Close()
+	if r.StatusCode == http.StatusNotFound {
+		return nil, nil
+	}
+	if r.StatusCode == http.StatusRequestedRangeNotSatisfiable {
+		return nil, ErrIMAListRestarted
+	}
+	if r.StatusCode != http.StatusOK {
+		return nil, errors.New(r.Status)
+	}
+	var entries []ima.Entry
+	if err = json.NewDecoder(r.Body).Decode(&entries); err != nil {
+		return nil, fmt.Errorf("error decoding IMA entries: %v", err)
+	}
+	return entries, nil
+}
@@ -2,56 +2,61 @@ package verifier_test
 
 import (
 	"bytes"
+	"crypto/rand"
 	"crypto/rsa"
+	"crypto/x509"
+	"crypto/x509/pkix"
 	"encoding/json"
 	"fmt"
+	ctx509 "github.com/google/certificate-transparency-go/x509"
 	"github.com/google/go-cmp/cmp"
 	"github.com/xcaliburne/RemoteAttestations/internal/verifier"
 	"github.com/xcaliburne/RemoteAttestations/internal/verifier/tests/fakes"
 	"github.com/xcaliburne/RemoteAttestations/pkg/httpClient"
 	"github.com/xcaliburne/RemoteAttestations/pkg/httpClient/tests/mocks"
+	"github.com/xcaliburne/RemoteAttestations/pkg/ima"
 	"github.com/xcaliburne/RemoteAttestations/pkg/tests"
 	"github.com/xcaliburne/RemoteAttestations/pkg/tpm"
 	tpmFakes "github.com/xcaliburne/RemoteAttestations/pkg/tpm/tests/fakes"
 	tpmMocks "github.com/xcaliburne/RemoteAttestations/pkg/tpm/tests/mocks"
+	verifierDB "github.com/xcaliburne/RemoteAttestations/pkg/verifier"
 	"io/ioutil"
+	"math/big"
 	"net/http"
+	"path/filepath"
+	"strings"
 	"testing"
+	"time"
 )
 
-var config = &verifier.Config{
-	Init: verifier.InitializationParams{
-		OwnerPassword: "",
-		UserPassword:  "",
-	},
-}
+var config = &verifier.Config{}
 
-func TestNewVerifier(t *testing.T) {
-	want := &verifier.DataVerifier{
-		Config:    config,
-		ProversEK: map[string]*verifier.Prover{},
-		ProversAK: map[string]*verifier.Prover{},
+// activatedProvers returns the number of stored provers with an activated AK.
+func activatedProvers(t *testing.T, v *verifier.DataVerifier) int {
+	provers, err := v.Provers.List()
+	if err != nil {
+		t.Fatalf("List() returned an error: %v", err)
+	}
+	n := 0
+	for _, p := range provers {
+		if p.AK != nil {
+			n++
+		}
 	}
+	return n
+}
 
+func TestNewVerifier(t *testing.T) {
 	got := verifier.NewVerifier(config)
-	//Check if got and want are deeply equals
-	if !cmp.Equal(got, want) {
-		t.Errorf(tests.Failure(t, got, want, ""))
-	}
-	if len(got.ProversAK) != 0 {
-		t.Errorf("proversEK map is not empty")
+	if got.Config != config {
+		t.Errorf(tests.Failure(t, got.Config, config, ""))
 	}
-	if len(got.ProversEK) != 0 {
-		t.Errorf("proversEK map is not empty")
+	if len(got.PendingAKs) != 0 {
+		t.Errorf("PendingAKs map is not empty")
 	}
-}
-
-func TestDataVerifier_InitParams(t *testing.T) {
-	v := verifier.NewVerifier(config)
-	want := v.Config.Init
-	got := v.InitParams()
-	if !cmp.Equal(got, want) {
-		t.Error(tests.Failure(t, got, want, ""))
+	provers, err := got.Provers.List()
+	if err != nil || len(provers) != 0 {
+		t.Errorf("prover store is not empty")
 	}
 }
 
@@ -64,6 +69,7 @@ func TestDataVerifier_AttestationRequest(t *testing.T) {
 	var testSuite = []struct {
 		name    string
 		input   []byte
+		sel     tpm.PCRSelection
 		mock    mocks.MockHttpClient
 		want    tpm.Quote
 		wantErr error
@@ -82,6 +88,28 @@ func TestDataVerifier_AttestationRequest(t *testing.T) {
 			want:    tpmFakes.GetFakeQuote(),
 			wantErr: nil,
 		},
+		{
+			name:  "PCR selection",
+			input: fakes.GetFakeNonce(),
+			sel:   tpm.PCRSelection{Bank: tpm.SHA256, PCRs: []int{0, 7}},
+			mock: mocks.MockHttpClient{
+				CatchPost: func(url string, contentType string, body []byte) (*http.Response, error) {
+					query := struct{ PCRSelection tpm.PCRSelection }{}
+					if err := json.Unmarshal(body, &query); err != nil {
+						return nil, err
+					}
+					if !cmp.Equal(query.PCRSelection, tpm.PCRSelection{Bank: tpm.SHA256, PCRs: []int{0, 7}}) {
+						return nil, fmt.Errorf("unexpected selection %v", query.PCRSelection)
+					}
+					return &http.Response{
+						StatusCode: http.StatusOK,
+						Body:       ioutil.NopCloser(bytes.NewReader(jsonQuote)),
+					}, nil
+				},
+			},
+			want:    tpmFakes.GetFakeQuote(),
+			wantErr: nil,
+		},
 		{
 			name:  "invalid nonce",
 			input: []byte(""),
@@ -154,7 +182,7 @@ func TestDataVerifier_AttestationRequest(t *testing.T) {
 	for _, test := range testSuite {
 		t.Run(test.name, func(t *testing.T) {
 			httpClient.Client = &test.mock
-			got, gotErr := v.AttestationRequest(test.input, "127.0.0.1")
+			got, gotErr := v.AttestationRequest(test.input, test.sel, "127.0.0.1")
 			if test.wantErr == nil && gotErr != nil {
 				t.Error(tests.Failure(t, gotErr, test.wantErr, ""))
 			} else if test.wantErr != nil && gotErr == nil {
@@ -167,6 +195,85 @@ func TestDataVerifier_AttestationRequest(t *testing.T) {
 	}
 }
 
+func TestDataVerifier_EventLogRequest(t *testing.T) {
+	v := verifier.NewVerifier(config)
+	response := func(status int, body string) func(url string) (*http.Response, error) {
+		return func(url string) (*http.Response, error) {
+			return &http.Response{StatusCode: status, Status: http.StatusText(status), Body: ioutil.NopCloser(strings.NewReader(body))}, nil
+		}
+	}
+	var testSuite = []struct {
+		name    string
+		mock    mocks.MockHttpClient
+		want    []byte
+		wantErr bool
+	}{
+		{name: "event log", mock: mocks.MockHttpClient{CatchGet: response(http.StatusOK, "event log")}, want: []byte("event log")},
+		{name: "prover without event log", mock: mocks.MockHttpClient{CatchGet: response(http.StatusNotFound, "no event log")}, want: nil},
+		{name: "server returns error", mock: mocks.MockHttpClient{CatchGet: response(http.StatusInternalServerError, "")}, wantErr: true},
+		{
+			name: "error occurred during communication with server",
+			mock: mocks.MockHttpClient{CatchGet: func(url string) (*http.Response, error) {
+				return nil, fmt.Errorf("some error")
+			}},
+			wantErr: true,
+		},
+	}
+	for _, test := range testSuite {
+		t.Run(test.name, func(t *testing.T) {
+			httpClient.Client = &test.mock
+			got, gotErr := v.EventLogRequest("127.0.0.1/eventlog")
+			if (gotErr != nil) != test.wantErr {
+				t.Error(tests.Failure(t, gotErr, test.wantErr, ""))
+			}
+			if !cmp.Equal(got, test.want) {
+				t.Error(tests.Failure(t, got, test.want, ""))
+			}
+		})
+	}
+}
+
+func TestDataVerifier_IMARequest(t *testing.T) {
+	v := verifier.NewVerifier(config)
+	entries := []ima.Entry{{PCR: ima.PCR, TemplateName: "ima-ng", FileHashAlg: "sha256", Path: "/usr/bin/bash"}}
+	jsonEntries, err := json.Marshal(entries)
+	if err != nil {
+		t.Fatal(err)
+	}
+	response := func(status int, body []byte) func(url string) (*http.Response, error) {
+		return func(url string) (*http.Response, error) {
+			if !strings.HasSuffix(url, "/ima?offset=2") {
+				return nil, fmt.Errorf("unexpected url %v", url)
+			}
+			return &http.Response{StatusCode: status, Status: http.StatusText(status), Body: ioutil.NopCloser(bytes.NewReader(body))}, nil
+		}
+	}
+	var testSuite = []struct {
+		name    string
+		mock    mocks.MockHttpClient
+		want    []ima.Entry
+		wantErr bool
+	}{
+		{name: "entries from offset", mock: mocks.MockHttpClient{CatchGet: response(http.StatusOK, jsonEntries)}, want: entries},
+		{name: "prover without IMA", mock: mocks.MockHttpClient{CatchGet: response(http.StatusNotFound, nil)}, want: nil},
+		{name: "list restarted", mock: mocks.MockHttpClient{CatchGet: response(http.StatusRequestedRangeNotSatisfiable, nil)}, wantErr: true},
+		{name: "server returns error", mock: mocks.MockHttpClient{CatchGet: response(http.StatusInternalServerError, nil)}, wantErr: true},
+		{name: "server returns bad json", mock: mocks.MockHttpClient{CatchGet: response(http.StatusOK, []byte("{some bad json"))}, wantErr: true},
+	}
+	for _, test := range testSuite {
+		t.Run(test.name, func(t *testing.T) {
+			httpClient.Client = &test.mock
+			got, gotErr := v.IMARequest("127.0.0.1/ima", 2)
+			if (gotErr != nil) != test.wantErr {
+				t.Error(tests.Failure(t, gotErr, test.wantErr, ""))
+			}
+			if !cmp.Equal(got, test.want) {
+				t.Error(tests.Failure(t, got, test.want, ""))
+			}
+		})
+	}
+}
+
 func TestDataVerifier_RegisterNewEK(t *testing.T) {
 	v := verifier.NewVerifier(config)
 	pkValid := tpmFakes.GetFakeEndorsementKeyValid().PublicKey()
@@ -251,10 +358,30 @@ func TestDataVerifier_RegisterNewEK(t *testing.T) {
 	}
 }
 
-func TestDataVerifier_RegisterNewAK(t *testing.T) {
-	v := verifier.NewVerifier(config)
-	pkValid := tpmFakes.GetFakeEndorsementKeyValid().PublicKey()
-	p := &verifier.Prover{
+// softwareProver returns a prover whose keys come from a fresh software TPM, so that credentials can be activated.
+func softwareProver(t *testing.T) (*verifier.Prover, tpm.TPM) {
+	swTPM, err := tpm.OpenSoftware(t.TempDir())
+	if err != nil {
+		t.Fatalf("OpenSoftware() returned an error: %v", err)
+	}
+	if err = swTPM.TakeOwnership("owner", "user"); err != nil {
+		t.Fatalf("TakeOwnership() returned an error: %v", err)
+	}
+	if err = swTPM.ProveOwnership("owner"); err != nil {
+		t.Fatalf("ProveOwnership() returned an error: %v", err)
+	}
+	if err = swTPM.ProveUsership("user"); err != nil {
+		t.Fatalf("ProveUsership() returned an error: %v", err)
+	}
+	ek, err := swTPM.GetEK()
+	if err != nil {
+		t.Fatalf("GetEK() returned an error: %v", err)
+	}
+	ak, err := swTPM.CreateAK()
+	if err != nil {
+		t.Fatalf("CreateAK() returned an error: %v", err)
+	}
+	return &verifier.Prover{
 		Name:     "test",
 		Endpoint: "0.0.0.0",
 		Port:     "80",
@@ -262,95 +389,400 @@ func TestDataVerifier_RegisterNewAK(t *testing.T) {
 			CatchVerifyEKCert: func() error {
 				return nil
 			},
-			CatchPublicKey: func() *rsa.PublicKey {
-				return pkValid
-			},
-		},
-		AK: &tpmMocks.MockAttestationKey{
-			CatchPublicKey: func() *rsa.PublicKey {
-				return pkValid
-			},
+			CatchPublicKey:   ek.PublicKey,
+			CatchCertificate: ek.Certificate,
 		},
+		AK: ak,
+	}, swTPM
+}
+
+func TestDataVerifier_RegisterNewAK(t *testing.T) {
+	v := verifier.NewVerifier(config)
+	p, _ := softwareProver(t)
+	other, _ := softwareProver(t)
+	// challenge leaves a challenge of p.AK pending, expired when expires is in the past
+	challenge := func(expires time.Time) func() {
+		return func() {
+			v.RegisterNewEK(p)
+			if _, err := v.RegisterNewAK(p); err != nil {
+				t.Fatalf("RegisterNewAK() returned an error: %v", err)
+			}
+			for _, pending := range v.PendingAKs {
+				pending.Expires = expires
+			}
+		}
 	}
+	reset := func() { v.SetStore(verifier.NewMemoryStore()); v.PendingAKs = map[string]*verifier.PendingAK{} }
 	var testSuite = []struct {
 		name    string
 		init    func()
 		cleanup func()
 		input   *verifier.Prover
-		want    error
+		wantErr bool
 	}{
 		{
-			name:  "correct use",
-			init:  func() { v.RegisterNewEK(p) },
-			cleanup: func() { v.ProversEK, v.ProversAK = map[string]*verifier.Prover{}, map[string]*verifier.Prover{} },
-			input: p,
-			want:  nil,
+			name:    "correct use",
+			init:    func() { v.RegisterNewEK(p) },
+			cleanup: func() { v.SetStore(verifier.NewMemoryStore()); v.PendingAKs = map[string]*verifier.PendingAK{} },
+			input:   p,
+			wantErr: false,
 		},
 		{
-			name:  "reinsert same key",
-			init:  func() {
-				v.RegisterNewEK(p)
-				v.RegisterNewAK(p)
-			},
-			cleanup: func() { v.ProversEK, v.ProversAK = map[string]*verifier.Prover{}, map[string]*verifier.Prover{} },
-			input: p,
-			want:  fmt.Errorf("some error"),
+			name:    "same AK while a challenge is pending",
+			init:    challenge(time.Now().Add(time.Minute)),
+			cleanup: reset,
+			input:   p,
+			wantErr: false,
 		},
 		{
-			name:  "unable to retrieve prover",
-			init:  func() {},
+			name:    "other AK while a challenge is pending",
+			init:    challenge(time.Now().Add(time.Minute)),
+			cleanup: reset,
+			input:   &verifier.Prover{Name: "test", Endpoint: "0.0.0.0", Port: "80", EK: p.EK, AK: other.AK},
+			wantErr: true,
+		},
+		{
+			name:    "other AK once the challenge expired",
+			init:    challenge(time.Now().Add(-time.Minute)),
+			cleanup: reset,
+			input:   &verifier.Prover{Name: "test", Endpoint: "0.0.0.0", Port: "80", EK: p.EK, AK: other.AK},
+			wantErr: false,
+		},
+		{
+			name:    "unable to retrieve prover",
+			init:    func() {},
 			cleanup: func() {},
-			input: p,
-			want:  fmt.Errorf("some error"),
+			input:   p,
+			wantErr: true,
 		},
 		{
-			name: "EK is nil",
-			init: func() {v.RegisterNewEK(p)},
-			cleanup: func() { v.ProversEK, v.ProversAK = map[string]*verifier.Prover{}, map[string]*verifier.Prover{} },
-			input: &verifier.Prover{
-				Name:     "test",
-				Endpoint: "0.0.0.0",
-				Port:     "80",
-				EK:       nil,
-				AK: &tpmMocks.MockAttestationKey{
-					CatchPublicKey: func() *rsa.PublicKey {
-						return pkValid
-					},
-				},
-			},
-			want: fmt.Errorf("some error"),
+			name:    "EK is nil",
+			init:    func() { v.RegisterNewEK(p) },
+			cleanup: func() { v.SetStore(verifier.NewMemoryStore()) },
+			input:   &verifier.Prover{Name: "test", Endpoint: "0.0.0.0", Port: "80", EK: nil, AK: p.AK},
+			wantErr: true,
+		},
+		{
+			name:    "AK is nil",
+			init:    func() { v.RegisterNewEK(p) },
+			cleanup: func() { v.SetStore(verifier.NewMemoryStore()) },
+			input:   &verifier.Prover{Name: "test", Endpoint: "0.0.0.0", Port: "80", EK: p.EK, AK: nil},
+			wantErr: true,
 		},
 		{
-			name: "AK is nil",
-			init: func() {v.RegisterNewEK(p)},
-			cleanup: func() { v.ProversEK, v.ProversAK = map[string]*verifier.Prover{}, map[string]*verifier.Prover{} },
+			name:    "AK public area does not match its public key",
+			init:    func() { v.RegisterNewEK(p) },
+			cleanup: func() { v.SetStore(verifier.NewMemoryStore()) },
 			input: &verifier.Prover{
 				Name:     "test",
 				Endpoint: "0.0.0.0",
 				Port:     "80",
-				EK: &tpmMocks.MockEndorsementKey{
-					CatchVerifyEKCert: func() error {
-						return nil
-					},
-					CatchPublicKey: func() *rsa.PublicKey {
-						return pkValid
-					},
+				EK:       p.EK,
+				AK: &tpm.AttestationKeyData{
+					PK: tpmFakes.GetFakeEndorsementKeyValid().PublicKey(),
+					B:  p.AK.Blob(),
+					P:  p.AK.PublicArea(),
+					V:  p.AK.TPMVersion(),
 				},
-				AK: nil,
 			},
-			want: fmt.Errorf("some error"),
+			wantErr: true,
 		},
 	}
 
 	for _, test := range testSuite {
 		t.Run(test.name, func(t *testing.T) {
 			test.init()
-			got := v.RegisterNewAK(test.input)
-			if test.want == nil && got != nil {
-				t.Error(tests.Failure(t, got, test.want, ""))
-			} else if test.want != nil && got == nil {
-				t.Error(tests.Failure(t, got, test.want, ""))
+			defer test.cleanup()
+			got, gotErr := v.RegisterNewAK(test.input)
+			if (gotErr != nil) != test.wantErr {
+				t.Error(tests.Failure(t, gotErr, test.wantErr, ""))
+			}
+			if !test.wantErr && got == nil {
+				t.Error(tests.Failure(t, got, "credential", ""))
+			}
+			if got := activatedProvers(t, v); got != 0 {
+				t.Error(tests.Failure(t, got, 0, "AK stored before activation"))
+			}
+		})
+	}
+}
+
+// newPrivacyCA returns a privacy CA with a self-signed root.
+func newPrivacyCA(t *testing.T) *verifierDB.PrivacyCA {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("GenerateKey() returned an error: %v", err)
+	}
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "Privacy CA"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		KeyUsage:              x509.KeyUsageCertSign,
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+	raw, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatalf("CreateCertificate() returned an error: %v", err)
+	}
+	cert, err := x509.ParseCertificate(raw)
+	if err != nil {
+		t.Fatalf("ParseCertificate() returned an error: %v", err)
+	}
+	return verifierDB.NewPrivacyCA(cert, key, time.Hour)
+}
+
+func TestDataVerifier_ActivateAK(t *testing.T) {
+	v := verifier.NewVerifier(config)
+	p, swTPM := softwareProver(t)
+	if err := v.RegisterNewEK(p); err != nil {
+		t.Fatalf("RegisterNewEK() returned an error: %v", err)
+	}
+	ca := newPrivacyCA(t)
+	// challenge registers p.AK and returns the secret recovered by the prover TPM.
+	challenge := func() []byte {
+		credential, err := v.RegisterNewAK(p)
+		if err != nil {
+			t.Fatalf("RegisterNewAK() returned an error: %v", err)
+		}
+		secret, err := swTPM.ActivateCredential(p.AK, credential)
+		if err != nil {
+			t.Fatalf("ActivateCredential() returned an error: %v", err)
+		}
+		return secret
+	}
+	var testSuite = []struct {
+		name     string
+		ca       *verifierDB.PrivacyCA
+		secret   func() []byte
+		wantErr  bool
+		wantAKs  int
+		wantCert bool
+	}{
+		{
+			name:    "nothing to activate",
+			secret:  func() []byte { return []byte("secret") },
+			wantErr: true,
+			wantAKs: 0,
+		},
+		{
+			name: "wrong secret",
+			secret: func() []byte {
+				return append(challenge(), 0)
+			},
+			wantErr: true,
+			wantAKs: 0,
+		},
+		{
+			name:    "correct secret",
+			secret:  challenge,
+			wantErr: false,
+			wantAKs: 1,
+		},
+		{
+			name:    "reinsert same key",
+			secret:  challenge,
+			wantErr: false,
+			wantAKs: 1,
+		},
+		{
+			name:     "privacy CA enabled",
+			ca:       ca,
+			secret:   challenge,
+			wantErr:  false,
+			wantAKs:  1,
+			wantCert: true,
+		},
+	}
+
+	for _, test := range testSuite {
+		t.Run(test.name, func(t *testing.T) {
+			v.CA = test.ca
+			cert, gotErr := v.ActivateAK(p.EK, test.secret())
+			if (gotErr != nil) != test.wantErr {
+				t.Error(tests.Failure(t, gotErr, test.wantErr, ""))
+			}
+			if got := activatedProvers(t, v); got != test.wantAKs {
+				t.Error(tests.Failure(t, got, test.wantAKs, "stored AKs"))
+			}
+			if len(v.PendingAKs) != 0 {
+				t.Error(tests.Failure(t, len(v.PendingAKs), 0, "pending AKs"))
+			}
+			if (cert != nil) != test.wantCert {
+				t.Error(tests.Failure(t, cert != nil, test.wantCert, "AK certificate"))
+			}
+			if cert == nil {
+				return
+			}
+			roots := x509.NewCertPool()
+			roots.AddCert(ca.Certificate())
+			ak, err := verifierDB.VerifyAKCertificate(cert, roots)
+			if err != nil {
+				t.Fatalf("VerifyAKCertificate() returned an error: %v", err)
+			}
+			if !cmp.Equal(ak.PublicKey(), p.AK.PublicKey()) {
+				t.Error(tests.Failure(t, ak.PublicKey(), p.AK.PublicKey(), "certified AK"))
+			}
+		})
+	}
+}
+
+func TestDataVerifier_ActivateAKRevoked(t *testing.T) {
+	var testSuite = []struct {
+		name   string
+		revoke func(v *verifier.DataVerifier, p *verifier.Prover) error
+	}{
+		{
+			name: "revoked EK certificate",
+			revoke: func(v *verifier.DataVerifier, p *verifier.Prover) error {
+				return v.Provers.Update(p.EK.PublicKey(), func(stored *verifier.Prover) error {
+					stored.Revoked = true
+					return nil
+				})
+			},
+		},
+		{
+			name: "blocked EK",
+			revoke: func(v *verifier.DataVerifier, p *verifier.Prover) error {
+				return v.Blocks.Block(verifier.BlockedEK{ID: verifier.Fingerprint(p.EK.PublicKey()), Name: p.Name, Time: time.Now(), Actor: "alice", Reason: "stolen"})
+			},
+		},
+	}
+	for _, test := range testSuite {
+		t.Run(test.name, func(t *testing.T) {
+			v := verifier.NewVerifier(config)
+			p, swTPM := softwareProver(t)
+			if err := v.RegisterNewEK(p); err != nil {
+				t.Fatalf("RegisterNewEK() returned an error: %v", err)
+			}
+			credential, err := v.RegisterNewAK(p)
+			if err != nil {
+				t.Fatalf("RegisterNewAK() returned an error: %v", err)
+			}
+			secret, err := swTPM.ActivateCredential(p.AK, credential)
+			if err != nil {
+				t.Fatalf("ActivateCredential() returned an error: %v", err)
+			}
+			if err = test.revoke(v, p); err != nil {
+				t.Fatalf("revoking prover returned an error: %v", err)
+			}
+			if _, err = v.ActivateAK(p.EK, secret); err == nil {
+				t.Error(tests.Failure(t, err, "error", "activation of a revoked prover"))
+			}
+			if got := activatedProvers(t, v); got != 0 {
+				t.Error(tests.Failure(t, got, 0, "stored AKs"))
+			}
+		})
+	}
+}
+
+func TestDataVerifier_RefreshRevocations(t *testing.T) {
+	caKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("GenerateKey() returned an error: %v", err)
+	}
+	caTemplate := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "Manufacturer EK CA"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		KeyUsage:              x509.KeyUsageCertSign | x509.KeyUsageCRLSign,
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+	caRaw, err := x509.CreateCertificate(rand.Reader, caTemplate, caTemplate, &caKey.PublicKey, caKey)
+	if err != nil {
+		t.Fatalf("CreateCertificate() returned an error: %v", err)
+	}
+	caCert, err := x509.ParseCertificate(caRaw)
+	if err != nil {
+		t.Fatalf("ParseCertificate() returned an error: %v", err)
+	}
+	store := verifierDB.NewEKTrustStore(verifierDB.EKPolicy{})
+	ctCA, err := ctx509.ParseCertificate(caRaw)
+	if err != nil {
+		t.Fatalf("ParseCertificate() returned an error: %v", err)
+	}
+	store.AddCertificate(ctCA)
+
+	// newProver returns a prover whose EK certificate has serial and is issued by the CA.
+	newProver := func(name string, serial int64) *verifier.Prover {
+		p, _ := softwareProver(t)
+		p.Name = name
+		template := &x509.Certificate{
+			SerialNumber: big.NewInt(serial),
+			NotBefore:    time.Now().Add(-time.Hour),
+			NotAfter:     time.Now().Add(time.Hour),
+			KeyUsage:     x509.KeyUsageKeyEncipherment,
+		}
+		raw, err := x509.CreateCertificate(rand.Reader, template, caCert, p.EK.PublicKey(), caKey)
+		if err != nil {
+			t.Fatalf("CreateCertificate() returned an error: %v", err)
+		}
+		cert, err := ctx509.ParseCertificate(raw)
+		if err != nil {
+			t.Fatalf("ParseCertificate() returned an error: %v", err)
+		}
+		p.EK.(*tpmMocks.MockEndorsementKey).CatchCertificate = func() *ctx509.Certificate {
+			return cert
+		}
+		return p
+	}
+	revoked, valid := newProver("revoked", 2), newProver("valid", 3)
+
+	crlFile := filepath.Join(t.TempDir(), "ek-ca.crl")
+	crl, err := x509.CreateRevocationList(rand.Reader, &x509.RevocationList{
+		Number:              big.NewInt(1),
+		ThisUpdate:          time.Now().Add(-time.Minute),
+		NextUpdate:          time.Now().Add(time.Hour),
+		RevokedCertificates: []pkix.RevokedCertificate{{SerialNumber: big.NewInt(2), RevocationTime: time.Now().Add(-time.Minute)}},
+	}, caCert, caKey)
+	if err != nil {
+		t.Fatalf("CreateRevocationList() returned an error: %v", err)
+	}
+
+	v := verifier.NewVerifier(&verifier.Config{EKTrust: verifier.EKTrustConfig{CRLFiles: []string{crlFile}}})
+	v.EKTrust = store
+	for _, p := range []*verifier.Prover{revoked, valid} {
+		if err = v.RegisterNewEK(p); err != nil {
+			t.Fatalf("RegisterNewEK() returned an error: %v", err)
+		}
+	}
+	// The CRL is published after the registration of the provers.
+	if err = ioutil.WriteFile(crlFile, crl, 0600); err != nil {
+		t.Fatal(err)
+	}
+	v.RefreshRevocations()
+
+	var testSuite = []struct {
+		name        string
+		prover      *verifier.Prover
+		wantRevoked bool
+		wantState   verifier.TrustState
+	}{
+		{name: "Revoked EK", prover: revoked, wantRevoked: true, wantState: verifier.StateRevoked},
+		{name: "Valid EK", prover: valid, wantRevoked: false, wantState: verifier.StateRegistered},
+	}
+	for _, test := range testSuite {
+		t.Run(test.name, func(t *testing.T) {
+			stored, err := v.Provers.GetByEK(test.prover.EK.PublicKey())
+			if err != nil {
+				t.Fatalf("GetByEK() returned an error: %v", err)
+			}
+			if stored.Revoked != test.wantRevoked {
+				t.Error(tests.Failure(t, stored.Revoked, test.wantRevoked, ""))
+			}
+			if stored.State != test.wantState {
+				t.Error(tests.Failure(t, stored.State, test.wantState, "trust state"))
+			}
+			_, gotErr := v.RegisterNewAK(test.prover)
+			if (gotErr != nil) != test.wantRevoked {
+				t.Error(tests.Failure(t, gotErr, test.wantRevoked, "RegisterNewAK() error"))
 			}
 		})
 	}
+	if err = v.RegisterNewEK(newProver("revoked again", 2)); err == nil {
+		t.Error(tests.Failure(t, err, "error", "registered a revoked EK"))
+	}
 }
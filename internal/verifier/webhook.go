@@ -0,0 +1,313 @@
+package verifier
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	log "github.com/sirupsen/logrus"
+	"net/http"
+	"sync"
+	"time"
+)
+
+const (
+	defaultWebhookTimeout    = 5 * time.Second
+	defaultWebhookAttempts   = 5
+	defaultWebhookBackoff    = time.Second
+	defaultWebhookMaxBackoff = time.Minute
+	webhookWorkers           = 4
+	webhookQueueSize         = 1024
+)
+
+const (
+	// SignatureHeader carries the hex HMAC-SHA256 of the payload, prefixed by "sha256=".
+	SignatureHeader = "X-Signature-256"
+	// DeliveryHeader carries the ID of the StateChange, it is the same for every attempt.
+	DeliveryHeader = "X-Webhook-Delivery"
+)
+
+var (
+	ErrDeadLetterNotFound = errors.New("dead letter not found")
+	ErrWebhooksDisabled   = errors.New("webhooks not configured")
+)
+
+// ProverIdentity identifies a prover in the webhook payloads, ID is the fingerprint of its EK.
+type ProverIdentity struct {
+	ID       string
+	Name     string
+	Endpoint string
+	Port     string
+	Labels   map[string]string `json:",omitempty"`
+}
+
+// StateChange is the payload sent to the webhooks when a prover moves From a trust state To another.
+// Attestation is the ID of the attestation that moved the prover, Evidence the query API path of its evidence.
+type StateChange struct {
+	ID          string
+	Time        time.Time
+	Prover      ProverIdentity
+	From        TrustState `json:",omitempty"`
+	To          TrustState
+	Reason      string
+	Failures    []string `json:",omitempty"`
+	Attestation uint64   `json:",omitempty"`
+	Evidence    string   `json:",omitempty"`
+}
+
+// DeadLetter is a payload that could not be delivered to the webhook at URL.
+type DeadLetter struct {
+	ID       uint64
+	Time     time.Time
+	URL      string
+	Payload  json.RawMessage
+	Attempts int
+	Error    string
+}
+
+// Notifier delivers the trust state changes of the provers to the configured webhooks in the background.
+// Failed deliveries are retried with an exponential backoff, then kept as dead letters in the store.
+type Notifier struct {
+	config WebhooksConfig
+	store  DeadLetterStore
+	client *http.Client
+	queue  chan delivery
+}
+
+// delivery is a payload to send to a webhook.
+type delivery struct {
+	endpoint WebhookConfig
+	id       string
+	payload  []byte
+}
+
+// NewNotifier returns a notifier for the webhooks of config, keeping its dead letters in store.
+func NewNotifier(config WebhooksConfig, store DeadLetterStore) *Notifier {
+	if config.Timeout <= 0 {
+		config.Timeout = defaultWebhookTimeout
+	}
+	if config.MaxAttempts <= 0 {
+		config.MaxAttempts = defaultWebhookAttempts
+	}
+	if config.Backoff <= 0 {
+		config.Backoff = defaultWebhookBackoff
+	}
+	if config.MaxBackoff <= 0 {
+		config.MaxBackoff = defaultWebhookMaxBackoff
+	}
+	return &Notifier{
+		config: config,
+		store:  store,
+		client: &http.Client{Timeout: config.Timeout},
+		queue:  make(chan delivery, webhookQueueSize),
+	}
+}
+
+// Notify queues change for the webhooks subscribed to its state. The change is a dead letter at once when the queue is full.
+func (n *Notifier) Notify(change StateChange) {
+	if change.ID == "" {
+		id := make([]byte, 16)
+		if _, err := rand.Read(id); err != nil {
+			log.Errorf("error generating webhook delivery ID: %v", err)
+		}
+		change.ID = hex.EncodeToString(id)
+	}
+	payload, err := json.Marshal(change)
+	if err != nil {
+		log.Errorf("error encoding state change: %v", err)
+		return
+	}
+	for _, endpoint := range n.config.Endpoints {
+		if !endpoint.subscribed(change.To) {
+			continue
+		}
+		d := delivery{endpoint: endpoint, id: change.ID, payload: payload}
+		select {
+		case n.queue <- d:
+		default:
+			n.deadLetter(d, 0, fmt.Errorf("webhook queue full"))
+		}
+	}
+}
+
+func (c WebhookConfig) subscribed(state TrustState) bool {
+	if len(c.Events) == 0 {
+		return true
+	}
+	for _, event := range c.Events {
+		if event == state {
+			return true
+		}
+	}
+	return false
+}
+
+// Run delivers the queued changes until ctx is done. The deliveries not over by then are kept as dead letters.
+func (n *Notifier) Run(ctx context.Context) {
+	var wg sync.WaitGroup
+	for i := 0; i < webhookWorkers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for {
+				select {
+				case d := <-n.queue:
+					n.send(ctx, d)
+				case <-ctx.Done():
+					return
+				}
+			}
+		}()
+	}
+	wg.Wait()
+	for {
+		select {
+		case d := <-n.queue:
+			n.deadLetter(d, 0, ctx.Err())
+		default:
+			return
+		}
+	}
+}
+
+// send attempts d until it is delivered, it gives up after the maximum attempts or once ctx is done.
+func (n *Notifier) send(ctx context.Context, d delivery) {
+	delay := n.config.Backoff
+	var err error
+	attempts := 0
+	for attempts < n.config.MaxAttempts {
+		attempts++
+		if err = n.post(ctx, d); err == nil {
+			return
+		}
+		log.Warnf("webhook %v: delivery %v failed %d times: %v", d.endpoint.URL, d.id, attempts, err)
+		if attempts == n.config.MaxAttempts {
+			break
+		}
+		timer := time.NewTimer(delay)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			n.deadLetter(d, attempts, err)
+			return
+		case <-timer.C:
+		}
+		if delay *= 2; delay > n.config.MaxBackoff {
+			delay = n.config.MaxBackoff
+		}
+	}
+	n.deadLetter(d, attempts, err)
+}
+
+// post sends d once, any answer but 2xx is a failure.
+func (n *Notifier) post(ctx context.Context, d delivery) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, d.endpoint.URL, bytes.NewReader(d.payload))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(DeliveryHeader, d.id)
+	req.Header.Set(SignatureHeader, Sign(d.endpoint.Secret, d.payload))
+	resp, err := n.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return errors.New(resp.Status)
+	}
+	return nil
+}
+
+// Sign returns the value of the signature header of payload, the hex HMAC-SHA256 of payload keyed by secret.
+func Sign(secret string, payload []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(payload)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+func (n *Notifier) deadLetter(d delivery, attempts int, err error) {
+	letter := &DeadLetter{Time: time.Now(), URL: d.endpoint.URL, Payload: d.payload, Attempts: attempts}
+	if err != nil {
+		letter.Error = err.Error()
+	}
+	if err := n.store.AddDeadLetter(letter); err != nil {
+		log.Errorf("webhook %v: error storing dead letter of delivery %v: %v", d.endpoint.URL, d.id, err)
+		return
+	}
+	log.Errorf("webhook %v: delivery %v kept as dead letter %d: %v", d.endpoint.URL, d.id, letter.ID, letter.Error)
+}
+
+// Redeliver sends the dead letter with ID id again, once. It is removed from the dead letters when it is delivered.
+func (n *Notifier) Redeliver(ctx context.Context, id uint64) error {
+	letters, err := n.store.DeadLetters()
+	if err != nil {
+		return fmt.Errorf("error reading dead letters: %v", err)
+	}
+	for _, letter := range letters {
+		if letter.ID != id {
+			continue
+		}
+		for _, endpoint := range n.config.Endpoints {
+			if endpoint.URL != letter.URL {
+				continue
+			}
+			var change StateChange
+			if err = json.Unmarshal(letter.Payload, &change); err != nil {
+				return fmt.Errorf("error decoding dead letter: %v", err)
+			}
+			if err = n.post(ctx, delivery{endpoint: endpoint, id: change.ID, payload: letter.Payload}); err != nil {
+				return fmt.Errorf("error delivering dead letter %d: %v", id, err)
+			}
+			return n.store.DeleteDeadLetter(id)
+		}
+		return fmt.Errorf("webhook %v no longer configured", letter.URL)
+	}
+	return ErrDeadLetterNotFound
+}
+
+// notify sends the trust state change of p from state from to the webhooks, record is the attestation that moved p.
+func (v *DataVerifier) notify(p *Prover, from TrustState, record *Attestation) {
+	if v.Notifier == nil || p.State == from {
+		return
+	}
+	id := Fingerprint(p.EK.PublicKey())
+	change := StateChange{
+		Time:   p.StateSince,
+		Prover: ProverIdentity{ID: id, Name: p.Name, Endpoint: p.Endpoint, Port: p.Port, Labels: p.Labels},
+		From:   from,
+		To:     p.State,
+		Reason: p.StateReason,
+	}
+	if record != nil {
+		change.Failures = record.Failures
+		change.Attestation = record.ID
+		change.Evidence = fmt.Sprintf("/provers/%v/attestations/%d", id, record.ID)
+	}
+	v.Notifier.Notify(change)
+}
+
+// DeadLetters returns the webhook deliveries that failed.
+func (v *DataVerifier) DeadLetters() ([]DeadLetter, error) {
+	letters, err := v.Letters.DeadLetters()
+	if err != nil {
+		return nil, fmt.Errorf("error reading dead letters: %v", err)
+	}
+	if letters == nil {
+		letters = []DeadLetter{}
+	}
+	return letters, nil
+}
+
+// Redeliver sends the dead letter with ID id again.
+func (v *DataVerifier) Redeliver(ctx context.Context, id uint64) error {
+	if v.Notifier == nil {
+		return ErrWebhooksDisabled
+	}
+	return v.Notifier.Redeliver(ctx, id)
+}
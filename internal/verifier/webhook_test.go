@@ -0,0 +1,174 @@
+package verifier
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/xcaliburne/RemoteAttestations/pkg/httpClient"
+	"github.com/xcaliburne/RemoteAttestations/pkg/httpClient/tests/mocks"
+	"github.com/xcaliburne/RemoteAttestations/pkg/tests"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+)
+
+// webhookServer records the state changes it receives, it fails the first failures deliveries.
+type webhookServer struct {
+	*httptest.Server
+	mutex    sync.Mutex
+	failures int
+	changes  []StateChange
+}
+
+func newWebhookServer(t *testing.T, secret string, failures int) *webhookServer {
+	s := &webhookServer{failures: failures}
+	s.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		s.mutex.Lock()
+		defer s.mutex.Unlock()
+		body, err := ioutil.ReadAll(r.Body)
+		if err != nil || r.Header.Get(SignatureHeader) != Sign(secret, body) {
+			t.Error(tests.Failure(t, r.Header.Get(SignatureHeader), Sign(secret, body), "signature"))
+			http.Error(w, "invalid signature", http.StatusUnauthorized)
+			return
+		}
+		if s.failures > 0 {
+			s.failures--
+			http.Error(w, "unavailable", http.StatusServiceUnavailable)
+			return
+		}
+		var change StateChange
+		if err = json.Unmarshal(body, &change); err != nil || r.Header.Get(DeliveryHeader) != change.ID {
+			t.Error(tests.Failure(t, string(body), "state change", "payload"))
+		}
+		s.changes = append(s.changes, change)
+	}))
+	return s
+}
+
+func (s *webhookServer) received() []StateChange {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return append([]StateChange(nil), s.changes...)
+}
+
+// waitFor polls done until it returns true or a few seconds pass.
+func waitFor(done func() bool) bool {
+	deadline := time.Now().Add(5 * time.Second)
+	for !done() {
+		if time.Now().After(deadline) {
+			return false
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	return true
+}
+
+func TestNotifier(t *testing.T) {
+	flaky := newWebhookServer(t, "flaky secret", 2)
+	defer flaky.Close()
+	down := newWebhookServer(t, "down secret", 100)
+	defer down.Close()
+	untrustedOnly := newWebhookServer(t, "", 0)
+	defer untrustedOnly.Close()
+	store := NewMemoryStore()
+	n := NewNotifier(WebhooksConfig{
+		Endpoints: []WebhookConfig{
+			{URL: flaky.URL, Secret: "flaky secret"},
+			{URL: down.URL, Secret: "down secret"},
+			{URL: untrustedOnly.URL, Events: []TrustState{StateUntrusted}},
+		},
+		MaxAttempts: 3,
+		Backoff:     time.Millisecond,
+		MaxBackoff:  2 * time.Millisecond,
+	}, store)
+	ctx, cancel := context.WithCancel(context.Background())
+	stopped := make(chan struct{})
+	go func() {
+		n.Run(ctx)
+		close(stopped)
+	}()
+	defer func() {
+		cancel()
+		<-stopped
+	}()
+
+	n.Notify(StateChange{Prover: ProverIdentity{Name: "edge"}, From: StateTrusted, To: StateUnreachable})
+	n.Notify(StateChange{Prover: ProverIdentity{Name: "edge"}, From: StateUnreachable, To: StateUntrusted, Failures: []string{"PCR 0 differs"}})
+	if !waitFor(func() bool { return len(flaky.received()) == 2 }) {
+		t.Fatal(tests.Failure(t, len(flaky.received()), 2, "changes delivered after retries"))
+	}
+	if !waitFor(func() bool { return len(untrustedOnly.received()) == 1 }) {
+		t.Fatal(tests.Failure(t, len(untrustedOnly.received()), 1, "changes delivered to the untrusted webhook"))
+	}
+	if got := untrustedOnly.received()[0]; got.To != StateUntrusted || got.Failures[0] != "PCR 0 differs" {
+		t.Error(tests.Failure(t, got, "untrusted change", ""))
+	}
+	var letters []DeadLetter
+	waitFor(func() bool {
+		letters, _ = store.DeadLetters()
+		return len(letters) == 2
+	})
+	if len(letters) != 2 || letters[0].URL != down.URL || letters[0].Attempts != 3 {
+		t.Fatal(tests.Failure(t, letters, "2 dead letters for the webhook down", ""))
+	}
+
+	down.mutex.Lock()
+	down.failures = 0
+	down.mutex.Unlock()
+	if err := n.Redeliver(context.Background(), letters[0].ID); err != nil {
+		t.Fatalf("Redeliver() returned an error: %v", err)
+	}
+	if err := n.Redeliver(context.Background(), letters[0].ID); err != ErrDeadLetterNotFound {
+		t.Error(tests.Failure(t, err, ErrDeadLetterNotFound, "dead letter redelivered twice"))
+	}
+	var want StateChange
+	if err := json.Unmarshal(letters[0].Payload, &want); err != nil {
+		t.Fatalf("Unmarshal() returned an error: %v", err)
+	}
+	if got := down.received(); len(got) != 1 || got[0].ID != want.ID || got[0].To != want.To {
+		t.Error(tests.Failure(t, got, want, "redelivered change"))
+	}
+	if letters, _ = store.DeadLetters(); len(letters) != 1 {
+		t.Error(tests.Failure(t, len(letters), 1, "dead letters left"))
+	}
+}
+
+func TestDataVerifier_notify(t *testing.T) {
+	hook := newWebhookServer(t, "secret", 0)
+	defer hook.Close()
+	v := NewVerifier(&Config{})
+	v.Notifier = NewNotifier(WebhooksConfig{Endpoints: []WebhookConfig{{URL: hook.URL, Secret: "secret"}}}, v.Letters)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go v.Notifier.Run(ctx)
+
+	p := activatedProver(t, v, "edge", map[string]string{"role": "edge"})
+	httpClient.Client = &mocks.MockHttpClient{CatchGet: func(url string) (*http.Response, error) {
+		return nil, fmt.Errorf("unreachable")
+	}}
+	for i := 0; i < 2; i++ {
+		if _, err := v.attestProver(p); err == nil {
+			t.Fatal(tests.Failure(t, err, "unreachable", "attestProver() error"))
+		}
+	}
+	if !waitFor(func() bool { return len(hook.received()) > 0 }) {
+		t.Fatal(tests.Failure(t, 0, 1, "state changes received"))
+	}
+	// The second attestation does not change the state.
+	time.Sleep(50 * time.Millisecond)
+	changes := hook.received()
+	if len(changes) != 1 {
+		t.Fatal(tests.Failure(t, len(changes), 1, "state changes received"))
+	}
+	id := Fingerprint(p.EK.PublicKey())
+	got := changes[0]
+	if got.Prover.ID != id || got.Prover.Labels["role"] != "edge" || got.From != "" || got.To != StateUnreachable || got.Attestation != 1 {
+		t.Error(tests.Failure(t, got, "edge unreachable", ""))
+	}
+	if want := fmt.Sprintf("/provers/%v/attestations/1", id); got.Evidence != want {
+		t.Error(tests.Failure(t, got.Evidence, want, "evidence"))
+	}
+}
@@ -0,0 +1,230 @@
+// Package eventlog parses the TCG PC Client firmware event log and replays it into PCR values.
+// Both the SHA-1 log format of TPM 1.2 firmware and the crypto agile format of TPM 2.0 firmware are supported.
+package eventlog
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"github.com/xcaliburne/RemoteAttestations/pkg/tpm"
+	"io"
+	"io/ioutil"
+	"sort"
+)
+
+// DefaultPath is where the Linux kernel exposes the firmware event log.
+const DefaultPath = "/sys/kernel/security/tpm0/binary_bios_measurements"
+
+// specIDSignature starts the first event of a crypto agile log.
+var specIDSignature = []byte("Spec ID Event03\x00")
+
+// TCG algorithm identifiers of the supported banks.
+const (
+	algSHA1   uint16 = 0x0004
+	algSHA256 uint16 = 0x000b
+	algSHA384 uint16 = 0x000c
+)
+
+var algBanks = map[uint16]tpm.Bank{algSHA1: tpm.SHA1, algSHA256: tpm.SHA256, algSHA384: tpm.SHA384}
+
+// Event is a measurement of the log. Digests holds the digest extended into PCR for each supported bank of the log.
+type Event struct {
+	// Index is the position of the event in the log.
+	Index   int
+	PCR     int
+	Type    EventType
+	Digests map[tpm.Bank][]byte
+	Data    []byte
+}
+
+// EventLog is a parsed event log. Banks are the supported banks the events carry digests for.
+type EventLog struct {
+	CryptoAgile bool
+	Banks       []tpm.Bank
+	Events      []Event
+}
+
+// Read parses the event log stored in file.
+func Read(file string) (*EventLog, error) {
+	raw, err := ioutil.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	return Parse(raw)
+}
+
+// Parse parses a binary event log in the SHA-1 or the crypto agile format.
+func Parse(raw []byte) (*EventLog, error) {
+	r := bytes.NewReader(raw)
+	first, err := readSHA1Event(r)
+	if err != nil {
+		return nil, fmt.Errorf("event 0: %v", err)
+	}
+	log := &EventLog{}
+	if first.Type != NoAction || !bytes.HasPrefix(first.Data, specIDSignature) {
+		log.Banks = []tpm.Bank{tpm.SHA1}
+		log.Events = append(log.Events, first)
+		for r.Len() > 0 {
+			event, err := readSHA1Event(r)
+			if err != nil {
+				return nil, fmt.Errorf("event %d: %v", len(log.Events), err)
+			}
+			event.Index = len(log.Events)
+			log.Events = append(log.Events, event)
+		}
+		return log, nil
+	}
+	// The Spec ID event is not extended, it lists the digests of the following events.
+	log.CryptoAgile = true
+	sizes, err := parseSpecID(first.Data)
+	if err != nil {
+		return nil, fmt.Errorf("event 0: %v", err)
+	}
+	for alg := range sizes {
+		if bank, ok := algBanks[alg]; ok {
+			log.Banks = append(log.Banks, bank)
+		}
+	}
+	sort.Slice(log.Banks, func(i, j int) bool { return log.Banks[i].Size() < log.Banks[j].Size() })
+	log.Events = append(log.Events, first)
+	for r.Len() > 0 {
+		event, err := readEvent2(r, sizes)
+		if err != nil {
+			return nil, fmt.Errorf("event %d: %v", len(log.Events), err)
+		}
+		event.Index = len(log.Events)
+		log.Events = append(log.Events, event)
+	}
+	return log, nil
+}
+
+// readSHA1Event reads a TCG_PCClientPCREvent.
+func readSHA1Event(r *bytes.Reader) (Event, error) {
+	var header struct {
+		PCR    uint32
+		Type   uint32
+		Digest [20]byte
+	}
+	if err := binary.Read(r, binary.LittleEndian, &header); err != nil {
+		return Event{}, fmt.Errorf("error reading event header: %v", err)
+	}
+	data, err := readData(r)
+	if err != nil {
+		return Event{}, err
+	}
+	return Event{
+		PCR:     int(header.PCR),
+		Type:    EventType(header.Type),
+		Digests: map[tpm.Bank][]byte{tpm.SHA1: header.Digest[:]},
+		Data:    data,
+	}, nil
+}
+
+// readEvent2 reads a TCG_PCR_EVENT2, sizes are the digest sizes of the Spec ID event.
+func readEvent2(r *bytes.Reader, sizes map[uint16]uint16) (Event, error) {
+	var header struct {
+		PCR   uint32
+		Type  uint32
+		Count uint32
+	}
+	if err := binary.Read(r, binary.LittleEndian, &header); err != nil {
+		return Event{}, fmt.Errorf("error reading event header: %v", err)
+	}
+	event := Event{PCR: int(header.PCR), Type: EventType(header.Type), Digests: map[tpm.Bank][]byte{}}
+	for i := uint32(0); i < header.Count; i++ {
+		var alg uint16
+		if err := binary.Read(r, binary.LittleEndian, &alg); err != nil {
+			return Event{}, fmt.Errorf("error reading digest algorithm: %v", err)
+		}
+		size, ok := sizes[alg]
+		if !ok {
+			return Event{}, fmt.Errorf("digest algorithm 0x%x missing from the Spec ID event", alg)
+		}
+		digest := make([]byte, size)
+		if _, err := io.ReadFull(r, digest); err != nil {
+			return Event{}, fmt.Errorf("error reading digest: %v", err)
+		}
+		if bank, ok := algBanks[alg]; ok {
+			event.Digests[bank] = digest
+		}
+	}
+	data, err := readData(r)
+	if err != nil {
+		return Event{}, err
+	}
+	event.Data = data
+	return event, nil
+}
+
+func readData(r *bytes.Reader) ([]byte, error) {
+	var size uint32
+	if err := binary.Read(r, binary.LittleEndian, &size); err != nil {
+		return nil, fmt.Errorf("error reading event size: %v", err)
+	}
+	if int64(size) > int64(r.Len()) {
+		return nil, fmt.Errorf("event size %d exceeds the log", size)
+	}
+	data := make([]byte, size)
+	if _, err := io.ReadFull(r, data); err != nil {
+		return nil, fmt.Errorf("error reading event data: %v", err)
+	}
+	return data, nil
+}
+
+// parseSpecID returns the digest sizes listed by a TCG_EfiSpecIDEventStruct.
+func parseSpecID(data []byte) (map[uint16]uint16, error) {
+	r := bytes.NewReader(data[len(specIDSignature):])
+	var header struct {
+		PlatformClass    uint32
+		SpecVersionMinor uint8
+		SpecVersionMajor uint8
+		SpecErrata       uint8
+		UintnSize        uint8
+		NumAlgorithms    uint32
+	}
+	if err := binary.Read(r, binary.LittleEndian, &header); err != nil {
+		return nil, fmt.Errorf("error reading Spec ID event: %v", err)
+	}
+	if int64(header.NumAlgorithms)*4 > int64(r.Len()) {
+		return nil, fmt.Errorf("invalid Spec ID event: %d algorithms", header.NumAlgorithms)
+	}
+	sizes := map[uint16]uint16{}
+	for i := uint32(0); i < header.NumAlgorithms; i++ {
+		var alg struct {
+			ID   uint16
+			Size uint16
+		}
+		if err := binary.Read(r, binary.LittleEndian, &alg); err != nil {
+			return nil, fmt.Errorf("error reading Spec ID event: %v", err)
+		}
+		if bank, ok := algBanks[alg.ID]; ok && int(alg.Size) != bank.Size() {
+			return nil, fmt.Errorf("invalid %v digest size in Spec ID event: %d", bank, alg.Size)
+		}
+		sizes[alg.ID] = alg.Size
+	}
+	return sizes, nil
+}
+
+// PCRs returns the indexes of the PCRs extended by the events in index order.
+func (l *EventLog) PCRs() []int {
+	measured := map[int]bool{}
+	var pcrs []int
+	for _, event := range l.Events {
+		if event.Type != NoAction && !measured[event.PCR] {
+			measured[event.PCR] = true
+			pcrs = append(pcrs, event.PCR)
+		}
+	}
+	sort.Ints(pcrs)
+	return pcrs
+}
+
+// HasBank tells whether the events of the log carry digests for bank.
+func (l *EventLog) HasBank(bank tpm.Bank) bool {
+	for _, b := range l.Banks {
+		if b.Equal(bank) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,218 @@
+package eventlog_test
+
+import (
+	"bytes"
+	"crypto/sha1"
+	"crypto/sha256"
+	"encoding/binary"
+	"github.com/google/go-cmp/cmp"
+	"github.com/xcaliburne/RemoteAttestations/pkg/eventlog"
+	"github.com/xcaliburne/RemoteAttestations/pkg/tests"
+	"github.com/xcaliburne/RemoteAttestations/pkg/tpm"
+	"io/ioutil"
+	"path/filepath"
+	"sort"
+	"testing"
+	"unicode/utf16"
+)
+
+type testEvent struct {
+	pcr   uint32
+	typ   eventlog.EventType
+	data  []byte
+	image bool // digest of a fake image instead of data
+}
+
+func (e testEvent) measured() []byte {
+	if e.image {
+		return []byte("image")
+	}
+	return e.data
+}
+
+func write(buf *bytes.Buffer, values ...interface{}) {
+	for _, v := range values {
+		_ = binary.Write(buf, binary.LittleEndian, v)
+	}
+}
+
+// sha1Log encodes events in the TPM 1.2 log format.
+func sha1Log(events []testEvent) []byte {
+	buf := &bytes.Buffer{}
+	for _, e := range events {
+		digest := sha1.Sum(e.measured())
+		write(buf, e.pcr, uint32(e.typ), digest, uint32(len(e.data)), e.data)
+	}
+	return buf.Bytes()
+}
+
+// agileLog encodes events in the crypto agile format with sha1 and sha256 digests.
+func agileLog(events []testEvent) []byte {
+	specID := &bytes.Buffer{}
+	write(specID, []byte("Spec ID Event03\x00"), uint32(0), uint8(0), uint8(2), uint8(0), uint8(2), uint32(2),
+		uint16(0x0004), uint16(20), uint16(0x000b), uint16(32), uint8(0))
+	buf := &bytes.Buffer{}
+	write(buf, uint32(0), uint32(eventlog.NoAction), [20]byte{}, uint32(specID.Len()), specID.Bytes())
+	for _, e := range events {
+		sha1Digest := sha1.Sum(e.measured())
+		sha256Digest := sha256.Sum256(e.measured())
+		write(buf, e.pcr, uint32(e.typ), uint32(2), uint16(0x0004), sha1Digest, uint16(0x000b), sha256Digest, uint32(len(e.data)), e.data)
+	}
+	return buf.Bytes()
+}
+
+func efiVariable(guid eventlog.GUID, name string, data []byte) []byte {
+	buf := &bytes.Buffer{}
+	unicode := utf16.Encode([]rune(name))
+	write(buf, guid, uint64(len(unicode)), uint64(len(data)), unicode, data)
+	return buf.Bytes()
+}
+
+func imageLoad(path []byte) []byte {
+	buf := &bytes.Buffer{}
+	write(buf, uint64(0x1000), uint64(0x2000), uint64(0), uint64(len(path)), path)
+	return buf.Bytes()
+}
+
+// replay computes the expected PCR values of events without the event log package.
+func replay(events []testEvent, bank tpm.Bank, locality byte) []tpm.PCR {
+	hash, _ := bank.Hash()
+	values := map[uint32][]byte{}
+	var ids []uint32
+	for _, e := range events {
+		if e.typ == eventlog.NoAction {
+			continue
+		}
+		value, ok := values[e.pcr]
+		if !ok {
+			value = make([]byte, hash.Size())
+			if e.pcr == 0 {
+				value[len(value)-1] = locality
+			}
+			ids = append(ids, e.pcr)
+		}
+		h := hash.New()
+		h.Write(e.measured())
+		digest := h.Sum(nil)
+		h = hash.New()
+		h.Write(value)
+		h.Write(digest)
+		values[e.pcr] = h.Sum(nil)
+	}
+	var pcrs []tpm.PCR
+	for _, id := range ids {
+		pcrs = append(pcrs, tpm.PCR{Id: int(id), Bank: bank, Value: values[id]})
+	}
+	sort.Slice(pcrs, func(i, j int) bool { return pcrs[i].Id < pcrs[j].Id })
+	return pcrs
+}
+
+var bootEvents = []testEvent{
+	{pcr: 0, typ: eventlog.SCRTMVersion, data: []byte("1.0")},
+	{pcr: 7, typ: eventlog.EFIVariableDriverConfig, data: efiVariable(eventlog.EFIGlobalVariable, "SecureBoot", []byte{1})},
+	{pcr: 0, typ: eventlog.Separator, data: []byte{0, 0, 0, 0}},
+	{pcr: 4, typ: eventlog.EFIBootServicesApplication, data: imageLoad([]byte("path")), image: true},
+	{pcr: 4, typ: eventlog.Separator, data: []byte{0, 0, 0, 0}},
+	{pcr: 7, typ: eventlog.Separator, data: []byte{0xff, 0xff, 0xff, 0xff}},
+}
+
+func TestParse_SHA1(t *testing.T) {
+	log, err := eventlog.Parse(sha1Log(bootEvents))
+	if err != nil {
+		t.Fatalf("Parse() returned an error: %v", err)
+	}
+	if log.CryptoAgile || !cmp.Equal(log.Banks, []tpm.Bank{tpm.SHA1}) || len(log.Events) != len(bootEvents) {
+		t.Fatal(tests.Failure(t, log, bootEvents, "SHA-1 log"))
+	}
+	got, err := log.Replay(tpm.SHA1)
+	if err != nil {
+		t.Fatalf("Replay() returned an error: %v", err)
+	}
+	want := replay(bootEvents, tpm.SHA1, 0)
+	if !cmp.Equal(got, want) {
+		t.Error(tests.Failure(t, got, want, ""))
+	}
+	if _, err = log.Replay(tpm.SHA256); err == nil {
+		t.Error(tests.Failure(t, err, "error", "SHA-1 log replayed in the sha256 bank"))
+	}
+}
+
+func TestParse_CryptoAgile(t *testing.T) {
+	locality := testEvent{pcr: 0, typ: eventlog.NoAction, data: append([]byte("StartupLocality\x00"), 3)}
+	events := append([]testEvent{locality}, bootEvents...)
+	file := filepath.Join(t.TempDir(), "binary_bios_measurements")
+	if err := ioutil.WriteFile(file, agileLog(events), 0600); err != nil {
+		t.Fatal(err)
+	}
+	log, err := eventlog.Read(file)
+	if err != nil {
+		t.Fatalf("Read() returned an error: %v", err)
+	}
+	if !log.CryptoAgile || !cmp.Equal(log.Banks, []tpm.Bank{tpm.SHA1, tpm.SHA256}) || len(log.Events) != len(events)+1 {
+		t.Fatal(tests.Failure(t, log, events, "crypto agile log"))
+	}
+	for _, bank := range log.Banks {
+		got, err := log.Replay(bank)
+		if err != nil {
+			t.Fatalf("Replay() returned an error: %v", err)
+		}
+		want := replay(events, bank, 3)
+		if !cmp.Equal(got, want) {
+			t.Error(tests.Failure(t, got, want, bank.String()))
+		}
+	}
+
+	variable, err := log.Events[3].EFIVariable()
+	if err != nil {
+		t.Fatalf("EFIVariable() returned an error: %v", err)
+	}
+	wantVariable := &eventlog.EFIVariable{VendorGUID: eventlog.EFIGlobalVariable, Name: "SecureBoot", Data: []byte{1}}
+	if !cmp.Equal(variable, wantVariable) {
+		t.Error(tests.Failure(t, variable, wantVariable, ""))
+	}
+	if got, want := variable.VendorGUID.String(), "8be4df61-93ca-11d2-aa0d-00e098032b8c"; got != want {
+		t.Error(tests.Failure(t, got, want, "GUID"))
+	}
+	image, err := log.Events[5].EFIImageLoad()
+	if err != nil {
+		t.Fatalf("EFIImageLoad() returned an error: %v", err)
+	}
+	if image.LengthInMemory != 0x2000 || string(image.DevicePath) != "path" {
+		t.Error(tests.Failure(t, image, "path", "image load event"))
+	}
+	if _, err = log.Events[5].EFIVariable(); err == nil {
+		t.Error(tests.Failure(t, err, "error", "image load event decoded as a variable"))
+	}
+	if failed, err := log.Events[4].SeparatorError(); err != nil || failed {
+		t.Error(tests.Failure(t, failed, false, "separator"))
+	}
+	if failed, err := log.Events[7].SeparatorError(); err != nil || !failed {
+		t.Error(tests.Failure(t, failed, true, "error separator"))
+	}
+	if !log.Events[3].DataMatchesDigest(tpm.SHA256) || log.Events[5].DataMatchesDigest(tpm.SHA256) {
+		t.Error(tests.Failure(t, log.Events[5].DataMatchesDigest(tpm.SHA256), false, "image digest"))
+	}
+	if got, want := log.Events[5].Type.String(), "EV_EFI_BOOT_SERVICES_APPLICATION"; got != want {
+		t.Error(tests.Failure(t, got, want, ""))
+	}
+}
+
+func TestParse_Invalid(t *testing.T) {
+	valid := agileLog(bootEvents)
+	var testSuite = []struct {
+		name  string
+		input []byte
+	}{
+		{name: "empty", input: []byte{}},
+		{name: "truncated header", input: valid[:len(valid)-len(bootEvents[len(bootEvents)-1].data)-10]},
+		{name: "truncated data", input: valid[:len(valid)-1]},
+		{name: "truncated SHA-1 log", input: sha1Log(bootEvents)[:40]},
+	}
+	for _, test := range testSuite {
+		t.Run(test.name, func(t *testing.T) {
+			if _, err := eventlog.Parse(test.input); err == nil {
+				t.Error(tests.Failure(t, err, "error", ""))
+			}
+		})
+	}
+}
@@ -0,0 +1,207 @@
+package eventlog
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"github.com/xcaliburne/RemoteAttestations/pkg/tpm"
+	"io"
+	"unicode/utf16"
+)
+
+// EventType is the type of an event as defined by the TCG PC Client Platform Firmware Profile.
+type EventType uint32
+
+const (
+	PrebootCert          EventType = 0x00
+	PostCode             EventType = 0x01
+	NoAction             EventType = 0x03
+	Separator            EventType = 0x04
+	Action               EventType = 0x05
+	EventTag             EventType = 0x06
+	SCRTMContents        EventType = 0x07
+	SCRTMVersion         EventType = 0x08
+	CPUMicrocode         EventType = 0x09
+	PlatformConfigFlags  EventType = 0x0a
+	TableOfDevices       EventType = 0x0b
+	CompactHash          EventType = 0x0c
+	IPL                  EventType = 0x0d
+	IPLPartitionData     EventType = 0x0e
+	NonhostCode          EventType = 0x0f
+	NonhostConfig        EventType = 0x10
+	NonhostInfo          EventType = 0x11
+	OmitBootDeviceEvents EventType = 0x12
+
+	EFIVariableDriverConfig    EventType = 0x80000001
+	EFIVariableBoot            EventType = 0x80000002
+	EFIBootServicesApplication EventType = 0x80000003
+	EFIBootServicesDriver      EventType = 0x80000004
+	EFIRuntimeServicesDriver   EventType = 0x80000005
+	EFIGPTEvent                EventType = 0x80000006
+	EFIAction                  EventType = 0x80000007
+	EFIPlatformFirmwareBlob    EventType = 0x80000008
+	EFIHandoffTables           EventType = 0x80000009
+	EFIPlatformFirmwareBlob2   EventType = 0x8000000a
+	EFIHandoffTables2          EventType = 0x8000000b
+	EFIVariableBoot2           EventType = 0x8000000c
+	EFIHCRTMEvent              EventType = 0x80000010
+	EFIVariableAuthority       EventType = 0x800000e0
+)
+
+var eventTypeNames = map[EventType]string{
+	PrebootCert:                "EV_PREBOOT_CERT",
+	PostCode:                   "EV_POST_CODE",
+	NoAction:                   "EV_NO_ACTION",
+	Separator:                  "EV_SEPARATOR",
+	Action:                     "EV_ACTION",
+	EventTag:                   "EV_EVENT_TAG",
+	SCRTMContents:              "EV_S_CRTM_CONTENTS",
+	SCRTMVersion:               "EV_S_CRTM_VERSION",
+	CPUMicrocode:               "EV_CPU_MICROCODE",
+	PlatformConfigFlags:        "EV_PLATFORM_CONFIG_FLAGS",
+	TableOfDevices:             "EV_TABLE_OF_DEVICES",
+	CompactHash:                "EV_COMPACT_HASH",
+	IPL:                        "EV_IPL",
+	IPLPartitionData:           "EV_IPL_PARTITION_DATA",
+	NonhostCode:                "EV_NONHOST_CODE",
+	NonhostConfig:              "EV_NONHOST_CONFIG",
+	NonhostInfo:                "EV_NONHOST_INFO",
+	OmitBootDeviceEvents:       "EV_OMIT_BOOT_DEVICE_EVENTS",
+	EFIVariableDriverConfig:    "EV_EFI_VARIABLE_DRIVER_CONFIG",
+	EFIVariableBoot:            "EV_EFI_VARIABLE_BOOT",
+	EFIBootServicesApplication: "EV_EFI_BOOT_SERVICES_APPLICATION",
+	EFIBootServicesDriver:      "EV_EFI_BOOT_SERVICES_DRIVER",
+	EFIRuntimeServicesDriver:   "EV_EFI_RUNTIME_SERVICES_DRIVER",
+	EFIGPTEvent:                "EV_EFI_GPT_EVENT",
+	EFIAction:                  "EV_EFI_ACTION",
+	EFIPlatformFirmwareBlob:    "EV_EFI_PLATFORM_FIRMWARE_BLOB",
+	EFIHandoffTables:           "EV_EFI_HANDOFF_TABLES",
+	EFIPlatformFirmwareBlob2:   "EV_EFI_PLATFORM_FIRMWARE_BLOB2",
+	EFIHandoffTables2:          "EV_EFI_HANDOFF_TABLES2",
+	EFIVariableBoot2:           "EV_EFI_VARIABLE_BOOT2",
+	EFIHCRTMEvent:              "EV_EFI_HCRTM_EVENT",
+	EFIVariableAuthority:       "EV_EFI_VARIABLE_AUTHORITY",
+}
+
+func (t EventType) String() string {
+	if name, ok := eventTypeNames[t]; ok {
+		return name
+	}
+	return fmt.Sprintf("EV_UNKNOWN(0x%x)", uint32(t))
+}
+
+// GUID is an EFI GUID in its binary, mixed endian, encoding.
+type GUID [16]byte
+
+func (g GUID) String() string {
+	return fmt.Sprintf("%08x-%04x-%04x-%x-%x",
+		binary.LittleEndian.Uint32(g[0:4]), binary.LittleEndian.Uint16(g[4:6]), binary.LittleEndian.Uint16(g[6:8]), g[8:10], g[10:])
+}
+
+// EFIGlobalVariable is the vendor GUID of the UEFI global variables such as SecureBoot.
+var EFIGlobalVariable = GUID{0x61, 0xdf, 0xe4, 0x8b, 0xca, 0x93, 0xd2, 0x11, 0xaa, 0x0d, 0x00, 0xe0, 0x98, 0x03, 0x2b, 0x8c}
+
+// EFIVariable is the UEFI_VARIABLE_DATA of the EFI variable events.
+type EFIVariable struct {
+	VendorGUID GUID
+	Name       string
+	Data       []byte
+}
+
+// EFIImageLoad is the UEFI_IMAGE_LOAD_EVENT of the EFI boot services and runtime services events.
+type EFIImageLoad struct {
+	LocationInMemory uint64
+	LengthInMemory   uint64
+	LinkTimeAddress  uint64
+	DevicePath       []byte
+}
+
+// EFIVariable decodes the data of an EFI variable event.
+func (e Event) EFIVariable() (*EFIVariable, error) {
+	switch e.Type {
+	case EFIVariableDriverConfig, EFIVariableBoot, EFIVariableBoot2, EFIVariableAuthority:
+	default:
+		return nil, fmt.Errorf("%v is not an EFI variable event", e.Type)
+	}
+	r := bytes.NewReader(e.Data)
+	var header struct {
+		VendorGUID GUID
+		NameLength uint64
+		DataLength uint64
+	}
+	if err := binary.Read(r, binary.LittleEndian, &header); err != nil {
+		return nil, fmt.Errorf("error reading EFI variable: %v", err)
+	}
+	if header.NameLength > uint64(r.Len())/2 || header.DataLength > uint64(r.Len())-header.NameLength*2 {
+		return nil, fmt.Errorf("EFI variable exceeds the event data")
+	}
+	name := make([]uint16, header.NameLength)
+	if err := binary.Read(r, binary.LittleEndian, name); err != nil {
+		return nil, fmt.Errorf("error reading EFI variable name: %v", err)
+	}
+	data := make([]byte, header.DataLength)
+	if _, err := io.ReadFull(r, data); err != nil {
+		return nil, fmt.Errorf("error reading EFI variable data: %v", err)
+	}
+	return &EFIVariable{VendorGUID: header.VendorGUID, Name: string(utf16.Decode(name)), Data: data}, nil
+}
+
+// EFIImageLoad decodes the data of an EFI boot services application, boot services driver or runtime driver event.
+func (e Event) EFIImageLoad() (*EFIImageLoad, error) {
+	switch e.Type {
+	case EFIBootServicesApplication, EFIBootServicesDriver, EFIRuntimeServicesDriver:
+	default:
+		return nil, fmt.Errorf("%v is not an EFI image load event", e.Type)
+	}
+	r := bytes.NewReader(e.Data)
+	var header struct {
+		LocationInMemory uint64
+		LengthInMemory   uint64
+		LinkTimeAddress  uint64
+		DevicePathLength uint64
+	}
+	if err := binary.Read(r, binary.LittleEndian, &header); err != nil {
+		return nil, fmt.Errorf("error reading EFI image load event: %v", err)
+	}
+	if header.DevicePathLength > uint64(r.Len()) {
+		return nil, fmt.Errorf("EFI device path exceeds the event data")
+	}
+	path := make([]byte, header.DevicePathLength)
+	if _, err := io.ReadFull(r, path); err != nil {
+		return nil, fmt.Errorf("error reading EFI device path: %v", err)
+	}
+	return &EFIImageLoad{
+		LocationInMemory: header.LocationInMemory,
+		LengthInMemory:   header.LengthInMemory,
+		LinkTimeAddress:  header.LinkTimeAddress,
+		DevicePath:       path,
+	}, nil
+}
+
+// SeparatorError tells whether a separator event reports a firmware error. Separators normally carry 0.
+func (e Event) SeparatorError() (bool, error) {
+	if e.Type != Separator {
+		return false, fmt.Errorf("%v is not a separator event", e.Type)
+	}
+	if len(e.Data) != 4 {
+		return false, fmt.Errorf("invalid separator size: %d", len(e.Data))
+	}
+	return binary.LittleEndian.Uint32(e.Data) != 0, nil
+}
+
+// DataMatchesDigest tells whether the bank digest of the event is the digest of its data.
+// The event data can only be trusted when it does, firmware measures some events differently,
+// e.g. boot services applications are measured by their PE image hash.
+func (e Event) DataMatchesDigest(bank tpm.Bank) bool {
+	digest, ok := e.Digests[bank]
+	if !ok {
+		return false
+	}
+	hash, err := bank.Hash()
+	if err != nil {
+		return false
+	}
+	h := hash.New()
+	h.Write(e.Data)
+	return bytes.Equal(h.Sum(nil), digest)
+}
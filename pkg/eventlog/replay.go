@@ -0,0 +1,63 @@
+package eventlog
+
+import (
+	"bytes"
+	"fmt"
+	"github.com/xcaliburne/RemoteAttestations/pkg/tpm"
+	"sort"
+)
+
+// startupLocalitySignature starts the EV_NO_ACTION event setting the initial value of PCR 0.
+var startupLocalitySignature = []byte("StartupLocality\x00")
+
+// Replay extends the bank digests of the events into PCRs starting from their reset value.
+// It returns the PCRs measured by the log in index order, other PCRs are left out.
+func (l *EventLog) Replay(bank tpm.Bank) ([]tpm.PCR, error) {
+	bank, err := tpm.ParseBank(string(bank))
+	if err != nil {
+		return nil, err
+	}
+	if !l.HasBank(bank) {
+		return nil, fmt.Errorf("event log has no %v digests", bank)
+	}
+	hash, err := bank.Hash()
+	if err != nil {
+		return nil, err
+	}
+	values := map[int][]byte{}
+	for _, event := range l.Events {
+		// EV_NO_ACTION events are not extended, the startup locality is the initial value of PCR 0.
+		if event.Type == NoAction && event.PCR == 0 && bytes.HasPrefix(event.Data, startupLocalitySignature) && len(event.Data) > len(startupLocalitySignature) {
+			values[0] = make([]byte, hash.Size())
+			values[0][hash.Size()-1] = event.Data[len(startupLocalitySignature)]
+		}
+	}
+	measured := map[int]bool{}
+	for _, event := range l.Events {
+		if event.Type == NoAction {
+			continue
+		}
+		if event.PCR < 0 || event.PCR >= len(tpm.All_pcrs) {
+			return nil, fmt.Errorf("event %d: invalid PCR index: %d", event.Index, event.PCR)
+		}
+		digest, ok := event.Digests[bank]
+		if !ok {
+			return nil, fmt.Errorf("event %d: missing %v digest", event.Index, bank)
+		}
+		value, ok := values[event.PCR]
+		if !ok {
+			value = make([]byte, hash.Size())
+		}
+		h := hash.New()
+		h.Write(value)
+		h.Write(digest)
+		values[event.PCR] = h.Sum(nil)
+		measured[event.PCR] = true
+	}
+	pcrs := make([]tpm.PCR, 0, len(measured))
+	for id := range measured {
+		pcrs = append(pcrs, tpm.PCR{Id: id, Bank: bank, Value: values[id]})
+	}
+	sort.Slice(pcrs, func(i, j int) bool { return pcrs[i].Id < pcrs[j].Id })
+	return pcrs, nil
+}
@@ -52,7 +52,12 @@ var _ HttpClient = (*DataHttpClient)(nil)
 var Client HttpClient
 
 func init() {
-	Client = &DataHttpClient{
+	Client = New(nil)
+}
+
+// New returns a client verifying the servers with tlsConfig, against the system roots when it is nil.
+func New(tlsConfig *tls.Config) *DataHttpClient {
+	return &DataHttpClient{
 		client: &http.Client{
 			Timeout: time.Second * 10,
 			Transport: &http.Transport{
@@ -64,9 +69,7 @@ func init() {
 				TLSHandshakeTimeout:   10 * time.Second,
 				ExpectContinueTimeout: 1 * time.Second,
 				DisableKeepAlives:     true,
-				TLSClientConfig: &tls.Config{
-					InsecureSkipVerify: true,
-				},
+				TLSClientConfig:       tlsConfig,
 			},
 		},
 	}
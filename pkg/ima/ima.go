@@ -0,0 +1,321 @@
+// Package ima parses the Linux IMA runtime measurement list and replays it into PCR 10.
+// The ascii and binary lists are supported with the ima, ima-ng and ima-sig templates.
+package ima
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha1"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"github.com/xcaliburne/RemoteAttestations/pkg/tpm"
+	"io"
+	"io/ioutil"
+	"strconv"
+	"strings"
+)
+
+// Default paths of the measurement list exposed by the kernel.
+const (
+	DefaultASCIIPath  = "/sys/kernel/security/ima/ascii_runtime_measurements"
+	DefaultBinaryPath = "/sys/kernel/security/ima/binary_runtime_measurements"
+)
+
+// PCR is the PCR IMA extends by default.
+const PCR = 10
+
+// BootAggregate is the path of the first entry, measuring the boot PCRs.
+const BootAggregate = "boot_aggregate"
+
+// nameLenMax is the size the file name is padded to in the template hash of the ima template.
+const nameLenMax = 255 + 1
+
+// Entry is a measurement of the list. TemplateData is nil when it cannot be rebuilt, for the ima template of
+// an ascii list. FileHash and Path are empty for unsupported templates.
+type Entry struct {
+	PCR          int
+	TemplateHash []byte
+	TemplateName string
+	TemplateData []byte `json:",omitempty"`
+	FileHashAlg  string
+	FileHash     []byte
+	Path         string
+	Signature    []byte `json:",omitempty"`
+}
+
+// Violation tells whether the entry records a measurement violation. Its template hash is then zero
+// and the PCR is extended with ones.
+func (e Entry) Violation() bool {
+	return bytes.Equal(e.TemplateHash, make([]byte, sha1.Size))
+}
+
+// Read parses the measurement list stored in file, in the ascii or the binary format.
+func Read(file string) ([]Entry, error) {
+	raw, err := ioutil.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	return Parse(raw)
+}
+
+// Parse parses an ascii or binary measurement list. The ascii list starts with the PCR index in decimal.
+func Parse(raw []byte) ([]Entry, error) {
+	if len(raw) > 0 && raw[0] >= '0' && raw[0] <= '9' {
+		return ParseASCII(raw)
+	}
+	return ParseBinary(raw)
+}
+
+// ParseASCII parses the ascii measurement list, one entry per line:
+//
+//	<pcr> <template hash> <template name> [<alg>:]<file hash> <path> [<signature>]
+func ParseASCII(raw []byte) ([]Entry, error) {
+	var entries []Entry
+	scanner := bufio.NewScanner(bytes.NewReader(raw))
+	for lineNumber := 1; scanner.Scan(); lineNumber++ {
+		line := scanner.Text()
+		if strings.TrimSpace(line) == "" {
+			continue
+		}
+		entry, err := parseASCIIEntry(line)
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %v", lineNumber, err)
+		}
+		entries = append(entries, entry)
+	}
+	return entries, scanner.Err()
+}
+
+func parseASCIIEntry(line string) (Entry, error) {
+	fields := strings.SplitN(line, " ", 5)
+	if len(fields) < 5 {
+		return Entry{}, fmt.Errorf("expected 5 fields, got %d", len(fields))
+	}
+	var entry Entry
+	var err error
+	if entry.PCR, err = strconv.Atoi(fields[0]); err != nil {
+		return Entry{}, fmt.Errorf("invalid PCR index: %v", err)
+	}
+	if entry.TemplateHash, err = hex.DecodeString(fields[1]); err != nil || len(entry.TemplateHash) != sha1.Size {
+		return Entry{}, fmt.Errorf("invalid template hash: %v", fields[1])
+	}
+	entry.TemplateName = fields[2]
+	fileHash, path := fields[3], fields[4]
+	switch entry.TemplateName {
+	case "ima":
+		entry.FileHashAlg = "sha1"
+	case "ima-ng", "ima-sig":
+		i := strings.Index(fileHash, ":")
+		if i < 0 {
+			return Entry{}, fmt.Errorf("missing file hash algorithm: %v", fileHash)
+		}
+		entry.FileHashAlg, fileHash = fileHash[:i], fileHash[i+1:]
+		if entry.TemplateName == "ima-sig" {
+			if i := strings.LastIndex(path, " "); i >= 0 {
+				if sig, err := hex.DecodeString(path[i+1:]); err == nil {
+					entry.Signature, path = sig, path[:i]
+				}
+			}
+		}
+	default:
+		return Entry{}, fmt.Errorf("unsupported template: %v", entry.TemplateName)
+	}
+	if entry.FileHash, err = hex.DecodeString(fileHash); err != nil {
+		return Entry{}, fmt.Errorf("invalid file hash: %v", err)
+	}
+	entry.Path = path
+	if entry.TemplateName != "ima" {
+		entry.TemplateData = ngTemplateData(entry)
+	}
+	return entry, nil
+}
+
+// ParseBinary parses the binary measurement list.
+func ParseBinary(raw []byte) ([]Entry, error) {
+	var entries []Entry
+	r := bytes.NewReader(raw)
+	for r.Len() > 0 {
+		entry, err := readBinaryEntry(r)
+		if err != nil {
+			return nil, fmt.Errorf("entry %d: %v", len(entries), err)
+		}
+		entries = append(entries, entry)
+	}
+	return entries, nil
+}
+
+func readBinaryEntry(r *bytes.Reader) (Entry, error) {
+	var header struct {
+		PCR          uint32
+		TemplateHash [sha1.Size]byte
+	}
+	if err := binary.Read(r, binary.LittleEndian, &header); err != nil {
+		return Entry{}, fmt.Errorf("error reading entry header: %v", err)
+	}
+	entry := Entry{PCR: int(header.PCR), TemplateHash: header.TemplateHash[:]}
+	name, err := readField(r)
+	if err != nil {
+		return Entry{}, fmt.Errorf("error reading template name: %v", err)
+	}
+	entry.TemplateName = string(name)
+	if entry.TemplateName == "ima" {
+		fileHash := make([]byte, sha1.Size)
+		if _, err := io.ReadFull(r, fileHash); err != nil {
+			return Entry{}, fmt.Errorf("error reading file hash: %v", err)
+		}
+		path, err := readField(r)
+		if err != nil {
+			return Entry{}, fmt.Errorf("error reading file name: %v", err)
+		}
+		entry.FileHashAlg, entry.FileHash, entry.Path = "sha1", fileHash, string(path)
+		return entry, nil
+	}
+	if entry.TemplateData, err = readField(r); err != nil {
+		return Entry{}, fmt.Errorf("error reading template data: %v", err)
+	}
+	if entry.TemplateName == "ima-ng" || entry.TemplateName == "ima-sig" {
+		if err = parseNGTemplateData(&entry); err != nil {
+			return Entry{}, err
+		}
+	}
+	return entry, nil
+}
+
+// readField reads a field prefixed with its 32 bits size.
+func readField(r *bytes.Reader) ([]byte, error) {
+	var size uint32
+	if err := binary.Read(r, binary.LittleEndian, &size); err != nil {
+		return nil, err
+	}
+	if int64(size) > int64(r.Len()) {
+		return nil, fmt.Errorf("field size %d exceeds the list", size)
+	}
+	field := make([]byte, size)
+	if _, err := io.ReadFull(r, field); err != nil {
+		return nil, err
+	}
+	return field, nil
+}
+
+// parseNGTemplateData decodes the d-ng, n-ng and sig fields of the template data of an ima-ng or ima-sig entry.
+func parseNGTemplateData(entry *Entry) error {
+	r := bytes.NewReader(entry.TemplateData)
+	digest, err := readField(r)
+	if err != nil {
+		return fmt.Errorf("error reading d-ng field: %v", err)
+	}
+	i := bytes.Index(digest, []byte(":\x00"))
+	if i < 0 {
+		return fmt.Errorf("invalid d-ng field")
+	}
+	entry.FileHashAlg, entry.FileHash = string(digest[:i]), digest[i+2:]
+	name, err := readField(r)
+	if err != nil {
+		return fmt.Errorf("error reading n-ng field: %v", err)
+	}
+	entry.Path = string(bytes.TrimSuffix(name, []byte{0}))
+	if entry.TemplateName == "ima-sig" && r.Len() > 0 {
+		if entry.Signature, err = readField(r); err != nil {
+			return fmt.Errorf("error reading sig field: %v", err)
+		}
+		if len(entry.Signature) == 0 {
+			entry.Signature = nil
+		}
+	}
+	return nil
+}
+
+// ngTemplateData rebuilds the template data of an ima-ng or ima-sig entry.
+func ngTemplateData(entry Entry) []byte {
+	buf := &bytes.Buffer{}
+	field := func(data []byte) {
+		_ = binary.Write(buf, binary.LittleEndian, uint32(len(data)))
+		buf.Write(data)
+	}
+	field(append([]byte(entry.FileHashAlg+":\x00"), entry.FileHash...))
+	field(append([]byte(entry.Path), 0))
+	if entry.TemplateName == "ima-sig" {
+		field(entry.Signature)
+	}
+	return buf.Bytes()
+}
+
+// Verify checks that the template hash of the entry is the hash of its template data and that the template data
+// holds its file hash and path, so that they are the ones extended into the PCR.
+func (e Entry) Verify() error {
+	if e.Violation() {
+		return nil
+	}
+	var digest [sha1.Size]byte
+	switch {
+	case e.TemplateName == "ima":
+		if len(e.FileHash) != sha1.Size {
+			return fmt.Errorf("invalid file hash size: %d", len(e.FileHash))
+		}
+		name := make([]byte, nameLenMax)
+		copy(name, e.Path)
+		digest = sha1.Sum(append(append([]byte{}, e.FileHash...), name...))
+	case e.TemplateName == "ima-ng" || e.TemplateName == "ima-sig":
+		data := ngTemplateData(e)
+		if e.TemplateData != nil && !bytes.Equal(data, e.TemplateData) {
+			return fmt.Errorf("template data does not match entry %v", e.Path)
+		}
+		digest = sha1.Sum(data)
+	case e.TemplateData != nil:
+		digest = sha1.Sum(e.TemplateData)
+	default:
+		return fmt.Errorf("missing template data")
+	}
+	if !bytes.Equal(digest[:], e.TemplateHash) {
+		return fmt.Errorf("template hash does not match entry %v", e.Path)
+	}
+	return nil
+}
+
+// Extend returns value extended with the entry in bank. The sha1 bank is extended with the template hash,
+// the other banks with the template data hashed with the bank algorithm.
+func (e Entry) Extend(value []byte, bank tpm.Bank) ([]byte, error) {
+	hash, err := bank.Hash()
+	if err != nil {
+		return nil, err
+	}
+	var digest []byte
+	switch {
+	case e.Violation():
+		digest = bytes.Repeat([]byte{0xff}, hash.Size())
+	case bank.Equal(tpm.SHA1):
+		digest = e.TemplateHash
+	case e.TemplateData != nil:
+		h := hash.New()
+		h.Write(e.TemplateData)
+		digest = h.Sum(nil)
+	default:
+		return nil, fmt.Errorf("%v template entries can only be replayed in the sha1 bank", e.TemplateName)
+	}
+	h := hash.New()
+	h.Write(value)
+	h.Write(digest)
+	return h.Sum(nil), nil
+}
+
+// Match replays entries from value and returns the number of entries after which it equals quoted,
+// -1 if no prefix of entries does. Entries of other PCRs are skipped.
+func Match(entries []Entry, value, quoted []byte, bank tpm.Bank) (int, error) {
+	if bytes.Equal(value, quoted) {
+		return 0, nil
+	}
+	for i, entry := range entries {
+		if entry.PCR != PCR {
+			continue
+		}
+		var err error
+		if value, err = entry.Extend(value, bank); err != nil {
+			return -1, fmt.Errorf("entry %d: %v", i, err)
+		}
+		if bytes.Equal(value, quoted) {
+			return i + 1, nil
+		}
+	}
+	return -1, nil
+}
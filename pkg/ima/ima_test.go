@@ -0,0 +1,190 @@
+package ima_test
+
+import (
+	"bytes"
+	"crypto/sha1"
+	"crypto/sha256"
+	"encoding/binary"
+	"fmt"
+	"github.com/google/go-cmp/cmp"
+	"github.com/xcaliburne/RemoteAttestations/pkg/ima"
+	"github.com/xcaliburne/RemoteAttestations/pkg/tests"
+	"github.com/xcaliburne/RemoteAttestations/pkg/tpm"
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+)
+
+type testFile struct {
+	path string
+	data string
+}
+
+var files = []testFile{
+	{path: ima.BootAggregate, data: "boot"},
+	{path: "/usr/bin/bash", data: "bash"},
+	{path: "/usr/lib/with space.so", data: "library"},
+}
+
+func field(buf *bytes.Buffer, data []byte) {
+	_ = binary.Write(buf, binary.LittleEndian, uint32(len(data)))
+	buf.Write(data)
+}
+
+// ngEntry returns the template data and template hash of an ima-ng entry measuring f.
+func ngEntry(f testFile) ([]byte, [sha1.Size]byte) {
+	fileHash := sha256.Sum256([]byte(f.data))
+	data := &bytes.Buffer{}
+	field(data, append([]byte("sha256:\x00"), fileHash[:]...))
+	field(data, append([]byte(f.path), 0))
+	return data.Bytes(), sha1.Sum(data.Bytes())
+}
+
+func asciiList(files []testFile) []byte {
+	buf := &bytes.Buffer{}
+	for _, f := range files {
+		_, templateHash := ngEntry(f)
+		fileHash := sha256.Sum256([]byte(f.data))
+		fmt.Fprintf(buf, "10 %x ima-ng sha256:%x %s\n", templateHash, fileHash, f.path)
+	}
+	return buf.Bytes()
+}
+
+func binaryList(files []testFile) []byte {
+	buf := &bytes.Buffer{}
+	for _, f := range files {
+		data, templateHash := ngEntry(f)
+		_ = binary.Write(buf, binary.LittleEndian, uint32(10))
+		buf.Write(templateHash[:])
+		field(buf, []byte("ima-ng"))
+		field(buf, data)
+	}
+	return buf.Bytes()
+}
+
+// pcr10 replays files in bank without the ima package.
+func pcr10(files []testFile, bank tpm.Bank) []byte {
+	hash, _ := bank.Hash()
+	value := make([]byte, hash.Size())
+	for _, f := range files {
+		data, templateHash := ngEntry(f)
+		digest := templateHash[:]
+		if !bank.Equal(tpm.SHA1) {
+			h := hash.New()
+			h.Write(data)
+			digest = h.Sum(nil)
+		}
+		h := hash.New()
+		h.Write(value)
+		h.Write(digest)
+		value = h.Sum(nil)
+	}
+	return value
+}
+
+func TestParse(t *testing.T) {
+	dir := t.TempDir()
+	for name, raw := range map[string][]byte{"ascii": asciiList(files), "binary": binaryList(files)} {
+		t.Run(name, func(t *testing.T) {
+			file := filepath.Join(dir, name)
+			if err := ioutil.WriteFile(file, raw, 0600); err != nil {
+				t.Fatal(err)
+			}
+			entries, err := ima.Read(file)
+			if err != nil {
+				t.Fatalf("Read() returned an error: %v", err)
+			}
+			if len(entries) != len(files) {
+				t.Fatal(tests.Failure(t, len(entries), len(files), "entries"))
+			}
+			for i, entry := range entries {
+				fileHash := sha256.Sum256([]byte(files[i].data))
+				if entry.Path != files[i].path || entry.FileHashAlg != "sha256" || !bytes.Equal(entry.FileHash, fileHash[:]) {
+					t.Error(tests.Failure(t, entry, files[i], ""))
+				}
+				if err = entry.Verify(); err != nil {
+					t.Error(tests.Failure(t, err, nil, "template hash"))
+				}
+			}
+			for _, bank := range []tpm.Bank{tpm.SHA1, tpm.SHA256} {
+				value := make([]byte, bank.Size())
+				got, err := ima.Match(entries, value, pcr10(files, bank), bank)
+				if err != nil || got != len(files) {
+					t.Error(tests.Failure(t, got, len(files), bank.String()))
+				}
+			}
+		})
+	}
+}
+
+func TestEntry_Verify(t *testing.T) {
+	entries, err := ima.ParseASCII(asciiList(files))
+	if err != nil {
+		t.Fatalf("ParseASCII() returned an error: %v", err)
+	}
+	line := fmt.Sprintf("10 %x ima-ng sha256:%x %s\n", entries[1].TemplateHash, make([]byte, sha256.Size), entries[1].Path)
+	tampered, err := ima.ParseASCII([]byte(line))
+	if err != nil {
+		t.Fatalf("ParseASCII() returned an error: %v", err)
+	}
+	if err = tampered[0].Verify(); err == nil {
+		t.Error(tests.Failure(t, err, "error", "file hash does not match template hash"))
+	}
+
+	legacyHash := sha1.Sum([]byte("legacy"))
+	name := make([]byte, 256)
+	copy(name, "/usr/bin/legacy")
+	templateHash := sha1.Sum(append(legacyHash[:], name...))
+	legacy, err := ima.ParseASCII([]byte(fmt.Sprintf("10 %x ima %x /usr/bin/legacy\n", templateHash, legacyHash)))
+	if err != nil {
+		t.Fatalf("ParseASCII() returned an error: %v", err)
+	}
+	if err = legacy[0].Verify(); err != nil {
+		t.Error(tests.Failure(t, err, nil, "ima template"))
+	}
+	if _, err = legacy[0].Extend(make([]byte, sha256.Size), tpm.SHA256); err == nil {
+		t.Error(tests.Failure(t, err, "error", "ima template replayed in the sha256 bank"))
+	}
+
+	violation, err := ima.ParseASCII([]byte(fmt.Sprintf("10 %x ima-ng sha256:%x /tmp/file\n", make([]byte, sha1.Size), make([]byte, sha256.Size))))
+	if err != nil {
+		t.Fatalf("ParseASCII() returned an error: %v", err)
+	}
+	if !violation[0].Violation() || violation[0].Verify() != nil {
+		t.Error(tests.Failure(t, violation[0], "violation", ""))
+	}
+	got, err := violation[0].Extend(make([]byte, sha1.Size), tpm.SHA1)
+	want := sha1.Sum(append(make([]byte, sha1.Size), bytes.Repeat([]byte{0xff}, sha1.Size)...))
+	if err != nil || !cmp.Equal(got, want[:]) {
+		t.Error(tests.Failure(t, got, want, "violation extends ones"))
+	}
+}
+
+func TestMatch(t *testing.T) {
+	entries, err := ima.ParseASCII(asciiList(files))
+	if err != nil {
+		t.Fatalf("ParseASCII() returned an error: %v", err)
+	}
+	start := make([]byte, sha1.Size)
+	var testSuite = []struct {
+		name   string
+		offset int
+		start  []byte
+		quoted []byte
+		want   int
+	}{
+		{name: "no new entry", start: start, quoted: start, want: 0},
+		{name: "entries added after the quote", start: start, quoted: pcr10(files[:2], tpm.SHA1), want: 2},
+		{name: "every entry", start: start, quoted: pcr10(files, tpm.SHA1), want: 3},
+		{name: "from an offset", offset: 1, start: pcr10(files[:1], tpm.SHA1), quoted: pcr10(files, tpm.SHA1), want: 2},
+		{name: "list does not match", start: start, quoted: bytes.Repeat([]byte{1}, sha1.Size), want: -1},
+	}
+	for _, test := range testSuite {
+		t.Run(test.name, func(t *testing.T) {
+			got, err := ima.Match(entries[test.offset:], test.start, test.quoted, tpm.SHA1)
+			if err != nil || got != test.want {
+				t.Error(tests.Failure(t, got, test.want, ""))
+			}
+		})
+	}
+}
@@ -0,0 +1,263 @@
+// Package metrics keeps counters, gauges and histograms and serves them in the Prometheus text exposition format.
+package metrics
+
+import (
+	"bufio"
+	"fmt"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// ContentType is the content type of the Prometheus text exposition format.
+const ContentType = "text/plain; version=0.0.4; charset=utf-8"
+
+// DefBuckets are the default histogram buckets in seconds, for latencies from 5ms to 10s.
+var DefBuckets = []float64{.005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10}
+
+// Registry holds metrics and serves them over HTTP.
+type Registry struct {
+	mutex    sync.Mutex
+	families map[string]*family
+}
+
+// NewRegistry returns an empty registry.
+func NewRegistry() *Registry {
+	return &Registry{families: map[string]*family{}}
+}
+
+// family is a metric and its series, one per combination of label values.
+type family struct {
+	name    string
+	help    string
+	kind    string
+	labels  []string
+	buckets []float64
+
+	mutex  sync.Mutex
+	series map[string]*series
+}
+
+type series struct {
+	values []string
+	value  float64
+	// counts are the observations of each bucket of a histogram, not cumulated.
+	counts []uint64
+	count  uint64
+}
+
+func (r *Registry) register(f *family) *family {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	if _, ok := r.families[f.name]; ok {
+		panic(fmt.Sprintf("metric %v registered twice", f.name))
+	}
+	f.series = map[string]*series{}
+	r.families[f.name] = f
+	return f
+}
+
+// get returns the series of values, creating it when it is new. The caller holds f.mutex.
+func (f *family) get(values []string) *series {
+	if len(values) != len(f.labels) {
+		panic(fmt.Sprintf("metric %v has labels %v, got values %v", f.name, f.labels, values))
+	}
+	key := strings.Join(values, "\xff")
+	s, ok := f.series[key]
+	if !ok {
+		s = &series{values: append([]string(nil), values...)}
+		if f.buckets != nil {
+			s.counts = make([]uint64, len(f.buckets))
+		}
+		f.series[key] = s
+	}
+	return s
+}
+
+// Counter is a value that only goes up, such as a number of requests.
+type Counter struct{ f *family }
+
+// NewCounter registers a counter named name with the given label names.
+func (r *Registry) NewCounter(name, help string, labels ...string) *Counter {
+	return &Counter{r.register(&family{name: name, help: help, kind: "counter", labels: labels})}
+}
+
+// Inc adds one to the series of the label values.
+func (c *Counter) Inc(values ...string) {
+	c.Add(1, values...)
+}
+
+// Add adds delta to the series of the label values, delta must not be negative.
+func (c *Counter) Add(delta float64, values ...string) {
+	if delta < 0 {
+		panic(fmt.Sprintf("counter %v decreased by %v", c.f.name, delta))
+	}
+	c.f.mutex.Lock()
+	defer c.f.mutex.Unlock()
+	c.f.get(values).value += delta
+}
+
+// Value returns the value of the series of the label values, 0 when it was never increased.
+func (c *Counter) Value(values ...string) float64 {
+	return c.f.value(values)
+}
+
+// Gauge is a value that goes up and down, such as a queue depth or a timestamp.
+type Gauge struct{ f *family }
+
+// NewGauge registers a gauge named name with the given label names.
+func (r *Registry) NewGauge(name, help string, labels ...string) *Gauge {
+	return &Gauge{r.register(&family{name: name, help: help, kind: "gauge", labels: labels})}
+}
+
+// Set sets the series of the label values to value.
+func (g *Gauge) Set(value float64, values ...string) {
+	g.f.mutex.Lock()
+	defer g.f.mutex.Unlock()
+	g.f.get(values).value = value
+}
+
+// Add adds delta to the series of the label values.
+func (g *Gauge) Add(delta float64, values ...string) {
+	g.f.mutex.Lock()
+	defer g.f.mutex.Unlock()
+	g.f.get(values).value += delta
+}
+
+// Delete removes the series of the label values, it is no longer exposed.
+func (g *Gauge) Delete(values ...string) {
+	g.f.mutex.Lock()
+	defer g.f.mutex.Unlock()
+	delete(g.f.series, strings.Join(values, "\xff"))
+}
+
+// Value returns the value of the series of the label values, 0 when it was never set.
+func (g *Gauge) Value(values ...string) float64 {
+	return g.f.value(values)
+}
+
+func (f *family) value(values []string) float64 {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	if s, ok := f.series[strings.Join(values, "\xff")]; ok {
+		return s.value
+	}
+	return 0
+}
+
+// Histogram counts observations, such as latencies, in buckets.
+type Histogram struct{ f *family }
+
+// NewHistogram registers a histogram named name with the upper bounds buckets, DefBuckets when nil, and the given label names.
+func (r *Registry) NewHistogram(name, help string, buckets []float64, labels ...string) *Histogram {
+	if buckets == nil {
+		buckets = DefBuckets
+	}
+	buckets = append([]float64(nil), buckets...)
+	sort.Float64s(buckets)
+	return &Histogram{r.register(&family{name: name, help: help, kind: "histogram", labels: labels, buckets: buckets})}
+}
+
+// Observe adds value to the series of the label values.
+func (h *Histogram) Observe(value float64, values ...string) {
+	h.f.mutex.Lock()
+	defer h.f.mutex.Unlock()
+	s := h.f.get(values)
+	if i := sort.SearchFloat64s(h.f.buckets, value); i < len(h.f.buckets) {
+		s.counts[i]++
+	}
+	s.value += value
+	s.count++
+}
+
+// Count returns the number of observations of the series of the label values.
+func (h *Histogram) Count(values ...string) uint64 {
+	h.f.mutex.Lock()
+	defer h.f.mutex.Unlock()
+	if s, ok := h.f.series[strings.Join(values, "\xff")]; ok {
+		return s.count
+	}
+	return 0
+}
+
+// ServeHTTP writes the metrics of r in the Prometheus text exposition format.
+func (r *Registry) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	w.Header().Set("Content-Type", ContentType)
+	buf := bufio.NewWriter(w)
+	r.write(buf)
+	buf.Flush()
+}
+
+// write writes the families sorted by name, their series sorted by label values.
+func (r *Registry) write(w *bufio.Writer) {
+	r.mutex.Lock()
+	families := make([]*family, 0, len(r.families))
+	for _, f := range r.families {
+		families = append(families, f)
+	}
+	r.mutex.Unlock()
+	sort.Slice(families, func(i, j int) bool { return families[i].name < families[j].name })
+	for _, f := range families {
+		f.write(w)
+	}
+}
+
+func (f *family) write(w *bufio.Writer) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	fmt.Fprintf(w, "# HELP %v %v\n", f.name, helpEscaper.Replace(f.help))
+	fmt.Fprintf(w, "# TYPE %v %v\n", f.name, f.kind)
+	keys := make([]string, 0, len(f.series))
+	for key := range f.series {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		s := f.series[key]
+		if f.buckets == nil {
+			fmt.Fprintf(w, "%v%v %v\n", f.name, labelPairs(f.labels, s.values, "", ""), formatFloat(s.value))
+			continue
+		}
+		var cumulated uint64
+		for i, bound := range f.buckets {
+			cumulated += s.counts[i]
+			fmt.Fprintf(w, "%v_bucket%v %d\n", f.name, labelPairs(f.labels, s.values, "le", formatFloat(bound)), cumulated)
+		}
+		fmt.Fprintf(w, "%v_bucket%v %d\n", f.name, labelPairs(f.labels, s.values, "le", "+Inf"), s.count)
+		fmt.Fprintf(w, "%v_sum%v %v\n", f.name, labelPairs(f.labels, s.values, "", ""), formatFloat(s.value))
+		fmt.Fprintf(w, "%v_count%v %d\n", f.name, labelPairs(f.labels, s.values, "", ""), s.count)
+	}
+}
+
+var (
+	helpEscaper  = strings.NewReplacer(`\`, `\\`, "\n", `\n`)
+	valueEscaper = strings.NewReplacer(`\`, `\\`, "\n", `\n`, `"`, `\"`)
+)
+
+// labelPairs formats labels and values as {name="value",...}, followed by the extra label when it is set.
+func labelPairs(labels, values []string, extra, extraValue string) string {
+	if len(labels) == 0 && extra == "" {
+		return ""
+	}
+	pairs := make([]string, 0, len(labels)+1)
+	for i, label := range labels {
+		pairs = append(pairs, fmt.Sprintf(`%v="%v"`, label, valueEscaper.Replace(values[i])))
+	}
+	if extra != "" {
+		pairs = append(pairs, fmt.Sprintf(`%v="%v"`, extra, extraValue))
+	}
+	return "{" + strings.Join(pairs, ",") + "}"
+}
+
+func formatFloat(v float64) string {
+	switch {
+	case math.IsInf(v, 1):
+		return "+Inf"
+	case math.IsInf(v, -1):
+		return "-Inf"
+	}
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
@@ -0,0 +1,86 @@
+package metrics_test
+
+import (
+	"github.com/xcaliburne/RemoteAttestations/pkg/metrics"
+	"github.com/xcaliburne/RemoteAttestations/pkg/tests"
+	"io/ioutil"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestRegistry(t *testing.T) {
+	r := metrics.NewRegistry()
+	requests := r.NewCounter("requests_total", "Requests served.", "path", "code")
+	depth := r.NewGauge("queue_depth", "Jobs waiting\nfor a worker.")
+	lastSeen := r.NewGauge("last_seen_timestamp_seconds", "Last time a host was seen.", "host")
+	latency := r.NewHistogram("latency_seconds", "Request latency.", []float64{1, 0.1})
+
+	requests.Inc("/attest", "200")
+	requests.Add(2, "/attest", "200")
+	requests.Inc(`/"quoted"`, "500")
+	depth.Set(4)
+	depth.Add(-1)
+	lastSeen.Set(1600000000, "edge")
+	lastSeen.Set(1600000001, "gone")
+	lastSeen.Delete("gone")
+	for _, v := range []float64{0.05, 0.1, 0.5, 3} {
+		latency.Observe(v)
+	}
+
+	if got := requests.Value("/attest", "200"); got != 3 {
+		t.Error(tests.Failure(t, got, 3, "counter value"))
+	}
+	if got := latency.Count(); got != 4 {
+		t.Error(tests.Failure(t, got, 4, "histogram count"))
+	}
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest("GET", "/metrics", nil))
+	if got := w.Header().Get("Content-Type"); got != metrics.ContentType {
+		t.Error(tests.Failure(t, got, metrics.ContentType, "content type"))
+	}
+	body, _ := ioutil.ReadAll(w.Body)
+	want := `# HELP last_seen_timestamp_seconds Last time a host was seen.
+# TYPE last_seen_timestamp_seconds gauge
+last_seen_timestamp_seconds{host="edge"} 1.6e+09
+# HELP latency_seconds Request latency.
+# TYPE latency_seconds histogram
+latency_seconds_bucket{le="0.1"} 2
+latency_seconds_bucket{le="1"} 3
+latency_seconds_bucket{le="+Inf"} 4
+latency_seconds_sum 3.65
+latency_seconds_count 4
+# HELP queue_depth Jobs waiting\nfor a worker.
+# TYPE queue_depth gauge
+queue_depth 3
+# HELP requests_total Requests served.
+# TYPE requests_total counter
+requests_total{path="/\"quoted\"",code="500"} 1
+requests_total{path="/attest",code="200"} 3
+`
+	if string(body) != want {
+		t.Error(tests.Failure(t, string(body), want, "exposition"))
+	}
+}
+
+func TestRegistry_invalid(t *testing.T) {
+	r := metrics.NewRegistry()
+	requests := r.NewCounter("requests_total", "Requests served.", "path")
+	var testSuite = []struct {
+		name string
+		f    func()
+	}{
+		{name: "missing label value", f: func() { requests.Inc() }},
+		{name: "negative counter delta", f: func() { requests.Add(-1, "/") }},
+		{name: "metric registered twice", f: func() { r.NewGauge("requests_total", "Requests served.") }},
+	}
+	for _, test := range testSuite {
+		t.Run(test.name, func(t *testing.T) {
+			defer func() {
+				if recover() == nil {
+					t.Error(tests.Failure(t, nil, "panic", ""))
+				}
+			}()
+			test.f()
+		})
+	}
+}
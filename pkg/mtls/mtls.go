@@ -0,0 +1,190 @@
+// Package mtls builds the TLS configurations mutually authenticating provers and verifier.
+package mtls
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+)
+
+// ErrInsecure is returned when TLS is not configured and plain HTTP was not explicitly allowed.
+var ErrInsecure = errors.New("TLS not configured, plain HTTP must be explicitly allowed")
+
+// Config is the certificate a side presents and the CAs it verifies the certificates of its peers with.
+// The same certificate is used as server and as client, it needs both extended key usages when it has any.
+type Config struct {
+	Certificate string `yaml:"certificate"`
+	Key         string `yaml:"key"`
+	// CAs are PEM bundles or directories of PEM bundles of the CAs issuing the certificates of the peers.
+	CAs []string `yaml:"cas"`
+	// AdminCAs issue the client certificates of the operators, the only ones accepted on the admin routes of a server.
+	// They must not issue certificates of peers.
+	AdminCAs []string `yaml:"admin_cas"`
+	// ServerName is the name expected in the certificates of the servers, the host of the URL when empty.
+	ServerName string `yaml:"server_name"`
+	// SkipServerName skips the name check of the certificates of the servers, their chain is still verified.
+	SkipServerName bool `yaml:"skip_server_name"`
+}
+
+// Enabled tells whether TLS is configured.
+func (c Config) Enabled() bool {
+	return c.Certificate != ""
+}
+
+// Check returns ErrInsecure when TLS is not configured, unless insecure allows plain HTTP.
+func (c Config) Check(insecure bool) error {
+	if c.Enabled() || insecure {
+		return nil
+	}
+	return ErrInsecure
+}
+
+// Server returns the TLS configuration of a server requiring client certificates issued by the CAs or the admin CAs.
+func (c Config) Server() (*tls.Config, error) {
+	cert, cas, err := c.load()
+	if err != nil {
+		return nil, err
+	}
+	for _, path := range c.AdminCAs {
+		if err = loadCAs(cas, path); err != nil {
+			return nil, err
+		}
+	}
+	return &tls.Config{
+		Certificates: []tls.Certificate{cert},
+		ClientCAs:    cas,
+		ClientAuth:   tls.RequireAndVerifyClientCert,
+		MinVersion:   tls.VersionTLS12,
+	}, nil
+}
+
+// Client returns the TLS configuration of a client presenting the certificate and trusting the servers issued by the CAs.
+func (c Config) Client() (*tls.Config, error) {
+	cert, cas, err := c.load()
+	if err != nil {
+		return nil, err
+	}
+	config := &tls.Config{
+		Certificates: []tls.Certificate{cert},
+		RootCAs:      cas,
+		ServerName:   c.ServerName,
+		MinVersion:   tls.VersionTLS12,
+	}
+	if c.SkipServerName {
+		// The default verification checks the name, the chain is verified by VerifyConnection instead.
+		config.InsecureSkipVerify = true
+		config.VerifyConnection = func(state tls.ConnectionState) error {
+			return verifyChain(state.PeerCertificates, cas)
+		}
+	}
+	return config, nil
+}
+
+// Operators authenticates the operators by their client certificate.
+type Operators struct {
+	roots *x509.CertPool
+}
+
+// ErrNotOperator is returned when a request is not sent with the client certificate of an operator.
+var ErrNotOperator = errors.New("not authenticated as operator")
+
+// Operators returns the operators whose certificates are issued by the admin CAs, nil when there is none.
+func (c Config) Operators() (*Operators, error) {
+	if len(c.AdminCAs) == 0 {
+		return nil, nil
+	}
+	roots := x509.NewCertPool()
+	for _, path := range c.AdminCAs {
+		if err := loadCAs(roots, path); err != nil {
+			return nil, err
+		}
+	}
+	return &Operators{roots: roots}, nil
+}
+
+// Operator returns the common name of the client certificate of r, ErrNotOperator when it is not issued by an admin CA.
+// The handshake accepted the certificate of any peer, the chain is verified again against the admin CAs alone.
+func (o *Operators) Operator(r *http.Request) (string, error) {
+	if r.TLS == nil || len(r.TLS.PeerCertificates) == 0 {
+		return "", ErrNotOperator
+	}
+	certs := r.TLS.PeerCertificates
+	intermediates := x509.NewCertPool()
+	for _, cert := range certs[1:] {
+		intermediates.AddCert(cert)
+	}
+	_, err := certs[0].Verify(x509.VerifyOptions{Roots: o.roots, Intermediates: intermediates, KeyUsages: []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth}})
+	if err != nil || certs[0].Subject.CommonName == "" {
+		return "", ErrNotOperator
+	}
+	return certs[0].Subject.CommonName, nil
+}
+
+// verifyChain verifies that the first certificate of certs is issued by roots, through the other ones.
+func verifyChain(certs []*x509.Certificate, roots *x509.CertPool) error {
+	if len(certs) == 0 {
+		return fmt.Errorf("no server certificate")
+	}
+	intermediates := x509.NewCertPool()
+	for _, cert := range certs[1:] {
+		intermediates.AddCert(cert)
+	}
+	_, err := certs[0].Verify(x509.VerifyOptions{Roots: roots, Intermediates: intermediates})
+	return err
+}
+
+func (c Config) load() (tls.Certificate, *x509.CertPool, error) {
+	if !c.Enabled() {
+		return tls.Certificate{}, nil, fmt.Errorf("no TLS certificate configured")
+	}
+	cert, err := tls.LoadX509KeyPair(c.Certificate, c.Key)
+	if err != nil {
+		return tls.Certificate{}, nil, fmt.Errorf("error loading TLS certificate: %v", err)
+	}
+	if len(c.CAs) == 0 {
+		return tls.Certificate{}, nil, fmt.Errorf("no CA configured to verify the TLS peers")
+	}
+	cas := x509.NewCertPool()
+	for _, path := range c.CAs {
+		if err = loadCAs(cas, path); err != nil {
+			return tls.Certificate{}, nil, err
+		}
+	}
+	return cert, cas, nil
+}
+
+// loadCAs adds the certificates of the PEM bundle path to pool, or of every PEM bundle it holds when it is a directory.
+func loadCAs(pool *x509.CertPool, path string) error {
+	info, err := os.Stat(path)
+	if err != nil {
+		return fmt.Errorf("error reading CAs: %v", err)
+	}
+	files := []string{path}
+	if info.IsDir() {
+		entries, err := ioutil.ReadDir(path)
+		if err != nil {
+			return fmt.Errorf("error reading CAs: %v", err)
+		}
+		files = nil
+		for _, entry := range entries {
+			if !entry.IsDir() {
+				files = append(files, filepath.Join(path, entry.Name()))
+			}
+		}
+	}
+	for _, file := range files {
+		data, err := ioutil.ReadFile(file)
+		if err != nil {
+			return fmt.Errorf("error reading CAs: %v", err)
+		}
+		if !pool.AppendCertsFromPEM(data) {
+			return fmt.Errorf("no PEM certificate in %v", file)
+		}
+	}
+	return nil
+}
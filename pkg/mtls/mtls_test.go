@@ -0,0 +1,232 @@
+package mtls_test
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"github.com/xcaliburne/RemoteAttestations/pkg/httpClient"
+	"github.com/xcaliburne/RemoteAttestations/pkg/mtls"
+	"github.com/xcaliburne/RemoteAttestations/pkg/tests"
+	"io/ioutil"
+	"math/big"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+type authority struct {
+	cert *x509.Certificate
+	key  *ecdsa.PrivateKey
+	file string
+}
+
+func writePEM(t *testing.T, file, blockType string, data []byte) string {
+	if err := ioutil.WriteFile(file, pem.EncodeToMemory(&pem.Block{Type: blockType, Bytes: data}), 0600); err != nil {
+		t.Fatalf("WriteFile() returned an error: %v", err)
+	}
+	return file
+}
+
+func newAuthority(t *testing.T, dir, name string) *authority {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("GenerateKey() returned an error: %v", err)
+	}
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: name},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		KeyUsage:              x509.KeyUsageCertSign,
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatalf("CreateCertificate() returned an error: %v", err)
+	}
+	cert, _ := x509.ParseCertificate(der)
+	return &authority{cert: cert, key: key, file: writePEM(t, filepath.Join(dir, name+".pem"), "CERTIFICATE", der)}
+}
+
+// issue returns the certificate and key files of name, valid for 127.0.0.1 as server and as client.
+func (a *authority) issue(t *testing.T, dir, name string) (string, string) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("GenerateKey() returned an error: %v", err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(time.Now().UnixNano()),
+		Subject:      pkix.Name{CommonName: name},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+		DNSNames:     []string{name},
+		IPAddresses:  []net.IP{net.IPv4(127, 0, 0, 1)},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, a.cert, &key.PublicKey, a.key)
+	if err != nil {
+		t.Fatalf("CreateCertificate() returned an error: %v", err)
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatalf("MarshalECPrivateKey() returned an error: %v", err)
+	}
+	return writePEM(t, filepath.Join(dir, name+".crt"), "CERTIFICATE", der), writePEM(t, filepath.Join(dir, name+".key"), "EC PRIVATE KEY", keyDER)
+}
+
+func TestConfig(t *testing.T) {
+	dir := t.TempDir()
+	proverCA := newAuthority(t, dir, "prover-ca")
+	verifierCA := newAuthority(t, dir, "verifier-ca")
+	otherCA := newAuthority(t, dir, "other-ca")
+	empty := filepath.Join(dir, "empty.pem")
+	if err := ioutil.WriteFile(empty, nil, 0600); err != nil {
+		t.Fatalf("WriteFile() returned an error: %v", err)
+	}
+	proverCert, proverKey := proverCA.issue(t, dir, "prover")
+	verifierCert, verifierKey := verifierCA.issue(t, dir, "verifier")
+	otherCert, otherKey := otherCA.issue(t, dir, "other")
+
+	server := mtls.Config{Certificate: proverCert, Key: proverKey, CAs: []string{verifierCA.file}}
+	serverTLS, err := server.Server()
+	if err != nil {
+		t.Fatalf("Server() returned an error: %v", err)
+	}
+	testServer := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte(r.TLS.PeerCertificates[0].Subject.CommonName))
+	}))
+	testServer.TLS = serverTLS
+	testServer.StartTLS()
+	defer testServer.Close()
+
+	var testSuite = []struct {
+		name    string
+		client  mtls.Config
+		wantErr bool
+	}{
+		{name: "mutual authentication", client: mtls.Config{Certificate: verifierCert, Key: verifierKey, CAs: []string{proverCA.file}}},
+		{name: "expected server name", client: mtls.Config{Certificate: verifierCert, Key: verifierKey, CAs: []string{proverCA.file}, ServerName: "prover"}},
+		{name: "unexpected server name", client: mtls.Config{Certificate: verifierCert, Key: verifierKey, CAs: []string{proverCA.file}, ServerName: "gateway"}, wantErr: true},
+		{name: "server name skipped", client: mtls.Config{Certificate: verifierCert, Key: verifierKey, CAs: []string{proverCA.file}, ServerName: "gateway", SkipServerName: true}},
+		{name: "server issued by another CA", client: mtls.Config{Certificate: verifierCert, Key: verifierKey, CAs: []string{otherCA.file}}, wantErr: true},
+		{name: "server issued by another CA, name skipped", client: mtls.Config{Certificate: verifierCert, Key: verifierKey, CAs: []string{otherCA.file}, SkipServerName: true}, wantErr: true},
+		{name: "client issued by another CA", client: mtls.Config{Certificate: otherCert, Key: otherKey, CAs: []string{proverCA.file}}, wantErr: true},
+	}
+	for _, test := range testSuite {
+		t.Run(test.name, func(t *testing.T) {
+			clientTLS, err := test.client.Client()
+			if err != nil {
+				t.Fatalf("Client() returned an error: %v", err)
+			}
+			resp, err := httpClient.New(clientTLS).Get(testServer.URL)
+			if (err != nil) != test.wantErr {
+				t.Fatal(tests.Failure(t, err, test.wantErr, "handshake error"))
+			}
+			if err != nil {
+				return
+			}
+			defer resp.Body.Close()
+			body, _ := ioutil.ReadAll(resp.Body)
+			if string(body) != "verifier" {
+				t.Error(tests.Failure(t, string(body), "verifier", "client certificate seen by the server"))
+			}
+		})
+	}
+
+	if _, err = httpClient.New(nil).Get(testServer.URL); err == nil {
+		t.Error(tests.Failure(t, err, "unknown authority", "client without TLS configuration"))
+	}
+	if _, err = (mtls.Config{Certificate: verifierCert, Key: verifierKey}).Client(); err == nil {
+		t.Error(tests.Failure(t, err, "no CA", "configuration without CA"))
+	}
+	if _, err = (mtls.Config{Certificate: verifierCert, Key: verifierKey, CAs: []string{empty}}).Client(); err == nil {
+		t.Error(tests.Failure(t, err, "no PEM certificate", "empty CA bundle"))
+	}
+}
+
+func TestConfig_Check(t *testing.T) {
+	var testSuite = []struct {
+		name     string
+		config   mtls.Config
+		insecure bool
+		want     error
+	}{
+		{name: "TLS configured", config: mtls.Config{Certificate: "verifier.crt"}},
+		{name: "insecure allowed", insecure: true},
+		{name: "insecure refused", want: mtls.ErrInsecure},
+	}
+	for _, test := range testSuite {
+		t.Run(test.name, func(t *testing.T) {
+			if got := test.config.Check(test.insecure); got != test.want {
+				t.Error(tests.Failure(t, got, test.want, ""))
+			}
+		})
+	}
+}
+
+func TestOperators_Operator(t *testing.T) {
+	dir := t.TempDir()
+	proverCA := newAuthority(t, dir, "prover-ca")
+	adminCA := newAuthority(t, dir, "admin-ca")
+	verifierCert, verifierKey := proverCA.issue(t, dir, "verifier")
+	proverCert, proverKey := proverCA.issue(t, dir, "prover")
+	aliceCert, aliceKey := adminCA.issue(t, dir, "alice")
+
+	server := mtls.Config{Certificate: verifierCert, Key: verifierKey, CAs: []string{proverCA.file}, AdminCAs: []string{adminCA.file}}
+	serverTLS, err := server.Server()
+	if err != nil {
+		t.Fatalf("Server() returned an error: %v", err)
+	}
+	operators, err := server.Operators()
+	if err != nil {
+		t.Fatalf("Operators() returned an error: %v", err)
+	}
+	testServer := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		operator, err := operators.Operator(r)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusForbidden)
+			return
+		}
+		_, _ = w.Write([]byte(operator))
+	}))
+	testServer.TLS = serverTLS
+	testServer.StartTLS()
+	defer testServer.Close()
+
+	var testSuite = []struct {
+		name   string
+		client mtls.Config
+		want   int
+	}{
+		{name: "operator", client: mtls.Config{Certificate: aliceCert, Key: aliceKey, CAs: []string{proverCA.file}}, want: http.StatusOK},
+		{name: "prover", client: mtls.Config{Certificate: proverCert, Key: proverKey, CAs: []string{proverCA.file}}, want: http.StatusForbidden},
+	}
+	for _, test := range testSuite {
+		t.Run(test.name, func(t *testing.T) {
+			clientTLS, err := test.client.Client()
+			if err != nil {
+				t.Fatalf("Client() returned an error: %v", err)
+			}
+			resp, err := httpClient.New(clientTLS).Get(testServer.URL)
+			if err != nil {
+				t.Fatalf("Get() returned an error: %v", err)
+			}
+			defer resp.Body.Close()
+			if resp.StatusCode != test.want {
+				t.Error(tests.Failure(t, resp.StatusCode, test.want, ""))
+			}
+		})
+	}
+
+	if operators, err = (mtls.Config{}).Operators(); operators != nil || err != nil {
+		t.Error(tests.Failure(t, operators, nil, "operators without admin CA"))
+	}
+}
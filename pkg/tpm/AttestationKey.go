@@ -13,16 +13,23 @@ type AttestationKey interface {
 	Save(filePath string) error
 	PublicKey() *rsa.PublicKey
 	Blob() []byte
+	// PublicArea returns the public key as the TPM encodes it: a TPM_PUBKEY for TPM 1.2, a TPMT_PUBLIC for TPM 2.0.
+	PublicArea() []byte
+	TPMVersion() int
 }
 
 type AttestationKeyData struct {
 	PK *rsa.PublicKey
 	B  []byte
+	P  []byte
+	V  int
 }
 
 type attestationKeyData struct {
-	PublicKey *rsa.PublicKey
-	Blob      []byte
+	PublicKey  *rsa.PublicKey
+	Blob       []byte
+	PublicArea []byte `json:",omitempty"`
+	TPMVersion int    `json:",omitempty"`
 }
 
 var _ AttestationKey = (*AttestationKeyData)(nil) // Verify that *AttestationKeyData implements AttestationKey.
@@ -70,8 +77,16 @@ func (ak *AttestationKeyData) Blob() []byte {
 	return ak.B
 }
 
+func (ak *AttestationKeyData) PublicArea() []byte {
+	return ak.P
+}
+
+func (ak *AttestationKeyData) TPMVersion() int {
+	return ak.V
+}
+
 func (ak *AttestationKeyData) MarshalJSON() ([]byte, error) {
-	return json.Marshal(&attestationKeyData{PublicKey: ak.PublicKey(), Blob: ak.Blob()})
+	return json.Marshal(&attestationKeyData{PublicKey: ak.PublicKey(), Blob: ak.Blob(), PublicArea: ak.PublicArea(), TPMVersion: ak.TPMVersion()})
 }
 
 func (ak *AttestationKeyData) UnmarshalJSON(data []byte) error {
@@ -82,6 +97,6 @@ func (ak *AttestationKeyData) UnmarshalJSON(data []byte) error {
 	if aux.PublicKey == nil || len(aux.Blob) == 0 {
 		return fmt.Errorf("missing required fields")
 	}
-	ak.PK, ak.B = aux.PublicKey, aux.Blob
+	ak.PK, ak.B, ak.P, ak.V = aux.PublicKey, aux.Blob, aux.PublicArea, aux.TPMVersion
 	return nil
 }
@@ -0,0 +1,135 @@
+package tpm
+
+import (
+	"crypto/rsa"
+	"fmt"
+	"github.com/google/go-tpm/tpm2"
+	"github.com/google/go-tpm/tpm2/credactivation"
+	"github.com/google/go-tpm/tpmutil"
+	"github.com/google/go-tspi/tspiconst"
+	"github.com/google/go-tspi/verification"
+	"math/big"
+)
+
+// Credential is a secret encrypted to an EK for one AK, only the TPM holding both keys can recover it.
+// For TPM 1.2, Secret and Credential are the asymmetric and symmetric blobs of TPM_ActivateIdentity.
+// For TPM 2.0, they are the encrypted seed and the credential blob of TPM2_ActivateCredential.
+type Credential struct {
+	Credential []byte
+	Secret     []byte
+}
+
+// MakeCredential encrypts secret to ek so that it can only be recovered by activating ak in the same TPM.
+// The public area of ak must hold the same public key as ak.PublicKey() and, for TPM 2.0, describe a restricted signing key.
+func MakeCredential(ek EndorsementKey, ak AttestationKey, secret []byte) (*Credential, error) {
+	if ek == nil || ak == nil {
+		return nil, fmt.Errorf("missing endorsement or attestation key")
+	}
+	if len(ak.PublicArea()) == 0 {
+		return nil, fmt.Errorf("attestation key has no public area")
+	}
+	switch ak.TPMVersion() {
+	case 1:
+		pub, err := parseTPM12PublicKey(ak.PublicArea())
+		if err != nil {
+			return nil, err
+		}
+		if !equalPublicKeys(pub, ak.PublicKey()) {
+			return nil, fmt.Errorf("public area does not match the attestation key")
+		}
+		if ek.Certificate() == nil {
+			return nil, fmt.Errorf("missing EK certificate")
+		}
+		asym, sym, err := verification.GenerateChallenge(ek.Certificate().Raw, ak.PublicArea(), secret)
+		if err != nil {
+			return nil, fmt.Errorf("error generating challenge: %v", err)
+		}
+		return &Credential{Credential: sym, Secret: asym}, nil
+	case 2:
+		public, err := tpm2.DecodePublic(ak.PublicArea())
+		if err != nil {
+			return nil, fmt.Errorf("unable to decode AK public area: %v", err)
+		}
+		required := tpm2.FlagFixedTPM | tpm2.FlagRestricted | tpm2.FlagSign
+		if public.Attributes&required != required {
+			return nil, fmt.Errorf("attestation key is not a restricted signing key")
+		}
+		key, err := public.Key()
+		if err != nil {
+			return nil, fmt.Errorf("unable to decode AK public key: %v", err)
+		}
+		pub, ok := key.(*rsa.PublicKey)
+		if !ok || !equalPublicKeys(pub, ak.PublicKey()) {
+			return nil, fmt.Errorf("public area does not match the attestation key")
+		}
+		name, err := public.Name()
+		if err != nil {
+			return nil, fmt.Errorf("unable to compute AK name: %v", err)
+		}
+		credBlob, encSecret, err := credactivation.Generate(name.Digest, ek.PublicKey(), 16, secret)
+		if err != nil {
+			return nil, fmt.Errorf("error generating credential: %v", err)
+		}
+		return &Credential{Credential: credBlob, Secret: encSecret}, nil
+	default:
+		return nil, fmt.Errorf("unsupported TPM version: %d", ak.TPMVersion())
+	}
+}
+
+func equalPublicKeys(a, b *rsa.PublicKey) bool {
+	return a != nil && b != nil && a.E == b.E && a.N.Cmp(b.N) == 0
+}
+
+// tpm12PubKey is a TPM 1.2 TPM_PUBKEY holding an RSA key (TPM Main Part 2 section 10.5).
+type tpm12PubKey struct {
+	AlgorithmID uint32
+	EncScheme   uint16
+	SigScheme   uint16
+	Params      tpmutil.U32Bytes
+	Key         tpmutil.U32Bytes
+}
+
+// tpm12RSAKeyParms is a TPM_RSA_KEY_PARMS, an empty exponent stands for 65537.
+type tpm12RSAKeyParms struct {
+	KeyLength uint32
+	NumPrimes uint32
+	Exponent  tpmutil.U32Bytes
+}
+
+// tpm12PublicKey encodes pk as the TPM_PUBKEY of a TPM 1.2 identity key.
+func tpm12PublicKey(pk *rsa.PublicKey) ([]byte, error) {
+	var exponent []byte
+	if pk.E != 65537 {
+		exponent = big.NewInt(int64(pk.E)).Bytes()
+	}
+	params, err := tpmutil.Pack(tpm12RSAKeyParms{KeyLength: uint32(pk.Size() * 8), NumPrimes: 2, Exponent: exponent})
+	if err != nil {
+		return nil, err
+	}
+	return tpmutil.Pack(tpm12PubKey{
+		AlgorithmID: tspiconst.TPM_ALG_RSA,
+		EncScheme:   tspiconst.TPM_ES_NONE,
+		SigScheme:   tspiconst.TPM_SS_RSASSAPKCS1v15_SHA1,
+		Params:      params,
+		Key:         pk.N.FillBytes(make([]byte, pk.Size())),
+	})
+}
+
+func parseTPM12PublicKey(blob []byte) (*rsa.PublicKey, error) {
+	var pub tpm12PubKey
+	if _, err := tpmutil.Unpack(blob, &pub); err != nil {
+		return nil, fmt.Errorf("invalid TPM_PUBKEY: %v", err)
+	}
+	if pub.AlgorithmID != tspiconst.TPM_ALG_RSA {
+		return nil, fmt.Errorf("TPM_PUBKEY is not RSA")
+	}
+	var params tpm12RSAKeyParms
+	if _, err := tpmutil.Unpack(pub.Params, &params); err != nil {
+		return nil, fmt.Errorf("invalid TPM_RSA_KEY_PARMS: %v", err)
+	}
+	e := 65537
+	if len(params.Exponent) > 0 {
+		e = int(new(big.Int).SetBytes(params.Exponent).Int64())
+	}
+	return &rsa.PublicKey{N: new(big.Int).SetBytes(pub.Key), E: e}, nil
+}
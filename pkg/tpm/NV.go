@@ -0,0 +1,25 @@
+package tpm
+
+import "fmt"
+
+// NVPolicy is the access policy of an NV index. An index is either authorized by the owner or bound to the values
+// its PCRs had when it was defined, it can be read and written by anyone when neither is set.
+type NVPolicy struct {
+	OwnerAuth bool
+	PCRs      PCRSelection
+}
+
+// PCRBound reports whether the index can only be accessed while its PCRs keep their values.
+func (p NVPolicy) PCRBound() bool {
+	return len(p.PCRs.PCRs) > 0
+}
+
+func (p NVPolicy) validate() error {
+	if p.OwnerAuth && p.PCRBound() {
+		return fmt.Errorf("an NV index is either owner authorized or PCR bound")
+	}
+	if p.PCRBound() {
+		return p.PCRs.Validate()
+	}
+	return nil
+}
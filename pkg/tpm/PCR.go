@@ -1,20 +1,205 @@
 package tpm
 
 import (
+	"bytes"
+	"crypto"
 	"fmt"
 	"github.com/google/go-tpm/tpmutil"
 	"sort"
+	"strings"
+
+	_ "crypto/sha1"   // Register SHA-1 for Bank.Hash
+	_ "crypto/sha256" // Register SHA-256 for Bank.Hash
+	_ "crypto/sha512" // Register SHA-384 for Bank.Hash
 )
 
 var All_pcrs = [...]int{0, 1, 2, 3, 4, 5, 6, 7, 8, 9, 10, 11, 12, 13, 14, 15, 16, 17, 18, 19, 20, 21, 22, 23}
 
+// Bank is the hash algorithm of a PCR bank. The zero value is the SHA-1 bank for compatibility with TPM 1.2.
+type Bank string
+
+const (
+	SHA1   Bank = "sha1"
+	SHA256 Bank = "sha256"
+	SHA384 Bank = "sha384"
+)
+
+var banks = map[Bank]crypto.Hash{SHA1: crypto.SHA1, SHA256: crypto.SHA256, SHA384: crypto.SHA384}
+
+func ParseBank(s string) (Bank, error) {
+	b := Bank(strings.ToLower(s))
+	if _, ok := banks[b.normalize()]; !ok {
+		return "", fmt.Errorf("unsupported PCR bank: %v", s)
+	}
+	return b.normalize(), nil
+}
+
+func (b Bank) normalize() Bank {
+	if b == "" {
+		return SHA1
+	}
+	return b
+}
+
+func (b Bank) Hash() (crypto.Hash, error) {
+	h, ok := banks[b.normalize()]
+	if !ok {
+		return 0, fmt.Errorf("unsupported PCR bank: %v", b)
+	}
+	return h, nil
+}
+
+// Size returns the digest size of the bank, 0 if the bank is not supported.
+func (b Bank) Size() int {
+	h, err := b.Hash()
+	if err != nil {
+		return 0
+	}
+	return h.Size()
+}
+
+func (b Bank) Equal(other Bank) bool {
+	return b.normalize() == other.normalize()
+}
+
+func (b Bank) String() string {
+	return string(b.normalize())
+}
+
 type PCR struct {
 	Id    int
+	Bank  Bank
 	Value []byte
 }
 
+// PCRSelection is a set of PCR indexes in one bank.
+type PCRSelection struct {
+	Bank Bank
+	PCRs []int
+}
+
+// AllPCRs selects every PCR of bank.
+func AllPCRs(bank Bank) PCRSelection {
+	return PCRSelection{Bank: bank, PCRs: All_pcrs[:]}
+}
+
+// Validate checks the bank and the PCR indexes of s.
+func (s PCRSelection) Validate() error {
+	if _, err := s.Bank.Hash(); err != nil {
+		return err
+	}
+	for _, id := range s.PCRs {
+		if id < 0 || id >= len(All_pcrs) {
+			return fmt.Errorf("invalid PCR index: %d", id)
+		}
+	}
+	return nil
+}
+
+// SelectPCRs returns the selection of the PCRs of pcrs that belong to bank.
+func SelectPCRs(pcrs []PCR, bank Bank) PCRSelection {
+	sel := PCRSelection{Bank: bank.normalize()}
+	for _, pcr := range FilterBank(pcrs, bank) {
+		sel.PCRs = append(sel.PCRs, pcr.Id)
+	}
+	sort.Ints(sel.PCRs)
+	return sel
+}
+
+// PCRMismatch is a PCR whose quoted value differs from its reference value.
+// Actual is nil when the PCR was not quoted, Expected is nil when the quoted PCR has no reference value.
+type PCRMismatch struct {
+	Id       int
+	Bank     Bank
+	Expected []byte
+	Actual   []byte
+}
+
+func (m PCRMismatch) String() string {
+	if m.Actual == nil {
+		return fmt.Sprintf("%v PCR %d: expected %x, not quoted", m.Bank, m.Id, m.Expected)
+	}
+	if m.Expected == nil {
+		return fmt.Sprintf("%v PCR %d: no reference value, got %x", m.Bank, m.Id, m.Actual)
+	}
+	return fmt.Sprintf("%v PCR %d: expected %x, got %x", m.Bank, m.Id, m.Expected, m.Actual)
+}
+
+// PCRMismatchError is returned by Quote.VerifyPCRs when the quoted PCR values differ from the reference values.
+type PCRMismatchError struct {
+	Mismatches []PCRMismatch
+}
+
+func (e *PCRMismatchError) Error() string {
+	s := make([]string, len(e.Mismatches))
+	for i, m := range e.Mismatches {
+		s[i] = m.String()
+	}
+	return fmt.Sprintf("PCRs don't match reference values: %v", strings.Join(s, "; "))
+}
+
+// ComparePCRs compares each PCR of expected to the PCR of actual with the same bank and index.
+// PCRs of actual without a reference value are mismatches too, the caller drops those it appraises otherwise.
+func ComparePCRs(expected, actual []PCR) error {
+	values := map[Bank]map[int][]byte{}
+	for _, pcr := range actual {
+		if values[pcr.Bank.normalize()] == nil {
+			values[pcr.Bank.normalize()] = map[int][]byte{}
+		}
+		values[pcr.Bank.normalize()][pcr.Id] = pcr.Value
+	}
+	referenced := map[Bank]map[int]bool{}
+	var mismatches []PCRMismatch
+	for _, pcr := range expected {
+		if referenced[pcr.Bank.normalize()] == nil {
+			referenced[pcr.Bank.normalize()] = map[int]bool{}
+		}
+		referenced[pcr.Bank.normalize()][pcr.Id] = true
+		value := values[pcr.Bank.normalize()][pcr.Id]
+		if !bytes.Equal(value, pcr.Value) {
+			mismatches = append(mismatches, PCRMismatch{Id: pcr.Id, Bank: pcr.Bank.normalize(), Expected: pcr.Value, Actual: value})
+		}
+	}
+	for _, pcr := range actual {
+		if !referenced[pcr.Bank.normalize()][pcr.Id] {
+			mismatches = append(mismatches, PCRMismatch{Id: pcr.Id, Bank: pcr.Bank.normalize(), Actual: pcr.Value})
+		}
+	}
+	sort.SliceStable(mismatches, func(i, j int) bool { return mismatches[i].Id < mismatches[j].Id })
+	if len(mismatches) > 0 {
+		return &PCRMismatchError{Mismatches: mismatches}
+	}
+	return nil
+}
+
+// selectValues returns the PCRs of pcrs whose index is in ids.
+func selectValues(pcrs []PCR, ids []int) []PCR {
+	var selected []PCR
+	for _, pcr := range pcrs {
+		for _, id := range ids {
+			if pcr.Id == id {
+				selected = append(selected, pcr)
+				break
+			}
+		}
+	}
+	return selected
+}
+
+// FilterBank returns the PCRs of pcrs that belong to bank.
+func FilterBank(pcrs []PCR, bank Bank) []PCR {
+	var filtered []PCR
+	for _, pcr := range pcrs {
+		if pcr.Bank.Equal(bank) {
+			filtered = append(filtered, pcr)
+		}
+	}
+	return filtered
+}
+
 // Creates a PCR composite as stated in tspi TPM-Main-Part-2-tspiTPM-Structures_v1.2_rev116_01032011.pdf section 5.4.1
 func pcrsToComposite(pcrs []PCR) ([]byte, error) {
+	pcrs = append([]PCR(nil), pcrs...)
 	sort.Slice(pcrs, func(i, j int) bool { return pcrs[i].Id < pcrs[j].Id })
 	var bitmap [3]byte   //24 PCRs bitmap
 	var valBuffer []byte //Buffer for serialized values
@@ -22,6 +207,9 @@ func pcrsToComposite(pcrs []PCR) ([]byte, error) {
 		if pcr.Id < 0 || pcr.Id >= 24 {
 			return nil, fmt.Errorf("invalid PCR index: %d", pcr.Id)
 		}
+		if !pcr.Bank.Equal(SHA1) {
+			return nil, fmt.Errorf("PCR %d: TPM 1.2 composites only support the sha1 bank, got %v", pcr.Id, pcr.Bank)
+		}
 		bitmapId := pcr.Id / 8
 		shift := pcr.Id % 8
 		bitmap[bitmapId] |= 1 << shift
@@ -34,3 +222,20 @@ func pcrsToComposite(pcrs []PCR) ([]byte, error) {
 	}{3, bitmap, valBuffer}
 	return tpmutil.Pack(PCRComposite)
 }
+
+// pcrsToDigest computes the TPM 2.0 quote PCR digest: the hash of the concatenated PCR values in index order.
+func pcrsToDigest(pcrs []PCR, hash crypto.Hash) ([]byte, error) {
+	pcrs = append([]PCR(nil), pcrs...)
+	sort.Slice(pcrs, func(i, j int) bool { return pcrs[i].Id < pcrs[j].Id })
+	h := hash.New()
+	for _, pcr := range pcrs {
+		if pcr.Id < 0 || pcr.Id >= 24 {
+			return nil, fmt.Errorf("invalid PCR index: %d", pcr.Id)
+		}
+		if len(pcr.Value) != pcr.Bank.Size() {
+			return nil, fmt.Errorf("PCR %d: invalid %v value size: %d", pcr.Id, pcr.Bank, len(pcr.Value))
+		}
+		h.Write(pcr.Value)
+	}
+	return h.Sum(nil), nil
+}
@@ -7,18 +7,31 @@ import (
 	"crypto/sha1"
 	"encoding/json"
 	"fmt"
+	"github.com/google/go-tpm/tpmutil"
 	"io"
+	"sort"
 )
 
 type Quote interface {
 	Verify(ak AttestationKey, nonce []byte) error
+	// VerifyPCRs checks the PCR values against the quote. It returns a *PCRMismatchError listing the PCRs
+	// that differ when the quote carries its PCR values.
 	VerifyPCRs(pcrs []PCR) error
+	// Selection returns the PCRs covered by the quote.
+	Selection() PCRSelection
+	// PCRValues returns the values of the quoted PCRs sent by the prover, they are not checked against the quote.
+	PCRValues() []PCR
 }
 
+// QuoteData is a TPM 1.2 quote. PCRs are the sha1 PCRs of the composite, the digest only matches them.
+// Values are the values of these PCRs read by the prover.
+// Parsed is a copy of the TPM_QUOTE_INFO signed in Raw, Verify rejects the quote when they differ.
 type QuoteData struct {
 	Raw       []byte
 	Parsed    ParsedQuote
 	Signature []byte
+	PCRs      []int `json:",omitempty"`
+	Values    []PCR `json:",omitempty"`
 }
 
 type ParsedQuote struct {
@@ -32,12 +45,23 @@ var _ Quote = (*QuoteData)(nil) // Verify that *QuoteData implements Quote.
 
 var (
 	DeserializeQuote = func(r io.Reader) (Quote, error) {
-		q := &QuoteData{}
+		var raw json.RawMessage
 		decoder := json.NewDecoder(r)
-		err := decoder.Decode(q)
+		err := decoder.Decode(&raw)
 		if err != nil {
 			return nil, err
 		}
+		version := struct{ Version string }{}
+		if err = json.Unmarshal(raw, &version); err != nil {
+			return nil, err
+		}
+		var q Quote = &QuoteData{}
+		if version.Version == TPM2QuoteVersion {
+			q = &TPM2QuoteData{}
+		}
+		if err = json.Unmarshal(raw, q); err != nil {
+			return nil, err
+		}
 		return q, nil
 	}
 	SerializeQuote = func(q Quote) ([]byte, error) {
@@ -45,40 +69,106 @@ var (
 	}
 )
 
+// quoteInfo decodes the TPM_QUOTE_INFO signed in Raw.
+func (q *QuoteData) quoteInfo() (*ParsedQuote, error) {
+	info := &ParsedQuote{}
+	read, err := tpmutil.Unpack(q.Raw, info)
+	if err != nil {
+		return nil, fmt.Errorf("error decoding TPM_QUOTE_INFO: %v", err)
+	}
+	if read != len(q.Raw) {
+		return nil, fmt.Errorf("error decoding TPM_QUOTE_INFO: %d trailing bytes", len(q.Raw)-read)
+	}
+	return info, nil
+}
+
+// Verify checks the signature of Raw and that the rest of the quote matches it: Parsed is the signed TPM_QUOTE_INFO
+// and, when the quote carries its PCR values, their composite is the signed one and covers the PCRs of Selection.
 func (q *QuoteData) Verify(ak AttestationKey, nonce []byte) error {
 	quoteDigest := sha1.Sum(q.Raw)
 	//First check signature
 	if err := rsa.VerifyPKCS1v15(ak.PublicKey(), crypto.SHA1, quoteDigest[:], q.Signature); err != nil {
 		return fmt.Errorf("invalid signature: %v", err)
 	}
+	info, err := q.quoteInfo()
+	if err != nil {
+		return err
+	}
+	if *info != q.Parsed {
+		return fmt.Errorf("parsed quote differs from the signed quote")
+	}
 	//Check nonce
-	if q.Parsed.Nonce != sha1.Sum(nonce) {
+	if info.Nonce != sha1.Sum(nonce) {
 		return fmt.Errorf("invalid nonce")
 	}
 	//Check object received from tspiTPM
-	if string(q.Parsed.Fixed[:]) != "QUOT" {
-		return fmt.Errorf("expected QUOT object got %s", q.Parsed.Fixed)
+	if string(info.Fixed[:]) != "QUOT" {
+		return fmt.Errorf("expected QUOT object got %s", info.Fixed)
+	}
+	//The PCR selection is only signed through the composite of the PCR values
+	if len(q.Values) > 0 {
+		values := FilterBank(q.Values, SHA1)
+		sort.Slice(values, func(i, j int) bool { return values[i].Id < values[j].Id })
+		if err = q.verifyPCRs(values); err != nil {
+			return fmt.Errorf("quoted PCR values: %v", err)
+		}
+		selection := append([]int{}, q.PCRs...)
+		sort.Ints(selection)
+		if len(selection) != len(values) {
+			return fmt.Errorf("PCR selection differs from the signed quote")
+		}
+		for i, pcr := range values {
+			if pcr.Id != selection[i] {
+				return fmt.Errorf("PCR selection differs from the signed quote")
+			}
+		}
 	}
 	return nil
 }
 
 func (q *QuoteData) VerifyPCRs(pcrs []PCR) error {
-	//Check pcr values
-	composite, err := pcrsToComposite(pcrs)
+	if len(q.Values) > 0 {
+		if err := q.verifyPCRs(q.Values); err != nil {
+			return fmt.Errorf("quoted PCR values: %v", err)
+		}
+		return ComparePCRs(FilterBank(pcrs, SHA1), q.Values)
+	}
+	return q.verifyPCRs(pcrs)
+}
+
+func (q *QuoteData) verifyPCRs(pcrs []PCR) error {
+	//Check pcr values, TPM 1.2 quotes only cover the sha1 bank
+	composite, err := pcrsToComposite(FilterBank(pcrs, SHA1))
 	if err != nil {
 		return fmt.Errorf("creating composite: %v", err)
 	}
-	if q.Parsed.Digest != sha1.Sum(composite) {
+	info, err := q.quoteInfo()
+	if err != nil {
+		return err
+	}
+	if info.Digest != sha1.Sum(composite) {
 		return fmt.Errorf("PCRs don't match ParsedQuote")
 	}
 	return nil
 }
 
+// Selection returns the PCRs of the composite sent by the prover, Verify checks them against the signed composite
+// when the quote carries its PCR values.
+func (q *QuoteData) Selection() PCRSelection {
+	return PCRSelection{Bank: SHA1, PCRs: q.PCRs}
+}
+
+func (q *QuoteData) PCRValues() []PCR {
+	return q.Values
+}
+
 func (q *QuoteData) UnmarshalJSON(data []byte) error {
 	aux := &struct {
 		Raw       []byte
 		Parsed    ParsedQuote
 		Signature []byte
+		PCRs      []int
+		Values    []PCR
 	}{}
 	if err := json.Unmarshal(data, aux); err != nil {
 		return err
@@ -91,6 +181,6 @@ func (q *QuoteData) UnmarshalJSON(data []byte) error {
 		bytes.Equal(aux.Parsed.Version[:], make([]byte, cap(aux.Parsed.Version))) {
 		return fmt.Errorf("missing required fields")
 	}
-	q.Raw, q.Parsed, q.Signature = aux.Raw, aux.Parsed, aux.Signature
+	q.Raw, q.Parsed, q.Signature, q.PCRs, q.Values = aux.Raw, aux.Parsed, aux.Signature, aux.PCRs, aux.Values
 	return nil
 }
@@ -0,0 +1,71 @@
+package tpm_test
+
+import (
+	"github.com/xcaliburne/RemoteAttestations/pkg/tests"
+	"github.com/xcaliburne/RemoteAttestations/pkg/tpm"
+	"testing"
+)
+
+func TestQuoteData_Verify(t *testing.T) {
+	swTPM := openOwnedSoftwareTPM(t, t.TempDir())
+	ak, err := swTPM.CreateAK()
+	if err != nil {
+		t.Fatalf("CreateAK() returned an error: %v", err)
+	}
+	if err = swTPM.ExtendPCR(4, []byte("boot loader"), 0, ""); err != nil {
+		t.Fatalf("ExtendPCR() returned an error: %v", err)
+	}
+	nonce := []byte("nonce")
+	quoted, err := swTPM.Quote(ak, nonce, tpm.PCRSelection{Bank: tpm.SHA1, PCRs: []int{0, 4, 7}})
+	if err != nil {
+		t.Fatalf("Quote() returned an error: %v", err)
+	}
+	genuine := *quoted.(*tpm.QuoteData)
+	// The prover reports PCR 4 unextended along with a parsed quote whose digest matches the values it reports.
+	forged, err := swTPM.Quote(ak, []byte("other nonce"), tpm.PCRSelection{Bank: tpm.SHA1, PCRs: []int{0, 7}})
+	if err != nil {
+		t.Fatalf("Quote() returned an error: %v", err)
+	}
+
+	var testSuite = []struct {
+		name    string
+		tamper  func(q *tpm.QuoteData)
+		wantErr bool
+	}{
+		{name: "correct use", tamper: func(q *tpm.QuoteData) {}, wantErr: false},
+		{
+			name:    "parsed digest differs from the signed digest",
+			tamper:  func(q *tpm.QuoteData) { q.Parsed.Digest[0] ^= 1 },
+			wantErr: true,
+		},
+		{
+			name: "parsed digest and values of another quote",
+			tamper: func(q *tpm.QuoteData) {
+				q.Parsed.Digest = forged.(*tpm.QuoteData).Parsed.Digest
+				q.PCRs, q.Values = forged.(*tpm.QuoteData).PCRs, forged.(*tpm.QuoteData).Values
+			},
+			wantErr: true,
+		},
+		{
+			name:    "PCR selection differs from the signed composite",
+			tamper:  func(q *tpm.QuoteData) { q.PCRs = []int{0, 7} },
+			wantErr: true,
+		},
+		{
+			name:    "trailing bytes after the signed quote info",
+			tamper:  func(q *tpm.QuoteData) { q.Raw = append(append([]byte{}, q.Raw...), 0) },
+			wantErr: true,
+		},
+	}
+	for _, test := range testSuite {
+		t.Run(test.name, func(t *testing.T) {
+			q := genuine
+			q.PCRs = append([]int{}, genuine.PCRs...)
+			q.Values = append([]tpm.PCR{}, genuine.Values...)
+			test.tamper(&q)
+			if gotErr := q.Verify(ak, nonce); (gotErr != nil) != test.wantErr {
+				t.Error(tests.Failure(t, gotErr, test.wantErr, ""))
+			}
+		})
+	}
+}
@@ -0,0 +1,688 @@
+package tpm
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/subtle"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"github.com/google/go-tpm/tpm2"
+	"github.com/google/go-tpm/tpmutil"
+	"github.com/google/go-tspi/tspiconst"
+	"io/ioutil"
+	"math/big"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+)
+
+const (
+	SoftwareStateFile   = "state.json"
+	SoftwareEKCAFile    = "ek_ca.pem"
+	softwareKeySize     = 2048
+	softwareCertSubject = "Software TPM"
+)
+
+// softwareTPM is a pure Go TPM emulation with sha1, sha256 and sha384 PCR banks persisted in a directory.
+// It is meant for development and CI, it does not protect any secret.
+type softwareTPM struct {
+	mu        sync.Mutex
+	statePath string
+	state     softwareState
+	ownerAuth [20]byte
+	userAuth  [20]byte
+}
+
+type softwareState struct {
+	EK        []byte // PKCS#1 private key
+	EKCert    []byte // DER certificate issued by the software manufacturer CA
+	Owned     bool
+	OwnerAuth [20]byte
+	SRKAuth   [20]byte
+	SRK       []byte // PKCS#1 private key
+	Banks     map[Bank][][]byte
+	NV        map[uint32]*softwareNVIndex
+}
+
+// softwareNVIndex is an NV index, Digest is the digest of its PCRs when it was defined.
+type softwareNVIndex struct {
+	Policy  NVPolicy
+	Digest  []byte
+	Data    []byte
+	Written bool
+}
+
+// softwareBanks are the PCR banks emulated by the software TPM.
+var softwareBanks = []Bank{SHA1, SHA256, SHA384}
+
+func (s *softwareState) resetPCRs() {
+	s.Banks = map[Bank][][]byte{}
+	for _, bank := range softwareBanks {
+		s.Banks[bank] = make([][]byte, len(All_pcrs))
+		for i := range s.Banks[bank] {
+			s.Banks[bank][i] = make([]byte, bank.Size())
+		}
+	}
+}
+
+var _ TPM = (*softwareTPM)(nil) // Verify that *softwareTPM implements TPM.
+
+// OpenSoftware opens the software TPM stored in stateDir.
+// The first call manufactures it: a new EK is generated and certified by a software manufacturer CA
+// whose certificate is written to SoftwareEKCAFile.
+func OpenSoftware(stateDir string) (TPM, error) {
+	if stateDir == "" {
+		return nil, fmt.Errorf("missing software TPM state directory")
+	}
+	if err := os.MkdirAll(stateDir, 0700); err != nil {
+		return nil, fmt.Errorf("error creating state directory: %v", err)
+	}
+	tpm := &softwareTPM{statePath: filepath.Join(stateDir, SoftwareStateFile)}
+	raw, err := ioutil.ReadFile(tpm.statePath)
+	if os.IsNotExist(err) {
+		if err = tpm.manufacture(filepath.Join(stateDir, SoftwareEKCAFile)); err != nil {
+			return nil, fmt.Errorf("error manufacturing software TPM: %v", err)
+		}
+		return tpm, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("error reading software TPM state: %v", err)
+	}
+	if err = json.Unmarshal(raw, &tpm.state); err != nil {
+		return nil, fmt.Errorf("error parsing software TPM state: %v", err)
+	}
+	if len(tpm.state.Banks) != len(softwareBanks) {
+		tpm.state.resetPCRs()
+	}
+	return tpm, nil
+}
+
+func (tpm *softwareTPM) manufacture(caPath string) error {
+	caKey, err := rsa.GenerateKey(rand.Reader, softwareKeySize)
+	if err != nil {
+		return err
+	}
+	now := time.Now()
+	caTemplate := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: softwareCertSubject + " EK CA"},
+		NotBefore:             now.Add(-time.Hour),
+		NotAfter:              now.AddDate(20, 0, 0),
+		KeyUsage:              x509.KeyUsageCertSign | x509.KeyUsageCRLSign,
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+	caDER, err := x509.CreateCertificate(rand.Reader, caTemplate, caTemplate, &caKey.PublicKey, caKey)
+	if err != nil {
+		return err
+	}
+	ek, err := rsa.GenerateKey(rand.Reader, softwareKeySize)
+	if err != nil {
+		return err
+	}
+	serial, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 64))
+	if err != nil {
+		return err
+	}
+	ekTemplate := &x509.Certificate{
+		SerialNumber: serial,
+		Subject:      pkix.Name{CommonName: softwareCertSubject + " EK"},
+		NotBefore:    now.Add(-time.Hour),
+		NotAfter:     now.AddDate(20, 0, 0),
+		KeyUsage:     x509.KeyUsageKeyEncipherment,
+	}
+	ekDER, err := x509.CreateCertificate(rand.Reader, ekTemplate, caTemplate, &ek.PublicKey, caKey)
+	if err != nil {
+		return err
+	}
+	caPEM := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: caDER})
+	if err = ioutil.WriteFile(caPath, caPEM, 0644); err != nil {
+		return fmt.Errorf("error writing EK CA certificate: %v", err)
+	}
+	tpm.state = softwareState{EK: x509.MarshalPKCS1PrivateKey(ek), EKCert: ekDER}
+	tpm.state.resetPCRs()
+	return tpm.save()
+}
+
+func (tpm *softwareTPM) save() error {
+	raw, err := json.Marshal(tpm.state)
+	if err != nil {
+		return fmt.Errorf("error marshaling software TPM state: %v", err)
+	}
+	tmp := tpm.statePath + ".tmp"
+	if err = ioutil.WriteFile(tmp, raw, 0600); err != nil {
+		return fmt.Errorf("error writing software TPM state: %v", err)
+	}
+	if err = os.Rename(tmp, tpm.statePath); err != nil {
+		return fmt.Errorf("error writing software TPM state: %v", err)
+	}
+	return nil
+}
+
+func (tpm *softwareTPM) Close() error {
+	return nil
+}
+
+func (tpm *softwareTPM) TakeOwnership(ownerPassword, userPassword string) error {
+	tpm.mu.Lock()
+	defer tpm.mu.Unlock()
+	if tpm.state.Owned {
+		return fmt.Errorf("unable to take ownership: TPM already owned")
+	}
+	srk, err := rsa.GenerateKey(rand.Reader, softwareKeySize)
+	if err != nil {
+		return fmt.Errorf("unable to create SRK: %v", err)
+	}
+	tpm.state.Owned = true
+	tpm.state.OwnerAuth = sha1.Sum([]byte(ownerPassword))
+	tpm.state.SRKAuth = sha1.Sum([]byte(userPassword))
+	tpm.state.SRK = x509.MarshalPKCS1PrivateKey(srk)
+	return tpm.save()
+}
+
+func (tpm *softwareTPM) IsOwned() (bool, error) {
+	tpm.mu.Lock()
+	defer tpm.mu.Unlock()
+	return tpm.state.Owned, nil
+}
+
+func (tpm *softwareTPM) ProveOwnership(ownerPassword string) error {
+	tpm.mu.Lock()
+	defer tpm.mu.Unlock()
+	tpm.ownerAuth = sha1.Sum([]byte(ownerPassword))
+	if tpm.ownerAuth != tpm.state.OwnerAuth {
+		return fmt.Errorf("SetSecret failed: authentication failed")
+	}
+	return nil
+}
+
+func (tpm *softwareTPM) ProveUsership(userPassword string) error {
+	tpm.mu.Lock()
+	defer tpm.mu.Unlock()
+	tpm.userAuth = sha1.Sum([]byte(userPassword))
+	if tpm.userAuth != tpm.state.SRKAuth {
+		return fmt.Errorf("error setting srk secret: authentication failed")
+	}
+	return nil
+}
+
+func (tpm *softwareTPM) GetEK() (EndorsementKey, error) {
+	tpm.mu.Lock()
+	defer tpm.mu.Unlock()
+	cert, err := parseEKCertificate(tpm.state.EKCert)
+	if err != nil {
+		return nil, fmt.Errorf("error parsing cert: %v", err)
+	}
+	rsaPubKey, ok := cert.PublicKey.(*rsa.PublicKey)
+	if !ok {
+		return nil, fmt.Errorf("public key is not RSA")
+	}
+	return &EndorsementKeyData{PK: rsaPubKey, C: cert}, nil
+}
+
+// srk returns the storage root key once the user has proven usership.
+func (tpm *softwareTPM) srk() (*rsa.PrivateKey, error) {
+	if !tpm.state.Owned {
+		return nil, fmt.Errorf("TPM is not owned")
+	}
+	if tpm.userAuth != tpm.state.SRKAuth {
+		return nil, fmt.Errorf("SRK authentication failed")
+	}
+	return x509.ParsePKCS1PrivateKey(tpm.state.SRK)
+}
+
+// storageCipher derives the symmetric key used to wrap blobs under the SRK.
+func storageCipher(srk *rsa.PrivateKey) (cipher.AEAD, error) {
+	key := sha256.Sum256(x509.MarshalPKCS1PrivateKey(srk))
+	block, err := aes.NewCipher(key[:])
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+func (tpm *softwareTPM) wrap(data []byte) ([]byte, error) {
+	srk, err := tpm.srk()
+	if err != nil {
+		return nil, err
+	}
+	aead, err := storageCipher(srk)
+	if err != nil {
+		return nil, err
+	}
+	nonce := make([]byte, aead.NonceSize())
+	if _, err = rand.Read(nonce); err != nil {
+		return nil, err
+	}
+	return aead.Seal(nonce, nonce, data, nil), nil
+}
+
+func (tpm *softwareTPM) unwrap(blob []byte) ([]byte, error) {
+	srk, err := tpm.srk()
+	if err != nil {
+		return nil, err
+	}
+	aead, err := storageCipher(srk)
+	if err != nil {
+		return nil, err
+	}
+	if len(blob) < aead.NonceSize() {
+		return nil, fmt.Errorf("blob too short")
+	}
+	return aead.Open(nil, blob[:aead.NonceSize()], blob[aead.NonceSize():], nil)
+}
+
+func (tpm *softwareTPM) CreateAK() (AttestationKey, error) {
+	tpm.mu.Lock()
+	defer tpm.mu.Unlock()
+	aik, err := rsa.GenerateKey(rand.Reader, softwareKeySize)
+	if err != nil {
+		return nil, err
+	}
+	blob, err := tpm.wrap(x509.MarshalPKCS1PrivateKey(aik))
+	if err != nil {
+		return nil, fmt.Errorf("error wrapping AK: %v", err)
+	}
+	pub, err := tpm12PublicKey(&aik.PublicKey)
+	if err != nil {
+		return nil, err
+	}
+	return &AttestationKeyData{PK: &aik.PublicKey, B: blob, P: pub, V: 1}, nil
+}
+
+func (tpm *softwareTPM) loadAK(blob []byte) (*rsa.PrivateKey, error) {
+	rawKey, err := tpm.unwrap(blob)
+	if err != nil {
+		return nil, fmt.Errorf("LoadKeyByBlob failed: %v", err)
+	}
+	aik, err := x509.ParsePKCS1PrivateKey(rawKey)
+	if err != nil {
+		return nil, fmt.Errorf("LoadKeyByBlob failed: %v", err)
+	}
+	return aik, nil
+}
+
+// ActivateCredential emulates TPM_ActivateIdentity, credentials are made for TPM 1.2 since the AK public area is a TPM_PUBKEY.
+func (tpm *softwareTPM) ActivateCredential(ak AttestationKey, credential *Credential) ([]byte, error) {
+	tpm.mu.Lock()
+	defer tpm.mu.Unlock()
+	if tpm.ownerAuth != tpm.state.OwnerAuth {
+		return nil, fmt.Errorf("ActivateIdentity failed: owner authentication failed")
+	}
+	aik, err := tpm.loadAK(ak.Blob())
+	if err != nil {
+		return nil, err
+	}
+	ek, err := x509.ParsePKCS1PrivateKey(tpm.state.EK)
+	if err != nil {
+		return nil, fmt.Errorf("error parsing EK: %v", err)
+	}
+	asym, err := rsa.DecryptOAEP(sha1.New(), nil, ek, credential.Secret, []byte("TCPA"))
+	if err != nil {
+		return nil, fmt.Errorf("ActivateIdentity failed: %v", err)
+	}
+	// TPM_ASYM_CA_CONTENTS: the session key and the digest of the identity key.
+	var contents struct {
+		AlgorithmID uint32
+		EncScheme   uint16
+		Key         tpmutil.U16Bytes
+		IDDigest    [sha1.Size]byte
+	}
+	if _, err = tpmutil.Unpack(asym, &contents); err != nil {
+		return nil, fmt.Errorf("ActivateIdentity failed: %v", err)
+	}
+	pub, err := tpm12PublicKey(&aik.PublicKey)
+	if err != nil {
+		return nil, err
+	}
+	if sha1.Sum(pub) != contents.IDDigest {
+		return nil, fmt.Errorf("ActivateIdentity failed: credential was made for another identity key")
+	}
+	if contents.AlgorithmID != tspiconst.TPM_ALG_AES128 || contents.EncScheme != tspiconst.TPM_ES_SYM_CBC_PKCS5PAD {
+		return nil, fmt.Errorf("ActivateIdentity failed: unsupported session key")
+	}
+	// TPM_SYM_CA_ATTESTATION: the credential size and TPM_KEY_PARMS, followed by the IV and the encrypted credential.
+	var attestation struct {
+		CredSize    uint32
+		AlgorithmID uint32
+		EncScheme   uint16
+		SigScheme   uint16
+		Params      tpmutil.U32Bytes
+	}
+	n, err := tpmutil.Unpack(credential.Credential, &attestation)
+	if err != nil {
+		return nil, fmt.Errorf("ActivateIdentity failed: %v", err)
+	}
+	encrypted := credential.Credential[n:]
+	if int(attestation.CredSize) != len(encrypted) || len(encrypted) < 2*aes.BlockSize || len(encrypted)%aes.BlockSize != 0 {
+		return nil, fmt.Errorf("ActivateIdentity failed: invalid credential size")
+	}
+	block, err := aes.NewCipher(contents.Key)
+	if err != nil {
+		return nil, fmt.Errorf("ActivateIdentity failed: %v", err)
+	}
+	secret := make([]byte, len(encrypted)-aes.BlockSize)
+	cipher.NewCBCDecrypter(block, encrypted[:aes.BlockSize]).CryptBlocks(secret, encrypted[aes.BlockSize:])
+	if secret, err = pkcs5Unpad(secret); err != nil {
+		return nil, fmt.Errorf("ActivateIdentity failed: %v", err)
+	}
+	return secret, nil
+}
+
+// errInvalidPadding is returned for every padding failure, the error must not tell which padding byte is wrong.
+var errInvalidPadding = errors.New("invalid padding")
+
+// pkcs5Unpad removes the PKCS#5 padding of the CBC plaintext data, every padding byte is checked in constant time.
+func pkcs5Unpad(data []byte) ([]byte, error) {
+	if len(data) == 0 || len(data)%aes.BlockSize != 0 {
+		return nil, errInvalidPadding
+	}
+	padding := int(data[len(data)-1])
+	valid := subtle.ConstantTimeLessOrEq(1, padding) & subtle.ConstantTimeLessOrEq(padding, aes.BlockSize)
+	for i := 1; i <= aes.BlockSize; i++ {
+		inPadding := subtle.ConstantTimeLessOrEq(i, padding)
+		valid &= subtle.ConstantTimeSelect(inPadding, subtle.ConstantTimeByteEq(data[len(data)-i], byte(padding)), 1)
+	}
+	if valid != 1 {
+		return nil, errInvalidPadding
+	}
+	return data[:len(data)-padding], nil
+}
+
+// Quote produces a TPM 1.2 quote for the sha1 bank and a TPM 2.0 TPMS_ATTEST quote signed with SHA-256 for other banks.
+func (tpm *softwareTPM) Quote(ak AttestationKey, nonce []byte, sel PCRSelection) (Quote, error) {
+	tpm.mu.Lock()
+	defer tpm.mu.Unlock()
+	aik, err := tpm.loadAK(ak.Blob())
+	if err != nil {
+		return nil, err
+	}
+	values, ok := tpm.state.Banks[sel.Bank.normalize()]
+	if !ok {
+		return nil, fmt.Errorf("unsupported PCR bank: %v", sel.Bank)
+	}
+	pcrIds := append([]int{}, sel.PCRs...)
+	sort.Ints(pcrIds)
+	pcrs := make([]PCR, 0, len(pcrIds))
+	for _, id := range pcrIds {
+		if id < 0 || id >= len(values) {
+			return nil, fmt.Errorf("failed to set the PCR bitmap: invalid PCR index: %d", id)
+		}
+		pcrs = append(pcrs, PCR{Id: id, Bank: sel.Bank.normalize(), Value: values[id]})
+	}
+	if sel.Bank.Equal(SHA1) {
+		return softwareQuote12(aik, nonce, pcrs)
+	}
+	return softwareQuote20(aik, nonce, sel.Bank, pcrs)
+}
+
+func softwareQuote12(aik *rsa.PrivateKey, nonce []byte, pcrs []PCR) (Quote, error) {
+	composite, err := pcrsToComposite(pcrs)
+	if err != nil {
+		return nil, fmt.Errorf("creating composite: %v", err)
+	}
+	parsed := ParsedQuote{
+		Version: [4]byte{1, 1, 0, 0},
+		Digest:  sha1.Sum(composite),
+		Nonce:   sha1.Sum(nonce),
+	}
+	copy(parsed.Fixed[:], "QUOT")
+	raw, err := tpmutil.Pack(parsed)
+	if err != nil {
+		return nil, fmt.Errorf("failed to ParsedQuote %v", err)
+	}
+	digest := sha1.Sum(raw)
+	signature, err := rsa.SignPKCS1v15(rand.Reader, aik, crypto.SHA1, digest[:])
+	if err != nil {
+		return nil, fmt.Errorf("failed to ParsedQuote %v", err)
+	}
+	ids := make([]int, len(pcrs))
+	for i, pcr := range pcrs {
+		ids[i] = pcr.Id
+	}
+	return &QuoteData{Raw: raw, Parsed: parsed, Signature: signature, PCRs: ids, Values: pcrs}, nil
+}
+
+func softwareQuote20(aik *rsa.PrivateKey, nonce []byte, bank Bank, pcrs []PCR) (Quote, error) {
+	alg, err := bankToAlgorithm(bank)
+	if err != nil {
+		return nil, err
+	}
+	pcrDigest, err := pcrsToDigest(pcrs, crypto.SHA256)
+	if err != nil {
+		return nil, fmt.Errorf("creating digest: %v", err)
+	}
+	ids := make([]int, len(pcrs))
+	for i, pcr := range pcrs {
+		ids[i] = pcr.Id
+	}
+	signerName := sha256.Sum256(x509.MarshalPKCS1PublicKey(&aik.PublicKey))
+	raw, err := tpm2.AttestationData{
+		Magic:           0xff544347,
+		Type:            tpm2.TagAttestQuote,
+		QualifiedSigner: tpm2.Name{Digest: &tpm2.HashValue{Alg: tpm2.AlgSHA256, Value: signerName[:]}},
+		ExtraData:       nonce,
+		ClockInfo:       tpm2.ClockInfo{Clock: uint64(time.Now().UnixNano() / int64(time.Millisecond)), Safe: 1},
+		AttestedQuoteInfo: &tpm2.QuoteInfo{
+			PCRSelection: tpm2.PCRSelection{Hash: alg, PCRs: ids},
+			PCRDigest:    pcrDigest,
+		},
+	}.Encode()
+	if err != nil {
+		return nil, fmt.Errorf("failed to quote: %v", err)
+	}
+	digest := sha256.Sum256(raw)
+	signature, err := rsa.SignPKCS1v15(rand.Reader, aik, crypto.SHA256, digest[:])
+	if err != nil {
+		return nil, fmt.Errorf("failed to quote: %v", err)
+	}
+	return &TPM2QuoteData{Raw: raw, Signature: signature, HashAlg: SHA256, Values: pcrs}, nil
+}
+
+func (tpm *softwareTPM) ListPCRs(bank Bank) ([]PCR, error) {
+	tpm.mu.Lock()
+	defer tpm.mu.Unlock()
+	values, ok := tpm.state.Banks[bank.normalize()]
+	if !ok {
+		return nil, fmt.Errorf("unsupported PCR bank: %v", bank)
+	}
+	pcrs := make([]PCR, len(values))
+	for i, val := range values {
+		pcrs[i] = PCR{Id: i, Bank: bank.normalize(), Value: append([]byte{}, val...)}
+	}
+	return pcrs, nil
+}
+
+// ExtendPCR extends every bank of pcrId with the digest of data, as TPM2_PCR_Event does: PCR = H(PCR || H(data)).
+func (tpm *softwareTPM) ExtendPCR(pcrId int, data []byte, eventId int, event string) error {
+	tpm.mu.Lock()
+	defer tpm.mu.Unlock()
+	if pcrId < 0 || pcrId >= len(All_pcrs) {
+		return fmt.Errorf("invalid PCR index: %d", pcrId)
+	}
+	for bank, values := range tpm.state.Banks {
+		hash, err := bank.Hash()
+		if err != nil {
+			return err
+		}
+		h := hash.New()
+		h.Write(data)
+		digest := h.Sum(nil)
+		h = hash.New()
+		h.Write(values[pcrId])
+		h.Write(digest)
+		values[pcrId] = h.Sum(nil)
+	}
+	return tpm.save()
+}
+
+// softwareSealed is the content of a software sealed blob, Digest is the hash of the sealed PCR values in the bank algorithm.
+type softwareSealed struct {
+	Selection PCRSelection
+	Digest    []byte
+	Data      []byte
+}
+
+// pcrDigest hashes the current values of the PCRs of sel.
+func (tpm *softwareTPM) pcrDigest(sel PCRSelection) ([]byte, error) {
+	values, ok := tpm.state.Banks[sel.Bank.normalize()]
+	if !ok {
+		return nil, fmt.Errorf("unsupported PCR bank: %v", sel.Bank)
+	}
+	hash, err := sel.Bank.Hash()
+	if err != nil {
+		return nil, err
+	}
+	pcrs := make([]PCR, 0, len(sel.PCRs))
+	for _, id := range sel.PCRs {
+		if id < 0 || id >= len(values) {
+			return nil, fmt.Errorf("invalid PCR index: %d", id)
+		}
+		pcrs = append(pcrs, PCR{Id: id, Bank: sel.Bank.normalize(), Value: values[id]})
+	}
+	return pcrsToDigest(pcrs, hash)
+}
+
+func (tpm *softwareTPM) Seal(data []byte, sel PCRSelection) ([]byte, error) {
+	tpm.mu.Lock()
+	defer tpm.mu.Unlock()
+	digest, err := tpm.pcrDigest(sel)
+	if err != nil {
+		return nil, err
+	}
+	raw, err := json.Marshal(softwareSealed{Selection: sel, Digest: digest, Data: data})
+	if err != nil {
+		return nil, err
+	}
+	blob, err := tpm.wrap(raw)
+	if err != nil {
+		return nil, fmt.Errorf("Seal failed: %v", err)
+	}
+	return blob, nil
+}
+
+func (tpm *softwareTPM) Unseal(blob []byte) ([]byte, error) {
+	tpm.mu.Lock()
+	defer tpm.mu.Unlock()
+	raw, err := tpm.unwrap(blob)
+	if err != nil {
+		return nil, fmt.Errorf("Unseal failed: %v", err)
+	}
+	var sealed softwareSealed
+	if err = json.Unmarshal(raw, &sealed); err != nil {
+		return nil, fmt.Errorf("Unseal failed: %v", err)
+	}
+	digest, err := tpm.pcrDigest(sealed.Selection)
+	if err != nil {
+		return nil, err
+	}
+	if !bytes.Equal(digest, sealed.Digest) {
+		return nil, fmt.Errorf("Unseal failed: PCR values differ from the sealed state")
+	}
+	return sealed.Data, nil
+}
+
+func (tpm *softwareTPM) NVDefine(index uint32, size int, policy NVPolicy) error {
+	tpm.mu.Lock()
+	defer tpm.mu.Unlock()
+	if err := policy.validate(); err != nil {
+		return err
+	}
+	if tpm.ownerAuth != tpm.state.OwnerAuth {
+		return fmt.Errorf("NVDefineSpace failed: owner authentication failed")
+	}
+	if _, ok := tpm.state.NV[index]; ok {
+		return fmt.Errorf("NVDefineSpace failed: NV index 0x%x already defined", index)
+	}
+	if size <= 0 {
+		return fmt.Errorf("NVDefineSpace failed: invalid size %d", size)
+	}
+	nv := &softwareNVIndex{Policy: policy, Data: make([]byte, size)}
+	if policy.PCRBound() {
+		digest, err := tpm.pcrDigest(policy.PCRs)
+		if err != nil {
+			return err
+		}
+		nv.Digest = digest
+	}
+	if tpm.state.NV == nil {
+		tpm.state.NV = map[uint32]*softwareNVIndex{}
+	}
+	tpm.state.NV[index] = nv
+	return tpm.save()
+}
+
+// nvIndex returns the index if policy satisfies its access policy.
+// A PCR bound index is checked against the PCRs it was defined with, not the ones policy names.
+func (tpm *softwareTPM) nvIndex(index uint32, policy NVPolicy) (*softwareNVIndex, error) {
+	nv, ok := tpm.state.NV[index]
+	if !ok {
+		return nil, fmt.Errorf("NV index 0x%x is not defined", index)
+	}
+	if nv.Policy.OwnerAuth && tpm.ownerAuth != tpm.state.OwnerAuth {
+		return nil, fmt.Errorf("NV index 0x%x: owner authentication failed", index)
+	}
+	if nv.Policy.PCRBound() {
+		digest, err := tpm.pcrDigest(nv.Policy.PCRs)
+		if err != nil {
+			return nil, err
+		}
+		if !bytes.Equal(digest, nv.Digest) {
+			return nil, fmt.Errorf("NV index 0x%x: PCR policy check failed", index)
+		}
+	}
+	return nv, nil
+}
+
+func (tpm *softwareTPM) NVRead(index uint32, policy NVPolicy) ([]byte, error) {
+	tpm.mu.Lock()
+	defer tpm.mu.Unlock()
+	nv, err := tpm.nvIndex(index, policy)
+	if err != nil {
+		return nil, err
+	}
+	if !nv.Written {
+		return nil, fmt.Errorf("NV index 0x%x has not been written", index)
+	}
+	return append([]byte{}, nv.Data...), nil
+}
+
+func (tpm *softwareTPM) NVWrite(index uint32, data []byte, policy NVPolicy) error {
+	tpm.mu.Lock()
+	defer tpm.mu.Unlock()
+	nv, err := tpm.nvIndex(index, policy)
+	if err != nil {
+		return err
+	}
+	if len(data) > len(nv.Data) {
+		return fmt.Errorf("NV index 0x%x: %d bytes do not fit in %d", index, len(data), len(nv.Data))
+	}
+	copy(nv.Data, data)
+	nv.Written = true
+	return tpm.save()
+}
+
+func (tpm *softwareTPM) NVUndefine(index uint32) error {
+	tpm.mu.Lock()
+	defer tpm.mu.Unlock()
+	if tpm.ownerAuth != tpm.state.OwnerAuth {
+		return fmt.Errorf("NVUndefineSpace failed: owner authentication failed")
+	}
+	if _, ok := tpm.state.NV[index]; !ok {
+		return fmt.Errorf("NV index 0x%x is not defined", index)
+	}
+	delete(tpm.state.NV, index)
+	return tpm.save()
+}
@@ -0,0 +1,311 @@
+package tpm_test
+
+import (
+	"crypto/aes"
+	"errors"
+	"github.com/google/go-cmp/cmp"
+	"github.com/xcaliburne/RemoteAttestations/pkg/tests"
+	"github.com/xcaliburne/RemoteAttestations/pkg/tpm"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func openOwnedSoftwareTPM(t *testing.T, dir string) tpm.TPM {
+	swTPM, err := tpm.OpenSoftware(dir)
+	if err != nil {
+		t.Fatalf("OpenSoftware() returned an error: %v", err)
+	}
+	if err = swTPM.TakeOwnership("owner", "user"); err != nil {
+		t.Fatalf("TakeOwnership() returned an error: %v", err)
+	}
+	if err = swTPM.ProveUsership("user"); err != nil {
+		t.Fatalf("ProveUsership() returned an error: %v", err)
+	}
+	return swTPM
+}
+
+func TestOpenSoftware(t *testing.T) {
+	dir := t.TempDir()
+	swTPM := openOwnedSoftwareTPM(t, dir)
+	if _, err := os.Stat(filepath.Join(dir, tpm.SoftwareEKCAFile)); err != nil {
+		t.Errorf("EK CA certificate not written: %v", err)
+	}
+	ek, err := swTPM.GetEK()
+	if err != nil {
+		t.Fatalf("GetEK() returned an error: %v", err)
+	}
+	reopened, err := tpm.OpenSoftware(dir)
+	if err != nil {
+		t.Fatalf("OpenSoftware() returned an error: %v", err)
+	}
+	owned, err := reopened.IsOwned()
+	if err != nil || !owned {
+		t.Error(tests.Failure(t, owned, true, "state not persisted"))
+	}
+	got, err := reopened.GetEK()
+	if err != nil {
+		t.Fatalf("GetEK() returned an error: %v", err)
+	}
+	if !cmp.Equal(got.PublicKey(), ek.PublicKey()) {
+		t.Error(tests.Failure(t, got.PublicKey(), ek.PublicKey(), "EK changed after reopening"))
+	}
+	if err = reopened.TakeOwnership("owner", "user"); err == nil {
+		t.Error(tests.Failure(t, err, "error", "took ownership twice"))
+	}
+}
+
+func TestSoftwareTPM_ProveOwnership(t *testing.T) {
+	swTPM := openOwnedSoftwareTPM(t, t.TempDir())
+	var testSuite = []struct {
+		name    string
+		prove   func(string) error
+		input   string
+		wantErr bool
+	}{
+		{name: "correct owner password", prove: swTPM.ProveOwnership, input: "owner", wantErr: false},
+		{name: "wrong owner password", prove: swTPM.ProveOwnership, input: "user", wantErr: true},
+		{name: "correct user password", prove: swTPM.ProveUsership, input: "user", wantErr: false},
+		{name: "wrong user password", prove: swTPM.ProveUsership, input: "owner", wantErr: true},
+	}
+	for _, test := range testSuite {
+		t.Run(test.name, func(t *testing.T) {
+			gotErr := test.prove(test.input)
+			if (gotErr != nil) != test.wantErr {
+				t.Error(tests.Failure(t, gotErr, test.wantErr, ""))
+			}
+		})
+	}
+}
+
+func TestSoftwareTPM_Quote(t *testing.T) {
+	swTPM := openOwnedSoftwareTPM(t, t.TempDir())
+	ak, err := swTPM.CreateAK()
+	if err != nil {
+		t.Fatalf("CreateAK() returned an error: %v", err)
+	}
+	if err = swTPM.ExtendPCR(10, []byte("measurement"), 0, ""); err != nil {
+		t.Fatalf("ExtendPCR() returned an error: %v", err)
+	}
+	nonce := []byte("nonce")
+	for _, bank := range []tpm.Bank{tpm.SHA1, tpm.SHA256, tpm.SHA384} {
+		t.Run(bank.String(), func(t *testing.T) {
+			quote, err := swTPM.Quote(ak, nonce, tpm.AllPCRs(bank))
+			if err != nil {
+				t.Fatalf("Quote() returned an error: %v", err)
+			}
+			if err = quote.Verify(ak, nonce); err != nil {
+				t.Error(tests.Failure(t, err, nil, "quote signature"))
+			}
+			if err = quote.Verify(ak, []byte("other nonce")); err == nil {
+				t.Error(tests.Failure(t, err, "error", "nonce not checked"))
+			}
+			pcrs, err := swTPM.ListPCRs(bank)
+			if err != nil {
+				t.Fatalf("ListPCRs() returned an error: %v", err)
+			}
+			if len(pcrs[10].Value) != bank.Size() {
+				t.Error(tests.Failure(t, len(pcrs[10].Value), bank.Size(), "PCR size"))
+			}
+			if err = quote.VerifyPCRs(pcrs); err != nil {
+				t.Error(tests.Failure(t, err, nil, "quoted PCRs"))
+			}
+			extended := pcrs[10].Value
+			pcrs[10].Value = make([]byte, bank.Size())
+			err = quote.VerifyPCRs(pcrs)
+			var mismatchErr *tpm.PCRMismatchError
+			want := []tpm.PCRMismatch{{Id: 10, Bank: bank, Expected: pcrs[10].Value, Actual: extended}}
+			if !errors.As(err, &mismatchErr) || !cmp.Equal(mismatchErr.Mismatches, want) {
+				t.Error(tests.Failure(t, err, want, "PCR 10 was not extended"))
+			}
+			quote.PCRValues()[10].Value = make([]byte, bank.Size())
+			if err = quote.VerifyPCRs(pcrs); err == nil || errors.As(err, &mismatchErr) {
+				t.Error(tests.Failure(t, err, "error", "quoted PCR values were altered"))
+			}
+		})
+	}
+	if err = swTPM.ProveUsership("owner"); err == nil {
+		t.Fatal("ProveUsership() accepted a wrong password")
+	}
+	if _, err = swTPM.Quote(ak, nonce, tpm.AllPCRs(tpm.SHA1)); err == nil {
+		t.Error(tests.Failure(t, err, "error", "quoted without SRK authorization"))
+	}
+}
+
+func TestSoftwareTPM_ActivateCredential(t *testing.T) {
+	swTPM := openOwnedSoftwareTPM(t, t.TempDir())
+	if err := swTPM.ProveOwnership("owner"); err != nil {
+		t.Fatalf("ProveOwnership() returned an error: %v", err)
+	}
+	ek, err := swTPM.GetEK()
+	if err != nil {
+		t.Fatalf("GetEK() returned an error: %v", err)
+	}
+	ak, err := swTPM.CreateAK()
+	if err != nil {
+		t.Fatalf("CreateAK() returned an error: %v", err)
+	}
+	otherAK, err := swTPM.CreateAK()
+	if err != nil {
+		t.Fatalf("CreateAK() returned an error: %v", err)
+	}
+	secret := []byte("0123456789abcdef0123456789abcdef")
+	credential, err := tpm.MakeCredential(ek, ak, secret)
+	if err != nil {
+		t.Fatalf("MakeCredential() returned an error: %v", err)
+	}
+	got, err := swTPM.ActivateCredential(ak, credential)
+	if err != nil {
+		t.Fatalf("ActivateCredential() returned an error: %v", err)
+	}
+	if !cmp.Equal(got, secret) {
+		t.Error(tests.Failure(t, got, secret, "recovered secret"))
+	}
+	if _, err = swTPM.ActivateCredential(otherAK, credential); err == nil {
+		t.Error(tests.Failure(t, err, "error", "activated a credential made for another AK"))
+	}
+	// The secret is a multiple of the block size, the last plaintext block is all padding. Its first and last bytes
+	// are corrupted by flipping the matching bits of the previous ciphertext block, both must fail alike.
+	var paddingErrs []string
+	for _, offset := range []int{2 * aes.BlockSize, aes.BlockSize + 1} {
+		tampered := &tpm.Credential{Credential: append([]byte(nil), credential.Credential...), Secret: credential.Secret}
+		tampered.Credential[len(tampered.Credential)-offset] ^= 1
+		_, err = swTPM.ActivateCredential(ak, tampered)
+		if err == nil {
+			t.Fatal(tests.Failure(t, err, "error", "activated a credential with a corrupted padding"))
+		}
+		paddingErrs = append(paddingErrs, err.Error())
+	}
+	if paddingErrs[0] != paddingErrs[1] {
+		t.Error(tests.Failure(t, paddingErrs[0], paddingErrs[1], "padding errors differ"))
+	}
+	forged := &tpm.AttestationKeyData{PK: otherAK.PublicKey(), B: ak.Blob(), P: ak.PublicArea(), V: ak.TPMVersion()}
+	if _, err = tpm.MakeCredential(ek, forged, secret); err == nil {
+		t.Error(tests.Failure(t, err, "error", "public area does not match the AK"))
+	}
+}
+
+func TestSoftwareTPM_Seal(t *testing.T) {
+	dir := t.TempDir()
+	swTPM := openOwnedSoftwareTPM(t, dir)
+	secret := []byte("tpmOwnerPassword")
+	sealed, err := swTPM.Seal(secret, tpm.PCRSelection{Bank: tpm.SHA256, PCRs: []int{16, 0}})
+	if err != nil {
+		t.Fatalf("Seal() returned an error: %v", err)
+	}
+	unbound, err := swTPM.Seal(secret, tpm.PCRSelection{Bank: tpm.SHA256})
+	if err != nil {
+		t.Fatalf("Seal() returned an error: %v", err)
+	}
+	if got, err := swTPM.Unseal(sealed); err != nil || !cmp.Equal(got, secret) {
+		t.Fatal(tests.Failure(t, err, nil, "unsealing in the sealed state"))
+	}
+	if err = swTPM.ExtendPCR(16, []byte("measurement"), 0, ""); err != nil {
+		t.Fatalf("ExtendPCR() returned an error: %v", err)
+	}
+
+	var testSuite = []struct {
+		name    string
+		blob    []byte
+		wantErr bool
+	}{
+		{name: "PCR extended", blob: sealed, wantErr: true},
+		{name: "No PCR bound", blob: unbound, wantErr: false},
+		{name: "Corrupted blob", blob: append([]byte{}, sealed[:len(sealed)-1]...), wantErr: true},
+	}
+	for _, test := range testSuite {
+		t.Run(test.name, func(t *testing.T) {
+			got, gotErr := swTPM.Unseal(test.blob)
+			if (gotErr != nil) != test.wantErr {
+				t.Fatal(tests.Failure(t, gotErr, test.wantErr, ""))
+			}
+			if gotErr == nil && !cmp.Equal(got, secret) {
+				t.Error(tests.Failure(t, got, secret, "unsealed data"))
+			}
+		})
+	}
+
+	other := openOwnedSoftwareTPM(t, t.TempDir())
+	if _, err = other.Unseal(unbound); err == nil {
+		t.Error(tests.Failure(t, err, "error", "unsealed on another TPM"))
+	}
+}
+
+func TestSoftwareTPM_NV(t *testing.T) {
+	dir := t.TempDir()
+	swTPM := openOwnedSoftwareTPM(t, dir)
+	if err := swTPM.ProveOwnership("owner"); err != nil {
+		t.Fatalf("ProveOwnership() returned an error: %v", err)
+	}
+	owner := tpm.NVPolicy{OwnerAuth: true}
+	bound := tpm.NVPolicy{PCRs: tpm.PCRSelection{Bank: tpm.SHA256, PCRs: []int{16}}}
+	for index, policy := range map[uint32]tpm.NVPolicy{0x01500000: owner, 0x01500001: bound, 0x01500002: {}} {
+		if err := swTPM.NVDefine(index, 8, policy); err != nil {
+			t.Fatalf("NVDefine(0x%x) returned an error: %v", index, err)
+		}
+		if err := swTPM.NVWrite(index, []byte("v1"), policy); err != nil {
+			t.Fatalf("NVWrite(0x%x) returned an error: %v", index, err)
+		}
+	}
+	if err := swTPM.NVDefine(0x01500003, 8, tpm.NVPolicy{OwnerAuth: true, PCRs: bound.PCRs}); err == nil {
+		t.Error(tests.Failure(t, err, "error", "owner authorized and PCR bound index"))
+	}
+	if err := swTPM.NVDefine(0x01500000, 8, owner); err == nil {
+		t.Error(tests.Failure(t, err, "error", "index defined twice"))
+	}
+	if err := swTPM.NVWrite(0x01500002, []byte("too long data"), tpm.NVPolicy{}); err == nil {
+		t.Error(tests.Failure(t, err, "error", "data larger than the index"))
+	}
+
+	// The state persists and PCR 16 changes after the definition of the bound index.
+	reopened, err := tpm.OpenSoftware(dir)
+	if err != nil {
+		t.Fatalf("OpenSoftware() returned an error: %v", err)
+	}
+	if err = reopened.ProveUsership("user"); err != nil {
+		t.Fatalf("ProveUsership() returned an error: %v", err)
+	}
+	if err = reopened.ExtendPCR(16, []byte("measurement"), 0, ""); err != nil {
+		t.Fatalf("ExtendPCR() returned an error: %v", err)
+	}
+	var testSuite = []struct {
+		name    string
+		index   uint32
+		policy  tpm.NVPolicy
+		want    []byte
+		wantErr bool
+	}{
+		{name: "Owner index without owner authorization", index: 0x01500000, policy: owner, wantErr: true},
+		{name: "PCR bound index after a PCR change", index: 0x01500001, policy: bound, wantErr: true},
+		{name: "PCR bound index with another PCR", index: 0x01500001, policy: tpm.NVPolicy{PCRs: tpm.PCRSelection{Bank: tpm.SHA256, PCRs: []int{23}}}, wantErr: true},
+		{name: "Index without authorization", index: 0x01500002, want: []byte("v1\x00\x00\x00\x00\x00\x00"), wantErr: false},
+		{name: "Undefined index", index: 0x01500003, wantErr: true},
+	}
+	for _, test := range testSuite {
+		t.Run(test.name, func(t *testing.T) {
+			got, gotErr := reopened.NVRead(test.index, test.policy)
+			if (gotErr != nil) != test.wantErr {
+				t.Fatal(tests.Failure(t, gotErr, test.wantErr, ""))
+			}
+			if !cmp.Equal(got, test.want) {
+				t.Error(tests.Failure(t, got, test.want, ""))
+			}
+		})
+	}
+
+	if err = reopened.NVUndefine(0x01500002); err == nil {
+		t.Error(tests.Failure(t, err, "error", "undefined an index without owner authorization"))
+	}
+	if err = reopened.ProveOwnership("owner"); err != nil {
+		t.Fatalf("ProveOwnership() returned an error: %v", err)
+	}
+	if got, err := reopened.NVRead(0x01500000, owner); err != nil || string(got[:2]) != "v1" {
+		t.Error(tests.Failure(t, err, nil, "owner authorized read"))
+	}
+	if err = reopened.NVUndefine(0x01500002); err != nil {
+		t.Fatalf("NVUndefine() returned an error: %v", err)
+	}
+	if _, err = reopened.NVRead(0x01500002, tpm.NVPolicy{}); err == nil {
+		t.Error(tests.Failure(t, err, "error", "read an undefined index"))
+	}
+}
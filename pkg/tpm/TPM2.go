@@ -0,0 +1,526 @@
+package tpm
+
+import (
+	"crypto/rsa"
+	"fmt"
+	"github.com/google/go-tpm/tpm2"
+	"github.com/google/go-tpm/tpmutil"
+	"io"
+	"sort"
+)
+
+const (
+	// SRKHandle is the persistent handle the storage root key is evicted to when taking ownership.
+	SRKHandle = tpmutil.Handle(0x81000001)
+	// EKCertIndexRSA is the NV index of the RSA EK certificate (TCG EK Credential Profile section 2.2.1.4).
+	EKCertIndexRSA = tpmutil.Handle(0x01c00002)
+)
+
+var (
+	srkTemplate = tpm2.Public{
+		Type:       tpm2.AlgRSA,
+		NameAlg:    tpm2.AlgSHA256,
+		Attributes: tpm2.FlagStorageDefault | tpm2.FlagNoDA,
+		RSAParameters: &tpm2.RSAParams{
+			Symmetric: &tpm2.SymScheme{Alg: tpm2.AlgAES, KeyBits: 128, Mode: tpm2.AlgCFB},
+			KeyBits:   2048,
+		},
+	}
+	// ekTemplate is the default RSA EK template of the TCG EK Credential Profile (section 2.1.5.1),
+	// CreatePrimary derives the certified EK from it.
+	ekTemplate = tpm2.Public{
+		Type:    tpm2.AlgRSA,
+		NameAlg: tpm2.AlgSHA256,
+		Attributes: tpm2.FlagFixedTPM | tpm2.FlagFixedParent | tpm2.FlagSensitiveDataOrigin |
+			tpm2.FlagAdminWithPolicy | tpm2.FlagRestricted | tpm2.FlagDecrypt,
+		AuthPolicy: []byte{
+			0x83, 0x71, 0x97, 0x67, 0x44, 0x84, 0xB3, 0xF8, 0x1A, 0x90, 0xCC, 0x8D, 0x46, 0xA5, 0xD7, 0x24,
+			0xFD, 0x52, 0xD7, 0x6E, 0x06, 0x52, 0x0B, 0x64, 0xF2, 0xA1, 0xDA, 0x1B, 0x33, 0x14, 0x69, 0xAA,
+		},
+		RSAParameters: &tpm2.RSAParams{
+			Symmetric:  &tpm2.SymScheme{Alg: tpm2.AlgAES, KeyBits: 128, Mode: tpm2.AlgCFB},
+			KeyBits:    2048,
+			ModulusRaw: make([]byte, 256),
+		},
+	}
+	akTemplate = tpm2.Public{
+		Type:       tpm2.AlgRSA,
+		NameAlg:    tpm2.AlgSHA256,
+		Attributes: tpm2.FlagSignerDefault | tpm2.FlagNoDA,
+		RSAParameters: &tpm2.RSAParams{
+			Sign:    &tpm2.SigScheme{Alg: tpm2.AlgRSASSA, Hash: tpm2.AlgSHA256},
+			KeyBits: 2048,
+		},
+	}
+)
+
+var bankAlgorithms = map[Bank]tpm2.Algorithm{SHA1: tpm2.AlgSHA1, SHA256: tpm2.AlgSHA256, SHA384: tpm2.AlgSHA384}
+
+func bankToAlgorithm(bank Bank) (tpm2.Algorithm, error) {
+	alg, ok := bankAlgorithms[bank.normalize()]
+	if !ok {
+		return tpm2.AlgNull, fmt.Errorf("unsupported PCR bank: %v", bank)
+	}
+	return alg, nil
+}
+
+func algorithmToBank(alg tpm2.Algorithm) (Bank, error) {
+	for bank, a := range bankAlgorithms {
+		if a == alg {
+			return bank, nil
+		}
+	}
+	return "", fmt.Errorf("unsupported hash algorithm: 0x%x", alg)
+}
+
+type tpm2TPM struct {
+	rw            io.ReadWriteCloser
+	ownerPassword string
+	userPassword  string
+}
+
+var _ TPM = (*tpm2TPM)(nil) // Verify that *tpm2TPM implements TPM.
+
+// OpenTPM2 wraps a TPM 2.0 command channel, such as /dev/tpmrm0 or a simulator.
+func OpenTPM2(rw io.ReadWriteCloser) TPM {
+	return &tpm2TPM{rw: rw}
+}
+
+func openTPM2(path string) (TPM, error) {
+	rw, err := tpm2.OpenTPM(path)
+	if err != nil {
+		return nil, fmt.Errorf("error opening %v: %v", path, err)
+	}
+	return OpenTPM2(rw), nil
+}
+
+func (tpm *tpm2TPM) Close() error {
+	return tpm.rw.Close()
+}
+
+func passwordAuth(password string) tpm2.AuthCommand {
+	return tpm2.AuthCommand{Session: tpm2.HandlePasswordSession, Attributes: tpm2.AttrContinueSession, Auth: []byte(password)}
+}
+
+// TakeOwnership sets the owner hierarchy authorization and persists an SRK protected by userPassword.
+func (tpm *tpm2TPM) TakeOwnership(ownerPassword, userPassword string) error {
+	err := tpm2.HierarchyChangeAuth(tpm.rw, tpm2.HandleOwner, passwordAuth(""), ownerPassword)
+	if err != nil {
+		return fmt.Errorf("unable to set owner authorization: %v", err)
+	}
+	srk, _, err := tpm2.CreatePrimary(tpm.rw, tpm2.HandleOwner, tpm2.PCRSelection{}, ownerPassword, userPassword, srkTemplate)
+	if err != nil {
+		return fmt.Errorf("unable to create SRK: %v", err)
+	}
+	defer tpm2.FlushContext(tpm.rw, srk)
+	err = tpm2.EvictControl(tpm.rw, ownerPassword, tpm2.HandleOwner, srk, SRKHandle)
+	if err != nil {
+		return fmt.Errorf("unable to persist SRK: %v", err)
+	}
+	return nil
+}
+
+// IsOwned reports whether TakeOwnership already persisted an SRK.
+func (tpm *tpm2TPM) IsOwned() (bool, error) {
+	handles, _, err := tpm2.GetCapability(tpm.rw, tpm2.CapabilityHandles, 1, uint32(SRKHandle))
+	if err != nil {
+		return false, fmt.Errorf("unable to list persistent handles: %v", err)
+	}
+	for _, h := range handles {
+		if h.(tpmutil.Handle) == SRKHandle {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+func (tpm *tpm2TPM) ProveOwnership(ownerPassword string) error {
+	// Changing the owner authorization to itself fails if ownerPassword is wrong.
+	err := tpm2.HierarchyChangeAuth(tpm.rw, tpm2.HandleOwner, passwordAuth(ownerPassword), ownerPassword)
+	if err != nil {
+		return fmt.Errorf("HierarchyChangeAuth failed: %v", err)
+	}
+	tpm.ownerPassword = ownerPassword
+	return nil
+}
+
+func (tpm *tpm2TPM) ProveUsership(userPassword string) error {
+	// TPM2_PolicySecret authorizes with the SRK without creating an object, it fails if userPassword is wrong.
+	session, _, err := tpm2.StartAuthSession(tpm.rw, tpm2.HandleNull, tpm2.HandleNull, make([]byte, 16), nil, tpm2.SessionPolicy, tpm2.AlgNull, tpm2.AlgSHA256)
+	if err != nil {
+		return fmt.Errorf("unable to start policy session: %v", err)
+	}
+	defer tpm2.FlushContext(tpm.rw, session)
+	_, _, err = tpm2.PolicySecret(tpm.rw, SRKHandle, passwordAuth(userPassword), session, nil, nil, nil, 0)
+	if err != nil {
+		return fmt.Errorf("error setting srk secret: %v", err)
+	}
+	tpm.userPassword = userPassword
+	return nil
+}
+
+func (tpm *tpm2TPM) GetEK() (EndorsementKey, error) {
+	rawCert, err := tpm2.NVReadEx(tpm.rw, EKCertIndexRSA, EKCertIndexRSA, "", 0)
+	if err != nil {
+		return nil, fmt.Errorf("error getting EK: %v", err)
+	}
+	cert, err := parseEKCertificate(rawCert)
+	if err != nil {
+		return nil, fmt.Errorf("error parsing cert: %v", err)
+	}
+	rsaPubKey, ok := cert.PublicKey.(*rsa.PublicKey)
+	if !ok {
+		return nil, fmt.Errorf("public key is not RSA")
+	}
+	return &EndorsementKeyData{PK: rsaPubKey, C: cert}, nil
+}
+
+// tpm2KeyBlob is the AK blob format of the TPM 2.0 backend: the public and private areas returned by TPM2_Create.
+type tpm2KeyBlob struct {
+	Public  tpmutil.U16Bytes
+	Private tpmutil.U16Bytes
+}
+
+func (tpm *tpm2TPM) CreateAK() (AttestationKey, error) {
+	private, public, _, _, _, err := tpm2.CreateKey(tpm.rw, SRKHandle, tpm2.PCRSelection{}, tpm.userPassword, "", akTemplate)
+	if err != nil {
+		return nil, fmt.Errorf("unable to create AK: %v", err)
+	}
+	pub, err := tpm2.DecodePublic(public)
+	if err != nil {
+		return nil, fmt.Errorf("unable to decode AK public area: %v", err)
+	}
+	key, err := pub.Key()
+	if err != nil {
+		return nil, fmt.Errorf("unable to decode AK public key: %v", err)
+	}
+	rsaPubKey, ok := key.(*rsa.PublicKey)
+	if !ok {
+		return nil, fmt.Errorf("public key is not RSA")
+	}
+	blob, err := tpmutil.Pack(tpm2KeyBlob{Public: public, Private: private})
+	if err != nil {
+		return nil, err
+	}
+	return &AttestationKeyData{PK: rsaPubKey, B: blob, P: public, V: 2}, nil
+}
+
+func (tpm *tpm2TPM) loadKey(blob []byte) (tpmutil.Handle, error) {
+	var key tpm2KeyBlob
+	if _, err := tpmutil.Unpack(blob, &key); err != nil {
+		return 0, fmt.Errorf("invalid key blob: %v", err)
+	}
+	handle, _, err := tpm2.Load(tpm.rw, SRKHandle, tpm.userPassword, key.Public, key.Private)
+	return handle, err
+}
+
+// ActivateCredential runs TPM2_ActivateCredential with the EK, whose policy requires the endorsement authorization.
+// The endorsement hierarchy is expected to have an empty authorization.
+func (tpm *tpm2TPM) ActivateCredential(ak AttestationKey, credential *Credential) ([]byte, error) {
+	var credBlob, encSecret tpmutil.U16Bytes
+	if _, err := tpmutil.Unpack(credential.Credential, &credBlob); err != nil {
+		return nil, fmt.Errorf("invalid credential blob: %v", err)
+	}
+	if _, err := tpmutil.Unpack(credential.Secret, &encSecret); err != nil {
+		return nil, fmt.Errorf("invalid encrypted secret: %v", err)
+	}
+	ek, _, err := tpm2.CreatePrimary(tpm.rw, tpm2.HandleEndorsement, tpm2.PCRSelection{}, "", "", ekTemplate)
+	if err != nil {
+		return nil, fmt.Errorf("unable to create EK: %v", err)
+	}
+	defer tpm2.FlushContext(tpm.rw, ek)
+	handle, err := tpm.loadKey(ak.Blob())
+	if err != nil {
+		return nil, fmt.Errorf("loading AK failed: %v", err)
+	}
+	defer tpm2.FlushContext(tpm.rw, handle)
+	session, _, err := tpm2.StartAuthSession(tpm.rw, tpm2.HandleNull, tpm2.HandleNull, make([]byte, 16), nil, tpm2.SessionPolicy, tpm2.AlgNull, tpm2.AlgSHA256)
+	if err != nil {
+		return nil, fmt.Errorf("unable to start policy session: %v", err)
+	}
+	defer tpm2.FlushContext(tpm.rw, session)
+	_, _, err = tpm2.PolicySecret(tpm.rw, tpm2.HandleEndorsement, passwordAuth(""), session, nil, nil, nil, 0)
+	if err != nil {
+		return nil, fmt.Errorf("PolicySecret failed: %v", err)
+	}
+	auth := []tpm2.AuthCommand{passwordAuth(""), {Session: session, Attributes: tpm2.AttrContinueSession}}
+	secret, err := tpm2.ActivateCredentialUsingAuth(tpm.rw, auth, handle, ek, credBlob, encSecret)
+	if err != nil {
+		return nil, fmt.Errorf("ActivateCredential failed: %v", err)
+	}
+	return secret, nil
+}
+
+func (tpm *tpm2TPM) Quote(ak AttestationKey, nonce []byte, sel PCRSelection) (Quote, error) {
+	alg, err := bankToAlgorithm(sel.Bank)
+	if err != nil {
+		return nil, err
+	}
+	handle, err := tpm.loadKey(ak.Blob())
+	if err != nil {
+		return nil, fmt.Errorf("loading AK failed: %v", err)
+	}
+	defer tpm2.FlushContext(tpm.rw, handle)
+	pcrIds := append([]int{}, sel.PCRs...)
+	sort.Ints(pcrIds)
+	attestation, signature, err := tpm2.Quote(tpm.rw, handle, "", "", nonce, tpm2.PCRSelection{Hash: alg, PCRs: pcrIds}, tpm2.AlgNull)
+	if err != nil {
+		return nil, fmt.Errorf("failed to quote: %v", err)
+	}
+	if signature.RSA == nil {
+		return nil, fmt.Errorf("expected an RSA signature, got algorithm 0x%x", signature.Alg)
+	}
+	hashAlg, err := algorithmToBank(signature.RSA.HashAlg)
+	if err != nil {
+		return nil, err
+	}
+	values := make([]PCR, 0, len(pcrIds))
+	for _, id := range pcrIds {
+		val, err := tpm2.ReadPCR(tpm.rw, id, alg)
+		if err != nil {
+			return nil, fmt.Errorf("error fetching PCR %d: %v", id, err)
+		}
+		values = append(values, PCR{Id: id, Bank: sel.Bank.normalize(), Value: val})
+	}
+	return &TPM2QuoteData{Raw: attestation, Signature: signature.RSA.Signature, HashAlg: hashAlg, Values: values}, nil
+}
+
+func (tpm *tpm2TPM) ListPCRs(bank Bank) ([]PCR, error) {
+	alg, err := bankToAlgorithm(bank)
+	if err != nil {
+		return nil, err
+	}
+	pcrs := make([]PCR, 0, len(All_pcrs))
+	for _, id := range All_pcrs {
+		val, err := tpm2.ReadPCR(tpm.rw, id, alg)
+		if err != nil {
+			return nil, fmt.Errorf("error fetching PCR %d: %v", id, err)
+		}
+		pcrs = append(pcrs, PCR{Id: id, Bank: bank.normalize(), Value: val})
+	}
+	return pcrs, nil
+}
+
+// ExtendPCR extends every active bank of pcrId with the digest of data, as TPM2_PCR_Event does.
+// TPM 2.0 event data is recorded by the event log, eventId and event are ignored.
+func (tpm *tpm2TPM) ExtendPCR(pcrId int, data []byte, eventId int, event string) error {
+	return tpm2.PCREvent(tpm.rw, tpmutil.Handle(pcrId), data)
+}
+
+// tpm2SealedBlob is the sealed blob format of the TPM 2.0 backend, the PCR selection is kept to satisfy the policy on unseal.
+type tpm2SealedBlob struct {
+	Hash    uint16
+	PCRs    tpmutil.U16Bytes // one byte per PCR index
+	Public  tpmutil.U16Bytes
+	Private tpmutil.U16Bytes
+}
+
+// pcrPolicy starts a policy session of sessionType asserting TPM2_PolicyPCR on sel and returns it with its digest.
+func (tpm *tpm2TPM) pcrPolicy(sel tpm2.PCRSelection, sessionType tpm2.SessionType) (tpmutil.Handle, []byte, error) {
+	session, _, err := tpm2.StartAuthSession(tpm.rw, tpm2.HandleNull, tpm2.HandleNull, make([]byte, 16), nil, sessionType, tpm2.AlgNull, tpm2.AlgSHA256)
+	if err != nil {
+		return 0, nil, fmt.Errorf("unable to start policy session: %v", err)
+	}
+	if len(sel.PCRs) > 0 {
+		if err = tpm2.PolicyPCR(tpm.rw, session, nil, sel); err != nil {
+			tpm2.FlushContext(tpm.rw, session)
+			return 0, nil, fmt.Errorf("PolicyPCR failed: %v", err)
+		}
+	}
+	digest, err := tpm2.PolicyGetDigest(tpm.rw, session)
+	if err != nil {
+		tpm2.FlushContext(tpm.rw, session)
+		return 0, nil, fmt.Errorf("PolicyGetDigest failed: %v", err)
+	}
+	return session, digest, nil
+}
+
+// Seal creates a sealed data object under the SRK whose policy requires the current values of the PCRs of sel.
+func (tpm *tpm2TPM) Seal(data []byte, sel PCRSelection) ([]byte, error) {
+	pcrSel, err := pcrSelection(sel)
+	if err != nil {
+		return nil, err
+	}
+	session, policy, err := tpm.pcrPolicy(pcrSel, tpm2.SessionTrial)
+	if err != nil {
+		return nil, err
+	}
+	tpm2.FlushContext(tpm.rw, session)
+	private, public, err := tpm2.Seal(tpm.rw, SRKHandle, tpm.userPassword, "", policy, data)
+	if err != nil {
+		return nil, fmt.Errorf("Seal failed: %v", err)
+	}
+	ids := make([]byte, len(pcrSel.PCRs))
+	for i, id := range pcrSel.PCRs {
+		ids[i] = byte(id)
+	}
+	return tpmutil.Pack(tpm2SealedBlob{Hash: uint16(pcrSel.Hash), PCRs: ids, Public: public, Private: private})
+}
+
+func (tpm *tpm2TPM) Unseal(blob []byte) ([]byte, error) {
+	var sealed tpm2SealedBlob
+	if _, err := tpmutil.Unpack(blob, &sealed); err != nil {
+		return nil, fmt.Errorf("invalid sealed blob: %v", err)
+	}
+	pcrSel := tpm2.PCRSelection{Hash: tpm2.Algorithm(sealed.Hash)}
+	for _, id := range sealed.PCRs {
+		pcrSel.PCRs = append(pcrSel.PCRs, int(id))
+	}
+	handle, _, err := tpm2.Load(tpm.rw, SRKHandle, tpm.userPassword, sealed.Public, sealed.Private)
+	if err != nil {
+		return nil, fmt.Errorf("loading sealed object failed: %v", err)
+	}
+	defer tpm2.FlushContext(tpm.rw, handle)
+	session, _, err := tpm.pcrPolicy(pcrSel, tpm2.SessionPolicy)
+	if err != nil {
+		return nil, err
+	}
+	defer tpm2.FlushContext(tpm.rw, session)
+	data, err := tpm2.UnsealWithSession(tpm.rw, session, handle, "")
+	if err != nil {
+		return nil, fmt.Errorf("Unseal failed: %v", err)
+	}
+	return data, nil
+}
+
+// nvBlockSize is the size of the NV reads and writes, below the TPM_PT_NV_BUFFER_MAX of common TPMs.
+const nvBlockSize = 512
+
+// pcrSelection converts sel to its go-tpm form with sorted indexes.
+func pcrSelection(sel PCRSelection) (tpm2.PCRSelection, error) {
+	alg, err := bankToAlgorithm(sel.Bank)
+	if err != nil {
+		return tpm2.PCRSelection{}, err
+	}
+	pcrIds := append([]int{}, sel.PCRs...)
+	sort.Ints(pcrIds)
+	return tpm2.PCRSelection{Hash: alg, PCRs: pcrIds}, nil
+}
+
+// NVDefine defines an owner authorized index, an index whose policy is TPM2_PolicyPCR or an index with an empty authorization.
+func (tpm *tpm2TPM) NVDefine(index uint32, size int, policy NVPolicy) error {
+	if err := policy.validate(); err != nil {
+		return err
+	}
+	attributes := tpm2.AttrAuthRead | tpm2.AttrAuthWrite
+	var authPolicy []byte
+	switch {
+	case policy.OwnerAuth:
+		attributes = tpm2.AttrOwnerRead | tpm2.AttrOwnerWrite
+	case policy.PCRBound():
+		sel, err := pcrSelection(policy.PCRs)
+		if err != nil {
+			return err
+		}
+		session, digest, err := tpm.pcrPolicy(sel, tpm2.SessionTrial)
+		if err != nil {
+			return err
+		}
+		tpm2.FlushContext(tpm.rw, session)
+		attributes, authPolicy = tpm2.AttrPolicyRead|tpm2.AttrPolicyWrite, digest
+	}
+	// The name algorithm is the hash of the policy digest, NVDefineSpace always uses SHA-1
+	public := tpm2.NVPublic{NVIndex: tpmutil.Handle(index), NameAlg: tpm2.AlgSHA256, Attributes: attributes | tpm2.AttrNoDA, AuthPolicy: authPolicy, DataSize: uint16(size)}
+	err := tpm2.NVDefineSpaceEx(tpm.rw, tpm2.HandleOwner, "", public, passwordAuth(tpm.ownerPassword))
+	if err != nil {
+		return fmt.Errorf("NVDefineSpace failed: %v", err)
+	}
+	return nil
+}
+
+// nvAuth returns the authorization handle and session to access index under policy, and a function releasing the session.
+// Policy sessions are reset once used, each command needs its own.
+func (tpm *tpm2TPM) nvAuth(index tpmutil.Handle, policy NVPolicy) (tpmutil.Handle, tpm2.AuthCommand, func(), error) {
+	switch {
+	case policy.OwnerAuth:
+		return tpm2.HandleOwner, passwordAuth(tpm.ownerPassword), func() {}, nil
+	case policy.PCRBound():
+		sel, err := pcrSelection(policy.PCRs)
+		if err != nil {
+			return 0, tpm2.AuthCommand{}, nil, err
+		}
+		session, _, err := tpm.pcrPolicy(sel, tpm2.SessionPolicy)
+		if err != nil {
+			return 0, tpm2.AuthCommand{}, nil, err
+		}
+		release := func() { tpm2.FlushContext(tpm.rw, session) }
+		return index, tpm2.AuthCommand{Session: session, Attributes: tpm2.AttrContinueSession}, release, nil
+	default:
+		return index, passwordAuth(""), func() {}, nil
+	}
+}
+
+func (tpm *tpm2TPM) NVRead(index uint32, policy NVPolicy) ([]byte, error) {
+	handle := tpmutil.Handle(index)
+	public, err := tpm2.NVReadPublic(tpm.rw, handle)
+	if err != nil {
+		return nil, fmt.Errorf("NVReadPublic failed: %v", err)
+	}
+	var data []byte
+	for offset := 0; offset < int(public.DataSize); offset += nvBlockSize {
+		size := int(public.DataSize) - offset
+		if size > nvBlockSize {
+			size = nvBlockSize
+		}
+		authHandle, auth, release, err := tpm.nvAuth(handle, policy)
+		if err != nil {
+			return nil, err
+		}
+		block, err := nvRead(tpm.rw, authHandle, handle, auth, uint16(offset), uint16(size))
+		release()
+		if err != nil {
+			return nil, err
+		}
+		data = append(data, block...)
+	}
+	return data, nil
+}
+
+// nvRead runs TPM2_NV_Read with any authorization, NVReadEx only supports passwords.
+func nvRead(rw io.ReadWriter, authHandle, index tpmutil.Handle, auth tpm2.AuthCommand, offset, size uint16) ([]byte, error) {
+	rawAuth, err := tpmutil.Pack(auth)
+	if err != nil {
+		return nil, err
+	}
+	resp, code, err := tpmutil.RunCommand(rw, tpm2.TagSessions, tpm2.CmdReadNV, authHandle, index, uint32(len(rawAuth)), tpmutil.RawBytes(rawAuth), size, offset)
+	if err != nil {
+		return nil, fmt.Errorf("NVRead failed: %v", err)
+	}
+	if code != tpmutil.RCSuccess {
+		return nil, fmt.Errorf("NVRead failed: response code 0x%x", code)
+	}
+	var paramSize uint32
+	var data tpmutil.U16Bytes
+	if _, err = tpmutil.Unpack(resp, &paramSize, &data); err != nil {
+		return nil, fmt.Errorf("NVRead failed: %v", err)
+	}
+	return data, nil
+}
+
+func (tpm *tpm2TPM) NVWrite(index uint32, data []byte, policy NVPolicy) error {
+	handle := tpmutil.Handle(index)
+	for offset := 0; offset < len(data); offset += nvBlockSize {
+		end := offset + nvBlockSize
+		if end > len(data) {
+			end = len(data)
+		}
+		authHandle, auth, release, err := tpm.nvAuth(handle, policy)
+		if err != nil {
+			return err
+		}
+		err = tpm2.NVWriteEx(tpm.rw, authHandle, handle, auth, data[offset:end], uint16(offset))
+		release()
+		if err != nil {
+			return fmt.Errorf("NVWrite failed: %v", err)
+		}
+	}
+	return nil
+}
+
+func (tpm *tpm2TPM) NVUndefine(index uint32) error {
+	err := tpm2.NVUndefineSpace(tpm.rw, tpm.ownerPassword, tpm2.HandleOwner, tpmutil.Handle(index))
+	if err != nil {
+		return fmt.Errorf("NVUndefineSpace failed: %v", err)
+	}
+	return nil
+}
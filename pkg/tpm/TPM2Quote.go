@@ -0,0 +1,182 @@
+package tpm
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/rsa"
+	"encoding/json"
+	"fmt"
+	"github.com/google/go-tpm/tpm2"
+	"sort"
+)
+
+const TPM2QuoteVersion = "2.0"
+
+// TPM2QuoteData is a TPM2_Quote result: a TPMS_ATTEST structure and its RSASSA signature.
+// HashAlg is the hash of the AK signing scheme, it defaults to SHA-256 and Verify rejects it when the AK scheme differs.
+// Values are the values of the quoted PCRs read by the prover.
+type TPM2QuoteData struct {
+	Raw       []byte
+	Signature []byte
+	HashAlg   Bank
+	Values    []PCR
+}
+
+var _ Quote = (*TPM2QuoteData)(nil) // Verify that *TPM2QuoteData implements Quote.
+
+type tpm2QuoteData struct {
+	Version   string
+	Raw       []byte
+	Signature []byte
+	HashAlg   Bank  `json:",omitempty"`
+	Values    []PCR `json:",omitempty"`
+}
+
+func (q *TPM2QuoteData) hash() (crypto.Hash, error) {
+	if q.HashAlg == "" {
+		return crypto.SHA256, nil
+	}
+	return q.HashAlg.Hash()
+}
+
+// signatureHash returns the hash of the signing scheme of ak, HashAlg is not signed and must name the same hash.
+// The scheme is read from the public area of TPM 2.0 AKs, the other AKs sign with SHA-256.
+func (q *TPM2QuoteData) signatureHash(ak AttestationKey) (crypto.Hash, error) {
+	want := crypto.SHA256
+	if ak.TPMVersion() == 2 {
+		public, err := tpm2.DecodePublic(ak.PublicArea())
+		if err != nil {
+			return 0, fmt.Errorf("unable to decode AK public area: %v", err)
+		}
+		if public.RSAParameters == nil || public.RSAParameters.Sign == nil || public.RSAParameters.Sign.Alg != tpm2.AlgRSASSA {
+			return 0, fmt.Errorf("attestation key has no RSASSA signing scheme")
+		}
+		if want, err = public.RSAParameters.Sign.Hash.Hash(); err != nil {
+			return 0, fmt.Errorf("unsupported AK signing scheme: %v", err)
+		}
+	}
+	hash, err := q.hash()
+	if err != nil {
+		return 0, err
+	}
+	if hash != want {
+		return 0, fmt.Errorf("quote hash %v does not match the %v signing scheme of the attestation key", hash, want)
+	}
+	return hash, nil
+}
+
+func (q *TPM2QuoteData) attestation() (*tpm2.AttestationData, error) {
+	attestation, err := tpm2.DecodeAttestationData(q.Raw)
+	if err != nil {
+		return nil, fmt.Errorf("error decoding TPMS_ATTEST: %v", err)
+	}
+	if attestation.Type != tpm2.TagAttestQuote || attestation.AttestedQuoteInfo == nil {
+		return nil, fmt.Errorf("expected quote attestation got type 0x%x", attestation.Type)
+	}
+	return attestation, nil
+}
+
+func (q *TPM2QuoteData) Verify(ak AttestationKey, nonce []byte) error {
+	hash, err := q.signatureHash(ak)
+	if err != nil {
+		return err
+	}
+	h := hash.New()
+	h.Write(q.Raw)
+	//First check signature
+	if err := rsa.VerifyPKCS1v15(ak.PublicKey(), hash, h.Sum(nil), q.Signature); err != nil {
+		return fmt.Errorf("invalid signature: %v", err)
+	}
+	//Check object received from TPM, the magic value is checked while decoding
+	attestation, err := q.attestation()
+	if err != nil {
+		return err
+	}
+	//Check nonce
+	if !bytes.Equal(attestation.ExtraData, nonce) {
+		return fmt.Errorf("invalid nonce")
+	}
+	return nil
+}
+
+func (q *TPM2QuoteData) VerifyPCRs(pcrs []PCR) error {
+	if len(q.Values) > 0 {
+		if err := q.verifyPCRs(q.Values); err != nil {
+			return fmt.Errorf("quoted PCR values: %v", err)
+		}
+		return ComparePCRs(FilterBank(pcrs, q.Selection().Bank), q.Values)
+	}
+	return q.verifyPCRs(pcrs)
+}
+
+func (q *TPM2QuoteData) verifyPCRs(pcrs []PCR) error {
+	attestation, err := q.attestation()
+	if err != nil {
+		return err
+	}
+	info := attestation.AttestedQuoteInfo
+	bank, err := algorithmToBank(info.PCRSelection.Hash)
+	if err != nil {
+		return err
+	}
+	pcrs = FilterBank(pcrs, bank)
+	sort.Slice(pcrs, func(i, j int) bool { return pcrs[i].Id < pcrs[j].Id })
+	quoted := append([]int{}, info.PCRSelection.PCRs...)
+	sort.Ints(quoted)
+	if len(quoted) != len(pcrs) {
+		return fmt.Errorf("quote covers %d %v PCRs, got %d", len(quoted), bank, len(pcrs))
+	}
+	for i, pcr := range pcrs {
+		if pcr.Id != quoted[i] {
+			return fmt.Errorf("PCR %d is not covered by the quote", pcr.Id)
+		}
+	}
+	//The PCR digest is computed with the signing scheme hash
+	hash, err := q.hash()
+	if err != nil {
+		return err
+	}
+	digest, err := pcrsToDigest(pcrs, hash)
+	if err != nil {
+		return fmt.Errorf("creating digest: %v", err)
+	}
+	if !bytes.Equal(info.PCRDigest, digest) {
+		return fmt.Errorf("PCRs don't match ParsedQuote")
+	}
+	return nil
+}
+
+// Selection returns the PCR selection of the attested quote, an empty selection if it cannot be decoded.
+func (q *TPM2QuoteData) Selection() PCRSelection {
+	attestation, err := q.attestation()
+	if err != nil {
+		return PCRSelection{}
+	}
+	bank, err := algorithmToBank(attestation.AttestedQuoteInfo.PCRSelection.Hash)
+	if err != nil {
+		return PCRSelection{}
+	}
+	pcrs := append([]int{}, attestation.AttestedQuoteInfo.PCRSelection.PCRs...)
+	sort.Ints(pcrs)
+	return PCRSelection{Bank: bank, PCRs: pcrs}
+}
+
+func (q *TPM2QuoteData) PCRValues() []PCR {
+	return q.Values
+}
+
+func (q *TPM2QuoteData) MarshalJSON() ([]byte, error) {
+	return json.Marshal(&tpm2QuoteData{Version: TPM2QuoteVersion, Raw: q.Raw, Signature: q.Signature, HashAlg: q.HashAlg, Values: q.Values})
+}
+
+func (q *TPM2QuoteData) UnmarshalJSON(data []byte) error {
+	aux := &tpm2QuoteData{}
+	if err := json.Unmarshal(data, aux); err != nil {
+		return err
+	}
+	if aux.Version != TPM2QuoteVersion || len(aux.Raw) == 0 || len(aux.Signature) == 0 {
+		return fmt.Errorf("missing required fields")
+	}
+	q.Raw, q.Signature, q.HashAlg, q.Values = aux.Raw, aux.Signature, aux.HashAlg, aux.Values
+	return nil
+}
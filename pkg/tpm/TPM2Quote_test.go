@@ -0,0 +1,133 @@
+package tpm_test
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/json"
+	"github.com/google/go-tpm/tpm2"
+	"github.com/xcaliburne/RemoteAttestations/pkg/tests"
+	"github.com/xcaliburne/RemoteAttestations/pkg/tpm"
+	"testing"
+)
+
+// fakeTPM2Quote signs a TPM2 quote of pcrs with key, the PCR digest and the signature use the hash of bank.
+func fakeTPM2Quote(t *testing.T, key *rsa.PrivateKey, nonce []byte, pcrs []tpm.PCR, bank tpm.Bank) *tpm.TPM2QuoteData {
+	hash, err := bank.Hash()
+	if err != nil {
+		t.Fatalf("unsupported bank: %v", err)
+	}
+	var ids []int
+	h := hash.New()
+	for _, pcr := range pcrs {
+		ids = append(ids, pcr.Id)
+		h.Write(pcr.Value)
+	}
+	raw, err := tpm2.AttestationData{
+		Magic:           0xff544347,
+		Type:            tpm2.TagAttestQuote,
+		QualifiedSigner: tpm2.Name{Digest: &tpm2.HashValue{Alg: tpm2.AlgSHA256, Value: make([]byte, sha256.Size)}},
+		ExtraData:       nonce,
+		AttestedQuoteInfo: &tpm2.QuoteInfo{
+			PCRSelection: tpm2.PCRSelection{Hash: tpm2.AlgSHA1, PCRs: ids},
+			PCRDigest:    h.Sum(nil),
+		},
+	}.Encode()
+	if err != nil {
+		t.Fatalf("unable to encode attestation: %v", err)
+	}
+	h = hash.New()
+	h.Write(raw)
+	signature, err := rsa.SignPKCS1v15(rand.Reader, key, hash, h.Sum(nil))
+	if err != nil {
+		t.Fatalf("unable to sign attestation: %v", err)
+	}
+	return &tpm.TPM2QuoteData{Raw: raw, Signature: signature, HashAlg: bank}
+}
+
+func TestTPM2QuoteData_Verify(t *testing.T) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("unable to generate key: %v", err)
+	}
+	otherKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("unable to generate key: %v", err)
+	}
+	nonce := []byte("nonce")
+	pcrs := []tpm.PCR{{Id: 0, Value: bytes.Repeat([]byte{1}, 20)}, {Id: 7, Value: bytes.Repeat([]byte{7}, 20)}}
+	quote := fakeTPM2Quote(t, key, nonce, pcrs, tpm.SHA256)
+	public, err := tpm2.Public{
+		Type:       tpm2.AlgRSA,
+		NameAlg:    tpm2.AlgSHA256,
+		Attributes: tpm2.FlagSignerDefault,
+		RSAParameters: &tpm2.RSAParams{
+			Sign:       &tpm2.SigScheme{Alg: tpm2.AlgRSASSA, Hash: tpm2.AlgSHA256},
+			KeyBits:    2048,
+			ModulusRaw: key.N.Bytes(),
+		},
+	}.Encode()
+	if err != nil {
+		t.Fatalf("unable to encode AK public area: %v", err)
+	}
+	tpm2AK := &tpm.AttestationKeyData{PK: &key.PublicKey, P: public, V: 2}
+	// The quote is valid but hashed with SHA-1, which the AK signing scheme does not allow
+	sha1Quote := fakeTPM2Quote(t, key, nonce, pcrs, tpm.SHA1)
+
+	var testSuite = []struct {
+		name    string
+		quote   *tpm.TPM2QuoteData
+		ak      *tpm.AttestationKeyData
+		nonce   []byte
+		pcrs    []tpm.PCR
+		wantErr bool
+	}{
+		{name: "correct use", ak: &tpm.AttestationKeyData{PK: &key.PublicKey}, nonce: nonce, pcrs: pcrs, wantErr: false},
+		{name: "TPM 2.0 AK", ak: tpm2AK, nonce: nonce, pcrs: pcrs, wantErr: false},
+		{name: "hash not matching the TPM 2.0 AK scheme", quote: sha1Quote, ak: tpm2AK, nonce: nonce, pcrs: pcrs, wantErr: true},
+		{name: "hash not matching the AK scheme", quote: sha1Quote, ak: &tpm.AttestationKeyData{PK: &key.PublicKey}, nonce: nonce, pcrs: pcrs, wantErr: true},
+		{name: "wrong AK", ak: &tpm.AttestationKeyData{PK: &otherKey.PublicKey}, nonce: nonce, pcrs: pcrs, wantErr: true},
+		{name: "wrong nonce", ak: &tpm.AttestationKeyData{PK: &key.PublicKey}, nonce: []byte("other"), pcrs: pcrs, wantErr: true},
+		{name: "wrong PCR value", ak: &tpm.AttestationKeyData{PK: &key.PublicKey}, nonce: nonce, pcrs: []tpm.PCR{{Id: 0, Value: bytes.Repeat([]byte{1}, 20)}, {Id: 7, Value: bytes.Repeat([]byte{8}, 20)}}, wantErr: true},
+		{name: "PCR not quoted", ak: &tpm.AttestationKeyData{PK: &key.PublicKey}, nonce: nonce, pcrs: []tpm.PCR{{Id: 0, Value: bytes.Repeat([]byte{1}, 20)}, {Id: 8, Value: bytes.Repeat([]byte{7}, 20)}}, wantErr: true},
+	}
+	for _, test := range testSuite {
+		t.Run(test.name, func(t *testing.T) {
+			q := test.quote
+			if q == nil {
+				q = quote
+			}
+			gotErr := q.Verify(test.ak, test.nonce)
+			if gotErr == nil {
+				gotErr = q.VerifyPCRs(test.pcrs)
+			}
+			if (gotErr != nil) != test.wantErr {
+				t.Error(tests.Failure(t, gotErr, test.wantErr, ""))
+			}
+		})
+	}
+}
+
+func TestDeserializeQuote_TPM2(t *testing.T) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("unable to generate key: %v", err)
+	}
+	want := fakeTPM2Quote(t, key, []byte("nonce"), []tpm.PCR{{Id: 0, Value: make([]byte, 20)}}, tpm.SHA256)
+	raw, err := json.Marshal(want)
+	if err != nil {
+		t.Fatalf("unable to marshal quote: %v", err)
+	}
+	got, err := tpm.DeserializeQuote(bytes.NewReader(raw))
+	if err != nil {
+		t.Fatalf("DeserializeQuote() returned an error: %v", err)
+	}
+	gotTPM2, ok := got.(*tpm.TPM2QuoteData)
+	if !ok {
+		t.Fatal(tests.Failure(t, got, want, "wrong quote type"))
+	}
+	if !bytes.Equal(gotTPM2.Raw, want.Raw) || !bytes.Equal(gotTPM2.Signature, want.Signature) {
+		t.Error(tests.Failure(t, gotTPM2, want, ""))
+	}
+}
@@ -0,0 +1,95 @@
+//go:build simulator
+// +build simulator
+
+package tpm_test
+
+import (
+	"github.com/google/go-tpm-tools/simulator"
+	"github.com/xcaliburne/RemoteAttestations/pkg/tests"
+	"github.com/xcaliburne/RemoteAttestations/pkg/tpm"
+	"testing"
+)
+
+// Run with: go test -tags simulator ./pkg/tpm/
+func TestTPM2_Simulator(t *testing.T) {
+	sim, err := simulator.Get()
+	if err != nil {
+		t.Fatalf("unable to start simulator: %v", err)
+	}
+	tpm2 := tpm.OpenTPM2(sim)
+	defer tpm2.Close()
+
+	owned, err := tpm2.IsOwned()
+	if err != nil || owned {
+		t.Fatal(tests.Failure(t, owned, false, "fresh simulator is owned"))
+	}
+	if err = tpm2.TakeOwnership("owner", "user"); err != nil {
+		t.Fatalf("TakeOwnership() returned an error: %v", err)
+	}
+	if owned, err = tpm2.IsOwned(); err != nil || !owned {
+		t.Fatal(tests.Failure(t, owned, true, ""))
+	}
+	if err = tpm2.ProveOwnership("wrong"); err == nil {
+		t.Error(tests.Failure(t, err, "error", "wrong owner password accepted"))
+	}
+	if err = tpm2.ProveOwnership("owner"); err != nil {
+		t.Fatalf("ProveOwnership() returned an error: %v", err)
+	}
+	if err = tpm2.ProveUsership("user"); err != nil {
+		t.Fatalf("ProveUsership() returned an error: %v", err)
+	}
+	ak, err := tpm2.CreateAK()
+	if err != nil {
+		t.Fatalf("CreateAK() returned an error: %v", err)
+	}
+	if err = tpm2.ExtendPCR(16, []byte("measurement"), 0, ""); err != nil {
+		t.Fatalf("ExtendPCR() returned an error: %v", err)
+	}
+	nonce := []byte("nonce")
+	for _, bank := range []tpm.Bank{tpm.SHA1, tpm.SHA256} {
+		quote, err := tpm2.Quote(ak, nonce, tpm.AllPCRs(bank))
+		if err != nil {
+			t.Fatalf("Quote() returned an error: %v", err)
+		}
+		if err = quote.Verify(ak, nonce); err != nil {
+			t.Error(tests.Failure(t, err, nil, "quote signature"))
+		}
+		pcrs, err := tpm2.ListPCRs(bank)
+		if err != nil {
+			t.Fatalf("ListPCRs() returned an error: %v", err)
+		}
+		if err = quote.VerifyPCRs(pcrs); err != nil {
+			t.Error(tests.Failure(t, err, nil, "quoted PCRs"))
+		}
+	}
+	sealed, err := tpm2.Seal([]byte("secret"), tpm.PCRSelection{Bank: tpm.SHA256, PCRs: []int{16}})
+	if err != nil {
+		t.Fatalf("Seal() returned an error: %v", err)
+	}
+	if got, err := tpm2.Unseal(sealed); err != nil || string(got) != "secret" {
+		t.Error(tests.Failure(t, err, nil, "unsealing in the sealed state"))
+	}
+	if err = tpm2.ExtendPCR(16, []byte("measurement"), 0, ""); err != nil {
+		t.Fatalf("ExtendPCR() returned an error: %v", err)
+	}
+	if _, err = tpm2.Unseal(sealed); err == nil {
+		t.Error(tests.Failure(t, err, "error", "unsealed after extending PCR 16"))
+	}
+	for index, policy := range map[uint32]tpm.NVPolicy{
+		0x01500000: {OwnerAuth: true},
+		0x01500001: {PCRs: tpm.PCRSelection{Bank: tpm.SHA256, PCRs: []int{16}}},
+	} {
+		if err = tpm2.NVDefine(index, 4, policy); err != nil {
+			t.Fatalf("NVDefine(0x%x) returned an error: %v", index, err)
+		}
+		if err = tpm2.NVWrite(index, []byte("v1.0"), policy); err != nil {
+			t.Fatalf("NVWrite(0x%x) returned an error: %v", index, err)
+		}
+		if got, err := tpm2.NVRead(index, policy); err != nil || string(got) != "v1.0" {
+			t.Error(tests.Failure(t, err, nil, "NV index read"))
+		}
+		if err = tpm2.NVUndefine(index); err != nil {
+			t.Errorf("NVUndefine(0x%x) returned an error: %v", index, err)
+		}
+	}
+}
@@ -6,9 +6,11 @@ import (
 )
 
 type MockAttestationKey struct {
-	CatchSave      func(filePath string) error
-	CatchPublicKey func() *rsa.PublicKey
-	CatchBlob      func() []byte
+	CatchSave       func(filePath string) error
+	CatchPublicKey  func() *rsa.PublicKey
+	CatchBlob       func() []byte
+	CatchPublicArea func() []byte
+	CatchTPMVersion func() int
 }
 
 var _ tpm.AttestationKey = (*MockAttestationKey)(nil) // Verify that *MockAttestationKey implements AttestationKey.
@@ -24,3 +26,11 @@ func (ak *MockAttestationKey) PublicKey() *rsa.PublicKey {
 func (ak *MockAttestationKey) Blob() []byte {
 	return ak.CatchBlob()
 }
+
+func (ak *MockAttestationKey) PublicArea() []byte {
+	return ak.CatchPublicArea()
+}
+
+func (ak *MockAttestationKey) TPMVersion() int {
+	return ak.CatchTPMVersion()
+}
@@ -8,6 +8,8 @@ type MockQuote struct {
 	CatchVerify     func(ak tpm.AttestationKey, nonce []byte) error
 	CatchVerifyPCRs func(pcrs []tpm.PCR) error
 	CatchUnmarshal  func(data []byte) error
+	CatchSelection  func() tpm.PCRSelection
+	CatchPCRValues  func() []tpm.PCR
 }
 
 var _ tpm.Quote = (*MockQuote)(nil) // Verify that *MockQuote implements Quote.
@@ -20,6 +22,14 @@ func (q *MockQuote) VerifyPCRs(pcrs []tpm.PCR) error {
 	return q.CatchVerifyPCRs(pcrs)
 }
 
+func (q *MockQuote) Selection() tpm.PCRSelection {
+	return q.CatchSelection()
+}
+
+func (q *MockQuote) PCRValues() []tpm.PCR {
+	return q.CatchPCRValues()
+}
+
 func (q *MockQuote) UnmarshalJSON(data []byte) error {
 	return q.CatchUnmarshal(data)
 }
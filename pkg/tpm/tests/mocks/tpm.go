@@ -5,16 +5,23 @@ import (
 )
 
 type MockTPM struct {
-	CatchClose          func() error
-	CatchTakeOwnership  func(ownerPassword, userPassword string) error
-	CatchIsOwned        func() (bool, error)
-	CatchProveOwnership func(ownerPassword string) error
-	CatchProveUsership  func(userPassword string) error
-	CatchGetEK          func() (tpm.EndorsementKey, error)
-	CatchCreateAK       func() (tpm.AttestationKey, error)
-	CatchQuote          func(ak tpm.AttestationKey, nonce []byte, pcrIds []int) (tpm.Quote, error)
-	CatchListPCRs       func() []tpm.PCR
-	CatchExtendPCR      func(pcr tpm.PCR, data []byte) error
+	CatchClose              func() error
+	CatchTakeOwnership      func(ownerPassword, userPassword string) error
+	CatchIsOwned            func() (bool, error)
+	CatchProveOwnership     func(ownerPassword string) error
+	CatchProveUsership      func(userPassword string) error
+	CatchGetEK              func() (tpm.EndorsementKey, error)
+	CatchCreateAK           func() (tpm.AttestationKey, error)
+	CatchActivateCredential func(ak tpm.AttestationKey, credential *tpm.Credential) ([]byte, error)
+	CatchQuote              func(ak tpm.AttestationKey, nonce []byte, sel tpm.PCRSelection) (tpm.Quote, error)
+	CatchListPCRs           func(bank tpm.Bank) ([]tpm.PCR, error)
+	CatchExtendPCR          func(pcrId int, data []byte, eventId int, event string) error
+	CatchSeal               func(data []byte, sel tpm.PCRSelection) ([]byte, error)
+	CatchUnseal             func(blob []byte) ([]byte, error)
+	CatchNVDefine           func(index uint32, size int, policy tpm.NVPolicy) error
+	CatchNVRead             func(index uint32, policy tpm.NVPolicy) ([]byte, error)
+	CatchNVWrite            func(index uint32, data []byte, policy tpm.NVPolicy) error
+	CatchNVUndefine         func(index uint32) error
 }
 
 var _ tpm.TPM = (*MockTPM)(nil) // Verify that a pointer to a MockTPM implements TPM.
@@ -75,26 +82,82 @@ func (t *MockTPM) CreateAK() (tpm.AttestationKey, error) {
 	return t.CatchCreateAK()
 }
 
-func (t *MockTPM) Quote(ak tpm.AttestationKey, nonce []byte, pcrIds []int) (tpm.Quote, error) {
+func (t *MockTPM) ActivateCredential(ak tpm.AttestationKey, credential *tpm.Credential) ([]byte, error) {
+	//default behavior
+	if t.CatchActivateCredential == nil {
+		return []byte{}, nil
+	}
+	return t.CatchActivateCredential(ak, credential)
+}
+
+func (t *MockTPM) Quote(ak tpm.AttestationKey, nonce []byte, sel tpm.PCRSelection) (tpm.Quote, error) {
 	//default behavior
 	if t.CatchQuote == nil {
 		return &tpm.QuoteData{}, nil
 	}
-	return t.CatchQuote(ak, nonce, pcrIds)
+	return t.CatchQuote(ak, nonce, sel)
 }
 
-func (t *MockTPM) ListPCRs() []tpm.PCR {
+func (t *MockTPM) ListPCRs(bank tpm.Bank) ([]tpm.PCR, error) {
 	//default behavior
 	if t.CatchListPCRs == nil {
-		return []tpm.PCR{}
+		return []tpm.PCR{}, nil
 	}
-	return t.CatchListPCRs()
+	return t.CatchListPCRs(bank)
 }
 
-func (t *MockTPM) ExtendPCR(pcr tpm.PCR, data []byte) error {
+func (t *MockTPM) ExtendPCR(pcrId int, data []byte, eventId int, event string) error {
 	//default *behavior
 	if t.CatchExtendPCR == nil {
 		return nil
 	}
-	return t.CatchExtendPCR(pcr, data)
+	return t.CatchExtendPCR(pcrId, data, eventId, event)
+}
+
+func (t *MockTPM) Seal(data []byte, sel tpm.PCRSelection) ([]byte, error) {
+	//default behavior
+	if t.CatchSeal == nil {
+		return []byte{}, nil
+	}
+	return t.CatchSeal(data, sel)
+}
+
+func (t *MockTPM) Unseal(blob []byte) ([]byte, error) {
+	//default behavior
+	if t.CatchUnseal == nil {
+		return []byte{}, nil
+	}
+	return t.CatchUnseal(blob)
+}
+
+func (t *MockTPM) NVDefine(index uint32, size int, policy tpm.NVPolicy) error {
+	//default behavior
+	if t.CatchNVDefine == nil {
+		return nil
+	}
+	return t.CatchNVDefine(index, size, policy)
+}
+
+func (t *MockTPM) NVRead(index uint32, policy tpm.NVPolicy) ([]byte, error) {
+	//default behavior
+	if t.CatchNVRead == nil {
+		return []byte{}, nil
+	}
+	return t.CatchNVRead(index, policy)
+}
+
+func (t *MockTPM) NVWrite(index uint32, data []byte, policy tpm.NVPolicy) error {
+	//default behavior
+	if t.CatchNVWrite == nil {
+		return nil
+	}
+	return t.CatchNVWrite(index, data, policy)
+}
+
+func (t *MockTPM) NVUndefine(index uint32) error {
+	//default behavior
+	if t.CatchNVUndefine == nil {
+		return nil
+	}
+	return t.CatchNVUndefine(index)
 }
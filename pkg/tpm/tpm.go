@@ -9,11 +9,18 @@ import (
 	"github.com/google/go-tspi/tspi"
 	"github.com/google/go-tspi/tspiconst"
 	"io/ioutil"
+	"os"
 	"sort"
 )
 
 var WellKnownSecret [20]byte
 
+var (
+	tpmVersionFile = "/sys/class/tpm/tpm0/tpm_version_major"
+	// The resource manager is preferred so the prover does not need exclusive access to the TPM.
+	tpm2Devices = []string{"/dev/tpmrm0", "/dev/tpm0"}
+)
+
 type TPM interface {
 	Close() error
 	TakeOwnership(ownerPassword, userPassword string) error
@@ -22,9 +29,20 @@ type TPM interface {
 	ProveUsership(userPassword string) error
 	GetEK() (EndorsementKey, error)
 	CreateAK() (AttestationKey, error)
-	Quote(ak AttestationKey, nonce []byte, pcrIds []int) (Quote, error)
-	ListPCRs() []PCR
+	// ActivateCredential recovers the secret of a credential made with MakeCredential for ak and the EK of this TPM.
+	ActivateCredential(ak AttestationKey, credential *Credential) ([]byte, error)
+	Quote(ak AttestationKey, nonce []byte, sel PCRSelection) (Quote, error)
+	ListPCRs(bank Bank) ([]PCR, error)
 	ExtendPCR(pcrId int, data []byte, eventId int, event string) error
+	// Seal encrypts data under the SRK, the blob only unseals on this TPM while the PCRs of sel keep their current values.
+	Seal(data []byte, sel PCRSelection) ([]byte, error)
+	Unseal(blob []byte) ([]byte, error)
+	// NVDefine defines an NV index of size bytes accessible under policy, it requires the owner authorization.
+	NVDefine(index uint32, size int, policy NVPolicy) error
+	// NVRead and NVWrite access an index with the policy it was defined with.
+	NVRead(index uint32, policy NVPolicy) ([]byte, error)
+	NVWrite(index uint32, data []byte, policy NVPolicy) error
+	NVUndefine(index uint32) error
 }
 
 type tspiTPM struct {
@@ -37,8 +55,35 @@ var _ TPM = (*tspiTPM)(nil) // Verify that *tspiTPM implements TPM.
 type OwnerToken *tspi.Policy
 type UserToken *tspi.Key
 
+// Open opens the TPM found on this machine, using go-tpm for TPM 2.0 devices and the trousers daemon otherwise.
 func Open() (TPM, error) {
-	return open()
+	return OpenVersion(Version())
+}
+
+// OpenVersion opens a TPM 1.2 through trousers or a TPM 2.0 device without probing.
+func OpenVersion(version int) (TPM, error) {
+	switch version {
+	case 1:
+		return open()
+	case 2:
+		for _, path := range tpm2Devices {
+			if _, err := os.Stat(path); err == nil {
+				return openTPM2(path)
+			}
+		}
+		return nil, fmt.Errorf("no TPM 2.0 device found")
+	default:
+		return nil, fmt.Errorf("unsupported TPM version: %d", version)
+	}
+}
+
+// Version returns the major version of the first TPM device exposed by the kernel, 1 if it cannot be determined.
+func Version() int {
+	val, err := ioutil.ReadFile(tpmVersionFile)
+	if err == nil && bytes.Equal(bytes.TrimSpace(val), []byte("2")) {
+		return 2
+	}
+	return 1
 }
 
 func open() (TPM, error) {
@@ -231,7 +276,8 @@ func (tpm *tspiTPM) getEKCert() ([]byte, error) {
 }
 
 func (tpm *tspiTPM) CreateAK() (AttestationKey, error) {
-	//We need to sign the new AIK with private CA key. We don't need CA so we are going to forge a well known one
+	//TPM_MakeIdentity needs a privacy CA key. The identity request is discarded, the verifier binds the AIK
+	//to the EK with ActivateCredential instead, so we forge a well known one
 	userToken, err := tpm.getUserToken()
 	if err != nil {
 		return nil, err
@@ -265,15 +311,36 @@ func (tpm *tspiTPM) CreateAK() (AttestationKey, error) {
 		return nil, err
 	}
 
-	_, err = aik.GetModulus()
+	pubKeyBlob, err := aik.GetPubKeyBlob()
 	if err != nil {
 		return nil, err
 	}
-	return &AttestationKeyData{PK: pubKey, B: blob}, nil
+	return &AttestationKeyData{PK: pubKey, B: blob, P: pubKeyBlob, V: 1}, nil
 }
 
-func (tpm *tspiTPM) Quote(ak AttestationKey, nonce []byte, pcrIds []int) (Quote, error) {
+// ActivateCredential runs TPM_ActivateIdentity, it requires the owner authorization set by ProveOwnership.
+func (tpm *tspiTPM) ActivateCredential(ak AttestationKey, credential *Credential) ([]byte, error) {
+	userToken, err := tpm.getUserToken()
+	if err != nil {
+		return nil, err
+	}
+	aik, err := tpm.contextHandle.LoadKeyByBlob(userToken, ak.Blob())
+	if err != nil {
+		return nil, fmt.Errorf("LoadKeyByBlob failed: %v", err)
+	}
+	secret, err := tpm.tpmHandle.ActivateIdentity(aik, credential.Secret, credential.Credential)
+	if err != nil {
+		return nil, fmt.Errorf("ActivateIdentity failed: %v", err)
+	}
+	return secret, nil
+}
+
+func (tpm *tspiTPM) Quote(ak AttestationKey, nonce []byte, sel PCRSelection) (Quote, error) {
 	//start := time.Now()
+	if !sel.Bank.Equal(SHA1) {
+		return nil, fmt.Errorf("TPM 1.2 only supports the sha1 bank, got %v", sel.Bank)
+	}
+	pcrIds := append([]int{}, sel.PCRs...)
 	q := QuoteData{}
 	userToken, err := tpm.getUserToken()
 	if err != nil {
@@ -302,21 +369,30 @@ func (tpm *tspiTPM) Quote(ak AttestationKey, nonce []byte, pcrIds []int) (Quote,
 		return nil, fmt.Errorf("failed to parse ParsedQuote: %v", err)
 	}
 	q.Parsed = parsed
+	q.PCRs = pcrIds
+	values, err := tpm.ListPCRs(SHA1)
+	if err != nil {
+		return nil, err
+	}
+	q.Values = selectValues(values, pcrIds)
 	//duration := time.Now().Sub(start)
 	//fmt.Printf("Quote time: %v\n", duration)
 	return &q, nil
 }
 
-func (tpm *tspiTPM) ListPCRs() []PCR {
+func (tpm *tspiTPM) ListPCRs(bank Bank) ([]PCR, error) {
+	if !bank.Equal(SHA1) {
+		return nil, fmt.Errorf("TPM 1.2 only supports the sha1 bank, got %v", bank)
+	}
 	pcrValues, err := tpm.tpmHandle.GetPCRValues()
 	if err != nil {
-		fmt.Println("Error fetching PCR values")
+		return nil, fmt.Errorf("error fetching PCR values: %v", err)
 	}
 	pcrs := make([]PCR, len(pcrValues))
 	for i, val := range pcrValues {
-		pcrs[i] = PCR{Id: i, Value: val}
+		pcrs[i] = PCR{Id: i, Bank: SHA1, Value: val}
 	}
-	return pcrs
+	return pcrs, nil
 }
 
 func (tpm *tspiTPM) ExtendPCR(pcrId int, data []byte, eventId int, event string) error {
@@ -327,3 +403,62 @@ func (tpm *tspiTPM) ExtendPCR(pcrId int, data []byte, eventId int, event string)
 	tpm.tpmHandle.ExtendPCR(pcrId, data, eventId, eventBytes)
 	return nil
 }
+
+// Seal runs Tspi_Data_Seal with the SRK, TPM 1.2 can only seal to the sha1 bank.
+func (tpm *tspiTPM) Seal(data []byte, sel PCRSelection) ([]byte, error) {
+	if !sel.Bank.Equal(SHA1) {
+		return nil, fmt.Errorf("TPM 1.2 only supports the sha1 bank, got %v", sel.Bank)
+	}
+	userToken, err := tpm.getUserToken()
+	if err != nil {
+		return nil, err
+	}
+	pcrs, err := tpm.contextHandle.CreatePCRs(tspiconst.TSS_PCRS_STRUCT_INFO)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get a reference to PCRs: %v", err)
+	}
+	pcrIds := append([]int{}, sel.PCRs...)
+	sort.Ints(pcrIds)
+	if err = pcrs.SetPCRs(pcrIds); err != nil {
+		return nil, fmt.Errorf("failed to set the PCR bitmap %v", err)
+	}
+	srk := (*tspi.Key)(userToken)
+	blob, err := srk.Seal(data, pcrs)
+	if err != nil {
+		return nil, fmt.Errorf("Seal failed: %v", err)
+	}
+	return blob, nil
+}
+
+func (tpm *tspiTPM) Unseal(blob []byte) ([]byte, error) {
+	userToken, err := tpm.getUserToken()
+	if err != nil {
+		return nil, err
+	}
+	srk := (*tspi.Key)(userToken)
+	data, err := srk.Unseal(blob)
+	if err != nil {
+		return nil, fmt.Errorf("Unseal failed: %v", err)
+	}
+	return data, nil
+}
+
+// NVDefine is not supported, go-tspi does not expose the NV space definition.
+func (tpm *tspiTPM) NVDefine(index uint32, size int, policy NVPolicy) error {
+	return fmt.Errorf("NV index definition is not supported by the TPM 1.2 backend")
+}
+
+// NVRead is not supported, go-tspi does not expose the size of an NV index.
+func (tpm *tspiTPM) NVRead(index uint32, policy NVPolicy) ([]byte, error) {
+	return nil, fmt.Errorf("NV index read is not supported by the TPM 1.2 backend")
+}
+
+// NVWrite is not supported, go-tspi does not expose NV writes.
+func (tpm *tspiTPM) NVWrite(index uint32, data []byte, policy NVPolicy) error {
+	return fmt.Errorf("NV index write is not supported by the TPM 1.2 backend")
+}
+
+// NVUndefine is not supported, go-tspi does not expose the NV space release.
+func (tpm *tspiTPM) NVUndefine(index uint32) error {
+	return fmt.Errorf("NV index release is not supported by the TPM 1.2 backend")
+}
@@ -0,0 +1,100 @@
+package verifier
+
+import (
+	"fmt"
+	"github.com/google/certificate-transparency-go/x509"
+	"github.com/xcaliburne/RemoteAttestations/pkg/httpClient"
+	"io/ioutil"
+	"net/http"
+	"time"
+)
+
+// crl is the revocation list of one CA of the trust store.
+type crl struct {
+	thisUpdate time.Time
+	// revoked maps the serial numbers of the revoked certificates to their revocation time.
+	revoked map[string]time.Time
+}
+
+// AddCRL adds a PEM or DER CRL signed by a CA of the store.
+// It replaces the current CRL of that CA unless the current one is more recent.
+func (s *EKTrustStore) AddCRL(raw []byte) error {
+	list, err := x509.ParseCRL(raw)
+	if err != nil {
+		return fmt.Errorf("error parsing CRL: %v", err)
+	}
+	var issuer *x509.Certificate
+	for _, cert := range s.certs {
+		if cert.CheckCRLSignature(list) == nil {
+			issuer = cert
+			break
+		}
+	}
+	if issuer == nil {
+		return fmt.Errorf("CRL issued by %v is not signed by a CA of the trust store", list.TBSCertList.Issuer)
+	}
+	next := &crl{
+		thisUpdate: list.TBSCertList.ThisUpdate,
+		revoked:    map[string]time.Time{},
+	}
+	for _, revoked := range list.TBSCertList.RevokedCertificates {
+		next.revoked[revoked.SerialNumber.String()] = revoked.RevocationTime
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	key := string(issuer.RawSubject)
+	if current, ok := s.crls[key]; ok && current.thisUpdate.After(next.thisUpdate) {
+		return nil
+	}
+	s.crls[key] = next
+	return nil
+}
+
+// LoadCRL adds the CRL stored in file.
+func (s *EKTrustStore) LoadCRL(file string) error {
+	raw, err := ioutil.ReadFile(file)
+	if err != nil {
+		return err
+	}
+	if err = s.AddCRL(raw); err != nil {
+		return fmt.Errorf("%v: %v", file, err)
+	}
+	return nil
+}
+
+// FetchCRL downloads and adds the CRL published at url.
+func (s *EKTrustStore) FetchCRL(url string) error {
+	r, err := httpClient.Client.Get(url)
+	if err != nil {
+		return fmt.Errorf("%v: %v", url, err)
+	}
+	defer r.Body.Close()
+	if r.StatusCode != http.StatusOK {
+		return fmt.Errorf("%v: unexpected status %v", url, r.Status)
+	}
+	raw, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		return fmt.Errorf("%v: %v", url, err)
+	}
+	if err = s.AddCRL(raw); err != nil {
+		return fmt.Errorf("%v: %v", url, err)
+	}
+	return nil
+}
+
+// checkRevocation rejects chain if one of its certificates is listed by the CRL of its issuer.
+// A chain whose CAs have no CRL is accepted.
+func (s *EKTrustStore) checkRevocation(chain []*x509.Certificate) error {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	for _, cert := range chain {
+		list, ok := s.crls[string(cert.RawIssuer)]
+		if !ok {
+			continue
+		}
+		if revokedAt, ok := list.revoked[cert.SerialNumber.String()]; ok {
+			return rejectEK(EKRevoked, "certificate %v issued by %v was revoked on %v", cert.SerialNumber, cert.Issuer.CommonName, revokedAt)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,99 @@
+package verifier_test
+
+import (
+	"crypto/rand"
+	stdx509 "crypto/x509"
+	"crypto/x509/pkix"
+	"errors"
+	"github.com/xcaliburne/RemoteAttestations/pkg/tests"
+	"github.com/xcaliburne/RemoteAttestations/pkg/verifier"
+	"io/ioutil"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// writeCRL writes the CRL of ca revoking certs, issued at thisUpdate, as file in dir.
+func writeCRL(t *testing.T, dir, file string, ca *testCA, thisUpdate time.Time, certs ...*testCA) {
+	var revoked []pkix.RevokedCertificate
+	for _, cert := range certs {
+		revoked = append(revoked, pkix.RevokedCertificate{SerialNumber: cert.cert.SerialNumber, RevocationTime: thisUpdate})
+	}
+	raw, err := stdx509.CreateRevocationList(rand.Reader, &stdx509.RevocationList{
+		Number:              big.NewInt(thisUpdate.UnixNano()),
+		ThisUpdate:          thisUpdate,
+		NextUpdate:          thisUpdate.Add(time.Hour),
+		RevokedCertificates: revoked,
+	}, ca.cert, ca.key)
+	if err != nil {
+		t.Fatalf("CreateRevocationList() returned an error: %v", err)
+	}
+	if err = ioutil.WriteFile(filepath.Join(dir, file), raw, 0600); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestEKTrustStore_CRL(t *testing.T) {
+	root := issue(t, nil, caTemplate("Manufacturer Root CA"), 2048)
+	intermediate := issue(t, root, caTemplate("Manufacturer EK CA"), 2048)
+	otherRoot := issue(t, nil, caTemplate("Other Root CA"), 2048)
+	revokedEK := issue(t, intermediate, ekTemplate(t, "id:49465800", time.Now().Add(time.Hour)), 2048)
+	validEK := issue(t, intermediate, ekTemplate(t, "id:49465800", time.Now().Add(time.Hour)), 2048)
+
+	store := verifier.NewEKTrustStore(verifier.EKPolicy{})
+	store.AddCertificate(parseCT(t, root.der))
+	store.AddCertificate(parseCT(t, intermediate.der))
+
+	dir := t.TempDir()
+	now := time.Now().Add(-time.Minute)
+	writeCRL(t, dir, "empty.crl", intermediate, now.Add(-time.Minute))
+	writeCRL(t, dir, "ek-ca.crl", intermediate, now, revokedEK)
+	writeCRL(t, dir, "other.crl", otherRoot, now, revokedEK)
+	server := httptest.NewServer(http.FileServer(http.Dir(dir)))
+	defer server.Close()
+
+	if err := store.LoadCRL(filepath.Join(dir, "empty.crl")); err != nil {
+		t.Fatalf("LoadCRL() returned an error: %v", err)
+	}
+	if err := store.Verify(parseCT(t, revokedEK.der)); err != nil {
+		t.Fatal(tests.Failure(t, err, nil, "EK rejected before being revoked"))
+	}
+	if err := store.FetchCRL(server.URL + "/ek-ca.crl"); err != nil {
+		t.Fatalf("FetchCRL() returned an error: %v", err)
+	}
+	if err := store.FetchCRL(server.URL + "/other.crl"); err == nil {
+		t.Error(tests.Failure(t, err, "error", "CRL of a CA outside the trust store"))
+	}
+	if err := store.FetchCRL(server.URL + "/missing.crl"); err == nil {
+		t.Error(tests.Failure(t, err, "error", "missing CRL"))
+	}
+	// An older CRL does not replace the current one.
+	if err := store.LoadCRL(filepath.Join(dir, "empty.crl")); err != nil {
+		t.Fatalf("LoadCRL() returned an error: %v", err)
+	}
+
+	var testSuite = []struct {
+		name string
+		ek   *testCA
+		want verifier.EKRejectionReason
+	}{
+		{name: "revoked", ek: revokedEK, want: verifier.EKRevoked},
+		{name: "not revoked", ek: validEK, want: ""},
+	}
+	for _, test := range testSuite {
+		t.Run(test.name, func(t *testing.T) {
+			gotErr := store.Verify(parseCT(t, test.ek.der))
+			var ekErr *verifier.EKCertError
+			if test.want == "" {
+				if gotErr != nil {
+					t.Error(tests.Failure(t, gotErr, nil, ""))
+				}
+			} else if !errors.As(gotErr, &ekErr) || ekErr.Reason != test.want {
+				t.Error(tests.Failure(t, gotErr, test.want, "rejection reason"))
+			}
+		})
+	}
+}
@@ -0,0 +1,251 @@
+package verifier
+
+import (
+	"crypto/rsa"
+	stdpkix "crypto/x509/pkix"
+	"encoding/asn1"
+	"encoding/hex"
+	"encoding/pem"
+	"fmt"
+	"github.com/google/certificate-transparency-go/x509"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// EKRejectionReason is the machine-readable reason why an EK certificate was rejected.
+type EKRejectionReason string
+
+const (
+	EKMalformedCertificate   EKRejectionReason = "malformed_certificate"
+	EKUnknownIssuer          EKRejectionReason = "unknown_issuer"
+	EKExpired                EKRejectionReason = "expired"
+	EKNotYetValid            EKRejectionReason = "not_yet_valid"
+	EKInvalidChain           EKRejectionReason = "invalid_chain"
+	EKManufacturerNotAllowed EKRejectionReason = "manufacturer_not_allowed"
+	EKWeakKey                EKRejectionReason = "weak_key"
+	EKRevoked                EKRejectionReason = "revoked"
+	// EKBlocked is an EK an operator put on the blocklist of the verifier.
+	EKBlocked EKRejectionReason = "blocked"
+)
+
+// EKCertError is returned by EKTrustStore.Verify when an EK certificate is rejected.
+type EKCertError struct {
+	Reason EKRejectionReason
+	Err    error
+}
+
+func (e *EKCertError) Error() string {
+	return fmt.Sprintf("%v: %v", e.Reason, e.Err)
+}
+
+func (e *EKCertError) Unwrap() error {
+	return e.Err
+}
+
+func rejectEK(reason EKRejectionReason, format string, a ...interface{}) *EKCertError {
+	return &EKCertError{Reason: reason, Err: fmt.Errorf(format, a...)}
+}
+
+// EKPolicy restricts the EK certificates accepted by an EKTrustStore.
+type EKPolicy struct {
+	// AllowedManufacturers lists TCG vendor IDs, either as written in the certificate ("id:49465800") or
+	// as their ASCII form ("IFX"). Any manufacturer is allowed when empty.
+	AllowedManufacturers []string
+	// MinKeySize is the minimum RSA modulus size in bits, 0 disables the check.
+	MinKeySize int
+}
+
+// oidTPMManufacturer is the TPM manufacturer attribute of the EK subject alternative name (TCG EK Credential Profile section 3.2.9).
+var oidTPMManufacturer = asn1.ObjectIdentifier{2, 23, 133, 2, 1}
+
+// EKTrustStore holds TPM manufacturer roots and intermediates and validates EK certificate chains against them.
+// Certificates revoked by the CRLs of these CAs are rejected.
+type EKTrustStore struct {
+	roots         *x509.CertPool
+	intermediates *x509.CertPool
+	policy        EKPolicy
+	// certs are the CA certificates of the store, to find the issuer of CRLs.
+	certs []*x509.Certificate
+	mu    sync.RWMutex
+	// crls are the current CRLs by raw issuer subject.
+	crls map[string]*crl
+}
+
+func NewEKTrustStore(policy EKPolicy) *EKTrustStore {
+	return &EKTrustStore{roots: x509.NewCertPool(), intermediates: x509.NewCertPool(), policy: policy, crls: map[string]*crl{}}
+}
+
+// LoadEKTrustStore loads the certificates of every path, a path being a PEM bundle, a DER certificate or a directory of those.
+// Self-signed certificates are roots, the others intermediates.
+func LoadEKTrustStore(paths []string, policy EKPolicy) (*EKTrustStore, error) {
+	store := NewEKTrustStore(policy)
+	for _, path := range paths {
+		info, err := os.Stat(path)
+		if err != nil {
+			return nil, err
+		}
+		files := []string{path}
+		if info.IsDir() {
+			entries, err := ioutil.ReadDir(path)
+			if err != nil {
+				return nil, err
+			}
+			files = files[:0]
+			for _, entry := range entries {
+				if !entry.IsDir() {
+					files = append(files, filepath.Join(path, entry.Name()))
+				}
+			}
+		}
+		for _, file := range files {
+			if err = store.loadFile(file); err != nil {
+				return nil, err
+			}
+		}
+	}
+	return store, nil
+}
+
+func (s *EKTrustStore) loadFile(file string) error {
+	raw, err := ioutil.ReadFile(file)
+	if err != nil {
+		return err
+	}
+	var ders [][]byte
+	for block, rest := pem.Decode(raw); block != nil; block, rest = pem.Decode(rest) {
+		if block.Type == "CERTIFICATE" {
+			ders = append(ders, block.Bytes)
+		}
+	}
+	if len(ders) == 0 {
+		ders = append(ders, raw)
+	}
+	for _, der := range ders {
+		cert, err := x509.ParseCertificate(der)
+		if x509.IsFatal(err) {
+			return fmt.Errorf("%v: error parsing certificate: %v", file, err)
+		}
+		s.AddCertificate(cert)
+	}
+	return nil
+}
+
+func (s *EKTrustStore) AddCertificate(cert *x509.Certificate) {
+	s.certs = append(s.certs, cert)
+	if cert.CheckSignatureFrom(cert) == nil {
+		s.roots.AddCert(cert)
+	} else {
+		s.intermediates.AddCert(cert)
+	}
+}
+
+// Verify builds the chain of an EK certificate up to a trusted root and applies the policy.
+// Rejections are returned as *EKCertError.
+func (s *EKTrustStore) Verify(cert *x509.Certificate) error {
+	if cert == nil {
+		return rejectEK(EKMalformedCertificate, "missing EK certificate")
+	}
+	now := time.Now()
+	if now.Before(cert.NotBefore) {
+		return rejectEK(EKNotYetValid, "EK certificate is valid from %v", cert.NotBefore)
+	}
+	if now.After(cert.NotAfter) {
+		return rejectEK(EKExpired, "EK certificate expired on %v", cert.NotAfter)
+	}
+	pub, ok := cert.PublicKey.(*rsa.PublicKey)
+	if !ok {
+		return rejectEK(EKMalformedCertificate, "EK public key is not RSA")
+	}
+	if pub.N.BitLen() < s.policy.MinKeySize {
+		return rejectEK(EKWeakKey, "EK is %d bits long, policy requires %d", pub.N.BitLen(), s.policy.MinKeySize)
+	}
+	// EK certificates usually have an empty subject and a critical subject alternative name holding only
+	// a directory name with the TPM attributes, which x509 does not handle. It is parsed by EKManufacturer.
+	leaf := *cert
+	leaf.UnhandledCriticalExtensions = nil
+	for _, id := range cert.UnhandledCriticalExtensions {
+		if !id.Equal(oidSubjectAltName) {
+			leaf.UnhandledCriticalExtensions = append(leaf.UnhandledCriticalExtensions, id)
+		}
+	}
+	chains, err := leaf.Verify(x509.VerifyOptions{
+		Roots:         s.roots,
+		Intermediates: s.intermediates,
+		CurrentTime:   now,
+		KeyUsages:     []x509.ExtKeyUsage{x509.ExtKeyUsageAny},
+	})
+	switch e := err.(type) {
+	case nil:
+	case x509.UnknownAuthorityError:
+		return &EKCertError{Reason: EKUnknownIssuer, Err: e}
+	case x509.CertificateInvalidError:
+		if e.Reason == x509.Expired {
+			return &EKCertError{Reason: EKExpired, Err: e}
+		}
+		return &EKCertError{Reason: EKInvalidChain, Err: e}
+	default:
+		return &EKCertError{Reason: EKInvalidChain, Err: err}
+	}
+	if err = s.checkRevocation(chains[0]); err != nil {
+		return err
+	}
+	if len(s.policy.AllowedManufacturers) == 0 {
+		return nil
+	}
+	manufacturer, err := EKManufacturer(cert)
+	if err != nil {
+		return &EKCertError{Reason: EKManufacturerNotAllowed, Err: err}
+	}
+	for _, allowed := range s.policy.AllowedManufacturers {
+		if strings.EqualFold(allowed, manufacturer) || strings.EqualFold(allowed, manufacturerASCII(manufacturer)) {
+			return nil
+		}
+	}
+	return rejectEK(EKManufacturerNotAllowed, "TPM manufacturer %v (%v) is not allowed", manufacturer, manufacturerASCII(manufacturer))
+}
+
+// EKManufacturer returns the TPM manufacturer attribute of the subject alternative name of an EK certificate, e.g. "id:49465800".
+func EKManufacturer(cert *x509.Certificate) (string, error) {
+	for _, ext := range cert.Extensions {
+		if !ext.Id.Equal(oidSubjectAltName) {
+			continue
+		}
+		var names []asn1.RawValue
+		if _, err := asn1.Unmarshal(ext.Value, &names); err != nil {
+			return "", fmt.Errorf("invalid subject alternative name: %v", err)
+		}
+		for _, name := range names {
+			// directoryName [4] Name
+			if name.Class != asn1.ClassContextSpecific || name.Tag != 4 {
+				continue
+			}
+			var rdns stdpkix.RDNSequence
+			if _, err := asn1.Unmarshal(name.Bytes, &rdns); err != nil {
+				return "", fmt.Errorf("invalid directory name: %v", err)
+			}
+			for _, rdn := range rdns {
+				for _, attr := range rdn {
+					if attr.Type.Equal(oidTPMManufacturer) {
+						if value, ok := attr.Value.(string); ok {
+							return value, nil
+						}
+					}
+				}
+			}
+		}
+	}
+	return "", fmt.Errorf("no TPM manufacturer in EK certificate")
+}
+
+// manufacturerASCII decodes a TCG vendor ID such as "id:49465800" to its ASCII form "IFX".
+func manufacturerASCII(id string) string {
+	raw, err := hex.DecodeString(strings.TrimPrefix(strings.ToLower(id), "id:"))
+	if err != nil {
+		return id
+	}
+	return strings.TrimRight(string(raw), "\x00 ")
+}
@@ -0,0 +1,154 @@
+package verifier_test
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	stdx509 "crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"encoding/pem"
+	"errors"
+	"github.com/google/certificate-transparency-go/x509"
+	"github.com/xcaliburne/RemoteAttestations/pkg/tests"
+	"github.com/xcaliburne/RemoteAttestations/pkg/verifier"
+	"io/ioutil"
+	"math/big"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+type testCA struct {
+	cert *stdx509.Certificate
+	key  *rsa.PrivateKey
+	der  []byte
+}
+
+// issue creates a certificate signed by parent, or self-signed when parent is nil.
+func issue(t *testing.T, parent *testCA, template *stdx509.Certificate, bits int) *testCA {
+	key, err := rsa.GenerateKey(rand.Reader, bits)
+	if err != nil {
+		t.Fatalf("GenerateKey() returned an error: %v", err)
+	}
+	signer, signerKey := template, key
+	if parent != nil {
+		signer, signerKey = parent.cert, parent.key
+	}
+	der, err := stdx509.CreateCertificate(rand.Reader, template, signer, &key.PublicKey, signerKey)
+	if err != nil {
+		t.Fatalf("CreateCertificate() returned an error: %v", err)
+	}
+	cert, err := stdx509.ParseCertificate(der)
+	if err != nil {
+		t.Fatalf("ParseCertificate() returned an error: %v", err)
+	}
+	return &testCA{cert: cert, key: key, der: der}
+}
+
+func caTemplate(name string) *stdx509.Certificate {
+	return &stdx509.Certificate{
+		SerialNumber:          big.NewInt(time.Now().UnixNano()),
+		Subject:               pkix.Name{CommonName: name},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		KeyUsage:              stdx509.KeyUsageCertSign | stdx509.KeyUsageCRLSign,
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+}
+
+// ekTemplate returns an EK certificate template whose subject alternative name holds the TPM manufacturer.
+func ekTemplate(t *testing.T, manufacturer string, notAfter time.Time) *stdx509.Certificate {
+	attributes, err := asn1.Marshal(pkix.RDNSequence{{{Type: asn1.ObjectIdentifier{2, 23, 133, 2, 1}, Value: manufacturer}}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	san, err := asn1.Marshal([]asn1.RawValue{{Class: asn1.ClassContextSpecific, Tag: 4, IsCompound: true, Bytes: attributes}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &stdx509.Certificate{
+		SerialNumber:    big.NewInt(time.Now().UnixNano()),
+		NotBefore:       time.Now().Add(-2 * time.Hour),
+		NotAfter:        notAfter,
+		KeyUsage:        stdx509.KeyUsageKeyEncipherment,
+		ExtraExtensions: []pkix.Extension{{Id: asn1.ObjectIdentifier{2, 5, 29, 17}, Critical: true, Value: san}},
+	}
+}
+
+func parseCT(t *testing.T, der []byte) *x509.Certificate {
+	cert, err := x509.ParseCertificate(der)
+	if x509.IsFatal(err) {
+		t.Fatalf("ParseCertificate() returned an error: %v", err)
+	}
+	return cert
+}
+
+func TestEKTrustStore_Verify(t *testing.T) {
+	root := issue(t, nil, caTemplate("Manufacturer Root CA"), 2048)
+	intermediate := issue(t, root, caTemplate("Manufacturer EK CA"), 2048)
+	otherRoot := issue(t, nil, caTemplate("Other Root CA"), 2048)
+
+	// root and intermediate are loaded from a directory, as a PEM bundle and a DER certificate.
+	dir := t.TempDir()
+	if err := ioutil.WriteFile(filepath.Join(dir, "root.pem"), pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: root.der}), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(dir, "intermediate.der"), intermediate.der, 0600); err != nil {
+		t.Fatal(err)
+	}
+	store, err := verifier.LoadEKTrustStore([]string{dir}, verifier.EKPolicy{AllowedManufacturers: []string{"IFX"}, MinKeySize: 2048})
+	if err != nil {
+		t.Fatalf("LoadEKTrustStore() returned an error: %v", err)
+	}
+
+	var testSuite = []struct {
+		name string
+		ek   *x509.Certificate
+		want verifier.EKRejectionReason
+	}{
+		{
+			name: "valid chain",
+			ek:   parseCT(t, issue(t, intermediate, ekTemplate(t, "id:49465800", time.Now().Add(time.Hour)), 2048).der),
+			want: "",
+		},
+		{
+			name: "missing certificate",
+			ek:   nil,
+			want: verifier.EKMalformedCertificate,
+		},
+		{
+			name: "unknown issuer",
+			ek:   parseCT(t, issue(t, otherRoot, ekTemplate(t, "id:49465800", time.Now().Add(time.Hour)), 2048).der),
+			want: verifier.EKUnknownIssuer,
+		},
+		{
+			name: "expired",
+			ek:   parseCT(t, issue(t, intermediate, ekTemplate(t, "id:49465800", time.Now().Add(-time.Hour)), 2048).der),
+			want: verifier.EKExpired,
+		},
+		{
+			name: "manufacturer not allowed",
+			ek:   parseCT(t, issue(t, intermediate, ekTemplate(t, "id:53544D20", time.Now().Add(time.Hour)), 2048).der),
+			want: verifier.EKManufacturerNotAllowed,
+		},
+		{
+			name: "weak key",
+			ek:   parseCT(t, issue(t, intermediate, ekTemplate(t, "id:49465800", time.Now().Add(time.Hour)), 1024).der),
+			want: verifier.EKWeakKey,
+		},
+	}
+	for _, test := range testSuite {
+		t.Run(test.name, func(t *testing.T) {
+			gotErr := store.Verify(test.ek)
+			var ekErr *verifier.EKCertError
+			if test.want == "" {
+				if gotErr != nil {
+					t.Error(tests.Failure(t, gotErr, nil, ""))
+				}
+			} else if !errors.As(gotErr, &ekErr) || ekErr.Reason != test.want {
+				t.Error(tests.Failure(t, gotErr, test.want, "rejection reason"))
+			}
+		})
+	}
+}
@@ -0,0 +1,106 @@
+package verifier
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"github.com/xcaliburne/RemoteAttestations/pkg/eventlog"
+	"github.com/xcaliburne/RemoteAttestations/pkg/tpm"
+	"strings"
+)
+
+// EventPolicy appraises the events of a firmware event log. The log must have been replayed against the quote first,
+// its events are only trusted once it does.
+type EventPolicy struct {
+	// RequireSecureBoot rejects logs without a measured SecureBoot variable set to 1.
+	RequireSecureBoot bool
+	// BootApplications are the accepted digests of EFI boot services applications, any application is accepted when empty.
+	BootApplications [][]byte
+}
+
+// ErrNoEventPolicy is returned when an event log is the only reference of an attestation and no event policy is configured.
+var ErrNoEventPolicy = errors.New("no event policy configured")
+
+// Configured reports whether p expects anything of the boot. A log matching the quote proves nothing without it.
+func (p EventPolicy) Configured() bool {
+	return p.RequireSecureBoot || len(p.BootApplications) > 0
+}
+
+// EventFailure is an event rejected by the policy. Event is -1 when the failure is about a missing event.
+type EventFailure struct {
+	Event  int
+	PCR    int
+	Type   eventlog.EventType
+	Reason string
+}
+
+func (f EventFailure) String() string {
+	if f.Event < 0 {
+		return f.Reason
+	}
+	return fmt.Sprintf("event %d (PCR %d, %v): %v", f.Event, f.PCR, f.Type, f.Reason)
+}
+
+// EventAppraisalError lists the events rejected by an EventPolicy.
+type EventAppraisalError struct {
+	Failures []EventFailure
+}
+
+func (e *EventAppraisalError) Error() string {
+	s := make([]string, len(e.Failures))
+	for i, f := range e.Failures {
+		s[i] = f.String()
+	}
+	return fmt.Sprintf("event log rejected: %v", strings.Join(s, "; "))
+}
+
+// Appraise checks the events of log with their bank digests. It returns an *EventAppraisalError listing the rejected events.
+func (p EventPolicy) Appraise(log *eventlog.EventLog, bank tpm.Bank) error {
+	var failures []EventFailure
+	secureBoot := false
+	for _, event := range log.Events {
+		fail := func(format string, a ...interface{}) {
+			failures = append(failures, EventFailure{Event: event.Index, PCR: event.PCR, Type: event.Type, Reason: fmt.Sprintf(format, a...)})
+		}
+		switch event.Type {
+		case eventlog.Separator:
+			if !event.DataMatchesDigest(bank) {
+				fail("data does not match digest")
+			} else if failed, err := event.SeparatorError(); err != nil {
+				fail("%v", err)
+			} else if failed {
+				fail("firmware error")
+			}
+		case eventlog.EFIBootServicesApplication:
+			if len(p.BootApplications) > 0 && !containsDigest(p.BootApplications, event.Digests[bank]) {
+				fail("boot application %x not accepted", event.Digests[bank])
+			}
+		case eventlog.EFIVariableDriverConfig:
+			variable, err := event.EFIVariable()
+			if err != nil || variable.VendorGUID != eventlog.EFIGlobalVariable || variable.Name != "SecureBoot" {
+				continue
+			}
+			if !event.DataMatchesDigest(bank) {
+				fail("data does not match digest")
+				continue
+			}
+			secureBoot = bytes.Equal(variable.Data, []byte{1})
+		}
+	}
+	if p.RequireSecureBoot && !secureBoot {
+		failures = append(failures, EventFailure{Event: -1, PCR: 7, Type: eventlog.EFIVariableDriverConfig, Reason: "secure boot is not enabled"})
+	}
+	if len(failures) > 0 {
+		return &EventAppraisalError{Failures: failures}
+	}
+	return nil
+}
+
+func containsDigest(digests [][]byte, digest []byte) bool {
+	for _, d := range digests {
+		if bytes.Equal(d, digest) {
+			return true
+		}
+	}
+	return false
+}
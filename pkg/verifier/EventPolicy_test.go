@@ -0,0 +1,120 @@
+package verifier_test
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"github.com/google/go-cmp/cmp"
+	"github.com/xcaliburne/RemoteAttestations/pkg/eventlog"
+	"github.com/xcaliburne/RemoteAttestations/pkg/tests"
+	"github.com/xcaliburne/RemoteAttestations/pkg/tpm"
+	"github.com/xcaliburne/RemoteAttestations/pkg/verifier"
+	"testing"
+	"unicode/utf16"
+)
+
+// event returns an event measuring data, or measured when set.
+func event(index, pcr int, typ eventlog.EventType, data, measured []byte) eventlog.Event {
+	if measured == nil {
+		measured = data
+	}
+	digest := sha256.Sum256(measured)
+	return eventlog.Event{Index: index, PCR: pcr, Type: typ, Digests: map[tpm.Bank][]byte{tpm.SHA256: digest[:]}, Data: data}
+}
+
+func secureBootVariable(value byte) []byte {
+	buf := &bytes.Buffer{}
+	name := utf16.Encode([]rune("SecureBoot"))
+	for _, v := range []interface{}{eventlog.EFIGlobalVariable, uint64(len(name)), uint64(1), name, []byte{value}} {
+		_ = binary.Write(buf, binary.LittleEndian, v)
+	}
+	return buf.Bytes()
+}
+
+func TestEventPolicy_Appraise(t *testing.T) {
+	bootloader := sha256.Sum256([]byte("bootloader"))
+	separator := []byte{0, 0, 0, 0}
+	log := func(events ...eventlog.Event) *eventlog.EventLog {
+		return &eventlog.EventLog{CryptoAgile: true, Banks: []tpm.Bank{tpm.SHA256}, Events: events}
+	}
+	trusted := log(
+		event(1, 7, eventlog.EFIVariableDriverConfig, secureBootVariable(1), nil),
+		event(2, 7, eventlog.Separator, separator, nil),
+		event(3, 4, eventlog.EFIBootServicesApplication, []byte("image load event"), []byte("bootloader")),
+	)
+	policy := verifier.EventPolicy{RequireSecureBoot: true, BootApplications: [][]byte{bootloader[:]}}
+
+	var testSuite = []struct {
+		name   string
+		policy verifier.EventPolicy
+		log    *eventlog.EventLog
+		want   []int
+	}{
+		{name: "trusted boot", policy: policy, log: trusted},
+		{name: "empty policy", policy: verifier.EventPolicy{}, log: log(event(1, 4, eventlog.EFIBootServicesApplication, nil, []byte("other")))},
+		{
+			name:   "secure boot disabled",
+			policy: policy,
+			log:    log(event(1, 7, eventlog.EFIVariableDriverConfig, secureBootVariable(0), nil)),
+			want:   []int{-1},
+		},
+		{
+			name:   "secure boot variable not matching its digest",
+			policy: policy,
+			log:    log(event(1, 7, eventlog.EFIVariableDriverConfig, secureBootVariable(1), secureBootVariable(0))),
+			want:   []int{1, -1},
+		},
+		{
+			name:   "unknown boot application",
+			policy: policy,
+			log: log(
+				event(1, 7, eventlog.EFIVariableDriverConfig, secureBootVariable(1), nil),
+				event(2, 4, eventlog.EFIBootServicesApplication, nil, []byte("other")),
+			),
+			want: []int{2},
+		},
+		{
+			name:   "firmware error",
+			policy: verifier.EventPolicy{},
+			log:    log(event(1, 0, eventlog.Separator, []byte{1, 0, 0, 0}, nil)),
+			want:   []int{1},
+		},
+	}
+	for _, test := range testSuite {
+		t.Run(test.name, func(t *testing.T) {
+			err := test.policy.Appraise(test.log, tpm.SHA256)
+			var appraisalErr *verifier.EventAppraisalError
+			var got []int
+			if errors.As(err, &appraisalErr) {
+				for _, failure := range appraisalErr.Failures {
+					got = append(got, failure.Event)
+				}
+			} else if err != nil {
+				t.Fatalf("Appraise() returned an unexpected error: %v", err)
+			}
+			if !cmp.Equal(got, test.want) {
+				t.Error(tests.Failure(t, err, test.want, "rejected events"))
+			}
+		})
+	}
+}
+
+func TestEventPolicy_Configured(t *testing.T) {
+	var testSuite = []struct {
+		name   string
+		policy verifier.EventPolicy
+		want   bool
+	}{
+		{name: "empty policy", policy: verifier.EventPolicy{}, want: false},
+		{name: "secure boot", policy: verifier.EventPolicy{RequireSecureBoot: true}, want: true},
+		{name: "boot applications", policy: verifier.EventPolicy{BootApplications: [][]byte{{1}}}, want: true},
+	}
+	for _, test := range testSuite {
+		t.Run(test.name, func(t *testing.T) {
+			if got := test.policy.Configured(); got != test.want {
+				t.Error(tests.Failure(t, got, test.want, "configured"))
+			}
+		})
+	}
+}
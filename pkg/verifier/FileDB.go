@@ -2,16 +2,28 @@ package verifier
 
 import (
 	"bufio"
+	"encoding/hex"
+	"fmt"
 	"github.com/xcaliburne/RemoteAttestations/pkg/tpm"
 	"os"
 	"strconv"
 	"strings"
 )
 
+// FileDB reads golden PCR values from a text file, one PCR per line:
+//
+//	[bank] PCR-<index>: <hex bytes>
+//
+// The bank defaults to sha1 so the kernel's /sys/class/tpm/tpm0/pcrs output can be used as is.
+// Hex bytes may be space separated or contiguous.
 type FileDB struct {
 	filepath string
 }
 
+func NewFileDB(filepath string) *FileDB {
+	return &FileDB{filepath: filepath}
+}
+
 func (f FileDB) GetPCRs() ([]tpm.PCR, error) {
 	file, err := os.Open(f.filepath)
 	if err != nil {
@@ -20,23 +32,38 @@ func (f FileDB) GetPCRs() ([]tpm.PCR, error) {
 	defer file.Close()
 	scanner := bufio.NewScanner(file)
 	pcrs := []tpm.PCR{}
-	id := 0
-	for scanner.Scan() {
-		line := scanner.Text()
-		vals := strings.Split(strings.TrimSpace(line), " ")[1:]
+	ids := map[tpm.Bank]int{}
+	for lineNumber := 1; scanner.Scan(); lineNumber++ {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) == 0 {
+			continue
+		}
+		bank := tpm.SHA1
+		if b, err := tpm.ParseBank(fields[0]); err == nil {
+			bank, fields = b, fields[1:]
+		}
+		if len(fields) < 2 {
+			return nil, fmt.Errorf("line %d: missing PCR value", lineNumber)
+		}
 		pcr := tpm.PCR{
-			Id:    id,
-			Value: []byte{},
+			Id:   ids[bank],
+			Bank: bank,
 		}
-		for _, v := range vals {
-			n, err := strconv.ParseInt(v, 16, 32)
-			if err != nil {
-				return nil, err
+		label := strings.TrimSuffix(fields[0], ":")
+		if i := strings.LastIndexAny(label, "-_"); i >= 0 {
+			if id, err := strconv.Atoi(label[i+1:]); err == nil {
+				pcr.Id = id
 			}
-			pcr.Value = append(pcr.Value, byte(n))
 		}
-		id += 1
+		pcr.Value, err = hex.DecodeString(strings.Join(fields[1:], ""))
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %v", lineNumber, err)
+		}
+		if len(pcr.Value) != bank.Size() {
+			return nil, fmt.Errorf("line %d: expected %d bytes for %v, got %d", lineNumber, bank.Size(), bank, len(pcr.Value))
+		}
+		ids[bank] = pcr.Id + 1
 		pcrs = append(pcrs, pcr)
 	}
-	return pcrs, err
+	return pcrs, scanner.Err()
 }
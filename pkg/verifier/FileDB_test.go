@@ -0,0 +1,66 @@
+package verifier_test
+
+import (
+	"bytes"
+	"github.com/google/go-cmp/cmp"
+	"github.com/xcaliburne/RemoteAttestations/pkg/tests"
+	"github.com/xcaliburne/RemoteAttestations/pkg/tpm"
+	"github.com/xcaliburne/RemoteAttestations/pkg/verifier"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestFileDB_GetPCRs(t *testing.T) {
+	sha1Value := strings.TrimSpace(strings.Repeat("0A ", 20))
+	sha256Value := strings.Repeat("0b", 32)
+	var testSuite = []struct {
+		name    string
+		input   string
+		want    []tpm.PCR
+		wantErr bool
+	}{
+		{
+			name:  "kernel format",
+			input: "PCR-00: " + sha1Value + "\nPCR-01: " + sha1Value + "\n",
+			want: []tpm.PCR{
+				{Id: 0, Bank: tpm.SHA1, Value: bytes.Repeat([]byte{0x0a}, 20)},
+				{Id: 1, Bank: tpm.SHA1, Value: bytes.Repeat([]byte{0x0a}, 20)},
+			},
+		},
+		{
+			name:  "bank prefix",
+			input: "sha1 PCR-07: " + sha1Value + "\n\nsha256 PCR-07: " + sha256Value + "\n",
+			want: []tpm.PCR{
+				{Id: 7, Bank: tpm.SHA1, Value: bytes.Repeat([]byte{0x0a}, 20)},
+				{Id: 7, Bank: tpm.SHA256, Value: bytes.Repeat([]byte{0x0b}, 32)},
+			},
+		},
+		{
+			name:    "value does not match bank",
+			input:   "sha256 PCR-00: " + sha1Value + "\n",
+			wantErr: true,
+		},
+		{
+			name:    "invalid hex",
+			input:   "PCR-00: zz\n",
+			wantErr: true,
+		},
+	}
+	for _, test := range testSuite {
+		t.Run(test.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "pcrs")
+			if err := ioutil.WriteFile(path, []byte(test.input), 0600); err != nil {
+				t.Fatal(err)
+			}
+			got, gotErr := verifier.NewFileDB(path).GetPCRs()
+			if (gotErr != nil) != test.wantErr {
+				t.Fatal(tests.Failure(t, gotErr, test.wantErr, ""))
+			}
+			if !test.wantErr && !cmp.Equal(got, test.want) {
+				t.Error(tests.Failure(t, got, test.want, ""))
+			}
+		})
+	}
+}
@@ -0,0 +1,129 @@
+package verifier
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/hex"
+	"fmt"
+	"github.com/xcaliburne/RemoteAttestations/pkg/ima"
+	"os"
+	"strings"
+)
+
+// IMAFindingKind tells why a measured file was reported.
+type IMAFindingKind string
+
+const (
+	// IMAUnknown is a file missing from the allowlist or whose hash is not accepted for its path.
+	IMAUnknown IMAFindingKind = "unknown"
+	// IMAForbidden is a file whose hash is in the denylist.
+	IMAForbidden IMAFindingKind = "forbidden"
+	// IMAInvalid is an entry whose template hash does not match its file hash and path.
+	IMAInvalid IMAFindingKind = "invalid"
+)
+
+// IMAFinding is a measured file reported by an IMAPolicy.
+type IMAFinding struct {
+	Kind     IMAFindingKind
+	Path     string
+	FileHash []byte
+}
+
+func (f IMAFinding) String() string {
+	return fmt.Sprintf("%v file %v (%x)", f.Kind, f.Path, f.FileHash)
+}
+
+// Equal tells whether f and other report the same file for the same reason.
+func (f IMAFinding) Equal(other IMAFinding) bool {
+	return f.Kind == other.Kind && f.Path == other.Path && bytes.Equal(f.FileHash, other.FileHash)
+}
+
+// IMAPolicy appraises the files measured by IMA. Allowlist maps paths to their accepted file hashes,
+// every file is accepted when it is empty. Denylist lists forbidden file hashes whatever their path.
+type IMAPolicy struct {
+	Allowlist map[string][][]byte
+	Denylist  [][]byte
+}
+
+// LoadIMAPolicy reads the allowlist and denylist files, either may be empty. Both use the sha256sum output format:
+//
+//	<hex file hash>  <path>
+//
+// The path is optional in the denylist. Empty lines and lines starting with # are ignored.
+func LoadIMAPolicy(allowlist, denylist string) (*IMAPolicy, error) {
+	policy := &IMAPolicy{Allowlist: map[string][][]byte{}}
+	if allowlist != "" {
+		err := readHashList(allowlist, func(hash []byte, path string) error {
+			if path == "" {
+				return fmt.Errorf("missing path")
+			}
+			policy.Allowlist[path] = append(policy.Allowlist[path], hash)
+			return nil
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+	if denylist != "" {
+		err := readHashList(denylist, func(hash []byte, path string) error {
+			policy.Denylist = append(policy.Denylist, hash)
+			return nil
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+	return policy, nil
+}
+
+func readHashList(file string, add func(hash []byte, path string) error) error {
+	f, err := os.Open(file)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	scanner := bufio.NewScanner(f)
+	for lineNumber := 1; scanner.Scan(); lineNumber++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		fields := strings.SplitN(line, " ", 2)
+		hash, err := hex.DecodeString(fields[0])
+		if err != nil {
+			return fmt.Errorf("%v: line %d: %v", file, lineNumber, err)
+		}
+		path := ""
+		if len(fields) == 2 {
+			// sha256sum marks files read in binary mode with *
+			path = strings.TrimPrefix(strings.TrimSpace(fields[1]), "*")
+		}
+		if err = add(hash, path); err != nil {
+			return fmt.Errorf("%v: line %d: %v", file, lineNumber, err)
+		}
+	}
+	return scanner.Err()
+}
+
+// Appraise returns the entries rejected by the policy. The boot aggregate and the violations are not appraised.
+func (p *IMAPolicy) Appraise(entries []ima.Entry) []IMAFinding {
+	var findings []IMAFinding
+	for _, entry := range entries {
+		if entry.Violation() || entry.Path == ima.BootAggregate {
+			continue
+		}
+		finding := IMAFinding{Path: entry.Path, FileHash: entry.FileHash}
+		switch {
+		case entry.Verify() != nil:
+			finding.Kind = IMAInvalid
+		case containsDigest(p.Denylist, entry.FileHash):
+			finding.Kind = IMAForbidden
+		case len(p.Allowlist) > 0 && !containsDigest(p.Allowlist[entry.Path], entry.FileHash):
+			finding.Kind = IMAUnknown
+		default:
+			continue
+		}
+		findings = append(findings, finding)
+	}
+	return findings
+}
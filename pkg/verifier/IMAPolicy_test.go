@@ -0,0 +1,100 @@
+package verifier_test
+
+import (
+	"bytes"
+	"crypto/sha1"
+	"crypto/sha256"
+	"encoding/binary"
+	"fmt"
+	"github.com/google/go-cmp/cmp"
+	"github.com/xcaliburne/RemoteAttestations/pkg/ima"
+	"github.com/xcaliburne/RemoteAttestations/pkg/tests"
+	"github.com/xcaliburne/RemoteAttestations/pkg/verifier"
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+)
+
+// imaEntry returns the ima-ng entry measuring a file of path with content.
+func imaEntry(path, content string) ima.Entry {
+	fileHash := sha256.Sum256([]byte(content))
+	data := &bytes.Buffer{}
+	for _, field := range [][]byte{append([]byte("sha256:\x00"), fileHash[:]...), append([]byte(path), 0)} {
+		_ = binary.Write(data, binary.LittleEndian, uint32(len(field)))
+		data.Write(field)
+	}
+	templateHash := sha1.Sum(data.Bytes())
+	return ima.Entry{PCR: ima.PCR, TemplateHash: templateHash[:], TemplateName: "ima-ng", TemplateData: data.Bytes(),
+		FileHashAlg: "sha256", FileHash: fileHash[:], Path: path}
+}
+
+func TestIMAPolicy_Appraise(t *testing.T) {
+	dir := t.TempDir()
+	bash := sha256.Sum256([]byte("bash"))
+	bashUpdate := sha256.Sum256([]byte("bash update"))
+	malware := sha256.Sum256([]byte("malware"))
+	allowlist := filepath.Join(dir, "allowlist")
+	denylist := filepath.Join(dir, "denylist")
+	content := fmt.Sprintf("# accepted files\n%x  /usr/bin/bash\n%x *%s\n\n", bash, bashUpdate, "/usr/bin/bash")
+	if err := ioutil.WriteFile(allowlist, []byte(content), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(denylist, []byte(fmt.Sprintf("%x\n", malware)), 0600); err != nil {
+		t.Fatal(err)
+	}
+	policy, err := verifier.LoadIMAPolicy(allowlist, denylist)
+	if err != nil {
+		t.Fatalf("LoadIMAPolicy() returned an error: %v", err)
+	}
+	denyOnly, err := verifier.LoadIMAPolicy("", denylist)
+	if err != nil {
+		t.Fatalf("LoadIMAPolicy() returned an error: %v", err)
+	}
+	tampered := imaEntry("/usr/bin/bash", "bash")
+	tampered.FileHash = malware[:]
+	violation := imaEntry("/tmp/file", "")
+	violation.TemplateHash = make([]byte, sha1.Size)
+
+	var testSuite = []struct {
+		name    string
+		policy  *verifier.IMAPolicy
+		entries []ima.Entry
+		want    []verifier.IMAFinding
+	}{
+		{
+			name:    "accepted files",
+			policy:  policy,
+			entries: []ima.Entry{imaEntry(ima.BootAggregate, "boot"), imaEntry("/usr/bin/bash", "bash"), imaEntry("/usr/bin/bash", "bash update"), violation},
+		},
+		{
+			name:    "unknown file",
+			policy:  policy,
+			entries: []ima.Entry{imaEntry("/usr/bin/vi", "bash")},
+			want:    []verifier.IMAFinding{{Kind: verifier.IMAUnknown, Path: "/usr/bin/vi", FileHash: bash[:]}},
+		},
+		{
+			name:    "forbidden file",
+			policy:  denyOnly,
+			entries: []ima.Entry{imaEntry("/usr/bin/vi", "bash"), imaEntry("/tmp/x", "malware")},
+			want:    []verifier.IMAFinding{{Kind: verifier.IMAForbidden, Path: "/tmp/x", FileHash: malware[:]}},
+		},
+		{
+			name:    "entry not matching its template hash",
+			policy:  denyOnly,
+			entries: []ima.Entry{tampered},
+			want:    []verifier.IMAFinding{{Kind: verifier.IMAInvalid, Path: "/usr/bin/bash", FileHash: malware[:]}},
+		},
+	}
+	for _, test := range testSuite {
+		t.Run(test.name, func(t *testing.T) {
+			got := test.policy.Appraise(test.entries)
+			if !cmp.Equal(got, test.want) {
+				t.Error(tests.Failure(t, got, test.want, ""))
+			}
+		})
+	}
+
+	if _, err = verifier.LoadIMAPolicy(denylist, ""); err == nil {
+		t.Error(tests.Failure(t, err, "error", "allowlist without paths"))
+	}
+}
@@ -0,0 +1,225 @@
+package verifier
+
+import (
+	"encoding/hex"
+	"fmt"
+	"github.com/xcaliburne/RemoteAttestations/pkg/tpm"
+	"gopkg.in/yaml.v3"
+	"io/ioutil"
+	"sort"
+)
+
+// PCRRule appraises one PCR. Values are the accepted values, any value is accepted when empty.
+// Unchanged requires the value quoted at the last attestation that passed the policy.
+// Ignored PCRs are neither quoted nor appraised.
+type PCRRule struct {
+	PCR       int
+	Values    [][]byte
+	Unchanged bool
+	Ignore    bool
+}
+
+// PCRPolicy is a set of rules applied to the provers named in Provers and to the provers carrying all of Labels.
+// A policy without provers nor labels applies to every prover.
+type PCRPolicy struct {
+	Name    string
+	Provers []string
+	Labels  map[string]string
+	Bank    tpm.Bank
+	Rules   []PCRRule
+}
+
+// PCRPolicies are the policies of a policy file, the first one applying to a prover is used.
+type PCRPolicies []PCRPolicy
+
+// Rule kinds reported in PCRRuleResult.
+const (
+	RuleValues    = "values"
+	RuleUnchanged = "unchanged"
+	RuleIgnore    = "ignore"
+)
+
+// PCRRuleResult is the outcome of a rule for one PCR.
+type PCRRuleResult struct {
+	PCR    int
+	Bank   tpm.Bank
+	Rule   string
+	Passed bool
+	Reason string
+}
+
+func (r PCRRuleResult) String() string {
+	status := "passed"
+	if !r.Passed {
+		status = "failed"
+	}
+	if r.Reason == "" {
+		return fmt.Sprintf("%v PCR %d: %v rule %v", r.Bank, r.PCR, r.Rule, status)
+	}
+	return fmt.Sprintf("%v PCR %d: %v rule %v: %v", r.Bank, r.PCR, r.Rule, status, r.Reason)
+}
+
+// LoadPCRPolicies reads a YAML or JSON policy file:
+//
+//	policies:
+//	  - name: fleet
+//	    labels: {site: paris}
+//	    bank: sha256
+//	    pcrs:
+//	      - pcr: 0
+//	        values: [<hex value>, <hex value>]
+//	      - pcr: 7
+//	        unchanged: true
+//	      - pcr: 10
+//	        ignore: true
+//
+// The bank defaults to sha1 like FileDB. A policy must appraise at least one PCR.
+func LoadPCRPolicies(file string) (PCRPolicies, error) {
+	raw, err := ioutil.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	var policyFile struct {
+		Policies []struct {
+			Name    string            `yaml:"name"`
+			Provers []string          `yaml:"provers"`
+			Labels  map[string]string `yaml:"labels"`
+			Bank    string            `yaml:"bank"`
+			PCRs    []struct {
+				PCR       int      `yaml:"pcr"`
+				Values    []string `yaml:"values"`
+				Unchanged bool     `yaml:"unchanged"`
+				Ignore    bool     `yaml:"ignore"`
+			} `yaml:"pcrs"`
+		} `yaml:"policies"`
+	}
+	if err = yaml.Unmarshal(raw, &policyFile); err != nil {
+		return nil, fmt.Errorf("%v: %v", file, err)
+	}
+	var policies PCRPolicies
+	for i, p := range policyFile.Policies {
+		policy := PCRPolicy{Name: p.Name, Provers: p.Provers, Labels: p.Labels, Bank: tpm.SHA1}
+		if policy.Name == "" {
+			policy.Name = fmt.Sprintf("policy-%d", i)
+		}
+		if p.Bank != "" {
+			if policy.Bank, err = tpm.ParseBank(p.Bank); err != nil {
+				return nil, fmt.Errorf("%v: policy %v: %v", file, policy.Name, err)
+			}
+		}
+		seen := map[int]bool{}
+		for _, r := range p.PCRs {
+			if r.PCR < 0 || r.PCR >= len(tpm.All_pcrs) {
+				return nil, fmt.Errorf("%v: policy %v: invalid PCR %d", file, policy.Name, r.PCR)
+			}
+			if seen[r.PCR] {
+				return nil, fmt.Errorf("%v: policy %v: PCR %d appears twice", file, policy.Name, r.PCR)
+			}
+			seen[r.PCR] = true
+			if r.Ignore && (len(r.Values) > 0 || r.Unchanged) {
+				return nil, fmt.Errorf("%v: policy %v: ignored PCR %d has rules", file, policy.Name, r.PCR)
+			}
+			rule := PCRRule{PCR: r.PCR, Unchanged: r.Unchanged, Ignore: r.Ignore}
+			for _, v := range r.Values {
+				value, err := hex.DecodeString(v)
+				if err != nil || len(value) != policy.Bank.Size() {
+					return nil, fmt.Errorf("%v: policy %v: invalid %v value for PCR %d: %v", file, policy.Name, policy.Bank, r.PCR, v)
+				}
+				rule.Values = append(rule.Values, value)
+			}
+			policy.Rules = append(policy.Rules, rule)
+		}
+		// An empty selection would quote every PCR and appraise none of them
+		if len(policy.Selection().PCRs) == 0 {
+			return nil, fmt.Errorf("%v: policy %v: no PCR is appraised", file, policy.Name)
+		}
+		policies = append(policies, policy)
+	}
+	return policies, nil
+}
+
+// Match returns the first policy applying to the prover with name and labels, nil if there is none.
+func (p PCRPolicies) Match(name string, labels map[string]string) *PCRPolicy {
+	for i := range p {
+		if p[i].applies(name, labels) {
+			return &p[i]
+		}
+	}
+	return nil
+}
+
+func (p *PCRPolicy) applies(name string, labels map[string]string) bool {
+	return ProverSelector{Provers: p.Provers, Labels: p.Labels}.Matches(name, labels)
+}
+
+// Selection returns the PCRs appraised by the policy.
+func (p *PCRPolicy) Selection() tpm.PCRSelection {
+	sel := tpm.PCRSelection{Bank: p.Bank}
+	for _, rule := range p.Rules {
+		if !rule.Ignore {
+			sel.PCRs = append(sel.PCRs, rule.PCR)
+		}
+	}
+	sort.Ints(sel.PCRs)
+	return sel
+}
+
+// Evaluate applies the rules to the quoted values actual. previous are the values of the last attestation
+// that passed the policy, the unchanged rules pass when there is none.
+// The values must have been checked against the quote with Quote.VerifyPCRs first.
+func (p *PCRPolicy) Evaluate(actual, previous []tpm.PCR) []PCRRuleResult {
+	value := func(pcrs []tpm.PCR, id int) []byte {
+		for _, pcr := range pcrs {
+			if pcr.Id == id && pcr.Bank.Equal(p.Bank) {
+				return pcr.Value
+			}
+		}
+		return nil
+	}
+	var results []PCRRuleResult
+	for _, rule := range p.Rules {
+		result := func(kind string, passed bool, format string, a ...interface{}) {
+			results = append(results, PCRRuleResult{PCR: rule.PCR, Bank: p.Bank, Rule: kind, Passed: passed, Reason: fmt.Sprintf(format, a...)})
+		}
+		if rule.Ignore {
+			result(RuleIgnore, true, "")
+			continue
+		}
+		actualValue := value(actual, rule.PCR)
+		if actualValue == nil {
+			result(RuleValues, false, "not quoted")
+			continue
+		}
+		if len(rule.Values) > 0 {
+			if containsDigest(rule.Values, actualValue) {
+				result(RuleValues, true, "")
+			} else {
+				result(RuleValues, false, "value %x not accepted", actualValue)
+			}
+		} else if !rule.Unchanged {
+			result(RuleValues, true, "any value accepted")
+		}
+		if rule.Unchanged {
+			previousValue := value(previous, rule.PCR)
+			switch {
+			case previousValue == nil:
+				result(RuleUnchanged, true, "first attestation")
+			case containsDigest([][]byte{previousValue}, actualValue):
+				result(RuleUnchanged, true, "")
+			default:
+				result(RuleUnchanged, false, "changed from %x to %x", previousValue, actualValue)
+			}
+		}
+	}
+	return results
+}
+
+// PCRRulesPassed tells whether every result passed.
+func PCRRulesPassed(results []PCRRuleResult) bool {
+	for _, r := range results {
+		if !r.Passed {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,170 @@
+package verifier_test
+
+import (
+	"bytes"
+	"github.com/google/go-cmp/cmp"
+	"github.com/xcaliburne/RemoteAttestations/pkg/tests"
+	"github.com/xcaliburne/RemoteAttestations/pkg/tpm"
+	"github.com/xcaliburne/RemoteAttestations/pkg/verifier"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestLoadPCRPolicies(t *testing.T) {
+	value := strings.Repeat("0a", 32)
+	var testSuite = []struct {
+		name    string
+		input   string
+		want    verifier.PCRPolicies
+		wantErr bool
+	}{
+		{
+			name: "yaml",
+			input: "policies:\n  - name: fleet\n    provers: [edge]\n    bank: sha256\n    pcrs:\n" +
+				"      - pcr: 0\n        values: [" + value + "]\n      - pcr: 7\n        unchanged: true\n      - pcr: 10\n        ignore: true\n",
+			want: verifier.PCRPolicies{{
+				Name:    "fleet",
+				Provers: []string{"edge"},
+				Bank:    tpm.SHA256,
+				Rules: []verifier.PCRRule{
+					{PCR: 0, Values: [][]byte{bytes.Repeat([]byte{0x0a}, 32)}},
+					{PCR: 7, Unchanged: true},
+					{PCR: 10, Ignore: true},
+				},
+			}},
+		},
+		{
+			name:  "json with default bank",
+			input: `{"policies": [{"labels": {"site": "paris"}, "pcrs": [{"pcr": 4, "unchanged": true}]}]}`,
+			want: verifier.PCRPolicies{{
+				Name:   "policy-0",
+				Labels: map[string]string{"site": "paris"},
+				Bank:   tpm.SHA1,
+				Rules:  []verifier.PCRRule{{PCR: 4, Unchanged: true}},
+			}},
+		},
+		{
+			name:    "value size does not match bank",
+			input:   "policies:\n  - pcrs:\n      - pcr: 0\n        values: [" + value + "]\n",
+			wantErr: true,
+		},
+		{
+			name:    "PCR listed twice",
+			input:   "policies:\n  - pcrs:\n      - pcr: 0\n      - pcr: 0\n",
+			wantErr: true,
+		},
+		{
+			name:    "ignored PCR with rules",
+			input:   "policies:\n  - pcrs:\n      - pcr: 0\n        ignore: true\n        unchanged: true\n",
+			wantErr: true,
+		},
+		{
+			name:    "no PCR",
+			input:   "policies:\n  - name: fleet\n",
+			wantErr: true,
+		},
+		{
+			name:    "only ignored PCRs",
+			input:   "policies:\n  - pcrs:\n      - pcr: 10\n        ignore: true\n",
+			wantErr: true,
+		},
+		{
+			name:    "invalid PCR",
+			input:   "policies:\n  - pcrs:\n      - pcr: 24\n",
+			wantErr: true,
+		},
+	}
+	dir := t.TempDir()
+	for i, test := range testSuite {
+		t.Run(test.name, func(t *testing.T) {
+			file := filepath.Join(dir, string(rune('a'+i)))
+			if err := ioutil.WriteFile(file, []byte(test.input), 0600); err != nil {
+				t.Fatal(err)
+			}
+			got, err := verifier.LoadPCRPolicies(file)
+			if (err != nil) != test.wantErr {
+				t.Fatal(tests.Failure(t, err, test.wantErr, ""))
+			}
+			if !cmp.Equal(got, test.want) {
+				t.Error(tests.Failure(t, got, test.want, ""))
+			}
+		})
+	}
+}
+
+func TestPCRPolicies_Match(t *testing.T) {
+	policies := verifier.PCRPolicies{
+		{Name: "by name", Provers: []string{"edge-1"}},
+		{Name: "by labels", Labels: map[string]string{"site": "paris", "role": "gateway"}},
+		{Name: "default"},
+	}
+	var testSuite = []struct {
+		name   string
+		prover string
+		labels map[string]string
+		want   string
+	}{
+		{name: "named prover", prover: "edge-1", labels: map[string]string{"site": "paris", "role": "gateway"}, want: "by name"},
+		{name: "all labels", prover: "edge-2", labels: map[string]string{"site": "paris", "role": "gateway", "rack": "3"}, want: "by labels"},
+		{name: "missing label", prover: "edge-2", labels: map[string]string{"site": "paris"}, want: "default"},
+		{name: "no label", prover: "edge-3", want: "default"},
+	}
+	for _, test := range testSuite {
+		t.Run(test.name, func(t *testing.T) {
+			got := policies.Match(test.prover, test.labels)
+			if got == nil || got.Name != test.want {
+				t.Error(tests.Failure(t, got, test.want, ""))
+			}
+		})
+	}
+	if got := policies[:2].Match("edge-3", nil); got != nil {
+		t.Error(tests.Failure(t, got, nil, "no policy applies"))
+	}
+}
+
+func TestPCRPolicy_Evaluate(t *testing.T) {
+	pcr := func(id int, b byte) tpm.PCR {
+		return tpm.PCR{Id: id, Bank: tpm.SHA1, Value: bytes.Repeat([]byte{b}, 20)}
+	}
+	policy := verifier.PCRPolicy{
+		Name: "fleet",
+		Bank: tpm.SHA1,
+		Rules: []verifier.PCRRule{
+			{PCR: 0, Values: [][]byte{pcr(0, 1).Value, pcr(0, 2).Value}},
+			{PCR: 7, Unchanged: true},
+			{PCR: 10, Ignore: true},
+		},
+	}
+	if got, want := policy.Selection(), (tpm.PCRSelection{Bank: tpm.SHA1, PCRs: []int{0, 7}}); !cmp.Equal(got, want) {
+		t.Error(tests.Failure(t, got, want, "selection"))
+	}
+	var testSuite = []struct {
+		name     string
+		actual   []tpm.PCR
+		previous []tpm.PCR
+		want     []bool
+	}{
+		{name: "first attestation", actual: []tpm.PCR{pcr(0, 1), pcr(7, 7)}, want: []bool{true, true, true}},
+		{name: "second firmware version", actual: []tpm.PCR{pcr(0, 2), pcr(7, 7)}, previous: []tpm.PCR{pcr(7, 7)}, want: []bool{true, true, true}},
+		{name: "value not accepted", actual: []tpm.PCR{pcr(0, 3), pcr(7, 7)}, want: []bool{false, true, true}},
+		{name: "changed PCR", actual: []tpm.PCR{pcr(0, 1), pcr(7, 8)}, previous: []tpm.PCR{pcr(7, 7)}, want: []bool{true, false, true}},
+		{name: "PCR not quoted", actual: []tpm.PCR{pcr(7, 7)}, want: []bool{false, true, true}},
+	}
+	for _, test := range testSuite {
+		t.Run(test.name, func(t *testing.T) {
+			results := policy.Evaluate(test.actual, test.previous)
+			var got []bool
+			for _, r := range results {
+				got = append(got, r.Passed)
+			}
+			if !cmp.Equal(got, test.want) {
+				t.Error(tests.Failure(t, results, test.want, ""))
+			}
+			if verifier.PCRRulesPassed(results) != (test.want[0] && test.want[1]) {
+				t.Error(tests.Failure(t, verifier.PCRRulesPassed(results), test.want, "PCRRulesPassed"))
+			}
+		})
+	}
+}
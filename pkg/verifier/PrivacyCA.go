@@ -0,0 +1,145 @@
+package verifier
+
+import (
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha1"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"fmt"
+	"github.com/xcaliburne/RemoteAttestations/pkg/tpm"
+	"io/ioutil"
+	"math/big"
+	"time"
+)
+
+// DefaultAKCertificateValidity is used when a PrivacyCA is created without a validity.
+const DefaultAKCertificateValidity = 365 * 24 * time.Hour
+
+// oidSubjectAltName is copied from the EK certificate, it holds the TPM manufacturer, model and version.
+var oidSubjectAltName = []int{2, 5, 29, 17}
+
+// PrivacyCA issues X.509 certificates for AKs whose credential was activated by a TPM with a trusted EK.
+// Relying parties can then check quotes against the CA root with VerifyAKCertificate.
+type PrivacyCA struct {
+	cert     *x509.Certificate
+	key      crypto.Signer
+	validity time.Duration
+}
+
+func NewPrivacyCA(cert *x509.Certificate, key crypto.Signer, validity time.Duration) *PrivacyCA {
+	if validity <= 0 {
+		validity = DefaultAKCertificateValidity
+	}
+	return &PrivacyCA{cert: cert, key: key, validity: validity}
+}
+
+// LoadPrivacyCA reads a PEM CA certificate and its PEM private key (PKCS#1, PKCS#8 or SEC 1).
+func LoadPrivacyCA(certFile, keyFile string, validity time.Duration) (*PrivacyCA, error) {
+	rawCert, err := readPEM(certFile, "CERTIFICATE")
+	if err != nil {
+		return nil, err
+	}
+	cert, err := x509.ParseCertificate(rawCert)
+	if err != nil {
+		return nil, fmt.Errorf("error parsing CA certificate: %v", err)
+	}
+	rawKey, err := readPEM(keyFile, "")
+	if err != nil {
+		return nil, err
+	}
+	key, err := parsePrivateKey(rawKey)
+	if err != nil {
+		return nil, fmt.Errorf("error parsing CA key: %v", err)
+	}
+	return NewPrivacyCA(cert, key, validity), nil
+}
+
+func readPEM(file, blockType string) ([]byte, error) {
+	raw, err := ioutil.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	block, _ := pem.Decode(raw)
+	if block == nil || (blockType != "" && block.Type != blockType) {
+		return nil, fmt.Errorf("%v: no PEM %v found", file, blockType)
+	}
+	return block.Bytes, nil
+}
+
+func parsePrivateKey(der []byte) (crypto.Signer, error) {
+	if key, err := x509.ParsePKCS1PrivateKey(der); err == nil {
+		return key, nil
+	}
+	if key, err := x509.ParseECPrivateKey(der); err == nil {
+		return key, nil
+	}
+	key, err := x509.ParsePKCS8PrivateKey(der)
+	if err != nil {
+		return nil, err
+	}
+	signer, ok := key.(crypto.Signer)
+	if !ok {
+		return nil, fmt.Errorf("unsupported private key type %T", key)
+	}
+	return signer, nil
+}
+
+func (ca *PrivacyCA) Certificate() *x509.Certificate {
+	return ca.cert
+}
+
+// IssueAKCertificate returns a DER certificate for ak, named after the prover. The caller must have checked the EK certificate
+// and activated a credential for ak with ek.
+func (ca *PrivacyCA) IssueAKCertificate(name string, ek tpm.EndorsementKey, ak tpm.AttestationKey) ([]byte, error) {
+	if ek == nil || ak == nil || ak.PublicKey() == nil {
+		return nil, fmt.Errorf("missing endorsement or attestation key")
+	}
+	serial, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+	if err != nil {
+		return nil, err
+	}
+	keyId := sha1.Sum(x509.MarshalPKCS1PublicKey(ak.PublicKey()))
+	now := time.Now()
+	template := &x509.Certificate{
+		SerialNumber:          serial,
+		Subject:               pkix.Name{CommonName: name},
+		NotBefore:             now.Add(-time.Minute),
+		NotAfter:              now.Add(ca.validity),
+		KeyUsage:              x509.KeyUsageDigitalSignature,
+		BasicConstraintsValid: true,
+		SubjectKeyId:          keyId[:],
+	}
+	if ekCert := ek.Certificate(); ekCert != nil {
+		for _, ext := range ekCert.Extensions {
+			if ext.Id.Equal(oidSubjectAltName) {
+				template.ExtraExtensions = append(template.ExtraExtensions, pkix.Extension{Id: oidSubjectAltName, Value: ext.Value})
+			}
+		}
+	}
+	cert, err := x509.CreateCertificate(rand.Reader, template, ca.cert, ak.PublicKey(), ca.key)
+	if err != nil {
+		return nil, fmt.Errorf("error creating AK certificate: %v", err)
+	}
+	return cert, nil
+}
+
+// VerifyAKCertificate checks that certDER was issued by a privacy CA in roots and returns the certified AK,
+// which can be used to verify quotes.
+func VerifyAKCertificate(certDER []byte, roots *x509.CertPool) (tpm.AttestationKey, error) {
+	cert, err := x509.ParseCertificate(certDER)
+	if err != nil {
+		return nil, fmt.Errorf("error parsing AK certificate: %v", err)
+	}
+	_, err = cert.Verify(x509.VerifyOptions{Roots: roots, KeyUsages: []x509.ExtKeyUsage{x509.ExtKeyUsageAny}})
+	if err != nil {
+		return nil, fmt.Errorf("error verifying AK certificate: %v", err)
+	}
+	pub, ok := cert.PublicKey.(*rsa.PublicKey)
+	if !ok {
+		return nil, fmt.Errorf("public key is not RSA")
+	}
+	return &tpm.AttestationKeyData{PK: pub}, nil
+}
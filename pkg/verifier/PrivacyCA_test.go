@@ -0,0 +1,113 @@
+package verifier_test
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"github.com/google/go-cmp/cmp"
+	"github.com/xcaliburne/RemoteAttestations/pkg/tests"
+	"github.com/xcaliburne/RemoteAttestations/pkg/tpm"
+	"github.com/xcaliburne/RemoteAttestations/pkg/verifier"
+	"io/ioutil"
+	"math/big"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// writeCA writes a self-signed CA certificate and its PKCS#8 key as PEM files in dir.
+func writeCA(t *testing.T, dir string) (certFile, keyFile string, cert *x509.Certificate) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("GenerateKey() returned an error: %v", err)
+	}
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "Privacy CA"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		KeyUsage:              x509.KeyUsageCertSign,
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+	raw, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatalf("CreateCertificate() returned an error: %v", err)
+	}
+	cert, err = x509.ParseCertificate(raw)
+	if err != nil {
+		t.Fatalf("ParseCertificate() returned an error: %v", err)
+	}
+	rawKey, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		t.Fatalf("MarshalPKCS8PrivateKey() returned an error: %v", err)
+	}
+	certFile, keyFile = filepath.Join(dir, "ca.crt"), filepath.Join(dir, "ca.key")
+	if err = ioutil.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: raw}), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err = ioutil.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: rawKey}), 0600); err != nil {
+		t.Fatal(err)
+	}
+	return certFile, keyFile, cert
+}
+
+func TestPrivacyCA_IssueAKCertificate(t *testing.T) {
+	dir := t.TempDir()
+	certFile, keyFile, caCert := writeCA(t, dir)
+	_, _, otherCACert := writeCA(t, t.TempDir())
+	ca, err := verifier.LoadPrivacyCA(certFile, keyFile, time.Hour)
+	if err != nil {
+		t.Fatalf("LoadPrivacyCA() returned an error: %v", err)
+	}
+	if _, err = verifier.LoadPrivacyCA(keyFile, keyFile, time.Hour); err == nil {
+		t.Error(tests.Failure(t, err, "error", "loaded a key as the CA certificate"))
+	}
+
+	swTPM, err := tpm.OpenSoftware(filepath.Join(dir, "swtpm"))
+	if err != nil {
+		t.Fatalf("OpenSoftware() returned an error: %v", err)
+	}
+	if err = swTPM.TakeOwnership("owner", "user"); err != nil {
+		t.Fatalf("TakeOwnership() returned an error: %v", err)
+	}
+	if err = swTPM.ProveUsership("user"); err != nil {
+		t.Fatalf("ProveUsership() returned an error: %v", err)
+	}
+	ek, err := swTPM.GetEK()
+	if err != nil {
+		t.Fatalf("GetEK() returned an error: %v", err)
+	}
+	ak, err := swTPM.CreateAK()
+	if err != nil {
+		t.Fatalf("CreateAK() returned an error: %v", err)
+	}
+	akCert, err := ca.IssueAKCertificate("prover", ek, ak)
+	if err != nil {
+		t.Fatalf("IssueAKCertificate() returned an error: %v", err)
+	}
+
+	var testSuite = []struct {
+		name    string
+		root    *x509.Certificate
+		wantErr bool
+	}{
+		{name: "issuing CA", root: caCert, wantErr: false},
+		{name: "other CA", root: otherCACert, wantErr: true},
+	}
+	for _, test := range testSuite {
+		t.Run(test.name, func(t *testing.T) {
+			roots := x509.NewCertPool()
+			roots.AddCert(test.root)
+			got, gotErr := verifier.VerifyAKCertificate(akCert, roots)
+			if (gotErr != nil) != test.wantErr {
+				t.Fatal(tests.Failure(t, gotErr, test.wantErr, ""))
+			}
+			if gotErr == nil && !cmp.Equal(got.PublicKey(), ak.PublicKey()) {
+				t.Error(tests.Failure(t, got.PublicKey(), ak.PublicKey(), "certified AK"))
+			}
+		})
+	}
+}
@@ -0,0 +1,29 @@
+package verifier
+
+// ProverSelector selects the provers named in Provers and the provers carrying all of Labels.
+// An empty selector selects every prover.
+type ProverSelector struct {
+	Provers []string          `yaml:"provers"`
+	Labels  map[string]string `yaml:"labels"`
+}
+
+// Matches tells whether the prover with name and labels is selected.
+func (s ProverSelector) Matches(name string, labels map[string]string) bool {
+	if len(s.Provers) == 0 && len(s.Labels) == 0 {
+		return true
+	}
+	for _, prover := range s.Provers {
+		if prover == name {
+			return true
+		}
+	}
+	if len(s.Labels) == 0 {
+		return false
+	}
+	for key, value := range s.Labels {
+		if v, ok := labels[key]; !ok || v != value {
+			return false
+		}
+	}
+	return true
+}